@@ -1,6 +1,9 @@
 package api
 
 import (
+	"strconv"
+	"strings"
+
 	"github.com/gofiber/fiber/v2"
 	"github.com/qwp0905/go-object-storage/internal/datanode"
 )
@@ -19,11 +22,18 @@ func NewData(svc datanode.DataNode) Controller {
 	c.router.Get("/:key", c.get)
 	c.router.Put("/:key", c.put)
 	c.router.Delete("/:key", c.delete)
+	c.router.Post("/:key/link", c.link)
+	c.router.Post("/:key/copy", c.copy)
+	c.router.Post("/delete", c.deleteBatch)
 
 	return c
 }
 
 func (c *data) get(ctx *fiber.Ctx) error {
+	if header := ctx.Get(fiber.HeaderRange); header != "" {
+		return c.getRange(ctx, header)
+	}
+
 	out, err := c.svc.GetObject(ctx.Context(), ctx.Params("key"))
 	if err != nil {
 		return err
@@ -32,6 +42,30 @@ func (c *data) get(ctx *fiber.Ctx) error {
 	return ctx.SendStream(out)
 }
 
+// namenodes only ask for a single range, "bytes=<first>-[last]".
+func (c *data) getRange(ctx *fiber.Ctx, header string) error {
+	first, last, ok := strings.Cut(strings.TrimPrefix(header, "bytes="), "-")
+	offset, err := strconv.ParseInt(first, 10, 64)
+	if !ok || err != nil || offset < 0 {
+		return fiber.ErrRequestedRangeNotSatisfiable
+	}
+	length := int64(-1)
+	if last != "" {
+		end, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || end < offset {
+			return fiber.ErrRequestedRangeNotSatisfiable
+		}
+		length = end - offset + 1
+	}
+
+	out, err := c.svc.GetObjectRange(ctx.Context(), ctx.Params("key"), offset, length)
+	if err != nil {
+		return err
+	}
+
+	return ctx.Status(fiber.StatusPartialContent).SendStream(out)
+}
+
 func (c *data) put(ctx *fiber.Ctx) error {
 	body := ctx.Request().BodyStream()
 	if body == nil {
@@ -46,8 +80,46 @@ func (c *data) put(ctx *fiber.Ctx) error {
 	return ctx.SendStatus(fiber.StatusOK)
 }
 
+// shared data is only removed with its last reference, unless purged.
 func (c *data) delete(ctx *fiber.Ctx) error {
-	if err := c.svc.DeleteObject(ctx.Params("key")); err != nil {
+	remove := c.svc.DeleteObject
+	if ctx.QueryBool("purge") {
+		remove = c.svc.PurgeObject
+	}
+	if err := remove(ctx.Params("key")); err != nil {
+		return err
+	}
+
+	return ctx.SendStatus(fiber.StatusOK)
+}
+
+func (c *data) link(ctx *fiber.Ctx) error {
+	if err := c.svc.LinkObject(ctx.Params("key")); err != nil {
+		return err
+	}
+
+	return ctx.SendStatus(fiber.StatusOK)
+}
+
+func (c *data) copy(ctx *fiber.Ctx) error {
+	to := ctx.Query("to")
+	if to == "" {
+		return fiber.ErrBadRequest
+	}
+	if err := c.svc.CopyObject(ctx.Params("key"), to); err != nil {
+		return err
+	}
+
+	return ctx.SendStatus(fiber.StatusOK)
+}
+
+func (c *data) deleteBatch(ctx *fiber.Ctx) error {
+	keys := make([]string, 0)
+	if err := ctx.BodyParser(&keys); err != nil {
+		return fiber.ErrBadRequest
+	}
+
+	if err := c.svc.DeleteObjects(keys); err != nil {
 		return err
 	}
 
@@ -0,0 +1,45 @@
+package api
+
+import (
+	"context"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/qwp0905/go-object-storage/internal/maintenance"
+	"github.com/qwp0905/go-object-storage/pkg/logger"
+)
+
+type gc struct {
+	*controllerImpl
+	svc maintenance.Collector
+}
+
+func NewGC(svc maintenance.Collector) Controller {
+	c := &gc{
+		controllerImpl: newController("/gc"),
+		svc:            svc,
+	}
+
+	c.router.Get("/", c.mark)
+	c.router.Post("/", c.run)
+
+	return c
+}
+
+func (c *gc) mark(ctx *fiber.Ctx) error {
+	out, err := c.svc.Mark(ctx.Context())
+	if err != nil {
+		return err
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(out)
+}
+
+func (c *gc) run(ctx *fiber.Ctx) error {
+	go func() {
+		if err := c.svc.Run(context.Background()); err != nil {
+			logger.Warnf("%+v", err)
+		}
+	}()
+
+	return ctx.Status(fiber.StatusAccepted).SendString("Accepted")
+}
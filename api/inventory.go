@@ -0,0 +1,41 @@
+package api
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/qwp0905/go-object-storage/internal/datanode"
+)
+
+type inventory struct {
+	*controllerImpl
+	svc datanode.DataNode
+}
+
+func NewInventory(svc datanode.DataNode) Controller {
+	c := &inventory{
+		controllerImpl: newController("/inventory"),
+		svc:            svc,
+	}
+
+	c.router.Get("/meta", c.meta)
+	c.router.Get("/object", c.object)
+
+	return c
+}
+
+func (c *inventory) meta(ctx *fiber.Ctx) error {
+	out, err := c.svc.ListMetadata()
+	if err != nil {
+		return err
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(out)
+}
+
+func (c *inventory) object(ctx *fiber.Ctx) error {
+	out, err := c.svc.ListObjects()
+	if err != nil {
+		return err
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(out)
+}
@@ -0,0 +1,66 @@
+package api
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/qwp0905/go-object-storage/internal/namenode"
+)
+
+type jobs struct {
+	*controllerImpl
+	svc namenode.Jobs
+}
+
+func NewJobs(svc namenode.Jobs) Controller {
+	c := &jobs{
+		controllerImpl: newController("/jobs"),
+		svc:            svc,
+	}
+
+	c.router.Get("/", c.list)
+	c.router.Post("/", c.start)
+	c.router.Get("/:id", c.get)
+	c.router.Delete("/:id", c.cancel)
+
+	return c
+}
+
+func (c *jobs) list(ctx *fiber.Ctx) error {
+	out, err := c.svc.List(ctx.Context())
+	if err != nil {
+		return err
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(out)
+}
+
+func (c *jobs) start(ctx *fiber.Ctx) error {
+	req := new(namenode.JobRequest)
+	if err := ctx.BodyParser(req); err != nil {
+		return fiber.ErrBadRequest
+	}
+
+	out, err := c.svc.Start(ctx.Context(), req)
+	if err != nil {
+		return err
+	}
+
+	return ctx.Status(fiber.StatusAccepted).JSON(out)
+}
+
+func (c *jobs) get(ctx *fiber.Ctx) error {
+	out, err := c.svc.Get(ctx.Context(), ctx.Params("id"))
+	if err != nil {
+		return err
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(out)
+}
+
+func (c *jobs) cancel(ctx *fiber.Ctx) error {
+	out, err := c.svc.Cancel(ctx.Context(), ctx.Params("id"))
+	if err != nil {
+		return err
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(out)
+}
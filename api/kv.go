@@ -0,0 +1,160 @@
+package api
+
+import (
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pkg/errors"
+	"github.com/qwp0905/go-object-storage/internal/kv"
+)
+
+type kvStore struct {
+	*controllerImpl
+	store kv.LockStore
+}
+
+func NewKV(store kv.LockStore) Controller {
+	c := &kvStore{
+		controllerImpl: newController("/kv"),
+		store:          store,
+	}
+
+	c.router.Get("/", c.get)
+	c.router.Get("/keys", c.keys)
+	c.router.Post("/mget", c.mget)
+	c.router.Put("/", c.set)
+	c.router.Delete("/", c.del)
+	c.router.Post("/lock", c.lock)
+	c.router.Post("/extend", c.extend)
+	c.router.Post("/unlock", c.unlock)
+	c.router.Post("/publish", c.publish)
+
+	return c
+}
+
+func (c *kvStore) get(ctx *fiber.Ctx) error {
+	value, err := c.store.Get(ctx.Context(), ctx.Query("key"))
+	if err != nil {
+		return err
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(&kv.ValueResponse{Value: value})
+}
+
+func (c *kvStore) keys(ctx *fiber.Ctx) error {
+	keys, err := c.store.Keys(ctx.Context(), ctx.Query("prefix"))
+	if err != nil {
+		return err
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(&kv.KeysResponse{Keys: keys})
+}
+
+func (c *kvStore) mget(ctx *fiber.Ctx) error {
+	body := new(kv.KeysRequest)
+	if err := ctx.BodyParser(body); err != nil {
+		return errors.WithStack(err)
+	}
+
+	values, err := c.store.MGet(ctx.Context(), body.Keys...)
+	if err != nil {
+		return err
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(&kv.ValuesResponse{Values: values})
+}
+
+func (c *kvStore) set(ctx *fiber.Ctx) error {
+	body := new(kv.SetRequest)
+	if err := ctx.BodyParser(body); err != nil {
+		return errors.WithStack(err)
+	}
+
+	if err := c.store.Set(
+		ctx.Context(),
+		body.Key,
+		body.Value,
+		time.Duration(body.TTL)*time.Millisecond,
+	); err != nil {
+		return err
+	}
+
+	return ctx.SendStatus(fiber.StatusOK)
+}
+
+func (c *kvStore) del(ctx *fiber.Ctx) error {
+	if err := c.store.Del(ctx.Context(), ctx.Query("key")); err != nil {
+		return err
+	}
+
+	return ctx.SendStatus(fiber.StatusOK)
+}
+
+func (c *kvStore) lock(ctx *fiber.Ctx) error {
+	body := new(kv.LockRequest)
+	if err := ctx.BodyParser(body); err != nil {
+		return errors.WithStack(err)
+	}
+
+	wait, token, err := c.store.TryLock(
+		ctx.Context(),
+		body.Key,
+		body.Owner,
+		body.Shared,
+		time.Duration(body.TTL)*time.Millisecond,
+	)
+	if err != nil {
+		return err
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(&kv.LockResponse{Wait: wait, Token: token})
+}
+
+func (c *kvStore) extend(ctx *fiber.Ctx) error {
+	body := new(kv.LockRequest)
+	if err := ctx.BodyParser(body); err != nil {
+		return errors.WithStack(err)
+	}
+
+	if err := c.store.Extend(
+		ctx.Context(),
+		body.Key,
+		body.Owner,
+		time.Duration(body.TTL)*time.Millisecond,
+	); err != nil {
+		return err
+	}
+
+	return ctx.SendStatus(fiber.StatusOK)
+}
+
+func (c *kvStore) unlock(ctx *fiber.Ctx) error {
+	body := new(kv.LockRequest)
+	if err := ctx.BodyParser(body); err != nil {
+		return errors.WithStack(err)
+	}
+
+	if err := c.store.Unlock(ctx.Context(), body.Key, body.Owner, body.Shared); err != nil {
+		return err
+	}
+
+	return ctx.SendStatus(fiber.StatusOK)
+}
+
+func (c *kvStore) publish(ctx *fiber.Ctx) error {
+	publisher, ok := c.store.(kv.Publisher)
+	if !ok {
+		return fiber.ErrNotImplemented
+	}
+
+	body := new(kv.SetRequest)
+	if err := ctx.BodyParser(body); err != nil {
+		return errors.WithStack(err)
+	}
+
+	if err := publisher.Publish(ctx.Context(), body.Key, body.Value); err != nil {
+		return err
+	}
+
+	return ctx.SendStatus(fiber.StatusOK)
+}
@@ -0,0 +1,79 @@
+package api
+
+import (
+	"context"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/qwp0905/go-object-storage/internal/maintenance"
+	"github.com/qwp0905/go-object-storage/pkg/logger"
+)
+
+type lifecycle struct {
+	*controllerImpl
+	svc maintenance.Lifecycle
+}
+
+func NewLifecycle(svc maintenance.Lifecycle) Controller {
+	c := &lifecycle{
+		controllerImpl: newController("/lifecycle"),
+		svc:            svc,
+	}
+
+	c.router.Get("/", c.plan)
+	c.router.Post("/", c.run)
+	c.router.Get("/rules", c.rules)
+	c.router.Put("/rules/:id", c.putRule)
+	c.router.Delete("/rules/:id", c.deleteRule)
+
+	return c
+}
+
+func (c *lifecycle) plan(ctx *fiber.Ctx) error {
+	out, err := c.svc.Plan(ctx.Context())
+	if err != nil {
+		return err
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(out)
+}
+
+func (c *lifecycle) run(ctx *fiber.Ctx) error {
+	go func() {
+		if err := c.svc.Run(context.Background()); err != nil {
+			logger.Warnf("%+v", err)
+		}
+	}()
+
+	return ctx.Status(fiber.StatusAccepted).SendString("Accepted")
+}
+
+func (c *lifecycle) rules(ctx *fiber.Ctx) error {
+	out, err := c.svc.Rules(ctx.Context())
+	if err != nil {
+		return err
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(out)
+}
+
+func (c *lifecycle) putRule(ctx *fiber.Ctx) error {
+	rule := new(maintenance.LifecycleRule)
+	if err := ctx.BodyParser(rule); err != nil {
+		return fiber.ErrBadRequest
+	}
+	rule.Id = ctx.Params("id")
+
+	if err := c.svc.PutRule(ctx.Context(), rule); err != nil {
+		return err
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(rule)
+}
+
+func (c *lifecycle) deleteRule(ctx *fiber.Ctx) error {
+	if err := c.svc.DeleteRule(ctx.Context(), ctx.Params("id")); err != nil {
+		return err
+	}
+
+	return ctx.Status(fiber.StatusOK).SendString("OK")
+}
@@ -1,6 +1,8 @@
 package api
 
 import (
+	"strconv"
+
 	"github.com/gofiber/fiber/v2"
 	"github.com/pkg/errors"
 	"github.com/qwp0905/go-object-storage/internal/datanode"
@@ -31,24 +33,57 @@ func (c *meta) get(ctx *fiber.Ctx) error {
 		return err
 	}
 
+	version := strconv.FormatUint(out.Version, 10)
+	ctx.Set(fiber.HeaderETag, version)
+	if match := ctx.Get(fiber.HeaderIfNoneMatch); match != "" && match == version {
+		return ctx.SendStatus(fiber.StatusNotModified)
+	}
+
+	if ctx.Get(fiber.HeaderAccept) == metadata.ContentType {
+		ctx.Set(fiber.HeaderContentType, metadata.ContentType)
+		return ctx.Status(fiber.StatusOK).Send(metadata.Marshal(out))
+	}
 	return ctx.Status(fiber.StatusOK).JSON(out)
 }
 
 func (c *meta) put(ctx *fiber.Ctx) error {
 	body := new(metadata.Metadata)
-	if err := ctx.BodyParser(body); err != nil {
+	if ctx.Get(fiber.HeaderContentType) == metadata.ContentType {
+		parsed, err := metadata.Unmarshal(ctx.Body())
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+		body = parsed
+	} else if err := ctx.BodyParser(body); err != nil {
 		return errors.WithStack(err)
 	}
 
-	if err := c.svc.PutMetadata(body); err != nil {
+	if match := ctx.Get("If-Match"); match != "" {
+		version, err := strconv.ParseUint(match, 10, 64)
+		if err != nil {
+			return fiber.ErrBadRequest
+		}
+		if err := c.svc.CompareAndPutMetadata(body, version); err != nil {
+			return err
+		}
+	} else if err := c.svc.PutMetadata(body); err != nil {
 		return err
 	}
 
+	ctx.Set(fiber.HeaderETag, strconv.FormatUint(body.Version, 10))
 	return ctx.SendStatus(fiber.StatusOK)
 }
 
 func (c *meta) delete(ctx *fiber.Ctx) error {
-	if err := c.svc.DeleteMetadata(c.getPath(ctx)); err != nil {
+	if match := ctx.Get("If-Match"); match != "" {
+		version, err := strconv.ParseUint(match, 10, 64)
+		if err != nil {
+			return fiber.ErrBadRequest
+		}
+		if err := c.svc.CompareAndDeleteMetadata(c.getPath(ctx), version); err != nil {
+			return err
+		}
+	} else if err := c.svc.DeleteMetadata(c.getPath(ctx)); err != nil {
 		return err
 	}
 
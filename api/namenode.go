@@ -2,10 +2,16 @@ package api
 
 import (
 	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"fmt"
+	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/qwp0905/go-object-storage/internal/metadata"
 	"github.com/qwp0905/go-object-storage/internal/namenode"
 )
 
@@ -23,6 +29,7 @@ func NewNameNode(svc namenode.NameNode) Controller {
 	c.router.Head("/*", c.headObject)
 	c.router.Get("/", c.listObject)
 	c.router.Get("/*", c.getObject)
+	c.router.Post("/", c.deleteObjects)
 	c.router.Post("/*", c.putObject)
 	c.router.Put("/*", c.putObject)
 	c.router.Delete("/*", c.deleteObject)
@@ -31,25 +38,57 @@ func NewNameNode(svc namenode.NameNode) Controller {
 }
 
 func (c *nameNode) getObject(ctx *fiber.Ctx) error {
-	meta, obj, err := c.svc.GetObject(ctx.Context(), c.getPath(ctx))
+	meta, err := c.svc.HeadObject(ctx.Context(), c.getPath(ctx))
 	if err != nil {
 		return err
 	}
-	ctx.Set("Content-Type", meta.Type)
-	ctx.Set("Last-Modified", meta.LastModified.Format(time.RFC1123))
-	ctx.Set("Key", meta.Key)
+	customerKey, err := getCustomerKey(ctx, headerCustomerKey)
+	if err != nil {
+		return err
+	}
+
+	// a single byte range is served, anything else gets the whole object.
+	input := &namenode.ReadObjectInput{Metadata: meta, Length: -1, CustomerKey: customerKey}
+	status := fiber.StatusOK
+	if ctx.Get(fiber.HeaderRange) != "" {
+		r, err := ctx.Range(int(meta.Size))
+		if err == fiber.ErrRangeUnsatisfiable {
+			ctx.Set(fiber.HeaderContentRange, fmt.Sprintf("bytes */%d", meta.Size))
+			return fiber.ErrRequestedRangeNotSatisfiable
+		}
+		if err == nil && r.Type == "bytes" && len(r.Ranges) == 1 {
+			input.Offset = int64(r.Ranges[0].Start)
+			input.Length = int64(r.Ranges[0].End - r.Ranges[0].Start + 1)
+			status = fiber.StatusPartialContent
+			ctx.Set(fiber.HeaderContentRange, fmt.Sprintf("bytes %d-%d/%d", r.Ranges[0].Start, r.Ranges[0].End, meta.Size))
+		}
+	}
+
+	obj, err := c.svc.ReadObject(ctx.Context(), input)
+	if err != nil {
+		return err
+	}
+	setHeaders(ctx, meta)
 
-	return ctx.SendStream(obj, int(meta.Size))
+	size := int(meta.Size)
+	if input.Length >= 0 {
+		size = int(input.Length)
+	}
+	return ctx.Status(status).SendStream(obj, size)
 }
 
 func (c *nameNode) listObject(ctx *fiber.Ctx) error {
-	list, err := c.svc.ListObject(
-		ctx.Context(),
-		ctx.Query("prefix"),
-		ctx.Query("delimiter"),
-		ctx.Query("after"),
-		ctx.QueryInt("limit", 1000),
-	)
+	// after and limit are the names used before continuation tokens.
+	startAfter := ctx.Query("start-after", ctx.Query("after"))
+	maxKeys := ctx.QueryInt("max-keys", ctx.QueryInt("limit", namenode.MaxListKeys))
+
+	list, err := c.svc.ListObject(ctx.Context(), &namenode.ListObjectInput{
+		Prefix:            ctx.Query("prefix"),
+		Delimiter:         ctx.Query("delimiter"),
+		StartAfter:        startAfter,
+		ContinuationToken: ctx.Query("continuation-token"),
+		MaxKeys:           maxKeys,
+	})
 	if err != nil {
 		return err
 	}
@@ -57,21 +96,175 @@ func (c *nameNode) listObject(ctx *fiber.Ctx) error {
 	return ctx.Status(fiber.StatusOK).JSON(list)
 }
 
+const (
+	headerCopySource        = "X-Copy-Source"
+	headerMetadataDirective = "X-Metadata-Directive"
+	headerRenameSource      = "X-Rename-Source"
+	headerTTL               = "X-TTL"
+	headerStorageClass      = "X-Storage-Class"
+	headerEncryption        = "X-Server-Side-Encryption"
+	headerCustomerAlgorithm = "X-SSE-Customer-Algorithm"
+	headerCustomerKey       = "X-SSE-Customer-Key"
+	headerCustomerKeyMD5    = "X-SSE-Customer-Key-MD5"
+	headerCopySourceKey     = "X-Copy-Source-SSE-Customer-Key"
+)
+
 func (c *nameNode) putObject(ctx *fiber.Ctx) error {
-	body := bytes.NewReader(ctx.BodyRaw())
-	if err := c.svc.PutObject(
-		ctx.Context(),
-		c.getPath(ctx),
-		ctx.Get("Content-Type", "text/plain"),
-		ctx.Request().Header.ContentLength(),
-		body,
-	); err != nil {
+	if source := ctx.Get(headerCopySource); source != "" {
+		return c.copyObject(ctx, source)
+	}
+	if source := ctx.Get(headerRenameSource); source != "" {
+		return c.renameObject(ctx, source)
+	}
+
+	expires, err := getExpires(ctx)
+	if err != nil {
+		return err
+	}
+	encrypt, customerKey, err := getEncryption(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := c.svc.PutObject(ctx.Context(), &namenode.PutObjectInput{
+		Key:                  c.getPath(ctx),
+		ContentType:          ctx.Get("Content-Type", "text/plain"),
+		Size:                 ctx.Request().Header.ContentLength(),
+		Body:                 bytes.NewReader(ctx.BodyRaw()),
+		Expires:              expires,
+		StorageClass:         strings.ToUpper(ctx.Get(headerStorageClass)),
+		ServerSideEncryption: encrypt,
+		CustomerKey:          customerKey,
+	}); err != nil {
+		return err
+	}
+
+	return ctx.Status(fiber.StatusOK).SendString("OK")
+}
+
+// getExpires reads when a new object expires, either as a date in Expires or
+// as seconds from now in X-TTL.
+func getExpires(ctx *fiber.Ctx) (time.Time, error) {
+	if ttl := ctx.Get(headerTTL); ttl != "" {
+		sec, err := strconv.Atoi(ttl)
+		if err != nil || sec <= 0 {
+			return time.Time{}, fiber.NewError(fiber.StatusBadRequest, "ttl must be a positive number of seconds")
+		}
+		return time.Now().Add(time.Duration(sec) * time.Second), nil
+	}
+
+	if expires := ctx.Get(fiber.HeaderExpires); expires != "" {
+		t, err := http.ParseTime(expires)
+		if err != nil {
+			return time.Time{}, fiber.NewError(fiber.StatusBadRequest, "expires must be an http date")
+		}
+		return t, nil
+	}
+
+	return time.Time{}, nil
+}
+
+// getEncryption reads whether a new object is encrypted by the server or with
+// a key of the customer.
+func getEncryption(ctx *fiber.Ctx) (bool, []byte, error) {
+	customerKey, err := getCustomerKey(ctx, headerCustomerKey)
+	if err != nil {
+		return false, nil, err
+	}
+
+	switch strings.ToUpper(ctx.Get(headerEncryption)) {
+	case "":
+		return false, customerKey, nil
+	case metadata.EncryptionServer:
+		return true, customerKey, nil
+	}
+	return false, nil, fiber.NewError(fiber.StatusBadRequest, "server side encryption must be AES256")
+}
+
+// customer keys are sent in base64, their md5 is optional and only checked
+// against the key.
+func getCustomerKey(ctx *fiber.Ctx, header string) ([]byte, error) {
+	encoded := ctx.Get(header)
+	if encoded == "" {
+		return nil, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "customer key must be base64")
+	}
+
+	if header == headerCustomerKey {
+		if digest := ctx.Get(headerCustomerKeyMD5); digest != "" {
+			sum := md5.Sum(key)
+			if digest != base64.StdEncoding.EncodeToString(sum[:]) {
+				return nil, fiber.NewError(fiber.StatusBadRequest, "customer key does not match its md5")
+			}
+		}
+	}
+	return key, nil
+}
+
+func setHeaders(ctx *fiber.Ctx, meta *metadata.Metadata) {
+	ctx.Set("Content-Type", meta.Type)
+	ctx.Set("Last-Modified", meta.LastModified.Format(time.RFC1123))
+	ctx.Set("Key", meta.Key)
+	ctx.Set(fiber.HeaderAcceptRanges, "bytes")
+	ctx.Set(headerStorageClass, meta.Class())
+	if !meta.Expires.IsZero() {
+		ctx.Set(fiber.HeaderExpires, meta.Expires.UTC().Format(http.TimeFormat))
+	}
+	switch meta.Encryption {
+	case metadata.EncryptionServer:
+		ctx.Set(headerEncryption, metadata.EncryptionServer)
+	case metadata.EncryptionCustomer:
+		ctx.Set(headerCustomerAlgorithm, "AES256")
+	}
+}
+
+func (c *nameNode) copyObject(ctx *fiber.Ctx, source string) error {
+	expires, err := getExpires(ctx)
+	if err != nil {
+		return err
+	}
+	encrypt, customerKey, err := getEncryption(ctx)
+	if err != nil {
+		return err
+	}
+	sourceKey, err := getCustomerKey(ctx, headerCopySourceKey)
+	if err != nil {
+		return err
+	}
+
+	if err := c.svc.CopyObject(ctx.Context(), &namenode.CopyObjectInput{
+		Source:               objectKey(source),
+		Key:                  c.getPath(ctx),
+		Directive:            namenode.MetadataDirective(strings.ToUpper(ctx.Get(headerMetadataDirective))),
+		ContentType:          ctx.Get("Content-Type", "text/plain"),
+		Expires:              expires,
+		StorageClass:         strings.ToUpper(ctx.Get(headerStorageClass)),
+		ServerSideEncryption: encrypt,
+		CustomerKey:          customerKey,
+		SourceCustomerKey:    sourceKey,
+	}); err != nil {
 		return err
 	}
 
 	return ctx.Status(fiber.StatusOK).SendString("OK")
 }
 
+func (c *nameNode) renameObject(ctx *fiber.Ctx, source string) error {
+	if err := c.svc.RenameObject(ctx.Context(), objectKey(source), c.getPath(ctx)); err != nil {
+		return err
+	}
+
+	return ctx.Status(fiber.StatusOK).SendString("OK")
+}
+
+// keys given outside the path may leave out the leading slash.
+func objectKey(source string) string {
+	return "/" + strings.TrimPrefix(source, "/")
+}
+
 func (c *nameNode) deleteObject(ctx *fiber.Ctx) error {
 	if err := c.svc.DeleteObject(ctx.Context(), c.getPath(ctx)); err != nil {
 		return err
@@ -80,15 +273,44 @@ func (c *nameNode) deleteObject(ctx *fiber.Ctx) error {
 	return ctx.Status(fiber.StatusOK).SendString("OK")
 }
 
+type deleteObjectsRequest struct {
+	Keys  []string `json:"keys"`
+	Quiet bool     `json:"quiet"`
+}
+
+// POST /?delete removes many keys at once and reports the outcome per key,
+// quiet leaves out the keys that were deleted.
+func (c *nameNode) deleteObjects(ctx *fiber.Ctx) error {
+	if !ctx.Request().URI().QueryArgs().Has("delete") {
+		return ctx.Next()
+	}
+
+	req := new(deleteObjectsRequest)
+	if err := ctx.BodyParser(req); err != nil {
+		return fiber.ErrBadRequest
+	}
+	for i := range req.Keys {
+		req.Keys[i] = objectKey(req.Keys[i])
+	}
+
+	out, err := c.svc.DeleteObjects(ctx.Context(), req.Keys)
+	if err != nil {
+		return err
+	}
+	if req.Quiet {
+		out.Deleted = nil
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(out)
+}
+
 func (c *nameNode) headObject(ctx *fiber.Ctx) error {
 	meta, err := c.svc.HeadObject(ctx.Context(), c.getPath(ctx))
 	if err != nil {
 		return err
 	}
-	ctx.Set("Content-Type", meta.Type)
+	setHeaders(ctx, meta)
 	ctx.Set("Content-Length", strconv.Itoa(int(meta.Size)))
-	ctx.Set("Last-Modified", meta.LastModified.Format(time.RFC1123))
-	ctx.Set("Key", meta.Key)
 
 	return ctx.Status(fiber.StatusOK).Send(nil)
 }
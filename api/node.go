@@ -0,0 +1,52 @@
+package api
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/qwp0905/go-object-storage/internal/maintenance"
+	"github.com/qwp0905/go-object-storage/internal/nodepool"
+)
+
+type node struct {
+	*controllerImpl
+	pool           nodepool.NodePool
+	decommissioner maintenance.Decommissioner
+}
+
+func NewNode(pool nodepool.NodePool, decommissioner maintenance.Decommissioner) Controller {
+	c := &node{
+		controllerImpl: newController("/nodes"),
+		pool:           pool,
+		decommissioner: decommissioner,
+	}
+
+	c.router.Get("/", c.list)
+	c.router.Post("/:id/decommission", c.decommission)
+	c.router.Delete("/:id/decommission", c.cancel)
+
+	return c
+}
+
+func (c *node) list(ctx *fiber.Ctx) error {
+	out, err := c.pool.GetNodes(ctx.Context())
+	if err != nil {
+		return err
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(out)
+}
+
+func (c *node) decommission(ctx *fiber.Ctx) error {
+	if err := c.decommissioner.Decommission(ctx.Context(), ctx.Params("id")); err != nil {
+		return err
+	}
+
+	return ctx.Status(fiber.StatusOK).SendString("OK")
+}
+
+func (c *node) cancel(ctx *fiber.Ctx) error {
+	if err := c.decommissioner.Cancel(ctx.Context(), ctx.Params("id")); err != nil {
+		return err
+	}
+
+	return ctx.Status(fiber.StatusOK).SendString("OK")
+}
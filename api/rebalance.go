@@ -0,0 +1,40 @@
+package api
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/qwp0905/go-object-storage/internal/maintenance"
+)
+
+type rebalance struct {
+	*controllerImpl
+	svc maintenance.Rebalancer
+}
+
+func NewRebalance(svc maintenance.Rebalancer) Controller {
+	c := &rebalance{
+		controllerImpl: newController("/rebalance"),
+		svc:            svc,
+	}
+
+	c.router.Get("/", c.plan)
+	c.router.Post("/", c.run)
+
+	return c
+}
+
+func (c *rebalance) plan(ctx *fiber.Ctx) error {
+	out, err := c.svc.Plan(ctx.Context())
+	if err != nil {
+		return err
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(out)
+}
+
+func (c *rebalance) run(ctx *fiber.Ctx) error {
+	if err := c.svc.RunAsync(); err != nil {
+		return err
+	}
+
+	return ctx.Status(fiber.StatusAccepted).SendString("Accepted")
+}
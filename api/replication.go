@@ -0,0 +1,70 @@
+package api
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/pkg/errors"
+	"github.com/qwp0905/go-object-storage/internal/replication"
+)
+
+type repl struct {
+	*controllerImpl
+	cluster replication.Cluster
+}
+
+func NewReplication(cluster replication.Cluster) Controller {
+	c := &repl{
+		controllerImpl: newController("/repl"),
+		cluster:        cluster,
+	}
+
+	c.router.Post("/vote", c.vote)
+	c.router.Post("/append", c.append)
+	c.router.Post("/snapshot", c.snapshot)
+
+	return c
+}
+
+func (c *repl) vote(ctx *fiber.Ctx) error {
+	body := new(replication.VoteRequest)
+	if err := ctx.BodyParser(body); err != nil {
+		return errors.WithStack(err)
+	}
+
+	out, err := c.cluster.RequestVote(body)
+	if err != nil {
+		return err
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(out)
+}
+
+func (c *repl) append(ctx *fiber.Ctx) error {
+	body := new(replication.AppendRequest)
+	if err := ctx.BodyParser(body); err != nil {
+		return errors.WithStack(err)
+	}
+
+	out, err := c.cluster.AppendEntries(body)
+	if err != nil {
+		return err
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(out)
+}
+
+func (c *repl) snapshot(ctx *fiber.Ctx) error {
+	body := new(replication.SnapshotRequest)
+	if err := ctx.BodyParser(body); err != nil {
+		return errors.WithStack(err)
+	}
+	if body.Snapshot == nil {
+		return fiber.ErrBadRequest
+	}
+
+	out, err := c.cluster.InstallSnapshot(body)
+	if err != nil {
+		return err
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(out)
+}
@@ -0,0 +1,41 @@
+package api
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/qwp0905/go-object-storage/internal/datanode"
+)
+
+type segments struct {
+	*controllerImpl
+	svc datanode.DataNode
+}
+
+func NewSegment(svc datanode.DataNode) Controller {
+	c := &segments{
+		controllerImpl: newController("/segments"),
+		svc:            svc,
+	}
+
+	c.router.Get("/", c.stat)
+	c.router.Post("/compact", c.compact)
+
+	return c
+}
+
+func (c *segments) stat(ctx *fiber.Ctx) error {
+	out, err := c.svc.SegmentStat()
+	if err != nil {
+		return err
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(out)
+}
+
+func (c *segments) compact(ctx *fiber.Ctx) error {
+	out, err := c.svc.Compact()
+	if err != nil {
+		return err
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(out)
+}
@@ -0,0 +1,31 @@
+package api
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/qwp0905/go-object-storage/internal/datanode"
+)
+
+type stat struct {
+	*controllerImpl
+	svc datanode.DataNode
+}
+
+func NewStat(svc datanode.DataNode) Controller {
+	c := &stat{
+		controllerImpl: newController("/stat"),
+		svc:            svc,
+	}
+
+	c.router.Get("/", c.get)
+
+	return c
+}
+
+func (c *stat) get(ctx *fiber.Ctx) error {
+	out, err := c.svc.Stat()
+	if err != nil {
+		return err
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(out)
+}
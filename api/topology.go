@@ -0,0 +1,41 @@
+package api
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/qwp0905/go-object-storage/internal/nodepool"
+)
+
+type topology struct {
+	*controllerImpl
+	pool nodepool.NodePool
+}
+
+func NewTopology(pool nodepool.NodePool) Controller {
+	c := &topology{
+		controllerImpl: newController("/topology"),
+		pool:           pool,
+	}
+
+	c.router.Get("/", c.get)
+	c.router.Get("/nodes", c.list)
+
+	return c
+}
+
+func (c *topology) get(ctx *fiber.Ctx) error {
+	out, err := c.pool.GetTopology(ctx.Context())
+	if err != nil {
+		return err
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(out)
+}
+
+func (c *topology) list(ctx *fiber.Ctx) error {
+	out, err := c.pool.GetNodes(ctx.Context())
+	if err != nil {
+		return err
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(out)
+}
@@ -5,57 +5,118 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 
+	"github.com/pkg/errors"
 	"github.com/qwp0905/go-object-storage/api"
 	"github.com/qwp0905/go-object-storage/internal/bufferpool"
 	"github.com/qwp0905/go-object-storage/internal/datanode"
 	"github.com/qwp0905/go-object-storage/internal/filesystem"
 	"github.com/qwp0905/go-object-storage/internal/http"
+	"github.com/qwp0905/go-object-storage/internal/kv"
+	"github.com/qwp0905/go-object-storage/internal/metadata"
 	"github.com/qwp0905/go-object-storage/pkg/logger"
+	"github.com/redis/go-redis/v9"
 )
 
 var app http.Application
 
 var (
+	storeType string
+	namenodes string
 	redisHost string
 	baseDir   string
 	redisDB   int
 	addr      uint
 	host      string
 	logLevel  string
+	zone      string
+	rack      string
+	machine   string
+	tier      string
+	packBelow int
+	segSize   int64
+	compact   int
+	ratio     float64
 )
 
 func main() {
+	flag.StringVar(&storeType, "store", "redis", "cluster metadata store (redis, raft)")
+	flag.StringVar(&namenodes, "namenodes", "", "comma separated host:port of the peer listeners of namenodes with raft store")
 	flag.StringVar(&redisHost, "redis", "localhost:6379", "redis host")
 	flag.IntVar(&redisDB, "db", 1, "redis db no")
 	flag.StringVar(&baseDir, "base", "/var/lib/datanode/", "base directory")
 	flag.StringVar(&host, "host", "", "host which to be register in redis")
 	flag.UintVar(&addr, "addr", 8080, "application port")
 	flag.StringVar(&logLevel, "log-level", "info", "log level")
+	flag.StringVar(&zone, "zone", "default", "zone label of failure domain")
+	flag.StringVar(&rack, "rack", "default", "rack label of failure domain")
+	flag.StringVar(&machine, "machine", "", "physical host label of failure domain (default os hostname)")
+
+	flag.StringVar(&tier, "tier", metadata.StorageClassStandard, "storage class of objects kept on this node (STANDARD, COLD)")
+
+	flag.IntVar(&packBelow, "pack-below", 0, "objects smaller than this many bytes are packed into segment files, 0 disables")
+	flag.Int64Var(&segSize, "segment-size", 64*bufferpool.MB, "bytes a segment file grows to before a new one starts")
+	flag.IntVar(&compact, "compact-interval", 600, "interval to compact segment files, 0 to disable")
+	flag.Float64Var(&ratio, "compact-ratio", 0.5, "share of deleted bytes that gets a segment file compacted")
 
 	flag.Parse()
 
 	logger.Config(logLevel)
 
+	if !metadata.ValidStorageClass(tier) {
+		logger.Fatal(errors.Errorf("unknown tier %s", tier))
+	}
+	if packBelow > 0 && (segSize < int64(packBelow) || ratio <= 0 || ratio > 1) {
+		logger.Fatal(errors.New("segments must hold packed objects and the compact ratio must be in (0, 1]"))
+	}
+
+	if machine == "" {
+		name, err := os.Hostname()
+		if err != nil {
+			panic(err)
+		}
+		machine = name
+	}
+
 	fs := filesystem.NewFileSystem(baseDir)
 	bp := bufferpool.NewBufferPool(int(float64(os.Getpagesize()*bufferpool.MB)*0.8), fs)
 	logger.Infof("%01f mb can be allocate", float64(os.Getpagesize())*0.8)
 
+	var store kv.Store
+	switch storeType {
+	case "redis":
+		store = kv.NewRedis(redis.NewClient(&redis.Options{Addr: redisHost, DB: redisDB}))
+	case "raft":
+		store = kv.NewRemote(strings.Split(namenodes, ","))
+	default:
+		logger.Fatal(errors.Errorf("unknown store %s", storeType))
+	}
+
 	node, err := datanode.NewDataNode(baseDir, &datanode.Config{
-		RedisHost: redisHost,
-		RedisDB:   redisDB,
-		Host:      fmt.Sprintf("%s:%d", host, addr),
-	}, bp)
+		Host:   fmt.Sprintf("%s:%d", host, addr),
+		Labels: datanode.Labels{Zone: zone, Rack: rack, Host: machine, Tier: tier},
+
+		PackBelow:    packBelow,
+		SegmentSize:  segSize,
+		CompactRatio: ratio,
+	}, bp, store)
 	if err != nil {
 		panic(err)
 	}
 	go node.Live()
+	if packBelow > 0 && compact > 0 {
+		go node.StartCompaction(compact)
+	}
 
 	dataController := api.NewData(node)
 	metaController := api.NewMeta(node)
 	healthController := api.NewHealth()
 	metricsController := api.NewMetrics()
+	statController := api.NewStat(node)
+	inventoryController := api.NewInventory(node)
+	segmentController := api.NewSegment(node)
 
 	app = http.NewApplication()
 	app.Mount(
@@ -63,6 +124,9 @@ func main() {
 		metaController,
 		healthController,
 		metricsController,
+		statController,
+		inventoryController,
+		segmentController,
 	)
 
 	sigs := make(chan os.Signal, 1)
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/pkg/errors"
+	"github.com/qwp0905/go-object-storage/internal/kv"
+	"github.com/qwp0905/go-object-storage/internal/locker"
+	"github.com/qwp0905/go-object-storage/internal/maintenance"
+	"github.com/qwp0905/go-object-storage/internal/nodepool"
+	"github.com/qwp0905/go-object-storage/internal/trie"
+	"github.com/qwp0905/go-object-storage/pkg/logger"
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	storeType string
+	namenodes string
+	redisHost string
+	redisDb   int
+	repair    bool
+	grace     time.Duration
+	logLevel  string
+)
+
+func main() {
+	flag.StringVar(&storeType, "store", "redis", "cluster metadata store (redis, raft)")
+	flag.StringVar(&namenodes, "namenodes", "", "comma separated host:port of the peer listeners of namenodes with raft store, or of the single namenode on redis to lock through")
+	flag.StringVar(&redisHost, "redis", "localhost:6379", "redis host")
+	flag.IntVar(&redisDb, "db", 1, "redis db")
+	flag.BoolVar(&repair, "repair", false, "repair found issues")
+	flag.DurationVar(&grace, "grace", time.Hour, "files younger than this are never reported as orphans")
+	flag.StringVar(&logLevel, "log-level", "info", "log level")
+
+	flag.Parse()
+
+	logger.Config(logLevel)
+
+	var store kv.Store
+	var lockerPool locker.LockerPool
+	switch storeType {
+	case "redis":
+		rc := redis.NewClient(&redis.Options{Addr: redisHost, DB: redisDb})
+		store = kv.NewRedis(rc)
+		if namenodes != "" {
+			// a single namenode keeps its locks in process.
+			lockerPool = locker.NewStorePool(kv.NewRemote(strings.Split(namenodes, ",")), time.Second*30)
+			break
+		}
+		lp, err := locker.NewPool(rc, time.Second*30)
+		if err != nil {
+			panic(err)
+		}
+		lockerPool = lp
+	case "raft":
+		remote := kv.NewRemote(strings.Split(namenodes, ","))
+		store = remote
+		lockerPool = locker.NewStorePool(remote, time.Second*30)
+	default:
+		logger.Fatal(errors.Errorf("unknown store %s", storeType))
+	}
+
+	nodePool := nodepool.NewNodePool(store, &nodepool.Config{CacheSize: nodepool.DefaultCacheSize})
+	checker := maintenance.NewChecker(
+		nodePool,
+		lockerPool,
+		trie.NewWalker(nodePool, lockerPool),
+		&maintenance.FsckConfig{Repair: repair, Grace: grace},
+	)
+
+	report, err := checker.Check(context.Background())
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		logger.Fatal(err)
+	}
+
+	if !report.Clean() {
+		os.Exit(1)
+	}
+}
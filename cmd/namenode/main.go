@@ -2,11 +2,21 @@ package main
 
 import (
 	"flag"
+	"os"
+	"strings"
+	"time"
 
+	"github.com/pkg/errors"
 	"github.com/qwp0905/go-object-storage/api"
+	"github.com/qwp0905/go-object-storage/internal/bufferpool"
+	"github.com/qwp0905/go-object-storage/internal/compression"
+	"github.com/qwp0905/go-object-storage/internal/encryption"
 	"github.com/qwp0905/go-object-storage/internal/http"
+	"github.com/qwp0905/go-object-storage/internal/kv"
+	"github.com/qwp0905/go-object-storage/internal/locker"
 	"github.com/qwp0905/go-object-storage/internal/namenode"
 	"github.com/qwp0905/go-object-storage/internal/nodepool"
+	"github.com/qwp0905/go-object-storage/internal/replication"
 	"github.com/qwp0905/go-object-storage/pkg/logger"
 	"github.com/redis/go-redis/v9"
 )
@@ -14,36 +24,206 @@ import (
 var app http.Application
 
 var (
-	addr      uint
-	redisHost string
-	redisDb   int
-	logLevel  string
+	addr        uint
+	peerAddr    uint
+	storeType   string
+	redisHost   string
+	redisDb     int
+	advertise   string
+	peers       string
+	namenodes   int
+	raftDir     string
+	concurrency string
+	cacheSize   int
+	metaCache   int
+	metaLease   time.Duration
+	keyFile     string
+	encrypt     bool
+	compressKey string
+	compressTyp string
+	dedup       bool
+	chunkAbove  uint
+	chunkSize   uint
+	logLevel    string
 )
 
+// master keys can also be given in this environment variable.
+const masterKeyEnv = "OBJECT_STORAGE_MASTER_KEYS"
+
 func main() {
 	flag.UintVar(&addr, "addr", 8080, "application addr")
+	flag.UintVar(&peerAddr, "peer-addr", 8081, "port serving raft and the kv store to other namenodes and datanodes, keep it off the public network")
+	flag.StringVar(&storeType, "store", "redis", "cluster metadata store (redis, raft)")
 	flag.StringVar(&redisHost, "redis", "localhost:6379", "redis host")
 	flag.IntVar(&redisDb, "db", 1, "redis db")
+	flag.StringVar(&advertise, "advertise", "", "host:port of the peer listener of this namenode reachable by other namenodes")
+	flag.StringVar(&peers, "peers", "", "comma separated host:port of the peer listeners of other namenodes")
+	flag.IntVar(&namenodes, "namenodes", 0, "number of namenodes sharing the cluster store, locks are kept in process when there is one (default 1, or 1 + the number of peers with raft store)")
+	flag.StringVar(&raftDir, "raft-dir", "/var/lib/namenode", "directory to persist raft log")
+	flag.StringVar(&concurrency, "concurrency", "lock", "metadata concurrency control (lock, optimistic)")
+	flag.IntVar(&cacheSize, "cache-size", nodepool.DefaultCacheSize, "number of metadata locations cached")
+	flag.IntVar(&metaCache, "meta-cache-size", 1024, "number of decoded metadata cached for reads (0 disables)")
+	flag.DurationVar(&metaLease, "meta-cache-lease", time.Second, "how long cached metadata is served before revalidating")
+	flag.StringVar(&keyFile, "master-key-file", "", "file of <id>=<base64 key> lines, the last key wraps new data keys")
+	flag.BoolVar(&encrypt, "encrypt", false, "encrypt every object, not only those asking for it")
+	flag.StringVar(&compressKey, "compress-prefixes", "", "comma separated <prefix>=<zstd|gzip> of keys to compress")
+	flag.StringVar(&compressTyp, "compress-types", "", "comma separated <content type>=<zstd|gzip> of objects to compress, text/ matches every text type")
+	flag.BoolVar(&dedup, "dedup", false, "share the data of objects with the same content, encrypted objects are never shared")
+	flag.UintVar(&chunkAbove, "chunk-threshold", 0, "objects storing more than this many bytes are split into chunks on different datanodes, 0 disables")
+	flag.UintVar(&chunkSize, "chunk-size", 8*bufferpool.MB, "bytes of each chunk of a split object")
 	flag.StringVar(&logLevel, "log-level", "info", "log level")
 
 	flag.Parse()
 
 	logger.Config(logLevel)
 
-	rc := redis.NewClient(&redis.Options{Addr: redisHost, DB: redisDb})
-	nodePool := nodepool.NewNodePool(rc)
-	nameNode, err := namenode.New(nodePool, rc)
-	if err != nil {
-		panic(err)
+	controllers := []api.Controller{api.NewHealth(), api.NewMetrics()}
+	// raft and the kv store do not check the caller, they are served on their own port.
+	peerControllers := []api.Controller{}
+
+	peerList := splitHosts(peers)
+	if storeType == "raft" {
+		if namenodes == 0 {
+			namenodes = 1 + len(peerList)
+		} else if namenodes != 1+len(peerList) {
+			logger.Fatal(errors.Errorf("%d namenodes given but %d peers", namenodes, len(peerList)))
+		}
+	} else if namenodes == 0 {
+		namenodes = 1
+	}
+	if namenodes < 1 {
+		logger.Fatal(errors.Errorf("namenodes must be positive, got %d", namenodes))
+	}
+	// a single namenode is the only one taking locks, whatever the store.
+	var local kv.LockStore
+	if namenodes == 1 {
+		logger.Info("running as a single namenode, locks are kept in process")
+		local = kv.NewLocal()
 	}
 
-	healthController := api.NewHealth()
-	apiController := api.NewNameNode(nameNode)
-	metricsController := api.NewMetrics()
+	var store kv.Store
+	var lockerPool locker.LockerPool
+	switch storeType {
+	case "redis":
+		rc := redis.NewClient(&redis.Options{Addr: redisHost, DB: redisDb})
+		store = kv.NewRedis(rc)
+		if local != nil {
+			if peerAddr == addr {
+				logger.Fatal(errors.Errorf("peer addr must differ from addr %d", addr))
+			}
+			// the pool manager and fsck lock through the peer listener.
+			lockerPool = locker.NewStorePool(local, time.Second*30)
+			peerControllers = append(peerControllers, api.NewKV(kv.WithLocks(store, local)))
+			break
+		}
+		lp, err := locker.NewPool(rc, time.Second*30)
+		if err != nil {
+			panic(err)
+		}
+		lockerPool = lp
+	case "raft":
+		if len(peerList) > 0 && advertise == "" {
+			logger.Fatal(errors.New("advertise is required to run with peers"))
+		}
+		if peerAddr == addr {
+			logger.Fatal(errors.Errorf("peer addr must differ from addr %d", addr))
+		}
+		logStore, err := replication.NewLogStore(raftDir)
+		if err != nil {
+			panic(err)
+		}
+		sm := kv.NewStateMachine()
+		cluster, err := replication.NewCluster(&replication.Config{
+			Addr:  advertise,
+			Peers: peerList,
+		}, logStore, replication.NewHttpTransport(), sm)
+		if err != nil {
+			panic(err)
+		}
+		replicated := kv.NewReplicated(sm, cluster)
+		if local != nil {
+			replicated = kv.WithLocks(replicated, local)
+			lockerPool = locker.NewStorePool(local, time.Second*30)
+		} else {
+			lockerPool = locker.NewStorePool(replicated, time.Second*30)
+		}
+		store = replicated
+		peerControllers = append(peerControllers, api.NewReplication(cluster), api.NewKV(replicated))
+		cluster.Start()
+	default:
+		logger.Fatal(errors.Errorf("unknown store %s", storeType))
+	}
+
+	if cacheSize <= 0 {
+		logger.Fatal(errors.Errorf("cache size must be positive, got %d", cacheSize))
+	}
+	nodePool := nodepool.NewNodePool(store, &nodepool.Config{
+		CacheSize:         cacheSize,
+		MetadataCacheSize: metaCache,
+		MetadataLease:     metaLease,
+	})
+	mode := namenode.Concurrency(concurrency)
+	if mode != namenode.ConcurrencyLock && mode != namenode.ConcurrencyOptimistic {
+		logger.Fatal(errors.Errorf("unknown concurrency %s", concurrency))
+	}
+	if chunkAbove > 0 && chunkSize == 0 {
+		logger.Fatal(errors.New("chunk size must be positive"))
+	}
+	config := &namenode.Config{
+		Concurrency:      mode,
+		EncryptByDefault: encrypt,
+		Dedup:            dedup,
+		ChunkThreshold:   chunkAbove,
+		ChunkSize:        chunkSize,
+	}
+	if keyFile != "" || os.Getenv(masterKeyEnv) != "" {
+		keys, err := encryption.LoadKeys(keyFile, masterKeyEnv)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		config.Keys = keys
+	} else if encrypt {
+		logger.Fatal(errors.New("encrypting every object needs a master key"))
+	}
+	if compressKey != "" || compressTyp != "" {
+		policy, err := compression.NewPolicy(compressKey, compressTyp)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		config.Compression = policy
+	}
+	nameNode := namenode.New(nodePool, lockerPool, config)
+
+	controllers = append(
+		controllers,
+		api.NewNameNode(nameNode),
+		api.NewJobs(namenode.NewJobs(nameNode, store)),
+		api.NewTopology(nodePool),
+	)
+
+	if len(peerControllers) > 0 {
+		peer := http.NewApplication()
+		peer.Mount(peerControllers...)
+		go func() {
+			if err := peer.Listen(peerAddr); err != nil {
+				logger.Fatal(err)
+			}
+		}()
+	}
 
 	app = http.NewApplication()
-	app.Mount(healthController, apiController, metricsController)
+	app.Mount(controllers...)
 	if err := app.Listen(addr); err != nil {
 		panic(err)
 	}
 }
+
+func splitHosts(hosts string) []string {
+	out := make([]string, 0)
+	for _, host := range strings.Split(hosts, ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			out = append(out, host)
+		}
+	}
+	return out
+}
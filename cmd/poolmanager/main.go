@@ -2,26 +2,55 @@ package main
 
 import (
 	"flag"
+	"strings"
+	"time"
 
+	"github.com/pkg/errors"
 	"github.com/qwp0905/go-object-storage/api"
+	"github.com/qwp0905/go-object-storage/internal/bufferpool"
 	"github.com/qwp0905/go-object-storage/internal/http"
+	"github.com/qwp0905/go-object-storage/internal/kv"
+	"github.com/qwp0905/go-object-storage/internal/locker"
+	"github.com/qwp0905/go-object-storage/internal/maintenance"
+	"github.com/qwp0905/go-object-storage/internal/namenode"
 	"github.com/qwp0905/go-object-storage/internal/nodepool"
+	"github.com/qwp0905/go-object-storage/internal/trie"
 	"github.com/qwp0905/go-object-storage/pkg/logger"
 	"github.com/redis/go-redis/v9"
 )
 
 var (
-	addr      uint
-	redisHost string
-	redisDb   int
-	sec       int
-	logLevel  string
+	addr          uint
+	storeType     string
+	namenodes     string
+	redisHost     string
+	redisDb       int
+	sec           int
+	drainInterval int
+	rebalanceSec  int
+	threshold     float64
+	bandwidth     int
+	gcSec         int
+	gcGrace       time.Duration
+	lifecycleSec  int
+	concurrency   string
+	logLevel      string
 )
 
 func main() {
+	flag.StringVar(&storeType, "store", "redis", "cluster metadata store (redis, raft)")
+	flag.StringVar(&namenodes, "namenodes", "", "comma separated host:port of the peer listeners of namenodes with raft store, or of the single namenode on redis to lock through")
 	flag.StringVar(&redisHost, "redis", "localhost:6379", "redis host")
 	flag.IntVar(&redisDb, "db", 1, "redis db")
 	flag.IntVar(&sec, "interval", 30, "interval to check health")
+	flag.IntVar(&drainInterval, "drain-interval", 60, "interval to move data out of decommissioning nodes")
+	flag.IntVar(&rebalanceSec, "rebalance-interval", 0, "interval to rebalance datanodes, 0 to disable")
+	flag.Float64Var(&threshold, "rebalance-threshold", 0.1, "allowed utilization deviation from cluster mean")
+	flag.IntVar(&bandwidth, "rebalance-bandwidth", 10, "rebalance bandwidth in mb/s, 0 for unlimited")
+	flag.IntVar(&gcSec, "gc-interval", 3600, "interval to collect orphaned objects, 0 to disable")
+	flag.DurationVar(&gcGrace, "gc-grace", time.Hour, "orphaned objects younger than this are kept")
+	flag.IntVar(&lifecycleSec, "lifecycle-interval", 3600, "interval to expire objects by lifecycle rules, 0 to disable")
+	flag.StringVar(&concurrency, "concurrency", "lock", "metadata concurrency control of namenodes (lock, optimistic)")
 	flag.UintVar(&addr, "addr", 8080, "listen addr")
 	flag.StringVar(&logLevel, "log-level", "info", "log level")
 
@@ -29,15 +58,84 @@ func main() {
 
 	logger.Config(logLevel)
 
-	rc := redis.NewClient(&redis.Options{Addr: redisHost, DB: redisDb})
-	manager := nodepool.NewPoolManager(rc)
+	var store kv.Store
+	var lockerPool locker.LockerPool
+	switch storeType {
+	case "redis":
+		rc := redis.NewClient(&redis.Options{Addr: redisHost, DB: redisDb})
+		store = kv.NewRedis(rc)
+		if namenodes != "" {
+			// a single namenode keeps its locks in process.
+			lockerPool = locker.NewStorePool(kv.NewRemote(strings.Split(namenodes, ",")), time.Second*30)
+			break
+		}
+		lp, err := locker.NewPool(rc, time.Second*30)
+		if err != nil {
+			panic(err)
+		}
+		lockerPool = lp
+	case "raft":
+		remote := kv.NewRemote(strings.Split(namenodes, ","))
+		store = remote
+		lockerPool = locker.NewStorePool(remote, time.Second*30)
+	default:
+		logger.Fatal(errors.Errorf("unknown store %s", storeType))
+	}
+
+	manager := nodepool.NewPoolManager(store)
 	go manager.Start(sec)
 
+	nodePool := nodepool.NewNodePool(store, &nodepool.Config{CacheSize: nodepool.DefaultCacheSize})
+	walker := trie.NewWalker(nodePool, lockerPool)
+	mover := trie.NewMover(nodePool, lockerPool)
+
+	decommissioner := maintenance.NewDecommissioner(nodePool, walker, mover)
+	go decommissioner.Start(drainInterval)
+
+	rebalancer := maintenance.NewRebalancer(nodePool, walker, mover, &maintenance.RebalanceConfig{
+		Threshold: threshold,
+		Bandwidth: bandwidth * bufferpool.MB,
+	})
+	if rebalanceSec > 0 {
+		go rebalancer.Start(rebalanceSec)
+	}
+
+	collector := maintenance.NewCollector(nodePool, walker, &maintenance.GCConfig{Grace: gcGrace})
+	if gcSec > 0 {
+		go collector.Start(gcSec)
+	}
+
+	mode := namenode.Concurrency(concurrency)
+	if mode != namenode.ConcurrencyLock && mode != namenode.ConcurrencyOptimistic {
+		logger.Fatal(errors.Errorf("unknown concurrency %s", concurrency))
+	}
+	lifecycle := maintenance.NewLifecycle(
+		store,
+		nodePool,
+		walker,
+		mover,
+		namenode.New(nodePool, lockerPool, &namenode.Config{Concurrency: mode}),
+	)
+	if lifecycleSec > 0 {
+		go lifecycle.Start(lifecycleSec)
+	}
+
 	healthController := api.NewHealth()
 	metricsController := api.NewMetrics()
+	nodeController := api.NewNode(nodePool, decommissioner)
+	rebalanceController := api.NewRebalance(rebalancer)
+	gcController := api.NewGC(collector)
+	lifecycleController := api.NewLifecycle(lifecycle)
 
 	app := http.NewApplication()
-	app.Mount(healthController, metricsController)
+	app.Mount(
+		healthController,
+		metricsController,
+		nodeController,
+		rebalanceController,
+		gcController,
+		lifecycleController,
+	)
 
 	if err := app.Listen(addr); err != nil {
 		panic(err)
@@ -73,11 +73,9 @@ func (p *bufferPoolImpl) Get(key string) (io.Reader, error) {
 
 func (p *bufferPoolImpl) Put(key string, size int, r io.Reader) error {
 	if !p.isAllowed(size) {
-		if _, err := p.fs.WriteFile(key, r); err != nil {
-			return err
-		}
 		p.table.deallocate(key)
-		return nil
+		_, err := p.fs.WriteFile(key, r)
+		return err
 	}
 
 	if err := p.acquire(size); err != nil {
@@ -95,7 +93,7 @@ func (p *bufferPoolImpl) Put(key string, size int, r io.Reader) error {
 }
 
 func (p *bufferPoolImpl) Delete(key string) error {
-	defer p.table.deallocate(key)
+	p.table.deallocate(key)
 	return p.fs.RemoveFile(key)
 }
 
@@ -110,21 +108,16 @@ func (p *bufferPoolImpl) BeforeDestroy(sig <-chan os.Signal, done chan struct{})
 
 func (p *bufferPoolImpl) flushAll() error {
 	for _, page := range p.table.toList() {
-		if !page.isDirty() {
-			continue
-		}
-		if _, err := p.fs.WriteFile(page.key, page.getData()); err != nil {
+		if err := page.flush(p.fs); err != nil {
 			return err
 		}
-		page.clearDirty()
 	}
 	return nil
 }
 
 func (p *bufferPoolImpl) lazyWrite(pg *page) {
 	for i := 0; i < p.retry; i++ {
-		if _, err := p.fs.WriteFile(pg.key, pg.getData()); err == nil {
-			pg.clearDirty()
+		if err := pg.flush(p.fs); err == nil {
 			return
 		}
 	}
@@ -6,6 +6,7 @@ import (
 	"sync"
 
 	"github.com/pkg/errors"
+	"github.com/qwp0905/go-object-storage/internal/filesystem"
 	"github.com/qwp0905/go-object-storage/pkg/list"
 	"github.com/qwp0905/go-object-storage/pkg/nocopy"
 )
@@ -40,29 +41,32 @@ func (bp *page) getSize() int {
 	return len(bp.data)
 }
 
-func (bp *page) isDirty() bool {
-	bp.mu.RLock()
-	defer bp.mu.RUnlock()
-	return bp.dirty
-}
-
 func (bp *page) setDirty() {
 	bp.mu.Lock()
 	defer bp.mu.Unlock()
 	bp.dirty = true
 }
 
-func (bp *page) clearDirty() {
+func (bp *page) clear() {
 	bp.mu.Lock()
 	defer bp.mu.Unlock()
+	bp.data = nil
+	bp.lastAccess = nil
 	bp.dirty = false
 }
 
-func (bp *page) clear() {
+func (bp *page) flush(fs filesystem.FileSystem) error {
 	bp.mu.Lock()
 	defer bp.mu.Unlock()
-	bp.data = nil
-	bp.lastAccess = nil
+	if !bp.dirty {
+		return nil
+	}
+
+	if _, err := fs.WriteFile(bp.key, bytes.NewReader(bp.data)); err != nil {
+		return err
+	}
+	bp.dirty = false
+	return nil
 }
 
 func (bp *page) putData(r io.Reader) error {
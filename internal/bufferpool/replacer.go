@@ -14,10 +14,8 @@ func (p *bufferPoolImpl) victim(size int) error {
 	}
 
 	page := p.table.oldest()
-	if page.isDirty() {
-		if _, err := p.fs.WriteFile(page.key, page.getData()); err != nil {
-			return err
-		}
+	if err := page.flush(p.fs); err != nil {
+		return err
 	}
 
 	s := page.getSize()
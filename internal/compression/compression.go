@@ -0,0 +1,152 @@
+package compression
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"strings"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+)
+
+const (
+	Zstd = "zstd"
+	Gzip = "gzip"
+)
+
+var ErrUnknownCodec = errors.New("unknown compression codec")
+
+func Valid(codec string) bool {
+	return codec == Zstd || codec == Gzip
+}
+
+// the encoder is safe for concurrent use with EncodeAll.
+var encoder, _ = zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedDefault))
+
+// Compress returns data compressed with codec.
+func Compress(codec string, data []byte) ([]byte, error) {
+	switch codec {
+	case Zstd:
+		return encoder.EncodeAll(data, make([]byte, 0, len(data)/2)), nil
+	case Gzip:
+		buf := bytes.NewBuffer(make([]byte, 0, len(data)/2))
+		w := gzip.NewWriter(buf)
+		if _, err := w.Write(data); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if err := w.Close(); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		return buf.Bytes(), nil
+	}
+	return nil, errors.WithStack(ErrUnknownCodec)
+}
+
+// NewReader returns the decompressed data of r.
+func NewReader(codec string, r io.Reader) (io.Reader, error) {
+	switch codec {
+	case Zstd:
+		d, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		return &decoder{r: d.IOReadCloser()}, nil
+	case Gzip:
+		d, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		return &decoder{r: d}, nil
+	}
+	return nil, errors.WithStack(ErrUnknownCodec)
+}
+
+// decoder releases the decompressor once the data is read.
+type decoder struct {
+	r io.ReadCloser
+}
+
+func (d *decoder) Read(p []byte) (int, error) {
+	n, err := d.r.Read(p)
+	if err != nil {
+		d.r.Close()
+		if err != io.EOF {
+			err = errors.WithStack(err)
+		}
+	}
+	return n, err
+}
+
+type rule struct {
+	match string
+	codec string
+}
+
+// Policy picks the codec of new objects by key prefix first and by content
+// type otherwise. the longest match wins.
+type Policy struct {
+	prefixes []rule
+	types    []rule
+}
+
+// NewPolicy parses comma separated <prefix>=<codec> and <content type>=<codec>
+// rules. a content type rule like text/ matches every text type.
+func NewPolicy(prefixes, types string) (*Policy, error) {
+	p := new(Policy)
+	var err error
+	if p.prefixes, err = parseRules(prefixes); err != nil {
+		return nil, err
+	}
+	if p.types, err = parseRules(types); err != nil {
+		return nil, err
+	}
+	for i := range p.types {
+		p.types[i].match = strings.ToLower(p.types[i].match)
+	}
+	return p, nil
+}
+
+func parseRules(s string) ([]rule, error) {
+	out := make([]rule, 0)
+	for _, r := range strings.Split(s, ",") {
+		if r = strings.TrimSpace(r); r == "" {
+			continue
+		}
+		match, codec, ok := strings.Cut(r, "=")
+		codec = strings.ToLower(strings.TrimSpace(codec))
+		if !ok || strings.TrimSpace(match) == "" {
+			return nil, errors.Errorf("compression rule must be <match>=<codec>, got %s", r)
+		}
+		if !Valid(codec) {
+			return nil, errors.Wrapf(ErrUnknownCodec, "compression rule %s", r)
+		}
+		out = append(out, rule{match: strings.TrimSpace(match), codec: codec})
+	}
+	return out, nil
+}
+
+// Codec returns the codec of an object, empty when it is kept as is.
+func (p *Policy) Codec(key, contentType string) string {
+	if p == nil {
+		return ""
+	}
+	if codec := longest(p.prefixes, key); codec != "" {
+		return codec
+	}
+	if media, _, err := mime.ParseMediaType(contentType); err == nil {
+		contentType = media
+	}
+	return longest(p.types, strings.ToLower(contentType))
+}
+
+func longest(rules []rule, s string) string {
+	codec, length := "", 0
+	for _, r := range rules {
+		if len(r.match) > length && strings.HasPrefix(s, r.match) {
+			codec, length = r.codec, len(r.match)
+		}
+	}
+	return codec
+}
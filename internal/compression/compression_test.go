@@ -0,0 +1,98 @@
+package compression
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestRoundTrip(t *testing.T) {
+	for _, codec := range []string{Zstd, Gzip} {
+		for _, data := range [][]byte{
+			{},
+			[]byte("a"),
+			[]byte(strings.Repeat("compressible ", 10000)),
+		} {
+			compressed, err := Compress(codec, data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r, err := NewReader(codec, bytes.NewReader(compressed))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Fatalf("%s does not round trip %d bytes", codec, len(data))
+			}
+		}
+	}
+
+	if _, err := Compress("lz4", nil); !errors.Is(err, ErrUnknownCodec) {
+		t.Fatalf("compressed with an unknown codec: %v", err)
+	}
+	if _, err := NewReader("lz4", nil); !errors.Is(err, ErrUnknownCodec) {
+		t.Fatalf("decompressed with an unknown codec: %v", err)
+	}
+}
+
+func TestCorrupted(t *testing.T) {
+	for _, codec := range []string{Zstd, Gzip} {
+		compressed, err := Compress(codec, []byte(strings.Repeat("compressible ", 1000)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := NewReader(codec, bytes.NewReader(compressed[:len(compressed)/2]))
+		if err != nil {
+			continue
+		}
+		if _, err := io.ReadAll(r); err == nil {
+			t.Fatalf("%s read truncated data", codec)
+		}
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	p, err := NewPolicy("/logs/=zstd, /logs/archive/=gzip", "text/=gzip,application/json=ZSTD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct{ key, contentType, codec string }{
+		{"/logs/a", "", Zstd},
+		{"/logs/archive/a", "", Gzip},
+		// prefixes win over content types.
+		{"/logs/a", "text/plain", Zstd},
+		{"/a", "text/plain", Gzip},
+		{"/a", "Text/CSV; charset=utf-8", Gzip},
+		{"/a", "application/json", Zstd},
+		{"/a", "image/png", ""},
+		{"/a", "", ""},
+		{"/log", "", ""},
+	} {
+		if codec := p.Codec(c.key, c.contentType); codec != c.codec {
+			t.Fatalf("%s of %s is compressed by %q, want %q", c.key, c.contentType, codec, c.codec)
+		}
+	}
+
+	var none *Policy
+	if codec := none.Codec("/logs/a", "text/plain"); codec != "" {
+		t.Fatalf("no policy compresses by %s", codec)
+	}
+}
+
+func TestPolicyInvalid(t *testing.T) {
+	for _, rules := range []string{"/a", "=zstd", "/a=lz4"} {
+		if _, err := NewPolicy(rules, ""); err == nil {
+			t.Fatalf("%s is parsed", rules)
+		}
+		if _, err := NewPolicy("", rules); err == nil {
+			t.Fatalf("%s is parsed", rules)
+		}
+	}
+}
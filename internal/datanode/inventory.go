@@ -0,0 +1,36 @@
+package datanode
+
+import (
+	"encoding/base64"
+	"fmt"
+
+	"github.com/qwp0905/go-object-storage/internal/filesystem"
+)
+
+func (d *dataNodeImpl) ListMetadata() ([]*filesystem.FileInfo, error) {
+	files, err := filesystem.List(fmt.Sprintf("%s/meta", d.basedir))
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]*filesystem.FileInfo, 0, len(files))
+	for _, file := range files {
+		key, err := base64.StdEncoding.DecodeString(file.Name)
+		if err != nil {
+			continue
+		}
+		file.Name = string(key)
+		out = append(out, file)
+	}
+
+	return out, nil
+}
+
+// packed objects are listed along with the files.
+func (d *dataNodeImpl) ListObjects() ([]*filesystem.FileInfo, error) {
+	files, err := filesystem.List(fmt.Sprintf("%s/object", d.basedir))
+	if err != nil || d.segments == nil {
+		return files, err
+	}
+	return append(files, d.segments.List()...), nil
+}
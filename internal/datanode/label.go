@@ -0,0 +1,31 @@
+package datanode
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/qwp0905/go-object-storage/internal/metadata"
+)
+
+func LabelKey(id string) string {
+	return fmt.Sprintf("LABEL:%s", id)
+}
+
+func IdFromLabelKey(key string) string {
+	return strings.TrimPrefix(key, "LABEL:")
+}
+
+type Labels struct {
+	Zone string `json:"zone"`
+	Rack string `json:"rack"`
+	Host string `json:"host"`
+	// storage class of objects the node holds, empty for standard.
+	Tier string `json:"tier,omitempty"`
+}
+
+func (l Labels) StorageClass() string {
+	if l.Tier == "" {
+		return metadata.StorageClassStandard
+	}
+	return l.Tier
+}
@@ -2,32 +2,75 @@ package datanode
 
 import (
 	"bytes"
+	"hash/fnv"
+	"io"
+	"sync"
+	"time"
 
-	"github.com/goccy/go-json"
+	"github.com/gofiber/fiber/v2"
 	"github.com/pkg/errors"
 	"github.com/qwp0905/go-object-storage/internal/metadata"
 )
 
+var (
+	ErrStaleToken      = fiber.NewError(fiber.StatusConflict, "stale fencing token")
+	ErrVersionMismatch = fiber.NewError(fiber.StatusPreconditionFailed, "metadata version mismatch")
+)
+
+func (d *dataNodeImpl) metaLock(key string) *sync.Mutex {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return &d.metaLocks[h.Sum32()%uint32(len(d.metaLocks))]
+}
+
 func (d *dataNodeImpl) GetMetadata(key string) (*metadata.Metadata, error) {
 	r, err := d.bp.Get(d.getMetaKey(key))
 	if err != nil {
 		return nil, err
 	}
-	metadata := new(metadata.Metadata)
-	if err := json.NewDecoder(r).Decode(metadata); err != nil {
+	b, err := io.ReadAll(r)
+	if c, ok := r.(io.Closer); ok {
+		c.Close()
+	}
+	if err != nil {
 		return nil, errors.WithStack(err)
 	}
 
-	return metadata, nil
+	return metadata.Unmarshal(b)
 }
 
-func (d *dataNodeImpl) PutMetadata(metadata *metadata.Metadata) error {
-	b, err := json.Marshal(metadata)
-	if err != nil {
-		return errors.WithStack(err)
+func (d *dataNodeImpl) PutMetadata(meta *metadata.Metadata) error {
+	return d.putMetadata(meta, nil)
+}
+
+func (d *dataNodeImpl) CompareAndPutMetadata(meta *metadata.Metadata, version uint64) error {
+	return d.putMetadata(meta, &version)
+}
+
+func (d *dataNodeImpl) putMetadata(meta *metadata.Metadata, expected *uint64) error {
+	mu := d.metaLock(meta.Key)
+	mu.Lock()
+	defer mu.Unlock()
+
+	current, err := d.GetMetadata(meta.Key)
+	if err != nil && !errors.Is(err, fiber.ErrNotFound) {
+		return err
 	}
 
-	if err := d.bp.Put(d.getMetaKey(metadata.Key), len(b), bytes.NewReader(b)); err != nil {
+	version := uint64(0)
+	if err == nil {
+		if current.Fence > meta.Fence {
+			return errors.WithStack(ErrStaleToken)
+		}
+		version = current.Version
+	}
+	if expected != nil && *expected != version {
+		return errors.WithStack(ErrVersionMismatch)
+	}
+	meta.Version = nextVersion(version)
+
+	b := metadata.Marshal(meta)
+	if err := d.bp.Put(d.getMetaKey(meta.Key), len(b), bytes.NewReader(b)); err != nil {
 		return err
 	}
 
@@ -37,3 +80,30 @@ func (d *dataNodeImpl) PutMetadata(metadata *metadata.Metadata) error {
 func (d *dataNodeImpl) DeleteMetadata(key string) error {
 	return d.bp.Delete(d.getMetaKey(key))
 }
+
+func (d *dataNodeImpl) CompareAndDeleteMetadata(key string, version uint64) error {
+	mu := d.metaLock(key)
+	mu.Lock()
+	defer mu.Unlock()
+
+	current, err := d.GetMetadata(key)
+	if errors.Is(err, fiber.ErrNotFound) {
+		return errors.WithStack(ErrVersionMismatch)
+	} else if err != nil {
+		return err
+	}
+	if current.Version != version {
+		return errors.WithStack(ErrVersionMismatch)
+	}
+
+	return d.bp.Delete(d.getMetaKey(key))
+}
+
+// versions are seeded from the clock so that a key deleted and created again
+// never reuses a version an optimistic writer may still hold.
+func nextVersion(current uint64) uint64 {
+	if now := uint64(time.Now().UnixNano()); now > current {
+		return now
+	}
+	return current + 1
+}
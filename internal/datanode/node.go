@@ -7,16 +7,19 @@ import (
 	"io"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/goccy/go-json"
 	"github.com/google/uuid"
 	"github.com/pkg/errors"
 	"github.com/qwp0905/go-object-storage/internal/bufferpool"
 	"github.com/qwp0905/go-object-storage/internal/filesystem"
+	"github.com/qwp0905/go-object-storage/internal/kv"
 	"github.com/qwp0905/go-object-storage/internal/metadata"
+	"github.com/qwp0905/go-object-storage/internal/segment"
 	"github.com/qwp0905/go-object-storage/pkg/logger"
 	"github.com/qwp0905/go-object-storage/pkg/nocopy"
-	"github.com/redis/go-redis/v9"
 )
 
 func HostKey(id string) string {
@@ -30,28 +33,53 @@ func IdFromKey(key string) string {
 type DataNode interface {
 	GetMetadata(key string) (*metadata.Metadata, error)
 	PutMetadata(metadata *metadata.Metadata) error
+	CompareAndPutMetadata(metadata *metadata.Metadata, version uint64) error
 	DeleteMetadata(key string) error
+	CompareAndDeleteMetadata(key string, version uint64) error
 	GetObject(ctx context.Context, key string) (io.Reader, error)
+	GetObjectRange(ctx context.Context, key string, offset, length int64) (io.Reader, error)
 	PutObject(key string, size int, r io.Reader) error
 	DeleteObject(key string) error
+	DeleteObjects(keys []string) error
+	LinkObject(key string) error
+	CopyObject(key, to string) error
+	PurgeObject(key string) error
+	SegmentStat() (*segment.Stat, error)
+	Compact() (*segment.CompactResult, error)
+	StartCompaction(sec int)
+	Stat() (*filesystem.Usage, error)
+	ListMetadata() ([]*filesystem.FileInfo, error)
+	ListObjects() ([]*filesystem.FileInfo, error)
 	Live()
 }
 
 type dataNodeImpl struct {
-	noCopy nocopy.NoCopy
-	bp     bufferpool.BufferPool
-	config *Config
-	rc     *redis.Client
-	id     string
+	noCopy    nocopy.NoCopy
+	bp        bufferpool.BufferPool
+	config    *Config
+	store     kv.Store
+	id        string
+	basedir   string
+	metaLocks [64]sync.Mutex
+	refLocks  [64]sync.Mutex
+	segments  segment.Store
 }
 
 type Config struct {
-	Host      string
-	RedisHost string
-	RedisDB   int
+	Host   string
+	Labels Labels
+	// objects smaller than this are packed into segment files, none when zero.
+	PackBelow    int
+	SegmentSize  int64
+	CompactRatio float64
 }
 
-func NewDataNode(basedir string, cfg *Config, bp bufferpool.BufferPool) (DataNode, error) {
+func NewDataNode(
+	basedir string,
+	cfg *Config,
+	bp bufferpool.BufferPool,
+	store kv.Store,
+) (DataNode, error) {
 	id, err := ensureId(basedir)
 	if err != nil {
 		return nil, err
@@ -61,12 +89,23 @@ func NewDataNode(basedir string, cfg *Config, bp bufferpool.BufferPool) (DataNod
 		return nil, err
 	}
 
-	return &dataNodeImpl{
-		bp:     bp,
-		config: cfg,
-		rc:     redis.NewClient(&redis.Options{Addr: cfg.RedisHost, DB: cfg.RedisDB}),
-		id:     id,
-	}, nil
+	d := &dataNodeImpl{
+		bp:      bp,
+		config:  cfg,
+		store:   store,
+		id:      id,
+		basedir: basedir,
+	}
+	if cfg.PackBelow > 0 {
+		d.segments, err = segment.Open(
+			fmt.Sprintf("%s/segment", basedir),
+			&segment.Config{SegmentSize: cfg.SegmentSize},
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
 }
 
 func (d *dataNodeImpl) getMetaKey(key string) string {
@@ -92,6 +131,9 @@ func ensureDirs(base string) error {
 	if err := filesystem.EnsureDir(fmt.Sprintf("%s/object", base)); err != nil {
 		return err
 	}
+	if err := filesystem.EnsureDir(fmt.Sprintf("%s/ref", base)); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -122,6 +164,10 @@ func ensureId(base string) (string, error) {
 	return id, nil
 }
 
+func (n *dataNodeImpl) Stat() (*filesystem.Usage, error) {
+	return filesystem.Stat(n.basedir)
+}
+
 func (n *dataNodeImpl) Live() {
 	if err := n.register(); err != nil {
 		logger.Warnf("%+v", errors.WithStack(err))
@@ -134,10 +180,14 @@ func (n *dataNodeImpl) Live() {
 }
 
 func (n *dataNodeImpl) register() error {
-	return errors.WithStack(n.rc.SetEx(
-		context.Background(),
-		HostKey(n.id),
-		n.config.Host,
-		time.Hour,
-	).Err())
+	labels, err := json.Marshal(n.config.Labels)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	ctx := context.Background()
+	if err := n.store.Set(ctx, LabelKey(n.id), string(labels), time.Hour); err != nil {
+		return err
+	}
+	return n.store.Set(ctx, HostKey(n.id), n.config.Host, time.Hour)
 }
@@ -3,16 +3,105 @@ package datanode
 import (
 	"context"
 	"io"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pkg/errors"
+	"github.com/qwp0905/go-object-storage/internal/metadata"
 )
 
+var ErrCopyShared = fiber.NewError(fiber.StatusBadRequest, "content addressed objects are linked, not copied")
+
 func (d *dataNodeImpl) GetObject(ctx context.Context, key string) (io.Reader, error) {
-	return d.bp.Get(d.getDataKey(key))
+	return d.readData(key)
+}
+
+type rangeReader struct {
+	io.Reader
+	io.Closer
+}
+
+// GetObjectRange reads length bytes from offset, or up to the end when length
+// is negative.
+func (d *dataNodeImpl) GetObjectRange(ctx context.Context, key string, offset, length int64) (io.Reader, error) {
+	r, err := d.GetObject(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if s, ok := r.(io.Seeker); ok {
+		_, err = s.Seek(offset, io.SeekStart)
+	} else {
+		_, err = io.CopyN(io.Discard, r, offset)
+	}
+	if err != nil && err != io.EOF {
+		if c, ok := r.(io.Closer); ok {
+			c.Close()
+		}
+		return nil, errors.WithStack(err)
+	}
+
+	if length < 0 {
+		return r, nil
+	}
+	// the file is still closed once the limited reader is sent.
+	if c, ok := r.(io.Closer); ok {
+		return &rangeReader{io.LimitReader(r, length), c}, nil
+	}
+	return io.LimitReader(r, length), nil
 }
 
 func (d *dataNodeImpl) PutObject(key string, size int, r io.Reader) error {
-	return d.bp.Put(d.getDataKey(key), size, r)
+	if metadata.IsContentSource(key) {
+		return d.putShared(key, size, r)
+	}
+	return d.writeData(key, size, r)
 }
 
 func (d *dataNodeImpl) DeleteObject(key string) error {
-	return d.bp.Delete(key)
+	if metadata.IsContentSource(key) {
+		return d.deleteShared(key)
+	}
+	return d.removeData(key)
+}
+
+// DeleteObjects keeps going past failures and returns the first one.
+func (d *dataNodeImpl) DeleteObjects(keys []string) error {
+	var first error
+	for _, key := range keys {
+		if err := d.DeleteObject(key); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// CopyObject stores the data of key again as to, so writing either one later
+// never reaches the other.
+func (d *dataNodeImpl) CopyObject(key, to string) error {
+	if metadata.IsContentSource(key) || metadata.IsContentSource(to) {
+		return ErrCopyShared
+	}
+
+	r, err := d.readData(key)
+	if err != nil {
+		return err
+	}
+	if c, ok := r.(io.Closer); ok {
+		defer c.Close()
+	}
+
+	// data is read from a file, a segment or a page, all of which seek.
+	s, ok := r.(io.Seeker)
+	if !ok {
+		return errors.New("data is not seekable")
+	}
+	size, err := s.Seek(0, io.SeekEnd)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if _, err := s.Seek(0, io.SeekStart); err != nil {
+		return errors.WithStack(err)
+	}
+
+	return d.writeData(to, int(size), r)
 }
@@ -0,0 +1,154 @@
+package datanode
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"strconv"
+	"sync"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pkg/errors"
+	"github.com/qwp0905/go-object-storage/internal/metadata"
+	"github.com/qwp0905/go-object-storage/pkg/logger"
+)
+
+// ContentKey points at the datanode holding a content addressed source, it is
+// only a hint for linking new objects to it.
+func ContentKey(source string) string {
+	return fmt.Sprintf("CONTENT:%s", source)
+}
+
+var ErrNotShared = fiber.NewError(fiber.StatusBadRequest, "only content addressed objects can be linked")
+
+func (d *dataNodeImpl) refLock(key string) *sync.Mutex {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return &d.refLocks[h.Sum32()%uint32(len(d.refLocks))]
+}
+
+func (d *dataNodeImpl) getRefKey(key string) string {
+	return fmt.Sprintf("ref/%s", key)
+}
+
+// refs is the number of objects sharing a content addressed source, zero when
+// the data is not kept.
+func (d *dataNodeImpl) refs(key string) (uint64, error) {
+	r, err := d.bp.Get(d.getRefKey(key))
+	if errors.Is(err, fiber.ErrNotFound) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	b, err := io.ReadAll(r)
+	if c, ok := r.(io.Closer); ok {
+		c.Close()
+	}
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	count, err := strconv.ParseUint(string(b), 10, 64)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	return count, nil
+}
+
+func (d *dataNodeImpl) setRefs(key string, count uint64) error {
+	if count == 0 {
+		return d.bp.Delete(d.getRefKey(key))
+	}
+	b := strconv.AppendUint(nil, count, 10)
+	return d.bp.Put(d.getRefKey(key), len(b), bytes.NewReader(b))
+}
+
+// putShared only writes the data when no object shares it yet.
+func (d *dataNodeImpl) putShared(key string, size int, r io.Reader) error {
+	mu := d.refLock(key)
+	mu.Lock()
+	defer mu.Unlock()
+
+	count, err := d.refs(key)
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		if err := d.writeData(key, size, r); err != nil {
+			return err
+		}
+	} else if _, err := io.Copy(io.Discard, r); err != nil {
+		return errors.WithStack(err)
+	}
+	return d.setRefs(key, count+1)
+}
+
+// LinkObject adds a reference to data that is already kept.
+func (d *dataNodeImpl) LinkObject(key string) error {
+	if !metadata.IsContentSource(key) {
+		return ErrNotShared
+	}
+
+	mu := d.refLock(key)
+	mu.Lock()
+	defer mu.Unlock()
+
+	count, err := d.refs(key)
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return errors.WithStack(fiber.ErrNotFound)
+	}
+	return d.setRefs(key, count+1)
+}
+
+// deleteShared removes the data once the last reference is gone.
+func (d *dataNodeImpl) deleteShared(key string) error {
+	mu := d.refLock(key)
+	mu.Lock()
+	defer mu.Unlock()
+
+	count, err := d.refs(key)
+	if err != nil {
+		return err
+	}
+	if count > 1 {
+		return d.setRefs(key, count-1)
+	}
+	return d.removeShared(key)
+}
+
+// PurgeObject removes data whatever references it still counts, for objects
+// no metadata points at anymore.
+func (d *dataNodeImpl) PurgeObject(key string) error {
+	if !metadata.IsContentSource(key) {
+		return d.removeData(key)
+	}
+
+	mu := d.refLock(key)
+	mu.Lock()
+	defer mu.Unlock()
+	return d.removeShared(key)
+}
+
+// the count goes first, data left without one is written again by the next
+// upload or collected as an orphan.
+func (d *dataNodeImpl) removeShared(key string) error {
+	if err := d.setRefs(key, 0); err != nil {
+		return err
+	}
+	if err := d.removeData(key); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	if id, err := d.store.Get(ctx, ContentKey(key)); err == nil && id == d.id {
+		if err := d.store.Del(ctx, ContentKey(key)); err != nil {
+			logger.Warnf("%+v", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package datanode
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pkg/errors"
+	"github.com/qwp0905/go-object-storage/internal/bufferpool"
+	"github.com/qwp0905/go-object-storage/internal/filesystem"
+	"github.com/qwp0905/go-object-storage/internal/kv"
+	"github.com/qwp0905/go-object-storage/internal/metadata"
+)
+
+func newDataNode(t *testing.T) (*dataNodeImpl, kv.Store) {
+	t.Helper()
+	dir := t.TempDir()
+	store := kv.NewLocal()
+	bp := bufferpool.NewBufferPool(bufferpool.MB, filesystem.NewFileSystem(dir))
+	d, err := NewDataNode(dir, &Config{}, bp, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d.(*dataNodeImpl), store
+}
+
+func mustRefs(t *testing.T, d *dataNodeImpl, key string, want uint64) {
+	t.Helper()
+	count, err := d.refs(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != want {
+		t.Fatalf("%s counts %d refs, want %d", key, count, want)
+	}
+	_, err = d.GetObject(context.Background(), key)
+	if kept := err == nil; kept != (want > 0) {
+		t.Fatalf("%s is kept %t with %d refs: %v", key, kept, want, err)
+	}
+}
+
+func TestSharedRefs(t *testing.T) {
+	d, store := newDataNode(t)
+	ctx := context.Background()
+	key := metadata.ContentSource([]byte("data"))
+
+	if err := d.LinkObject(key); !errors.Is(err, fiber.ErrNotFound) {
+		t.Fatalf("linked data that is not kept: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := d.PutObject(key, 4, strings.NewReader("data")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := d.LinkObject(key); err != nil {
+		t.Fatal(err)
+	}
+	mustRefs(t, d, key, 3)
+	if err := store.Set(ctx, ContentKey(key), d.id, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	for want := uint64(2); ; want-- {
+		if err := d.DeleteObject(key); err != nil {
+			t.Fatal(err)
+		}
+		mustRefs(t, d, key, want)
+		if want == 0 {
+			break
+		}
+		r, err := d.GetObject(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b, _ := io.ReadAll(r); string(b) != "data" {
+			t.Fatalf("shared data is %q", b)
+		}
+	}
+	if _, err := store.Get(ctx, ContentKey(key)); err == nil {
+		t.Fatal("content hint is left after the last reference")
+	}
+}
+
+func TestPurgeShared(t *testing.T) {
+	d, _ := newDataNode(t)
+	key := metadata.ContentSource([]byte("data"))
+	for i := 0; i < 2; i++ {
+		if err := d.PutObject(key, 4, strings.NewReader("data")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := d.PurgeObject(key); err != nil {
+		t.Fatal(err)
+	}
+	mustRefs(t, d, key, 0)
+
+	if err := d.LinkObject("plain"); !errors.Is(err, ErrNotShared) {
+		t.Fatalf("linked data that is not content addressed: %v", err)
+	}
+	if err := d.CopyObject(key, "copy"); !errors.Is(err, ErrCopyShared) {
+		t.Fatalf("copied shared data: %v", err)
+	}
+}
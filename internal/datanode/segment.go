@@ -0,0 +1,96 @@
+package datanode
+
+import (
+	"io"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pkg/errors"
+	"github.com/qwp0905/go-object-storage/internal/segment"
+	"github.com/qwp0905/go-object-storage/pkg/logger"
+)
+
+var ErrPackingDisabled = fiber.NewError(fiber.StatusBadRequest, "small objects are not packed on this node")
+
+// packs reports whether an object of size goes into a segment instead of a
+// file of its own.
+func (d *dataNodeImpl) packs(key string, size int) bool {
+	return d.segments != nil && size >= 0 && size < d.config.PackBelow && len(key) <= segment.MaxKeyLength
+}
+
+// writeData keeps the data of key packed or in a file by its size, the other
+// one is removed when it is overwritten.
+func (d *dataNodeImpl) writeData(key string, size int, r io.Reader) error {
+	if !d.packs(key, size) {
+		if err := d.bp.Put(d.getDataKey(key), size, r); err != nil {
+			return err
+		}
+		if d.segments != nil {
+			_, err := d.segments.Delete(key)
+			return err
+		}
+		return nil
+	}
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return errors.WithStack(err)
+	}
+	if err := d.segments.Put(key, data); err != nil {
+		return err
+	}
+	return d.bp.Delete(d.getDataKey(key))
+}
+
+func (d *dataNodeImpl) readData(key string) (io.Reader, error) {
+	if d.segments != nil {
+		if r, ok := d.segments.Get(key); ok {
+			return r, nil
+		}
+	}
+	return d.bp.Get(d.getDataKey(key))
+}
+
+func (d *dataNodeImpl) removeData(key string) error {
+	if d.segments != nil {
+		if ok, err := d.segments.Delete(key); err != nil || ok {
+			return err
+		}
+	}
+	return d.bp.Delete(d.getDataKey(key))
+}
+
+func (d *dataNodeImpl) SegmentStat() (*segment.Stat, error) {
+	if d.segments == nil {
+		return nil, ErrPackingDisabled
+	}
+	return d.segments.Stat(), nil
+}
+
+func (d *dataNodeImpl) Compact() (*segment.CompactResult, error) {
+	if d.segments == nil {
+		return nil, ErrPackingDisabled
+	}
+	return d.segments.Compact(d.config.CompactRatio)
+}
+
+func (d *dataNodeImpl) StartCompaction(sec int) {
+	if d.segments == nil {
+		return
+	}
+	for range time.NewTicker(time.Second * time.Duration(sec)).C {
+		result, err := d.Compact()
+		if err != nil {
+			logger.Errorf("%+v", err)
+			continue
+		}
+		if result.Segments > 0 {
+			logger.Infof(
+				"compacted %d segments, moved %d objects and reclaimed %d bytes",
+				result.Segments,
+				result.Objects,
+				result.Reclaimed,
+			)
+		}
+	}
+}
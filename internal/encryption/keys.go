@@ -0,0 +1,139 @@
+package encryption
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"os"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrUnknownKey = fiber.NewError(fiber.StatusInternalServerError, "master key of the object is not loaded")
+	ErrWrongKey   = fiber.NewError(fiber.StatusForbidden, "encryption key does not match the object")
+)
+
+// KeyManager wraps data keys with master keys it never hands out. it is
+// implemented locally from key files and can be backed by an external kms.
+type KeyManager interface {
+	// CurrentKeyId is the master key new data keys are wrapped with.
+	CurrentKeyId() string
+	Wrap(ctx context.Context, dataKey []byte) (string, []byte, error)
+	Unwrap(ctx context.Context, keyId string, wrapped []byte) ([]byte, error)
+}
+
+type localKeyManager struct {
+	current string
+	keys    map[string][]byte
+}
+
+// NewLocalKeyManager keeps master keys in memory, the last one is current.
+// older keys stay to unwrap data keys until they are rotated.
+func NewLocalKeyManager(ids []string, keys [][]byte) (KeyManager, error) {
+	if len(ids) == 0 || len(ids) != len(keys) {
+		return nil, errors.New("no master key given")
+	}
+
+	m := &localKeyManager{keys: make(map[string][]byte)}
+	for i, id := range ids {
+		if len(keys[i]) != KeySize {
+			return nil, errors.Errorf("master key %s must be %d bytes", id, KeySize)
+		}
+		m.keys[id] = keys[i]
+	}
+	m.current = ids[len(ids)-1]
+	return m, nil
+}
+
+// LoadKeys reads master keys as lines of "<id>=<base64 key>", from a file or
+// from an environment variable where they may also be separated by commas.
+// blank lines and lines starting with # are skipped. the last key is current.
+func LoadKeys(path, env string) (KeyManager, error) {
+	var b []byte
+	if path != "" {
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		b = raw
+	}
+	if v := os.Getenv(env); env != "" && v != "" {
+		b = append(b, '\n')
+		b = append(b, strings.ReplaceAll(v, ",", "\n")...)
+	}
+
+	ids := make([]string, 0)
+	keys := make([][]byte, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		id, encoded, ok := strings.Cut(line, "=")
+		if !ok || id == "" {
+			return nil, errors.Errorf("master key line must be <id>=<base64 key>")
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, errors.Wrapf(err, "master key %s", id)
+		}
+		ids = append(ids, id)
+		keys = append(keys, key)
+	}
+
+	return NewLocalKeyManager(ids, keys)
+}
+
+func (m *localKeyManager) CurrentKeyId() string {
+	return m.current
+}
+
+func (m *localKeyManager) Wrap(ctx context.Context, dataKey []byte) (string, []byte, error) {
+	wrapped, err := WrapKey(m.keys[m.current], dataKey)
+	if err != nil {
+		return "", nil, err
+	}
+	return m.current, wrapped, nil
+}
+
+func (m *localKeyManager) Unwrap(ctx context.Context, keyId string, wrapped []byte) ([]byte, error) {
+	key, ok := m.keys[keyId]
+	if !ok {
+		return nil, errors.WithStack(ErrUnknownKey)
+	}
+	return UnwrapKey(key, wrapped)
+}
+
+// WrapKey seals a data key with a key encryption key, prefixed by its nonce.
+func WrapKey(kek, dataKey []byte) ([]byte, error) {
+	aead, err := newAEAD(kek)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, aead.NonceSize(), aead.NonceSize()+len(dataKey)+aead.Overhead())
+	if _, err := rand.Read(out); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return aead.Seal(out, out, dataKey, nil), nil
+}
+
+func UnwrapKey(kek, wrapped []byte) ([]byte, error) {
+	aead, err := newAEAD(kek)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) < aead.NonceSize() {
+		return nil, errors.WithStack(ErrWrongKey)
+	}
+	key, err := aead.Open(nil, wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, errors.WithStack(ErrWrongKey)
+	}
+	return key, nil
+}
@@ -0,0 +1,95 @@
+package encryption
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestWrapKey(t *testing.T) {
+	kek, dataKey := randomBytes(t, KeySize), randomBytes(t, KeySize)
+	wrapped, err := WrapKey(kek, dataKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := UnwrapKey(kek, wrapped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, dataKey) {
+		t.Fatal("data key does not round trip")
+	}
+
+	if _, err := UnwrapKey(randomBytes(t, KeySize), wrapped); !errors.Is(err, ErrWrongKey) {
+		t.Fatalf("unwrapped with a wrong key: %v", err)
+	}
+	if _, err := UnwrapKey(kek, wrapped[:4]); !errors.Is(err, ErrWrongKey) {
+		t.Fatalf("unwrapped a short key: %v", err)
+	}
+}
+
+func TestLocalKeyManager(t *testing.T) {
+	ctx := context.Background()
+	old, current := randomBytes(t, KeySize), randomBytes(t, KeySize)
+	dir := t.TempDir()
+	file := filepath.Join(dir, "keys")
+	if err := os.WriteFile(file, []byte("# rotated\nold="+base64.StdEncoding.EncodeToString(old)+"\n\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TEST_MASTER_KEYS", "new="+base64.StdEncoding.EncodeToString(current))
+
+	keys, err := LoadKeys(file, "TEST_MASTER_KEYS")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if keys.CurrentKeyId() != "new" {
+		t.Fatalf("current key is %s, want new", keys.CurrentKeyId())
+	}
+
+	dataKey := randomBytes(t, KeySize)
+	id, wrapped, err := keys.Wrap(ctx, dataKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != "new" {
+		t.Fatalf("wrapped with %s, want new", id)
+	}
+	got, err := keys.Unwrap(ctx, id, wrapped)
+	if err != nil || !bytes.Equal(got, dataKey) {
+		t.Fatalf("data key does not round trip: %v", err)
+	}
+
+	// keys wrapped before the rotation still open.
+	byOld, err := WrapKey(old, dataKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := keys.Unwrap(ctx, "old", byOld); err != nil || !bytes.Equal(got, dataKey) {
+		t.Fatalf("old data key does not unwrap: %v", err)
+	}
+	if _, err := keys.Unwrap(ctx, "old", wrapped); !errors.Is(err, ErrWrongKey) {
+		t.Fatalf("unwrapped by the wrong master key: %v", err)
+	}
+	if _, err := keys.Unwrap(ctx, "gone", wrapped); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("unwrapped by an unknown master key: %v", err)
+	}
+}
+
+func TestLoadKeysInvalid(t *testing.T) {
+	for name, v := range map[string]string{
+		"no key":     "",
+		"no id":      "=" + base64.StdEncoding.EncodeToString(make([]byte, KeySize)),
+		"bad base64": "a=!!",
+		"short key":  "a=" + base64.StdEncoding.EncodeToString(make([]byte, 16)),
+	} {
+		t.Setenv("TEST_MASTER_KEYS", v)
+		if _, err := LoadKeys("", "TEST_MASTER_KEYS"); err == nil {
+			t.Fatalf("%s is loaded", name)
+		}
+	}
+}
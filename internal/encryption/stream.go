@@ -0,0 +1,219 @@
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pkg/errors"
+)
+
+// data is sealed in chunks of ChunkSize so a range only needs the chunks it
+// covers. every chunk carries its own tag.
+const (
+	ChunkSize = 64 << 10
+	KeySize   = 32
+	tagSize   = 16
+	sealed    = ChunkSize + tagSize
+)
+
+var ErrCorrupted = fiber.NewError(fiber.StatusInternalServerError, "encrypted object is corrupted")
+
+// GenerateDataKey returns a fresh key for one object. data keys are never
+// shared, which keeps the counter nonces of the chunks unique.
+func GenerateDataKey() ([]byte, error) {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return key, nil
+}
+
+func chunks(size uint64) uint64 {
+	if size == 0 {
+		return 1
+	}
+	return (size + ChunkSize - 1) / ChunkSize
+}
+
+// SealedSize is the size of size bytes of plaintext once encrypted.
+func SealedSize(size uint64) uint64 {
+	return size + chunks(size)*tagSize
+}
+
+// OpenedSize is the size of the plaintext of size bytes of sealed data.
+func OpenedSize(size uint64) uint64 {
+	return size - (size+sealed-1)/sealed*tagSize
+}
+
+// SealedRange maps a range of plaintext to the chunks that hold it, returned
+// as the offset and length of sealed data to read and the index of the first
+// chunk.
+func SealedRange(size, offset, length uint64) (uint64, uint64, uint64) {
+	first := offset / ChunkSize
+	last := first
+	if length > 0 {
+		last = (offset + length - 1) / ChunkSize
+	}
+	end := (last + 1) * sealed
+	if total := SealedSize(size); end > total {
+		end = total
+	}
+	return first * sealed, end - first*sealed, first
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return aead, nil
+}
+
+// the last chunk is flagged in its nonce so a truncated object never
+// decrypts.
+func nonce(b []byte, index uint64, last bool) []byte {
+	binary.BigEndian.PutUint64(b[:8], index)
+	b[8], b[9], b[10], b[11] = 0, 0, 0, 0
+	if last {
+		b[11] = 1
+	}
+	return b
+}
+
+type encrypter struct {
+	aead  cipher.AEAD
+	r     io.Reader
+	size  uint64
+	index uint64
+	count uint64
+	nonce []byte
+	plain []byte
+	out   []byte
+	err   error
+}
+
+// NewEncrypter seals size bytes read from r.
+func NewEncrypter(key []byte, r io.Reader, size uint64) (io.Reader, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return &encrypter{
+		aead:  aead,
+		r:     r,
+		size:  size,
+		count: chunks(size),
+		nonce: make([]byte, aead.NonceSize()),
+		plain: make([]byte, ChunkSize),
+		out:   make([]byte, 0, sealed),
+	}, nil
+}
+
+func (e *encrypter) Read(p []byte) (int, error) {
+	for len(e.out) == 0 {
+		if e.err != nil {
+			return 0, e.err
+		}
+		if e.index == e.count {
+			return 0, io.EOF
+		}
+
+		n, err := io.ReadFull(e.r, e.plain)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = nil
+		}
+		if err != nil {
+			e.err = errors.WithStack(err)
+			return 0, e.err
+		}
+
+		last := e.index == e.count-1
+		if want := e.size - e.index*ChunkSize; n < ChunkSize && uint64(n) < want {
+			e.err = errors.WithStack(io.ErrUnexpectedEOF)
+			return 0, e.err
+		}
+		e.out = e.aead.Seal(e.out[:0], nonce(e.nonce, e.index, last), e.plain[:n], nil)
+		e.index++
+	}
+
+	n := copy(p, e.out)
+	e.out = e.out[n:]
+	return n, nil
+}
+
+type decrypter struct {
+	aead  cipher.AEAD
+	r     io.Reader
+	index uint64
+	count uint64
+	skip  int
+	nonce []byte
+	in    []byte
+	out   []byte
+	err   error
+}
+
+// NewDecrypter opens sealed data of an object holding size bytes of
+// plaintext, read from r starting at chunk first. skip bytes of the first
+// chunk are dropped.
+func NewDecrypter(key []byte, r io.Reader, size, first uint64, skip int) (io.Reader, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return &decrypter{
+		aead:  aead,
+		r:     r,
+		index: first,
+		count: chunks(size),
+		skip:  skip,
+		nonce: make([]byte, aead.NonceSize()),
+		in:    make([]byte, sealed),
+	}, nil
+}
+
+func (d *decrypter) Read(p []byte) (int, error) {
+	for len(d.out) == 0 {
+		if d.err != nil {
+			return 0, d.err
+		}
+		if d.index >= d.count {
+			return 0, io.EOF
+		}
+
+		n, err := io.ReadFull(d.r, d.in)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = nil
+		}
+		if err != nil {
+			d.err = errors.WithStack(err)
+			return 0, d.err
+		}
+
+		last := d.index == d.count-1
+		plain, err := d.aead.Open(d.in[:0], nonce(d.nonce, d.index, last), d.in[:n], nil)
+		if err != nil || (!last && n < sealed) {
+			d.err = errors.WithStack(ErrCorrupted)
+			return 0, d.err
+		}
+		d.index++
+
+		if d.skip > len(plain) {
+			d.skip = len(plain)
+		}
+		d.out = plain[d.skip:]
+		d.skip = 0
+	}
+
+	n := copy(p, d.out)
+	d.out = d.out[n:]
+	return n, nil
+}
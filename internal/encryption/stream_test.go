@@ -0,0 +1,150 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func randomBytes(t *testing.T, size int) []byte {
+	t.Helper()
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func seal(t *testing.T, key, plain []byte) []byte {
+	t.Helper()
+	r, err := NewEncrypter(key, bytes.NewReader(plain), uint64(len(plain)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uint64(len(out)) != SealedSize(uint64(len(plain))) {
+		t.Fatalf("sealed %d bytes into %d, want %d", len(plain), len(out), SealedSize(uint64(len(plain))))
+	}
+	if OpenedSize(uint64(len(out))) != uint64(len(plain)) {
+		t.Fatalf("%d sealed bytes open to %d, want %d", len(out), OpenedSize(uint64(len(out))), len(plain))
+	}
+	return out
+}
+
+// open reads a range of plaintext out of the sealed data the way the
+// namenode does, only the chunks holding it are read.
+func open(key, data []byte, size, offset, length uint64) ([]byte, error) {
+	start, n, first := SealedRange(size, offset, length)
+	r, err := NewDecrypter(key, bytes.NewReader(data[start:start+n]), size, first, int(offset-first*ChunkSize))
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(io.LimitReader(r, int64(length)))
+}
+
+func TestStreamRoundTrip(t *testing.T) {
+	key, err := GenerateDataKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, size := range []int{0, 1, ChunkSize - 1, ChunkSize, ChunkSize + 1, ChunkSize*3 + 100} {
+		plain := randomBytes(t, size)
+		data := seal(t, key, plain)
+		got, err := open(key, data, uint64(size), 0, uint64(size))
+		if err != nil {
+			t.Fatalf("size %d: %+v", size, err)
+		}
+		if !bytes.Equal(got, plain) {
+			t.Fatalf("size %d does not round trip", size)
+		}
+	}
+}
+
+func TestStreamRange(t *testing.T) {
+	key, err := GenerateDataKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := uint64(ChunkSize*3 + 100)
+	plain := randomBytes(t, int(size))
+	data := seal(t, key, plain)
+
+	for _, c := range []struct{ offset, length uint64 }{
+		{10, 20},
+		{ChunkSize + 5, 100},
+		{ChunkSize - 10, 20},
+		{ChunkSize / 2, ChunkSize * 2},
+		{ChunkSize * 3, 100},
+		{size - 1, 1},
+		{ChunkSize, 0},
+	} {
+		got, err := open(key, data, size, c.offset, c.length)
+		if err != nil {
+			t.Fatalf("range %d+%d: %+v", c.offset, c.length, err)
+		}
+		if !bytes.Equal(got, plain[c.offset:c.offset+c.length]) {
+			t.Fatalf("range %d+%d does not match", c.offset, c.length)
+		}
+	}
+}
+
+func TestStreamTampered(t *testing.T) {
+	key, err := GenerateDataKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := uint64(ChunkSize*2 + 100)
+	data := seal(t, key, randomBytes(t, int(size)))
+
+	swapped := append([]byte(nil), data...)
+	copy(swapped[:sealed], data[sealed:2*sealed])
+	copy(swapped[sealed:2*sealed], data[:sealed])
+
+	flipped := append([]byte(nil), data...)
+	flipped[ChunkSize+tagSize+7] ^= 1
+
+	other, err := GenerateDataKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, c := range map[string]struct {
+		key  []byte
+		data []byte
+	}{
+		// dropping the last chunk leaves a full chunk that is not flagged last.
+		"truncated to whole chunks": {key, data[:2*sealed]},
+		"truncated last chunk":      {key, data[:len(data)-1]},
+		"swapped chunks":            {key, swapped},
+		"flipped bit":               {key, flipped},
+		"other key":                 {other, data},
+	} {
+		r, err := NewDecrypter(c.key, bytes.NewReader(c.data), size, 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.ReadAll(r); !errors.Is(err, ErrCorrupted) {
+			t.Fatalf("%s: opened with %v", name, err)
+		}
+	}
+}
+
+func TestEncrypterShortBody(t *testing.T) {
+	key, err := GenerateDataKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewEncrypter(key, bytes.NewReader(randomBytes(t, ChunkSize)), ChunkSize*2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(r); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("sealed a short body with %v", err)
+	}
+}
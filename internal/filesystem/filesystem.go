@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"syscall"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/pkg/errors"
@@ -83,3 +85,53 @@ func EnsureDir(path string) error {
 	}
 	return nil
 }
+
+type Usage struct {
+	Total uint64 `json:"total"`
+	Free  uint64 `json:"free"`
+}
+
+func Stat(path string) (*Usage, error) {
+	stat := new(syscall.Statfs_t)
+	if err := syscall.Statfs(path, stat); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return &Usage{
+		Total: uint64(stat.Blocks) * uint64(stat.Bsize),
+		Free:  uint64(stat.Bavail) * uint64(stat.Bsize),
+	}, nil
+}
+
+type FileInfo struct {
+	Name         string    `json:"name"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"last_modified"`
+}
+
+func List(path string) ([]*FileInfo, error) {
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	out := make([]*FileInfo, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		out = append(out, &FileInfo{
+			Name:         entry.Name(),
+			Size:         info.Size(),
+			LastModified: info.ModTime(),
+		})
+	}
+
+	return out, nil
+}
@@ -23,6 +23,8 @@ type applicationImpl struct {
 
 func NewApplication() Application {
 	source := fiber.New(fiber.Config{
+		// keys and headers end up in metadata caches that outlive the request.
+		Immutable:         true,
 		StreamRequestBody: true,
 		JSONEncoder:       json.Marshal,
 		JSONDecoder:       json.Unmarshal,
@@ -0,0 +1,39 @@
+package kv
+
+import (
+	"context"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+var ErrLockLost = fiber.NewError(fiber.StatusConflict, "lock is no longer held")
+
+type Store interface {
+	Get(ctx context.Context, key string) (string, error)
+	MGet(ctx context.Context, keys ...string) ([]string, error)
+	Set(ctx context.Context, key, value string, ttl time.Duration) error
+	Del(ctx context.Context, key string) error
+	Keys(ctx context.Context, prefix string) ([]string, error)
+}
+
+type LockStore interface {
+	Store
+	TryLock(ctx context.Context, key, owner string, shared bool, ttl time.Duration) (time.Duration, uint64, error)
+	Extend(ctx context.Context, key, owner string, ttl time.Duration) error
+	Unlock(ctx context.Context, key, owner string, shared bool) error
+}
+
+type Notifier interface {
+	Released(key string) <-chan struct{}
+}
+
+// messages are delivered to subscribers of every process sharing the store,
+// the publisher included.
+type Publisher interface {
+	Publish(ctx context.Context, channel, message string) error
+}
+
+type Subscriber interface {
+	Subscribe(ctx context.Context, channel string, fn func(message string)) error
+}
@@ -0,0 +1,170 @@
+package kv
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pkg/errors"
+	"github.com/qwp0905/go-object-storage/internal/replication"
+	"github.com/qwp0905/go-object-storage/pkg/nocopy"
+)
+
+type localStoreImpl struct {
+	noCopy   nocopy.NoCopy
+	sm       *StateMachine
+	mu       *sync.Mutex
+	released map[string]chan struct{}
+}
+
+func NewLocal() LockStore {
+	sm := NewStateMachine()
+	// the counter is not persisted, datanodes keep the fences of the last run.
+	// starting from the clock keeps new tokens above them across restarts.
+	sm.fence = uint64(time.Now().UnixNano())
+	return &localStoreImpl{
+		sm:       sm,
+		mu:       new(sync.Mutex),
+		released: make(map[string]chan struct{}),
+	}
+}
+
+func (s *localStoreImpl) Get(ctx context.Context, key string) (string, error) {
+	value, ok := s.sm.get(key)
+	if !ok {
+		return "", errors.WithStack(fiber.ErrNotFound)
+	}
+	return value, nil
+}
+
+func (s *localStoreImpl) MGet(ctx context.Context, keys ...string) ([]string, error) {
+	out := make([]string, len(keys))
+	for i, key := range keys {
+		out[i], _ = s.sm.get(key)
+	}
+	return out, nil
+}
+
+func (s *localStoreImpl) Keys(ctx context.Context, prefix string) ([]string, error) {
+	return s.sm.keys(prefix), nil
+}
+
+func (s *localStoreImpl) Set(ctx context.Context, key, value string, ttl time.Duration) error {
+	cmd := &command{Value: value, Now: time.Now()}
+	if ttl > 0 {
+		cmd.Expire = cmd.Now.Add(ttl)
+	}
+	s.sm.execute(replication.OperationPut, key, cmd)
+	return nil
+}
+
+func (s *localStoreImpl) Del(ctx context.Context, key string) error {
+	s.sm.execute(replication.OperationDel, key, &command{Now: time.Now()})
+	return nil
+}
+
+func (s *localStoreImpl) Publish(ctx context.Context, channel, message string) error {
+	s.sm.execute(operationPublish, channel, &command{Value: message, Now: time.Now()})
+	return nil
+}
+
+func (s *localStoreImpl) Subscribe(ctx context.Context, channel string, fn func(message string)) error {
+	s.sm.subscribe(channel, fn)
+	return nil
+}
+
+func (s *localStoreImpl) TryLock(
+	ctx context.Context,
+	key, owner string,
+	shared bool,
+	ttl time.Duration,
+) (time.Duration, uint64, error) {
+	now := time.Now()
+	res := s.sm.execute(operationLock, key, &command{
+		Owner:  owner,
+		Shared: shared,
+		Expire: now.Add(ttl),
+		Now:    now,
+	}).(*lockResult)
+	return res.wait, res.token, nil
+}
+
+func (s *localStoreImpl) Extend(ctx context.Context, key, owner string, ttl time.Duration) error {
+	now := time.Now()
+	res := s.sm.execute(operationExtend, key, &command{Owner: owner, Expire: now.Add(ttl), Now: now})
+	if held, _ := res.(bool); !held {
+		return errors.WithStack(ErrLockLost)
+	}
+	return nil
+}
+
+func (s *localStoreImpl) Unlock(ctx context.Context, key, owner string, shared bool) error {
+	s.sm.execute(operationUnlock, key, &command{Owner: owner, Shared: shared, Now: time.Now()})
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ch, ok := s.released[key]; ok {
+		close(ch)
+		delete(s.released, key)
+	}
+	return nil
+}
+
+func (s *localStoreImpl) Released(key string) <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ch, ok := s.released[key]
+	if !ok {
+		ch = make(chan struct{})
+		s.released[key] = ch
+	}
+	return ch
+}
+
+type combinedStoreImpl struct {
+	Store
+	locks LockStore
+}
+
+func WithLocks(store Store, locks LockStore) LockStore {
+	return &combinedStoreImpl{Store: store, locks: locks}
+}
+
+func (s *combinedStoreImpl) TryLock(
+	ctx context.Context,
+	key, owner string,
+	shared bool,
+	ttl time.Duration,
+) (time.Duration, uint64, error) {
+	return s.locks.TryLock(ctx, key, owner, shared, ttl)
+}
+
+func (s *combinedStoreImpl) Extend(ctx context.Context, key, owner string, ttl time.Duration) error {
+	return s.locks.Extend(ctx, key, owner, ttl)
+}
+
+func (s *combinedStoreImpl) Unlock(ctx context.Context, key, owner string, shared bool) error {
+	return s.locks.Unlock(ctx, key, owner, shared)
+}
+
+func (s *combinedStoreImpl) Publish(ctx context.Context, channel, message string) error {
+	if p, ok := s.Store.(Publisher); ok {
+		return p.Publish(ctx, channel, message)
+	}
+	return nil
+}
+
+func (s *combinedStoreImpl) Subscribe(ctx context.Context, channel string, fn func(message string)) error {
+	if sub, ok := s.Store.(Subscriber); ok {
+		return sub.Subscribe(ctx, channel, fn)
+	}
+	return nil
+}
+
+func (s *combinedStoreImpl) Released(key string) <-chan struct{} {
+	if n, ok := s.locks.(Notifier); ok {
+		return n.Released(key)
+	}
+	return nil
+}
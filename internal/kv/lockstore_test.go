@@ -0,0 +1,169 @@
+package kv
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/qwp0905/go-object-storage/internal/replication"
+)
+
+// newLockStore opens a backend persisting under dir, the returned func stops it.
+type newLockStore func(t *testing.T, dir string) (LockStore, func())
+
+func newLocalLockStore(t *testing.T, dir string) (LockStore, func()) {
+	return NewLocal(), func() {}
+}
+
+func newReplicatedLockStore(t *testing.T, dir string) (LockStore, func()) {
+	log, err := replication.NewLogStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sm := NewStateMachine()
+	transport := replication.NewLocalTransport()
+	cluster, err := replication.NewCluster(&replication.Config{
+		Addr:              "namenode",
+		ElectionTimeout:   time.Millisecond * 20,
+		HeartbeatInterval: time.Millisecond * 10,
+	}, log, transport.Node("namenode"), sm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport.Register("namenode", cluster)
+	cluster.Start()
+
+	deadline := time.Now().Add(time.Second * 5)
+	for !cluster.IsLeader() {
+		if time.Now().After(deadline) {
+			t.Fatal("no leader elected")
+		}
+		time.Sleep(time.Millisecond * 5)
+	}
+	return NewReplicated(sm, cluster), cluster.Stop
+}
+
+func TestLocalLockStore(t *testing.T) {
+	testLockStore(t, newLocalLockStore)
+}
+
+func TestReplicatedLockStore(t *testing.T) {
+	testLockStore(t, newReplicatedLockStore)
+}
+
+func testLockStore(t *testing.T, open newLockStore) {
+	ctx := context.Background()
+	const ttl = time.Second * 10
+
+	start := func(t *testing.T) LockStore {
+		store, stop := open(t, t.TempDir())
+		t.Cleanup(stop)
+		return store
+	}
+	mustLock := func(t *testing.T, store LockStore, key, owner string, shared bool, ttl time.Duration) uint64 {
+		t.Helper()
+		wait, token, err := store.TryLock(ctx, key, owner, shared, ttl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if wait > 0 {
+			t.Fatalf("%s should hold %s, waits %s", owner, key, wait)
+		}
+		return token
+	}
+	mustWait := func(t *testing.T, store LockStore, key, owner string, shared bool) {
+		t.Helper()
+		wait, _, err := store.TryLock(ctx, key, owner, shared, ttl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if wait <= 0 {
+			t.Fatalf("%s must wait for %s", owner, key)
+		}
+	}
+
+	t.Run("exclusive", func(t *testing.T) {
+		store := start(t)
+		first := mustLock(t, store, "key", "a", false, ttl)
+		mustWait(t, store, "key", "b", false)
+		mustWait(t, store, "key", "b", true)
+
+		if err := store.Unlock(ctx, "key", "a", false); err != nil {
+			t.Fatal(err)
+		}
+		if second := mustLock(t, store, "key", "b", false, ttl); second <= first {
+			t.Fatalf("token %d is not above %d", second, first)
+		}
+	})
+
+	t.Run("unlock by another owner", func(t *testing.T) {
+		store := start(t)
+		mustLock(t, store, "key", "a", false, ttl)
+		if err := store.Unlock(ctx, "key", "b", false); err != nil {
+			t.Fatal(err)
+		}
+		mustWait(t, store, "key", "b", false)
+	})
+
+	t.Run("shared", func(t *testing.T) {
+		store := start(t)
+		mustLock(t, store, "key", "r1", true, ttl)
+		mustLock(t, store, "key", "r2", true, ttl)
+		mustWait(t, store, "key", "w", false)
+
+		for _, owner := range []string{"r1", "r2"} {
+			if err := store.Unlock(ctx, "key", owner, true); err != nil {
+				t.Fatal(err)
+			}
+		}
+		mustLock(t, store, "key", "w", false, ttl)
+		mustWait(t, store, "key", "r1", true)
+	})
+
+	t.Run("tokens increase across keys", func(t *testing.T) {
+		store := start(t)
+		var last uint64
+		for _, key := range []string{"a", "b", "c"} {
+			token := mustLock(t, store, key, "owner", false, ttl)
+			if token <= last {
+				t.Fatalf("token %d is not above %d", token, last)
+			}
+			last = token
+		}
+	})
+
+	t.Run("lease expires", func(t *testing.T) {
+		store := start(t)
+		mustLock(t, store, "key", "a", false, time.Millisecond*50)
+		time.Sleep(time.Millisecond * 80)
+
+		mustLock(t, store, "key", "b", false, ttl)
+		if err := store.Extend(ctx, "key", "a", ttl); !errors.Is(err, ErrLockLost) {
+			t.Fatalf("extending a taken lock must fail, got %v", err)
+		}
+	})
+
+	t.Run("extend keeps the lease", func(t *testing.T) {
+		store := start(t)
+		mustLock(t, store, "key", "a", false, time.Millisecond*50)
+		if err := store.Extend(ctx, "key", "a", ttl); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Millisecond * 80)
+		mustWait(t, store, "key", "b", false)
+	})
+
+	t.Run("tokens increase across restarts", func(t *testing.T) {
+		dir := t.TempDir()
+		store, stop := open(t, dir)
+		first := mustLock(t, store, "key", "a", false, ttl)
+		stop()
+
+		store, stop = open(t, dir)
+		defer stop()
+		if second := mustLock(t, store, "other", "b", false, ttl); second <= first {
+			t.Fatalf("token %d after restart is not above %d", second, first)
+		}
+	})
+}
@@ -0,0 +1,91 @@
+package kv
+
+import (
+	"context"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pkg/errors"
+	"github.com/qwp0905/go-object-storage/pkg/nocopy"
+	"github.com/redis/go-redis/v9"
+)
+
+type redisStoreImpl struct {
+	noCopy nocopy.NoCopy
+	rc     *redis.Client
+}
+
+func NewRedis(rc *redis.Client) Store {
+	return &redisStoreImpl{rc: rc}
+}
+
+func (s *redisStoreImpl) Get(ctx context.Context, key string) (string, error) {
+	value, err := s.rc.Get(ctx, key).Result()
+	if err == redis.Nil {
+		return "", errors.WithStack(fiber.ErrNotFound)
+	}
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	return value, nil
+}
+
+func (s *redisStoreImpl) MGet(ctx context.Context, keys ...string) ([]string, error) {
+	values, err := s.rc.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	out := make([]string, len(values))
+	for i, v := range values {
+		if str, ok := v.(string); ok {
+			out[i] = str
+		}
+	}
+	return out, nil
+}
+
+func (s *redisStoreImpl) Set(ctx context.Context, key, value string, ttl time.Duration) error {
+	return errors.WithStack(s.rc.Set(ctx, key, value, ttl).Err())
+}
+
+func (s *redisStoreImpl) Del(ctx context.Context, key string) error {
+	return errors.WithStack(s.rc.Del(ctx, key).Err())
+}
+
+func (s *redisStoreImpl) Publish(ctx context.Context, channel, message string) error {
+	return errors.WithStack(s.rc.Publish(ctx, channel, message).Err())
+}
+
+func (s *redisStoreImpl) Subscribe(ctx context.Context, channel string, fn func(message string)) error {
+	sub := s.rc.Subscribe(ctx, channel)
+	if _, err := sub.Receive(ctx); err != nil {
+		sub.Close()
+		return errors.WithStack(err)
+	}
+
+	go func() {
+		defer sub.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-sub.Channel():
+				if !ok {
+					return
+				}
+				fn(msg.Payload)
+			}
+		}
+	}()
+	return nil
+}
+
+func (s *redisStoreImpl) Keys(ctx context.Context, prefix string) ([]string, error) {
+	keys, err := s.rc.Keys(ctx, prefix+"*").Result()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return keys, nil
+}
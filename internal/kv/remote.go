@@ -0,0 +1,193 @@
+package kv
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/gofiber/fiber/v2"
+	"github.com/pkg/errors"
+	"github.com/qwp0905/go-object-storage/pkg/nocopy"
+	"github.com/valyala/fasthttp"
+)
+
+type SetRequest struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+	TTL   int64  `json:"ttl,omitempty"`
+}
+
+type LockRequest struct {
+	Key    string `json:"key"`
+	Owner  string `json:"owner"`
+	Shared bool   `json:"shared,omitempty"`
+	TTL    int64  `json:"ttl,omitempty"`
+}
+
+type LockResponse struct {
+	Wait  time.Duration `json:"wait"`
+	Token uint64        `json:"token"`
+}
+
+type ValueResponse struct {
+	Value string `json:"value"`
+}
+
+type KeysRequest struct {
+	Keys []string `json:"keys"`
+}
+
+type ValuesResponse struct {
+	Values []string `json:"values"`
+}
+
+type KeysResponse struct {
+	Keys []string `json:"keys"`
+}
+
+type remoteStoreImpl struct {
+	noCopy nocopy.NoCopy
+	client *fasthttp.Client
+	hosts  func() []string
+}
+
+func NewRemote(hosts []string) LockStore {
+	return newRemote(func() []string { return hosts })
+}
+
+func newRemote(hosts func() []string) *remoteStoreImpl {
+	return &remoteStoreImpl{
+		client: &fasthttp.Client{MaxConnsPerHost: 1024},
+		hosts:  hosts,
+	}
+}
+
+func (s *remoteStoreImpl) Get(ctx context.Context, key string) (string, error) {
+	out := new(ValueResponse)
+	if err := s.do(fasthttp.MethodGet, "/kv?key="+url.QueryEscape(key), nil, out); err != nil {
+		return "", err
+	}
+	return out.Value, nil
+}
+
+func (s *remoteStoreImpl) MGet(ctx context.Context, keys ...string) ([]string, error) {
+	out := new(ValuesResponse)
+	if err := s.do(fasthttp.MethodPost, "/kv/mget", &KeysRequest{Keys: keys}, out); err != nil {
+		return nil, err
+	}
+	return out.Values, nil
+}
+
+func (s *remoteStoreImpl) Set(ctx context.Context, key, value string, ttl time.Duration) error {
+	return s.do(fasthttp.MethodPut, "/kv", &SetRequest{
+		Key:   key,
+		Value: value,
+		TTL:   ttl.Milliseconds(),
+	}, nil)
+}
+
+func (s *remoteStoreImpl) Del(ctx context.Context, key string) error {
+	return s.do(fasthttp.MethodDelete, "/kv?key="+url.QueryEscape(key), nil, nil)
+}
+
+func (s *remoteStoreImpl) Keys(ctx context.Context, prefix string) ([]string, error) {
+	out := new(KeysResponse)
+	if err := s.do(fasthttp.MethodGet, "/kv/keys?prefix="+url.QueryEscape(prefix), nil, out); err != nil {
+		return nil, err
+	}
+	return out.Keys, nil
+}
+
+func (s *remoteStoreImpl) Publish(ctx context.Context, channel, message string) error {
+	return s.do(fasthttp.MethodPost, "/kv/publish", &SetRequest{Key: channel, Value: message}, nil)
+}
+
+func (s *remoteStoreImpl) TryLock(
+	ctx context.Context,
+	key, owner string,
+	shared bool,
+	ttl time.Duration,
+) (time.Duration, uint64, error) {
+	out := new(LockResponse)
+	if err := s.do(fasthttp.MethodPost, "/kv/lock", &LockRequest{
+		Key:    key,
+		Owner:  owner,
+		Shared: shared,
+		TTL:    ttl.Milliseconds(),
+	}, out); err != nil {
+		return 0, 0, err
+	}
+	return out.Wait, out.Token, nil
+}
+
+func (s *remoteStoreImpl) Extend(ctx context.Context, key, owner string, ttl time.Duration) error {
+	return s.do(fasthttp.MethodPost, "/kv/extend", &LockRequest{
+		Key:   key,
+		Owner: owner,
+		TTL:   ttl.Milliseconds(),
+	}, nil)
+}
+
+func (s *remoteStoreImpl) Unlock(ctx context.Context, key, owner string, shared bool) error {
+	return s.do(fasthttp.MethodPost, "/kv/unlock", &LockRequest{
+		Key:    key,
+		Owner:  owner,
+		Shared: shared,
+	}, nil)
+}
+
+func (s *remoteStoreImpl) do(method, path string, body, out any) error {
+	hosts := s.hosts()
+	if len(hosts) == 0 {
+		return errors.WithStack(fiber.ErrServiceUnavailable)
+	}
+
+	var err error
+	for _, host := range hosts {
+		if err = s.request(host, method, path, body, out); err == nil {
+			return nil
+		}
+		if errors.Is(err, fiber.ErrNotFound) {
+			return err
+		}
+	}
+	return err
+}
+
+func (s *remoteStoreImpl) request(host, method, path string, body, out any) error {
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	res := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(res)
+
+	req.Header.SetMethod(method)
+	req.SetRequestURI(fmt.Sprintf("http://%s%s", host, path))
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		req.Header.SetContentType("application/json")
+		req.SetBody(b)
+	}
+
+	if err := s.client.Do(req, res); err != nil {
+		return errors.WithStack(err)
+	}
+
+	if res.StatusCode() == fiber.StatusNotFound {
+		return errors.WithStack(fiber.ErrNotFound)
+	} else if res.StatusCode() == fiber.StatusConflict {
+		// the kv api only conflicts on extending a lock held by someone else.
+		return errors.WithStack(ErrLockLost)
+	} else if res.StatusCode() >= 400 {
+		return errors.WithStack(errors.Errorf("%s", string(res.Body())))
+	}
+
+	if out == nil {
+		return nil
+	}
+	return errors.WithStack(json.Unmarshal(res.Body(), out))
+}
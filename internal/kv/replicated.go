@@ -0,0 +1,159 @@
+package kv
+
+import (
+	"context"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/gofiber/fiber/v2"
+	"github.com/pkg/errors"
+	"github.com/qwp0905/go-object-storage/internal/replication"
+	"github.com/qwp0905/go-object-storage/pkg/nocopy"
+)
+
+type replicatedStoreImpl struct {
+	noCopy  nocopy.NoCopy
+	sm      *StateMachine
+	cluster replication.Cluster
+	leader  *remoteStoreImpl
+}
+
+func NewReplicated(sm *StateMachine, cluster replication.Cluster) LockStore {
+	return &replicatedStoreImpl{
+		sm:      sm,
+		cluster: cluster,
+		leader: newRemote(func() []string {
+			if addr := cluster.LeaderAddr(); addr != "" {
+				return []string{addr}
+			}
+			return nil
+		}),
+	}
+}
+
+func (s *replicatedStoreImpl) propose(
+	ctx context.Context,
+	operation replication.Operation,
+	key string,
+	cmd *command,
+) (any, error) {
+	cmd.Now = time.Now()
+	b, err := json.Marshal(cmd)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return s.cluster.Propose(ctx, &replication.Log{
+		Operation: operation,
+		Key:       key,
+		Value:     b,
+	})
+}
+
+func (s *replicatedStoreImpl) Get(ctx context.Context, key string) (string, error) {
+	value, ok := s.sm.get(key)
+	if !ok {
+		return "", errors.WithStack(fiber.ErrNotFound)
+	}
+	return value, nil
+}
+
+func (s *replicatedStoreImpl) MGet(ctx context.Context, keys ...string) ([]string, error) {
+	out := make([]string, len(keys))
+	for i, key := range keys {
+		out[i], _ = s.sm.get(key)
+	}
+	return out, nil
+}
+
+func (s *replicatedStoreImpl) Keys(ctx context.Context, prefix string) ([]string, error) {
+	return s.sm.keys(prefix), nil
+}
+
+func (s *replicatedStoreImpl) Set(ctx context.Context, key, value string, ttl time.Duration) error {
+	if !s.cluster.IsLeader() {
+		return s.leader.Set(ctx, key, value, ttl)
+	}
+
+	cmd := &command{Value: value}
+	if ttl > 0 {
+		cmd.Expire = time.Now().Add(ttl)
+	}
+	_, err := s.propose(ctx, replication.OperationPut, key, cmd)
+	return err
+}
+
+func (s *replicatedStoreImpl) Del(ctx context.Context, key string) error {
+	if !s.cluster.IsLeader() {
+		return s.leader.Del(ctx, key)
+	}
+
+	_, err := s.propose(ctx, replication.OperationDel, key, &command{})
+	return err
+}
+
+func (s *replicatedStoreImpl) Publish(ctx context.Context, channel, message string) error {
+	if !s.cluster.IsLeader() {
+		return s.leader.Publish(ctx, channel, message)
+	}
+
+	_, err := s.propose(ctx, operationPublish, channel, &command{Value: message})
+	return err
+}
+
+func (s *replicatedStoreImpl) Subscribe(ctx context.Context, channel string, fn func(message string)) error {
+	s.sm.subscribe(channel, fn)
+	return nil
+}
+
+func (s *replicatedStoreImpl) TryLock(
+	ctx context.Context,
+	key, owner string,
+	shared bool,
+	ttl time.Duration,
+) (time.Duration, uint64, error) {
+	if !s.cluster.IsLeader() {
+		return s.leader.TryLock(ctx, key, owner, shared, ttl)
+	}
+
+	v, err := s.propose(ctx, operationLock, key, &command{
+		Owner:  owner,
+		Shared: shared,
+		Expire: time.Now().Add(ttl),
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	res, ok := v.(*lockResult)
+	if !ok {
+		return 0, 0, errors.New("invalid lock result")
+	}
+	return res.wait, res.token, nil
+}
+
+func (s *replicatedStoreImpl) Extend(ctx context.Context, key, owner string, ttl time.Duration) error {
+	if !s.cluster.IsLeader() {
+		return s.leader.Extend(ctx, key, owner, ttl)
+	}
+
+	res, err := s.propose(ctx, operationExtend, key, &command{
+		Owner:  owner,
+		Expire: time.Now().Add(ttl),
+	})
+	if err != nil {
+		return err
+	}
+	if held, _ := res.(bool); !held {
+		return errors.WithStack(ErrLockLost)
+	}
+	return nil
+}
+
+func (s *replicatedStoreImpl) Unlock(ctx context.Context, key, owner string, shared bool) error {
+	if !s.cluster.IsLeader() {
+		return s.leader.Unlock(ctx, key, owner, shared)
+	}
+
+	_, err := s.propose(ctx, operationUnlock, key, &command{Owner: owner, Shared: shared})
+	return err
+}
@@ -0,0 +1,292 @@
+package kv
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/pkg/errors"
+	"github.com/qwp0905/go-object-storage/internal/replication"
+	"github.com/qwp0905/go-object-storage/pkg/logger"
+	"github.com/qwp0905/go-object-storage/pkg/nocopy"
+)
+
+const (
+	operationLock    = replication.Operation("LOCK")
+	operationExtend  = replication.Operation("EXTEND")
+	operationUnlock  = replication.Operation("UNLOCK")
+	operationPublish = replication.Operation("PUBLISH")
+)
+
+type lockResult struct {
+	wait  time.Duration
+	token uint64
+}
+
+type command struct {
+	Value  string    `json:"value,omitempty"`
+	Expire time.Time `json:"expire,omitempty"`
+	Owner  string    `json:"owner,omitempty"`
+	Shared bool      `json:"shared,omitempty"`
+	Now    time.Time `json:"now"`
+}
+
+type entry struct {
+	value  string
+	expire time.Time
+}
+
+func (e *entry) expired(now time.Time) bool {
+	return !e.expire.IsZero() && !now.Before(e.expire)
+}
+
+type reader struct {
+	count  int
+	expire time.Time
+}
+
+type lockState struct {
+	writer       string
+	writerExpire time.Time
+	readers      map[string]*reader
+}
+
+type StateMachine struct {
+	noCopy nocopy.NoCopy
+	mu     *sync.RWMutex
+	data   map[string]*entry
+	locks  map[string]*lockState
+	fence  uint64
+	subs   map[string][]func(string)
+}
+
+func NewStateMachine() *StateMachine {
+	return &StateMachine{
+		mu:    new(sync.RWMutex),
+		data:  make(map[string]*entry),
+		locks: make(map[string]*lockState),
+		subs:  make(map[string][]func(string)),
+	}
+}
+
+func (m *StateMachine) Apply(log *replication.Log) any {
+	cmd := new(command)
+	if err := json.Unmarshal(log.Value, cmd); err != nil {
+		logger.Errorf("invalid command at index %d %+v", log.Index, err)
+		return nil
+	}
+
+	return m.execute(log.Operation, log.Key, cmd)
+}
+
+func (m *StateMachine) execute(operation replication.Operation, key string, cmd *command) any {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch operation {
+	case replication.OperationPut:
+		m.data[key] = &entry{value: cmd.Value, expire: cmd.Expire}
+	case replication.OperationDel:
+		delete(m.data, key)
+	case operationLock:
+		return m.lock(key, cmd)
+	case operationExtend:
+		return m.extend(key, cmd)
+	case operationUnlock:
+		m.unlock(key, cmd)
+	case operationPublish:
+		for _, fn := range m.subs[key] {
+			fn(cmd.Value)
+		}
+	}
+	return nil
+}
+
+func (m *StateMachine) lock(key string, cmd *command) *lockResult {
+	state, ok := m.locks[key]
+	if !ok {
+		state = &lockState{readers: make(map[string]*reader)}
+		m.locks[key] = state
+	}
+	for owner, r := range state.readers {
+		if !cmd.Now.Before(r.expire) {
+			delete(state.readers, owner)
+		}
+	}
+	if state.writer != "" && !cmd.Now.Before(state.writerExpire) {
+		state.writer = ""
+	}
+
+	if state.writer != "" && state.writer != cmd.Owner {
+		return &lockResult{wait: state.writerExpire.Sub(cmd.Now)}
+	}
+
+	if cmd.Shared {
+		r, ok := state.readers[cmd.Owner]
+		if !ok {
+			r = new(reader)
+			state.readers[cmd.Owner] = r
+		}
+		r.count++
+		r.expire = cmd.Expire
+		return &lockResult{}
+	}
+
+	var wait time.Duration
+	for _, r := range state.readers {
+		if d := r.expire.Sub(cmd.Now); d > wait {
+			wait = d
+		}
+	}
+	if wait > 0 {
+		return &lockResult{wait: wait}
+	}
+
+	m.fence++
+	state.writer = cmd.Owner
+	state.writerExpire = cmd.Expire
+	return &lockResult{token: m.fence}
+}
+
+func (m *StateMachine) extend(key string, cmd *command) bool {
+	state, ok := m.locks[key]
+	if !ok || state.writer != cmd.Owner {
+		return false
+	}
+	state.writerExpire = cmd.Expire
+	return true
+}
+
+func (m *StateMachine) unlock(key string, cmd *command) {
+	state, ok := m.locks[key]
+	if !ok {
+		return
+	}
+
+	if cmd.Shared {
+		if r, ok := state.readers[cmd.Owner]; ok {
+			if r.count--; r.count <= 0 {
+				delete(state.readers, cmd.Owner)
+			}
+		}
+	} else if state.writer == cmd.Owner {
+		state.writer = ""
+	}
+
+	if state.writer == "" && len(state.readers) == 0 {
+		delete(m.locks, key)
+	}
+}
+
+type snapshotEntry struct {
+	Value  string    `json:"value"`
+	Expire time.Time `json:"expire,omitempty"`
+}
+
+type snapshotReader struct {
+	Count  int       `json:"count"`
+	Expire time.Time `json:"expire"`
+}
+
+type snapshotLock struct {
+	Writer       string                    `json:"writer,omitempty"`
+	WriterExpire time.Time                 `json:"writer_expire,omitempty"`
+	Readers      map[string]snapshotReader `json:"readers,omitempty"`
+}
+
+type snapshot struct {
+	Data  map[string]snapshotEntry `json:"data"`
+	Locks map[string]snapshotLock  `json:"locks"`
+	Fence uint64                   `json:"fence"`
+}
+
+func (m *StateMachine) Snapshot() ([]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	snap := &snapshot{
+		Data:  make(map[string]snapshotEntry, len(m.data)),
+		Locks: make(map[string]snapshotLock, len(m.locks)),
+		Fence: m.fence,
+	}
+	for key, e := range m.data {
+		snap.Data[key] = snapshotEntry{Value: e.value, Expire: e.expire}
+	}
+	for key, state := range m.locks {
+		l := snapshotLock{
+			Writer:       state.writer,
+			WriterExpire: state.writerExpire,
+			Readers:      make(map[string]snapshotReader, len(state.readers)),
+		}
+		for owner, r := range state.readers {
+			l.Readers[owner] = snapshotReader{Count: r.count, Expire: r.expire}
+		}
+		snap.Locks[key] = l
+	}
+
+	b, err := json.Marshal(snap)
+	return b, errors.WithStack(err)
+}
+
+// Restore replaces the state with a snapshot. subscribers are kept, published
+// messages are not part of the snapshot.
+func (m *StateMachine) Restore(data []byte) error {
+	snap := new(snapshot)
+	if err := json.Unmarshal(data, snap); err != nil {
+		return errors.WithStack(err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.data = make(map[string]*entry, len(snap.Data))
+	for key, e := range snap.Data {
+		m.data[key] = &entry{value: e.Value, expire: e.Expire}
+	}
+	m.locks = make(map[string]*lockState, len(snap.Locks))
+	for key, l := range snap.Locks {
+		state := &lockState{
+			writer:       l.Writer,
+			writerExpire: l.WriterExpire,
+			readers:      make(map[string]*reader, len(l.Readers)),
+		}
+		for owner, r := range l.Readers {
+			state.readers[owner] = &reader{count: r.Count, expire: r.Expire}
+		}
+		m.locks[key] = state
+	}
+	m.fence = snap.Fence
+	return nil
+}
+
+// subscribers are called while applying the log, replayed entries included.
+func (m *StateMachine) subscribe(channel string, fn func(string)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.subs[channel] = append(m.subs[channel], fn)
+}
+
+func (m *StateMachine) get(key string) (string, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	e, ok := m.data[key]
+	if !ok || e.expired(time.Now()) {
+		return "", false
+	}
+	return e.value, true
+}
+
+func (m *StateMachine) keys(prefix string) []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	now := time.Now()
+	out := make([]string, 0)
+	for key, e := range m.data {
+		if strings.HasPrefix(key, prefix) && !e.expired(now) {
+			out = append(out, key)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
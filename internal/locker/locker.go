@@ -7,10 +7,13 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/pkg/errors"
+	"github.com/qwp0905/go-object-storage/internal/kv"
 	"github.com/qwp0905/go-object-storage/pkg/nocopy"
 	"github.com/redis/go-redis/v9"
 )
 
+const fenceKey = "FENCE"
+
 func readLockKey(key string) string {
 	return fmt.Sprintf("READ:%s", key)
 }
@@ -36,12 +39,19 @@ return redis.call("PUBLISH", KEYS[1], "")
 
 var writeLockScript = redis.NewScript(`
 if redis.call("EXISTS", KEYS[2]) == 1 then
-	return redis.call("PTTL", KEYS[2])
+	return {redis.call("PTTL", KEYS[2]), 0}
 end
 if redis.call("SET", KEYS[1], ARGV[1], "NX", "PX", ARGV[2]) then
-	return -10
+	return {-10, redis.call("INCR", KEYS[3])}
+end
+return {redis.call("PTTL", KEYS[1]), 0}
+`)
+
+var extendScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) ~= ARGV[1] then
+	return 0
 end
-return redis.call("PTTL", KEYS[1])
+return redis.call("PEXPIRE", KEYS[1], ARGV[2])
 `)
 
 var writeUnlockScript = redis.NewScript(`
@@ -57,16 +67,17 @@ return redis.call("PUBLISH", KEYS[1], "")
 type RWMutex interface {
 	RLock(ctx context.Context) error
 	RUnlock(ctx context.Context) error
-	Lock(ctx context.Context) error
+	Lock(ctx context.Context) (uint64, error)
 	Unlock(ctx context.Context) error
 }
 
 type rwMutexImpl struct {
-	noCopy  nocopy.NoCopy
-	rc      *redis.Client
-	key     string
-	current string
-	timeout time.Duration
+	noCopy   nocopy.NoCopy
+	rc       *redis.Client
+	key      string
+	current  string
+	timeout  time.Duration
+	watchdog *watchdog
 }
 
 func NewRWMutex(rc *redis.Client, key string, timeout time.Duration) (RWMutex, error) {
@@ -76,6 +87,7 @@ func NewRWMutex(rc *redis.Client, key string, timeout time.Duration) (RWMutex, e
 		readUnlockScript,
 		writeLockScript,
 		writeUnlockScript,
+		extendScript,
 	} {
 		ok, err := s.Exists(ctx, rc).Result()
 		if err != nil {
@@ -127,37 +139,64 @@ func generate() string {
 	return uuid.Must(uuid.NewRandom()).String()
 }
 
-func (l *rwMutexImpl) Lock(ctx context.Context) error {
+func (l *rwMutexImpl) Lock(ctx context.Context) (uint64, error) {
 	v := generate()
 	sub := l.rc.Subscribe(ctx, l.key)
 	defer sub.Close()
 	for {
-		pttl, err := writeLockScript.Run(
+		res, err := writeLockScript.Run(
 			ctx,
 			l.rc,
-			[]string{l.key, readLockKey(l.key)},
+			[]string{l.key, readLockKey(l.key), fenceKey},
 			v,
 			l.timeout.Milliseconds(),
-		).Int()
+		).Int64Slice()
 		if err != nil {
-			return errors.WithStack(err)
+			return 0, errors.WithStack(err)
 		}
-		if pttl == -10 {
+		if res[0] == -10 {
 			l.current = v
-			return nil
+			l.watchdog = watch(l.timeout, func() error { return l.extend(v) })
+			return uint64(res[1]), nil
 		}
 		select {
 		case <-sub.Channel():
-		case <-time.After(time.Duration(pttl) * time.Millisecond):
+		case <-time.After(time.Duration(res[0]) * time.Millisecond):
 		}
 	}
 }
 
+func (l *rwMutexImpl) extend(owner string) error {
+	held, err := extendScript.Run(
+		context.Background(),
+		l.rc,
+		[]string{l.key},
+		owner,
+		l.timeout.Milliseconds(),
+	).Int()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if held == 0 {
+		return errors.WithStack(kv.ErrLockLost)
+	}
+	return nil
+}
+
+// Unlock returns kv.ErrLockLost if the lease ran out while the lock was held.
 func (l *rwMutexImpl) Unlock(ctx context.Context) error {
-	return errors.WithStack(writeUnlockScript.Run(
+	var lost error
+	if l.watchdog != nil {
+		lost = l.watchdog.stop()
+		l.watchdog = nil
+	}
+	if err := writeUnlockScript.Run(
 		ctx,
 		l.rc,
 		[]string{l.key},
 		l.current,
-	).Err())
+	).Err(); err != nil {
+		return errors.WithStack(err)
+	}
+	return lost
 }
@@ -0,0 +1,179 @@
+package locker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/qwp0905/go-object-storage/internal/kv"
+)
+
+// openLockers returns pools of different processes sharing one backend.
+type openLockers func(t *testing.T, timeout time.Duration) func() LockerPool
+
+func newStoreLockerPool(t *testing.T, timeout time.Duration) func() LockerPool {
+	store := kv.NewLocal()
+	return func() LockerPool {
+		return NewStorePool(store, timeout)
+	}
+}
+
+func TestStoreLocker(t *testing.T) {
+	testLocker(t, newStoreLockerPool)
+}
+
+func testLocker(t *testing.T, open openLockers) {
+	ctx := context.Background()
+	const timeout = time.Second * 10
+	const blocked = time.Millisecond * 100
+
+	mustLock := func(t *testing.T, l RWMutex) uint64 {
+		t.Helper()
+		token, err := l.Lock(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	// goLock takes the lock in the background, the channel gets its token.
+	goLock := func(l RWMutex) <-chan uint64 {
+		ch := make(chan uint64, 1)
+		go func() {
+			token, err := l.Lock(ctx)
+			if err != nil {
+				t.Error(err)
+			}
+			ch <- token
+		}()
+		return ch
+	}
+	mustWait := func(t *testing.T, ch <-chan uint64) {
+		t.Helper()
+		select {
+		case <-ch:
+			t.Fatal("lock is taken twice")
+		case <-time.After(blocked):
+		}
+	}
+	mustGet := func(t *testing.T, ch <-chan uint64) uint64 {
+		t.Helper()
+		select {
+		case token := <-ch:
+			return token
+		case <-time.After(time.Second * 5):
+			t.Fatal("lock is not handed over")
+			return 0
+		}
+	}
+
+	t.Run("exclusive", func(t *testing.T) {
+		pool := open(t, timeout)
+		a, b := pool().Get("key"), pool().Get("key")
+		first := mustLock(t, a)
+		ch := goLock(b)
+		mustWait(t, ch)
+
+		if err := a.Unlock(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if second := mustGet(t, ch); second <= first {
+			t.Fatalf("token %d is not above %d", second, first)
+		}
+		if err := b.Unlock(ctx); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("unlock by another owner", func(t *testing.T) {
+		pool := open(t, timeout)
+		a, b, c := pool().Get("key"), pool().Get("key"), pool().Get("key")
+		mustLock(t, a)
+		if err := b.Unlock(ctx); err != nil {
+			t.Fatal(err)
+		}
+		ch := goLock(c)
+		mustWait(t, ch)
+
+		if err := a.Unlock(ctx); err != nil {
+			t.Fatal(err)
+		}
+		mustGet(t, ch)
+		if err := c.Unlock(ctx); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("shared", func(t *testing.T) {
+		pool := open(t, timeout)
+		r1, r2, w := pool().Get("key"), pool().Get("key"), pool().Get("key")
+		for _, r := range []RWMutex{r1, r2} {
+			if err := r.RLock(ctx); err != nil {
+				t.Fatal(err)
+			}
+		}
+		ch := goLock(w)
+		mustWait(t, ch)
+
+		for _, r := range []RWMutex{r1, r2} {
+			if err := r.RUnlock(ctx); err != nil {
+				t.Fatal(err)
+			}
+		}
+		mustGet(t, ch)
+
+		read := make(chan error, 1)
+		go func() { read <- r1.RLock(ctx) }()
+		select {
+		case <-read:
+			t.Fatal("read lock is taken under a write lock")
+		case <-time.After(blocked):
+		}
+		if err := w.Unlock(ctx); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case err := <-read:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(time.Second * 5):
+			t.Fatal("read lock is not handed over")
+		}
+		if err := r1.RUnlock(ctx); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("tokens increase across keys", func(t *testing.T) {
+		pool := open(t, timeout)()
+		var last uint64
+		for _, key := range []string{"a", "b", "c"} {
+			l := pool.Get(key)
+			token := mustLock(t, l)
+			if token <= last {
+				t.Fatalf("token %d is not above %d", token, last)
+			}
+			last = token
+			if err := l.Unlock(ctx); err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+
+	t.Run("watchdog keeps the lease", func(t *testing.T) {
+		pool := open(t, time.Millisecond*150)
+		a, b := pool().Get("key"), pool().Get("key")
+		mustLock(t, a)
+		time.Sleep(time.Millisecond * 300)
+
+		ch := goLock(b)
+		mustWait(t, ch)
+		if err := a.Unlock(ctx); err != nil {
+			t.Fatalf("lease is lost: %v", err)
+		}
+		mustGet(t, ch)
+		if err := b.Unlock(ctx); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/pkg/errors"
+	"github.com/qwp0905/go-object-storage/internal/kv"
 	"github.com/qwp0905/go-object-storage/pkg/list"
 	"github.com/qwp0905/go-object-storage/pkg/nocopy"
 	"github.com/redis/go-redis/v9"
@@ -16,13 +17,12 @@ type LockerPool interface {
 }
 
 type lockerPoolImpl struct {
-	noCopy   nocopy.NoCopy
-	rc       *redis.Client
-	timeout  time.Duration
-	pool     map[string]*lockerPoolItem
-	accessed *list.DoubleLinked[string]
-	maxSize  int
-	mu       *sync.Mutex
+	noCopy    nocopy.NoCopy
+	newLocker func(key string) RWMutex
+	pool      map[string]*lockerPoolItem
+	accessed  *list.DoubleLinked[string]
+	maxSize   int
+	mu        *sync.Mutex
 }
 
 type lockerPoolItem struct {
@@ -37,6 +37,7 @@ func NewPool(rc *redis.Client, timeout time.Duration) (LockerPool, error) {
 		readUnlockScript,
 		writeLockScript,
 		writeUnlockScript,
+		extendScript,
 	} {
 		ok, err := s.Exists(ctx, rc).Result()
 		if err != nil {
@@ -49,14 +50,25 @@ func NewPool(rc *redis.Client, timeout time.Duration) (LockerPool, error) {
 			return nil, errors.WithStack(err)
 		}
 	}
+	return newLockerPool(func(key string) RWMutex {
+		return &rwMutexImpl{rc: rc, timeout: timeout, key: key}
+	}), nil
+}
+
+func NewStorePool(store kv.LockStore, timeout time.Duration) LockerPool {
+	return newLockerPool(func(key string) RWMutex {
+		return newStoreRWMutex(store, key, timeout)
+	})
+}
+
+func newLockerPool(newLocker func(key string) RWMutex) *lockerPoolImpl {
 	return &lockerPoolImpl{
-		rc:       rc,
-		timeout:  timeout,
-		pool:     make(map[string]*lockerPoolItem),
-		accessed: list.NewDoubleLinked[string](),
-		maxSize:  500,
-		mu:       new(sync.Mutex),
-	}, nil
+		newLocker: newLocker,
+		pool:      make(map[string]*lockerPoolItem),
+		accessed:  list.NewDoubleLinked[string](),
+		maxSize:   500,
+		mu:        new(sync.Mutex),
+	}
 }
 
 func (p *lockerPoolImpl) Get(key string) RWMutex {
@@ -71,7 +83,7 @@ func (p *lockerPoolImpl) Get(key string) RWMutex {
 
 	item = &lockerPoolItem{
 		lastAccess: list.NewDoubleLinkedElement[string](key),
-		locker:     &rwMutexImpl{rc: p.rc, timeout: p.timeout, key: key},
+		locker:     p.newLocker(key),
 	}
 
 	for len(p.pool) >= p.maxSize {
@@ -0,0 +1,45 @@
+//go:build redis
+
+package locker
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// run with a disposable redis: REDIS_ADDR=localhost:6379 go test -tags redis ./internal/locker
+// the db given by REDIS_DB (15 by default) is flushed.
+func newRedisLockerPool(t *testing.T, timeout time.Duration) func() LockerPool {
+	addr := os.Getenv("REDIS_ADDR")
+	if addr == "" {
+		t.Skip("REDIS_ADDR is not set")
+	}
+	db := 15
+	if v := os.Getenv("REDIS_DB"); v != "" {
+		var err error
+		if db, err = strconv.Atoi(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rc := redis.NewClient(&redis.Options{Addr: addr, DB: db})
+	t.Cleanup(func() { rc.Close() })
+	if err := rc.FlushDB(context.Background()).Err(); err != nil {
+		t.Fatal(err)
+	}
+	return func() LockerPool {
+		pool, err := NewPool(rc, timeout)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pool
+	}
+}
+
+func TestRedisLocker(t *testing.T) {
+	testLocker(t, newRedisLockerPool)
+}
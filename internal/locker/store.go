@@ -0,0 +1,94 @@
+package locker
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/qwp0905/go-object-storage/internal/kv"
+	"github.com/qwp0905/go-object-storage/pkg/nocopy"
+)
+
+const storePollInterval = time.Millisecond * 20
+
+type storeRWMutexImpl struct {
+	noCopy   nocopy.NoCopy
+	store    kv.LockStore
+	key      string
+	reader   string
+	current  string
+	timeout  time.Duration
+	watchdog *watchdog
+}
+
+func newStoreRWMutex(store kv.LockStore, key string, timeout time.Duration) RWMutex {
+	return &storeRWMutexImpl{
+		store:   store,
+		key:     key,
+		reader:  generate(),
+		timeout: timeout,
+	}
+}
+
+func (l *storeRWMutexImpl) acquire(ctx context.Context, owner string, shared bool) (uint64, error) {
+	notifier, _ := l.store.(kv.Notifier)
+	for {
+		var released <-chan struct{}
+		if notifier != nil {
+			released = notifier.Released(l.key)
+		}
+
+		wait, token, err := l.store.TryLock(ctx, l.key, owner, shared, l.timeout)
+		if err != nil {
+			return 0, err
+		}
+		if wait <= 0 {
+			return token, nil
+		}
+		if released == nil && wait > storePollInterval {
+			wait = storePollInterval
+		}
+
+		select {
+		case <-ctx.Done():
+			return 0, errors.WithStack(ctx.Err())
+		case <-released:
+		case <-time.After(wait):
+		}
+	}
+}
+
+func (l *storeRWMutexImpl) RLock(ctx context.Context) error {
+	_, err := l.acquire(ctx, l.reader, true)
+	return err
+}
+
+func (l *storeRWMutexImpl) RUnlock(ctx context.Context) error {
+	return l.store.Unlock(ctx, l.key, l.reader, true)
+}
+
+func (l *storeRWMutexImpl) Lock(ctx context.Context) (uint64, error) {
+	v := generate()
+	token, err := l.acquire(ctx, v, false)
+	if err != nil {
+		return 0, err
+	}
+	l.current = v
+	l.watchdog = watch(l.timeout, func() error {
+		return l.store.Extend(context.Background(), l.key, v, l.timeout)
+	})
+	return token, nil
+}
+
+// Unlock returns kv.ErrLockLost if the lease ran out while the lock was held.
+func (l *storeRWMutexImpl) Unlock(ctx context.Context) error {
+	var lost error
+	if l.watchdog != nil {
+		lost = l.watchdog.stop()
+		l.watchdog = nil
+	}
+	if err := l.store.Unlock(ctx, l.key, l.current, false); err != nil {
+		return err
+	}
+	return lost
+}
@@ -0,0 +1,54 @@
+package locker
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/qwp0905/go-object-storage/internal/kv"
+	"github.com/qwp0905/go-object-storage/pkg/logger"
+)
+
+// watchdog extends a write lease until stopped. a failed extension is retried
+// while the lease lasts, once it runs out or is taken the lock is lost.
+type watchdog struct {
+	done chan struct{}
+	lost chan struct{}
+}
+
+func watch(timeout time.Duration, extend func() error) *watchdog {
+	w := &watchdog{done: make(chan struct{}), lost: make(chan struct{})}
+	go func() {
+		t := time.NewTicker(timeout / 3)
+		defer t.Stop()
+		extended := time.Now()
+		for {
+			select {
+			case <-w.done:
+				return
+			case <-t.C:
+				err := extend()
+				if err == nil {
+					extended = time.Now()
+					continue
+				}
+				if errors.Is(err, kv.ErrLockLost) || time.Since(extended) >= timeout {
+					logger.Errorf("lease is lost %+v", err)
+					close(w.lost)
+					return
+				}
+				logger.Warnf("%+v", err)
+			}
+		}
+	}()
+	return w
+}
+
+func (w *watchdog) stop() error {
+	close(w.done)
+	select {
+	case <-w.lost:
+		return errors.WithStack(kv.ErrLockLost)
+	default:
+		return nil
+	}
+}
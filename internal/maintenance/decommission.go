@@ -0,0 +1,156 @@
+package maintenance
+
+import (
+	"context"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pkg/errors"
+	"github.com/qwp0905/go-object-storage/internal/metadata"
+	"github.com/qwp0905/go-object-storage/internal/nodepool"
+	"github.com/qwp0905/go-object-storage/internal/trie"
+	"github.com/qwp0905/go-object-storage/pkg/logger"
+	"github.com/qwp0905/go-object-storage/pkg/nocopy"
+)
+
+var ErrDecommissioned = fiber.NewError(fiber.StatusConflict, "datanode is already decommissioned")
+
+type Decommissioner interface {
+	Decommission(ctx context.Context, id string) error
+	Cancel(ctx context.Context, id string) error
+	Start(sec int)
+}
+
+type decommissionerImpl struct {
+	noCopy nocopy.NoCopy
+	pool   nodepool.NodePool
+	walker trie.Walker
+	mover  trie.Mover
+}
+
+func NewDecommissioner(pool nodepool.NodePool, walker trie.Walker, mover trie.Mover) Decommissioner {
+	return &decommissionerImpl{pool: pool, walker: walker, mover: mover}
+}
+
+func (d *decommissionerImpl) Decommission(ctx context.Context, id string) error {
+	if _, err := d.pool.GetNodeHost(ctx, id); err != nil {
+		return err
+	}
+	return d.pool.SetNodeState(ctx, id, nodepool.NodeStateDecommissioning)
+}
+
+// a drained node holds nothing anymore, so it is not put back to use.
+func (d *decommissionerImpl) Cancel(ctx context.Context, id string) error {
+	state, err := d.pool.GetNodeState(ctx, id)
+	if err != nil {
+		return err
+	}
+	if state == nodepool.NodeStateDecommissioned {
+		return ErrDecommissioned
+	}
+	return d.pool.SetNodeState(ctx, id, nodepool.NodeStateActive)
+}
+
+func (d *decommissionerImpl) Start(sec int) {
+	ctx := context.Background()
+	timer := time.NewTicker(time.Second * time.Duration(sec))
+	for range timer.C {
+		nodes, err := d.pool.GetNodes(ctx)
+		if err != nil {
+			logger.Errorf("%+v", err)
+			continue
+		}
+		for _, node := range nodes {
+			if node.State != nodepool.NodeStateDecommissioning {
+				continue
+			}
+			if err := d.drain(ctx, node.Id); err != nil {
+				logger.Errorf("%+v", err)
+			}
+		}
+	}
+}
+
+func (d *decommissionerImpl) drain(ctx context.Context, id string) error {
+	metadataList := make([]*trie.Entry, 0)
+	objectList := make([]*trie.Entry, 0)
+	if err := d.walker.Walk(ctx, func(e *trie.Entry) error {
+		if e.Id == id {
+			metadataList = append(metadataList, e)
+		}
+		if e.Metadata.FileExists() && stores(e.Metadata, id) {
+			objectList = append(objectList, e)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	if len(metadataList) == 0 && len(objectList) == 0 {
+		logger.Infof("datanode %s drained", id)
+		return d.pool.SetNodeState(ctx, id, nodepool.NodeStateDecommissioned)
+	}
+
+	logger.Infof(
+		"draining datanode %s, %d metadata and %d objects left",
+		id,
+		len(metadataList),
+		len(objectList),
+	)
+
+	targets := newTargets(d.pool)
+	for _, e := range objectList {
+		if e.Metadata.Chunked() {
+			to, err := targets.chunks(ctx, e.Metadata, id, e.Metadata.Class())
+			if err != nil {
+				return err
+			}
+			if _, err := d.mover.MoveChunks(ctx, e, to, ""); err != nil {
+				if !errors.Is(err, trie.ErrStale) {
+					logger.Warnf("%+v", err)
+				}
+			}
+			continue
+		}
+
+		to, err := targets.acquire(ctx, e.Metadata, e.Metadata.Class())
+		if err != nil {
+			return err
+		}
+		if _, err := d.mover.MoveObject(ctx, e, to); err != nil {
+			if !errors.Is(err, trie.ErrStale) {
+				logger.Warnf("%+v", err)
+			}
+		}
+	}
+
+	moved := make(map[string]string)
+	for _, e := range metadataList {
+		if parentId, ok := moved[e.Parent]; ok {
+			e.ParentId = parentId
+		}
+		to, err := d.pool.AcquireNode(ctx)
+		if err != nil {
+			return err
+		}
+		if err := d.mover.MoveMetadata(ctx, e, to); err != nil {
+			if !errors.Is(err, trie.ErrStale) {
+				logger.Warnf("%+v", err)
+			}
+			continue
+		}
+		moved[e.Metadata.Key] = to
+	}
+
+	return nil
+}
+
+// stores reports whether node id holds any of the data of meta.
+func stores(meta *metadata.Metadata, id string) bool {
+	for _, part := range meta.Parts() {
+		if part.NodeId == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,367 @@
+package maintenance
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pkg/errors"
+	"github.com/qwp0905/go-object-storage/internal/filesystem"
+	"github.com/qwp0905/go-object-storage/internal/locker"
+	"github.com/qwp0905/go-object-storage/internal/metadata"
+	"github.com/qwp0905/go-object-storage/internal/nodepool"
+	"github.com/qwp0905/go-object-storage/internal/trie"
+	"github.com/qwp0905/go-object-storage/pkg/nocopy"
+)
+
+const (
+	IssueMissingRoot    = "missing_root"
+	IssueDanglingRoute  = "dangling_route"
+	IssueOrphanMetadata = "orphan_metadata"
+	IssueOrphanObject   = "orphan_object"
+	IssuePrefixOrder    = "prefix_order"
+)
+
+type FsckIssue struct {
+	Kind     string `json:"kind"`
+	NodeId   string `json:"node_id"`
+	Key      string `json:"key"`
+	ParentId string `json:"parent_id,omitempty"`
+	Parent   string `json:"parent,omitempty"`
+	Detail   string `json:"detail,omitempty"`
+	Repaired bool   `json:"repaired"`
+	Error    string `json:"error,omitempty"`
+}
+
+type FsckReport struct {
+	Metadata int          `json:"metadata"`
+	Objects  int          `json:"objects"`
+	Issues   []*FsckIssue `json:"issues"`
+}
+
+func (r *FsckReport) Clean() bool {
+	for _, issue := range r.Issues {
+		if !issue.Repaired {
+			return false
+		}
+	}
+	return true
+}
+
+type FsckConfig struct {
+	Repair bool
+	Grace  time.Duration
+}
+
+type Checker interface {
+	Check(ctx context.Context) (*FsckReport, error)
+}
+
+type checkerImpl struct {
+	noCopy     nocopy.NoCopy
+	pool       nodepool.NodePool
+	lockerPool locker.LockerPool
+	walker     trie.Walker
+	config     *FsckConfig
+	rootKey    string
+}
+
+func NewChecker(
+	pool nodepool.NodePool,
+	lockerPool locker.LockerPool,
+	walker trie.Walker,
+	config *FsckConfig,
+) Checker {
+	return &checkerImpl{
+		pool:       pool,
+		lockerPool: lockerPool,
+		walker:     walker,
+		config:     config,
+		rootKey:    "/",
+	}
+}
+
+type fileRef struct {
+	nodeId string
+	name   string
+}
+
+type fsckState struct {
+	report      *FsckReport
+	visited     map[fileRef]bool
+	live        map[fileRef]bool
+	rootMissing bool
+}
+
+func (c *checkerImpl) Check(ctx context.Context) (*FsckReport, error) {
+	nodes, err := c.pool.GetNodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// listing before the walk makes everything linked in the meantime either
+	// visible to the walk or younger than the grace period.
+	metaFiles := make(map[string][]*filesystem.FileInfo)
+	objectFiles := make(map[string][]*filesystem.FileInfo)
+	for _, node := range nodes {
+		if metaFiles[node.Id], err = c.pool.ListMetadata(ctx, node.Id); err != nil {
+			return nil, err
+		}
+		if objectFiles[node.Id], err = c.pool.ListObjects(ctx, node.Id); err != nil {
+			return nil, err
+		}
+	}
+
+	state := &fsckState{
+		report:  &FsckReport{Issues: make([]*FsckIssue, 0)},
+		visited: make(map[fileRef]bool),
+		live:    make(map[fileRef]bool),
+	}
+
+	rootId, err := c.walker.RootId(ctx)
+	if errors.Is(err, fiber.ErrNotFound) {
+		state.rootMissing = true
+		c.report(state, &FsckIssue{Kind: IssueMissingRoot, Key: c.rootKey, Detail: "root is not registered"})
+	} else if err != nil {
+		return nil, err
+	} else if err := c.visit(ctx, state, "", nil, rootId, c.rootKey); err != nil {
+		return nil, err
+	}
+
+	// every file looks orphaned without a root, none is removed so that the
+	// trie can still be put back.
+	if state.rootMissing {
+		state.report.Metadata = len(state.visited)
+		return state.report, nil
+	}
+
+	deadline := time.Now().Add(-c.config.Grace)
+	for _, node := range nodes {
+		for _, file := range metaFiles[node.Id] {
+			if state.visited[fileRef{node.Id, file.Name}] || file.LastModified.After(deadline) {
+				continue
+			}
+			c.orphanMetadata(ctx, state, node.Id, file.Name)
+		}
+	}
+
+	for _, node := range nodes {
+		for _, file := range objectFiles[node.Id] {
+			if state.live[fileRef{node.Id, file.Name}] || file.LastModified.After(deadline) {
+				continue
+			}
+			c.orphanObject(ctx, state, node.Id, file.Name)
+		}
+	}
+
+	state.report.Metadata = len(state.visited)
+	state.report.Objects = len(state.live)
+	return state.report, nil
+}
+
+func (c *checkerImpl) visit(
+	ctx context.Context,
+	state *fsckState,
+	parentId string,
+	parent *metadata.Metadata,
+	id, current string,
+) error {
+	ref := fileRef{id, current}
+	if state.visited[ref] {
+		return nil
+	}
+
+	currentMeta, err := c.pool.GetMetadata(ctx, id, current)
+	if errors.Is(err, fiber.ErrNotFound) && parent == nil {
+		state.rootMissing = true
+		c.report(state, &FsckIssue{Kind: IssueMissingRoot, NodeId: id, Key: current, Detail: "root metadata is missing"})
+		return nil
+	} else if errors.Is(err, fiber.ErrNotFound) {
+		issue := &FsckIssue{
+			Kind:     IssueDanglingRoute,
+			NodeId:   id,
+			Key:      current,
+			ParentId: parentId,
+			Parent:   parent.Key,
+		}
+		c.repair(ctx, state, issue, func() error {
+			return c.removeRoute(ctx, parentId, parent.Key, id, current)
+		})
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	state.visited[ref] = true
+	if currentMeta.FileExists() {
+		for _, part := range currentMeta.Parts() {
+			state.live[fileRef{part.NodeId, part.Source}] = true
+		}
+	}
+
+	c.checkOrder(ctx, state, id, currentMeta)
+
+	for _, next := range currentMeta.NextNodes {
+		if err := c.visit(ctx, state, id, currentMeta, next.NodeId, next.Key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *checkerImpl) checkOrder(ctx context.Context, state *fsckState, id string, meta *metadata.Metadata) {
+	unsorted := false
+	for i, next := range meta.NextNodes {
+		if len(next.Key) <= len(meta.Key) || !strings.HasPrefix(next.Key, meta.Key) {
+			c.report(state, &FsckIssue{
+				Kind:   IssuePrefixOrder,
+				NodeId: id,
+				Key:    meta.Key,
+				Detail: fmt.Sprintf("route %s does not extend %s", next.Key, meta.Key),
+			})
+		}
+		if i == 0 {
+			continue
+		}
+
+		prev := meta.NextNodes[i-1]
+		if prev.Key >= next.Key {
+			unsorted = true
+		}
+		if shared := commonPrefix(prev.Key, next.Key); len(shared) > len(meta.Key) {
+			c.report(state, &FsckIssue{
+				Kind:   IssuePrefixOrder,
+				NodeId: id,
+				Key:    meta.Key,
+				Detail: fmt.Sprintf("routes %s and %s share prefix %s", prev.Key, next.Key, shared),
+			})
+		}
+	}
+
+	if !unsorted {
+		return
+	}
+
+	issue := &FsckIssue{
+		Kind:   IssuePrefixOrder,
+		NodeId: id,
+		Key:    meta.Key,
+		Detail: "routes are not sorted",
+	}
+	c.repair(ctx, state, issue, func() error {
+		return c.sortRoutes(ctx, id, meta.Key)
+	})
+}
+
+func (c *checkerImpl) orphanMetadata(ctx context.Context, state *fsckState, id, key string) {
+	issue := &FsckIssue{Kind: IssueOrphanMetadata, NodeId: id, Key: key}
+	meta, err := c.pool.GetMetadata(ctx, id, key)
+	if errors.Is(err, fiber.ErrNotFound) {
+		return
+	} else if err != nil {
+		issue.Error = err.Error()
+		c.report(state, issue)
+		return
+	}
+
+	// an unreachable object may still be wanted, it is only reported and its
+	// data is kept so that it can be put back under its key.
+	if meta.FileExists() {
+		for _, part := range meta.Parts() {
+			state.live[fileRef{part.NodeId, part.Source}] = true
+		}
+		issue.Detail = fmt.Sprintf("object %s on %s is kept", meta.Source, meta.NodeId)
+		c.report(state, issue)
+		return
+	}
+
+	c.repair(ctx, state, issue, func() error {
+		return c.pool.DeleteMetadataIf(ctx, id, key, meta.Version)
+	})
+}
+
+func (c *checkerImpl) orphanObject(ctx context.Context, state *fsckState, id, source string) {
+	issue := &FsckIssue{Kind: IssueOrphanObject, NodeId: id, Key: source}
+	c.repair(ctx, state, issue, func() error {
+		return c.pool.PurgeDirect(ctx, &metadata.Metadata{NodeId: id, Source: source})
+	})
+}
+
+func (c *checkerImpl) report(state *fsckState, issue *FsckIssue) {
+	state.report.Issues = append(state.report.Issues, issue)
+}
+
+func (c *checkerImpl) repair(ctx context.Context, state *fsckState, issue *FsckIssue, fn func() error) {
+	c.report(state, issue)
+	if !c.config.Repair {
+		return
+	}
+
+	if err := fn(); err != nil {
+		issue.Error = err.Error()
+		return
+	}
+	issue.Repaired = true
+}
+
+func (c *checkerImpl) removeRoute(ctx context.Context, parentId, parent, id, key string) error {
+	locker := c.lockerPool.Get(parent)
+	token, err := locker.Lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer locker.Unlock(ctx)
+
+	if _, err := c.pool.GetMetadata(ctx, id, key); err == nil {
+		return errors.WithStack(trie.ErrStale)
+	} else if !errors.Is(err, fiber.ErrNotFound) {
+		return err
+	}
+
+	parentMeta, err := c.pool.GetMetadata(ctx, parentId, parent)
+	if err != nil {
+		return err
+	}
+
+	for i, next := range parentMeta.NextNodes {
+		if next.NodeId == id && next.Key == key {
+			parentMeta.RemoveNext(i)
+			parentMeta.Fence = token
+			return c.pool.PutMetadataIf(ctx, parentId, parentMeta, parentMeta.Version)
+		}
+	}
+
+	return nil
+}
+
+func (c *checkerImpl) sortRoutes(ctx context.Context, id, key string) error {
+	locker := c.lockerPool.Get(key)
+	token, err := locker.Lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer locker.Unlock(ctx)
+
+	meta, err := c.pool.GetMetadata(ctx, id, key)
+	if err != nil {
+		return err
+	}
+
+	sort.SliceStable(meta.NextNodes, func(i, j int) bool {
+		return meta.NextNodes[i].Key < meta.NextNodes[j].Key
+	})
+	meta.Fence = token
+	return c.pool.PutMetadataIf(ctx, id, meta, meta.Version)
+}
+
+func commonPrefix(a, b string) string {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return a[:i]
+}
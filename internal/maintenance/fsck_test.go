@@ -0,0 +1,130 @@
+package maintenance
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/qwp0905/go-object-storage/internal/metadata"
+)
+
+func check(t *testing.T, c *cluster, repair bool) *FsckReport {
+	t.Helper()
+	checker := NewChecker(c.pool, c.lockerPool, c.walker, &FsckConfig{Repair: repair, Grace: time.Hour})
+	report, err := checker.Check(context.Background())
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	return report
+}
+
+func issuesOf(report *FsckReport, kind string) []*FsckIssue {
+	out := make([]*FsckIssue, 0)
+	for _, issue := range report.Issues {
+		if issue.Kind == kind {
+			out = append(out, issue)
+		}
+	}
+	return out
+}
+
+// findNode returns the route to the node of key.
+func findNode(t *testing.T, c *cluster, key string) *metadata.NextRoute {
+	t.Helper()
+	ctx := context.Background()
+	rootId, err := c.walker.RootId(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	route := &metadata.NextRoute{NodeId: rootId, Key: "/"}
+	for route.Key != key {
+		meta, err := c.pool.GetMetadata(ctx, route.NodeId, route.Key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		index := meta.FindPrefix(key)
+		if index == -1 {
+			t.Fatalf("no node of %s", key)
+		}
+		route = meta.GetNext(index)
+	}
+	return route
+}
+
+func TestFsckClean(t *testing.T) {
+	c := newCluster(t, nil)
+	c.put(t, "/a/1", "/a/2", "/b")
+	c.pool.Age(time.Hour * 2)
+
+	report := check(t, c, true)
+	if len(report.Issues) != 0 {
+		t.Fatalf("clean trie reports %+v", report.Issues[0])
+	}
+	if data, meta := c.pool.Files(); report.Objects != data || report.Metadata != meta {
+		t.Fatalf("checked %d metadata and %d objects of %d and %d", report.Metadata, report.Objects, meta, data)
+	}
+}
+
+func TestFsckDanglingRoute(t *testing.T) {
+	c := newCluster(t, nil)
+	c.put(t, "/a/1", "/a/2", "/b")
+	ctx := context.Background()
+	route := findNode(t, c, "/a/2")
+	meta, err := c.pool.GetMetadata(ctx, route.NodeId, route.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.pool.DeleteMetadata(ctx, route.NodeId, route.Key); err != nil {
+		t.Fatal(err)
+	}
+	c.pool.Age(time.Hour * 2)
+
+	report := check(t, c, false)
+	if len(issuesOf(report, IssueDanglingRoute)) != 1 || report.Clean() {
+		t.Fatalf("dangling route is not reported: %+v", report.Issues)
+	}
+	if _, ok := c.pool.Data(meta.NodeId, meta.Source); !ok {
+		t.Fatal("check without repair removed data")
+	}
+
+	report = check(t, c, true)
+	dangling, orphans := issuesOf(report, IssueDanglingRoute), issuesOf(report, IssueOrphanObject)
+	if len(dangling) != 1 || dangling[0].Key != "/a/2" || !report.Clean() {
+		t.Fatalf("dangling route is not repaired: %+v", report.Issues)
+	}
+	if len(orphans) != 1 || orphans[0].Key != meta.Source {
+		t.Fatalf("data of the lost object is not collected: %+v", report.Issues)
+	}
+
+	if report := check(t, c, true); len(report.Issues) != 0 {
+		t.Fatalf("repaired trie reports %+v", report.Issues[0])
+	}
+	c.get(t, "/a/1")
+	c.get(t, "/b")
+}
+
+func TestFsckMissingRoot(t *testing.T) {
+	c := newCluster(t, nil)
+	c.put(t, "/a/1", "/a/2", "/b")
+	ctx := context.Background()
+	rootId, err := c.walker.RootId(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.pool.DeleteMetadata(ctx, rootId, "/"); err != nil {
+		t.Fatal(err)
+	}
+	c.pool.Age(time.Hour * 2)
+	data, meta := c.pool.Files()
+
+	report := check(t, c, true)
+	if len(issuesOf(report, IssueMissingRoot)) != 1 || report.Clean() {
+		t.Fatalf("missing root is not reported: %+v", report.Issues)
+	}
+
+	// every node looks orphaned without a root, none is removed so the trie
+	// can be put back.
+	if d, m := c.pool.Files(); d != data || m != meta {
+		t.Fatalf("%d data and %d metadata files left of %d and %d", d, m, data, meta)
+	}
+}
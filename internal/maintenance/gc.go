@@ -0,0 +1,147 @@
+package maintenance
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/qwp0905/go-object-storage/internal/filesystem"
+	"github.com/qwp0905/go-object-storage/internal/metadata"
+	"github.com/qwp0905/go-object-storage/internal/nodepool"
+	"github.com/qwp0905/go-object-storage/internal/trie"
+	"github.com/qwp0905/go-object-storage/pkg/logger"
+	"github.com/qwp0905/go-object-storage/pkg/nocopy"
+)
+
+type Collector interface {
+	Mark(ctx context.Context) (*GCPlan, error)
+	Run(ctx context.Context) error
+	Start(sec int)
+}
+
+type GCConfig struct {
+	Grace time.Duration
+}
+
+type GCCandidate struct {
+	NodeId       string    `json:"node_id"`
+	Source       string    `json:"source"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"last_modified"`
+}
+
+type GCPlan struct {
+	Live       int            `json:"live"`
+	Scanned    int            `json:"scanned"`
+	Candidates []*GCCandidate `json:"candidates"`
+}
+
+type collectorImpl struct {
+	noCopy nocopy.NoCopy
+	pool   nodepool.NodePool
+	walker trie.Walker
+	config *GCConfig
+	mu     *sync.Mutex
+}
+
+func NewCollector(pool nodepool.NodePool, walker trie.Walker, config *GCConfig) Collector {
+	return &collectorImpl{
+		pool:   pool,
+		walker: walker,
+		config: config,
+		mu:     new(sync.Mutex),
+	}
+}
+
+func (c *collectorImpl) Start(sec int) {
+	ctx := context.Background()
+	timer := time.NewTicker(time.Second * time.Duration(sec))
+	for range timer.C {
+		if err := c.Run(ctx); err != nil {
+			logger.Errorf("%+v", err)
+		}
+	}
+}
+
+func (c *collectorImpl) Mark(ctx context.Context) (*GCPlan, error) {
+	nodes, err := c.pool.GetNodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// objects are listed before marking so anything linked during the walk is
+	// either marked or younger than the grace period.
+	files := make(map[string][]*filesystem.FileInfo)
+	for _, node := range nodes {
+		list, err := c.pool.ListObjects(ctx, node.Id)
+		if err != nil {
+			logger.Warnf("skip sweeping datanode %s: %+v", node.Id, err)
+			continue
+		}
+		files[node.Id] = list
+	}
+
+	live := make(map[fileRef]bool)
+	if err := c.walker.Walk(ctx, func(e *trie.Entry) error {
+		if e.Metadata.FileExists() {
+			for _, part := range e.Metadata.Parts() {
+				live[fileRef{part.NodeId, part.Source}] = true
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	plan := &GCPlan{Live: len(live), Candidates: make([]*GCCandidate, 0)}
+	deadline := time.Now().Add(-c.config.Grace)
+	for id, list := range files {
+		plan.Scanned += len(list)
+		for _, file := range list {
+			if live[fileRef{id, file.Name}] || file.LastModified.After(deadline) {
+				continue
+			}
+			plan.Candidates = append(plan.Candidates, &GCCandidate{
+				NodeId:       id,
+				Source:       file.Name,
+				Size:         file.Size,
+				LastModified: file.LastModified,
+			})
+		}
+	}
+
+	return plan, nil
+}
+
+func (c *collectorImpl) Run(ctx context.Context) error {
+	if !c.mu.TryLock() {
+		return fiber.NewError(fiber.StatusConflict, "garbage collection already running")
+	}
+	defer c.mu.Unlock()
+
+	plan, err := c.Mark(ctx)
+	if err != nil {
+		return err
+	}
+
+	removed := 0
+	freed := int64(0)
+	for _, candidate := range plan.Candidates {
+		// shared data left behind still counts references of its own.
+		if err := c.pool.PurgeDirect(ctx, &metadata.Metadata{
+			NodeId: candidate.NodeId,
+			Source: candidate.Source,
+		}); err != nil {
+			logger.Warnf("%+v", err)
+			continue
+		}
+		removed++
+		freed += candidate.Size
+	}
+
+	if removed > 0 {
+		logger.Infof("garbage collection removed %d objects, %d bytes", removed, freed)
+	}
+	return nil
+}
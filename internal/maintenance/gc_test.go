@@ -0,0 +1,90 @@
+package maintenance
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/qwp0905/go-object-storage/internal/metadata"
+	"github.com/qwp0905/go-object-storage/internal/namenode"
+)
+
+// putOrphan writes data no metadata points at.
+func putOrphan(t *testing.T, c *cluster, id, source string) *metadata.Metadata {
+	t.Helper()
+	meta := &metadata.Metadata{NodeId: id, Source: source}
+	if err := c.pool.PutDirect(context.Background(), meta, strings.NewReader(source)); err != nil {
+		t.Fatal(err)
+	}
+	return meta
+}
+
+func TestCollectorKeepsReachable(t *testing.T) {
+	c := newCluster(t, &namenode.Config{Dedup: true, ChunkThreshold: 8, ChunkSize: 4})
+	// plain, chunked and shared objects, and one overwritten in place.
+	c.put(t, "/a", "/chunked/object", "/s/1", "/s/2", "/b")
+	if err := c.namenode.PutObject(context.Background(), &namenode.PutObjectInput{
+		Key:  "/s/2",
+		Size: len("/s/1"),
+		Body: strings.NewReader("/s/1"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	old := putOrphan(t, c, "node-1", "old")
+	c.pool.Age(time.Hour * 2)
+	young := putOrphan(t, c, "node-2", "young")
+	data, _ := c.pool.Files()
+
+	collector := NewCollector(c.pool, c.walker, &GCConfig{Grace: time.Hour})
+	plan, err := collector.Mark(context.Background())
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if len(plan.Candidates) != 1 || plan.Candidates[0].Source != old.Source || plan.Live != data-2 {
+		t.Fatalf("plan marks %d live and %d candidates", plan.Live, len(plan.Candidates))
+	}
+
+	if err := collector.Run(context.Background()); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if _, ok := c.pool.Data(old.NodeId, old.Source); ok {
+		t.Fatal("orphan is kept")
+	}
+	if _, ok := c.pool.Data(young.NodeId, young.Source); !ok {
+		t.Fatal("orphan within the grace period is removed")
+	}
+	if left, _ := c.pool.Files(); left != data-1 {
+		t.Fatalf("%d data files left of %d", left, data)
+	}
+	for _, key := range []string{"/a", "/chunked/object", "/s/1", "/b"} {
+		c.get(t, key)
+	}
+}
+
+func TestCollectorStopsOnWalkError(t *testing.T) {
+	c := newCluster(t, nil)
+	c.put(t, "/a/1", "/a/2", "/b")
+	putOrphan(t, c, "node-0", "orphan")
+	c.pool.Age(time.Hour * 2)
+	data, _ := c.pool.Files()
+
+	// objects below a node that cannot be read are not known to be
+	// unreachable.
+	failed := errors.New("read failed")
+	c.pool.Fail(func(method, id, key string) error {
+		if method == "GetMetadata" && key == "/a/" {
+			return failed
+		}
+		return nil
+	})
+	err := NewCollector(c.pool, c.walker, &GCConfig{Grace: time.Hour}).Run(context.Background())
+	c.pool.Fail(nil)
+	if !errors.Is(err, failed) {
+		t.Fatalf("collected past a failed walk: %v", err)
+	}
+	if left, _ := c.pool.Files(); left != data {
+		t.Fatalf("%d data files left of %d", left, data)
+	}
+}
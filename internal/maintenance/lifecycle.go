@@ -0,0 +1,327 @@
+package maintenance
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/gofiber/fiber/v2"
+	"github.com/pkg/errors"
+	"github.com/qwp0905/go-object-storage/internal/kv"
+	"github.com/qwp0905/go-object-storage/internal/metadata"
+	"github.com/qwp0905/go-object-storage/internal/namenode"
+	"github.com/qwp0905/go-object-storage/internal/nodepool"
+	"github.com/qwp0905/go-object-storage/internal/trie"
+	"github.com/qwp0905/go-object-storage/pkg/logger"
+	"github.com/qwp0905/go-object-storage/pkg/nocopy"
+)
+
+func LifecycleKey(id string) string {
+	return fmt.Sprintf("LIFECYCLE:%s", id)
+}
+
+var ErrInvalidRule = fiber.NewError(
+	fiber.StatusBadRequest,
+	"rule needs an id, positive expiration or transition days and a known storage class",
+)
+
+// LifecycleRule moves objects under a prefix to StorageClass once they are
+// older than TransitionDays and expires them after ExpirationDays. zero days
+// disable either action.
+type LifecycleRule struct {
+	Id             string `json:"id"`
+	Prefix         string `json:"prefix"`
+	ExpirationDays int    `json:"expiration_days,omitempty"`
+	TransitionDays int    `json:"transition_days,omitempty"`
+	// cold when empty.
+	StorageClass string `json:"storage_class,omitempty"`
+	Disabled     bool   `json:"disabled,omitempty"`
+}
+
+func (r *LifecycleRule) class() string {
+	if r.StorageClass == "" {
+		return metadata.StorageClassCold
+	}
+	return r.StorageClass
+}
+
+func (r *LifecycleRule) valid() bool {
+	if r.Id == "" || r.ExpirationDays < 0 || r.TransitionDays < 0 {
+		return false
+	}
+	if r.ExpirationDays == 0 && r.TransitionDays == 0 {
+		return false
+	}
+	return metadata.ValidStorageClass(r.class())
+}
+
+func (r *LifecycleRule) after(meta *metadata.Metadata, days int) time.Time {
+	return meta.LastModified.Add(time.Duration(days) * 24 * time.Hour)
+}
+
+type ExpiredObject struct {
+	Key     string    `json:"key"`
+	Rule    string    `json:"rule,omitempty"`
+	Expires time.Time `json:"expires"`
+
+	metadata *metadata.Metadata
+}
+
+type ObjectTransition struct {
+	Key          string    `json:"key"`
+	Rule         string    `json:"rule"`
+	From         string    `json:"from"`
+	StorageClass string    `json:"storage_class"`
+	Due          time.Time `json:"due"`
+
+	entry *trie.Entry
+}
+
+type LifecyclePlan struct {
+	Scanned     int                 `json:"scanned"`
+	Expired     []*ExpiredObject    `json:"expired"`
+	Transitions []*ObjectTransition `json:"transitions"`
+}
+
+type Lifecycle interface {
+	Rules(ctx context.Context) ([]*LifecycleRule, error)
+	PutRule(ctx context.Context, rule *LifecycleRule) error
+	DeleteRule(ctx context.Context, id string) error
+	Plan(ctx context.Context) (*LifecyclePlan, error)
+	Run(ctx context.Context) error
+	Start(sec int)
+}
+
+type lifecycleImpl struct {
+	noCopy   nocopy.NoCopy
+	store    kv.Store
+	pool     nodepool.NodePool
+	walker   trie.Walker
+	mover    trie.Mover
+	nameNode namenode.NameNode
+	mu       *sync.Mutex
+}
+
+func NewLifecycle(
+	store kv.Store,
+	pool nodepool.NodePool,
+	walker trie.Walker,
+	mover trie.Mover,
+	nameNode namenode.NameNode,
+) Lifecycle {
+	return &lifecycleImpl{
+		store:    store,
+		pool:     pool,
+		walker:   walker,
+		mover:    mover,
+		nameNode: nameNode,
+		mu:       new(sync.Mutex),
+	}
+}
+
+func (l *lifecycleImpl) Start(sec int) {
+	ctx := context.Background()
+	timer := time.NewTicker(time.Second * time.Duration(sec))
+	for range timer.C {
+		if err := l.Run(ctx); err != nil {
+			logger.Errorf("%+v", err)
+		}
+	}
+}
+
+func (l *lifecycleImpl) Rules(ctx context.Context) ([]*LifecycleRule, error) {
+	keys, err := l.store.Keys(ctx, LifecycleKey(""))
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return []*LifecycleRule{}, nil
+	}
+	sort.Strings(keys)
+
+	values, err := l.store.MGet(ctx, keys...)
+	if err != nil {
+		return nil, err
+	}
+
+	rules := make([]*LifecycleRule, 0, len(values))
+	for _, value := range values {
+		if value == "" {
+			continue
+		}
+		rule := new(LifecycleRule)
+		if err := json.Unmarshal([]byte(value), rule); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func (l *lifecycleImpl) PutRule(ctx context.Context, rule *LifecycleRule) error {
+	if !rule.valid() {
+		return ErrInvalidRule
+	}
+
+	b, err := json.Marshal(rule)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return l.store.Set(ctx, LifecycleKey(rule.Id), string(b), 0)
+}
+
+func (l *lifecycleImpl) DeleteRule(ctx context.Context, id string) error {
+	return l.store.Del(ctx, LifecycleKey(id))
+}
+
+func (l *lifecycleImpl) Plan(ctx context.Context) (*LifecyclePlan, error) {
+	rules, err := l.Rules(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	plan := &LifecyclePlan{
+		Expired:     make([]*ExpiredObject, 0),
+		Transitions: make([]*ObjectTransition, 0),
+	}
+	if err := l.walker.Walk(ctx, func(e *trie.Entry) error {
+		if !e.Metadata.FileExists() {
+			return nil
+		}
+		plan.Scanned++
+		if expired := expiry(e.Metadata, rules); expired != nil && !now.Before(expired.Expires) {
+			plan.Expired = append(plan.Expired, expired)
+			return nil
+		}
+		if t := transition(e, rules); t != nil && !now.Before(t.Due) {
+			plan.Transitions = append(plan.Transitions, t)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	return plan, nil
+}
+
+// expiry returns when an object expires, by its own expires or the rule that
+// expires it first, or nil if it never does.
+func expiry(meta *metadata.Metadata, rules []*LifecycleRule) *ExpiredObject {
+	var out *ExpiredObject
+	if !meta.Expires.IsZero() {
+		out = &ExpiredObject{Key: meta.Key, Expires: meta.Expires, metadata: meta}
+	}
+
+	for _, rule := range rules {
+		if rule.Disabled || rule.ExpirationDays == 0 || !strings.HasPrefix(meta.Key, rule.Prefix) {
+			continue
+		}
+		expires := rule.after(meta, rule.ExpirationDays)
+		if out == nil || expires.Before(out.Expires) {
+			out = &ExpiredObject{Key: meta.Key, Rule: rule.Id, Expires: expires, metadata: meta}
+		}
+	}
+
+	return out
+}
+
+// transition returns the earliest move of an object to another class, or nil
+// if no rule moves it.
+func transition(e *trie.Entry, rules []*LifecycleRule) *ObjectTransition {
+	var out *ObjectTransition
+	for _, rule := range rules {
+		if rule.Disabled ||
+			rule.TransitionDays == 0 ||
+			rule.class() == e.Metadata.Class() ||
+			!strings.HasPrefix(e.Metadata.Key, rule.Prefix) {
+			continue
+		}
+		due := rule.after(e.Metadata, rule.TransitionDays)
+		if out == nil || due.Before(out.Due) {
+			out = &ObjectTransition{
+				Key:          e.Metadata.Key,
+				Rule:         rule.Id,
+				From:         e.Metadata.Class(),
+				StorageClass: rule.class(),
+				Due:          due,
+				entry:        e,
+			}
+		}
+	}
+
+	return out
+}
+
+func (l *lifecycleImpl) Run(ctx context.Context) error {
+	if !l.mu.TryLock() {
+		return fiber.NewError(fiber.StatusConflict, "lifecycle already running")
+	}
+	defer l.mu.Unlock()
+
+	plan, err := l.Plan(ctx)
+	if err != nil {
+		return err
+	}
+
+	expired := 0
+	for i := 0; i < len(plan.Expired); i += namenode.MaxDeleteKeys {
+		end := i + namenode.MaxDeleteKeys
+		if end > len(plan.Expired) {
+			end = len(plan.Expired)
+		}
+
+		objects := make([]*metadata.Metadata, 0, end-i)
+		for _, v := range plan.Expired[i:end] {
+			objects = append(objects, v.metadata)
+		}
+
+		result, err := l.nameNode.ExpireObjects(ctx, objects)
+		if err != nil {
+			return err
+		}
+		for _, e := range result.Errors {
+			logger.Warnf("expire %s: %s", e.Key, e.Message)
+		}
+		expired += len(result.Deleted)
+	}
+
+	transitioned := 0
+	targets := newTargets(l.pool)
+	for _, t := range plan.Transitions {
+		if _, err := l.transition(ctx, targets, t); err != nil {
+			if !errors.Is(err, trie.ErrStale) {
+				logger.Warnf("%+v", err)
+			}
+			continue
+		}
+		transitioned++
+	}
+
+	if expired > 0 || transitioned > 0 {
+		logger.Infof("lifecycle expired %d objects, transitioned %d objects", expired, transitioned)
+	}
+	return nil
+}
+
+// transition moves the object of t to its new tier, chunks are spread over
+// the tier again.
+func (l *lifecycleImpl) transition(ctx context.Context, targets *targets, t *ObjectTransition) (uint, error) {
+	if t.entry.Metadata.Chunked() {
+		to, err := targets.chunks(ctx, t.entry.Metadata, "", t.StorageClass)
+		if err != nil {
+			return 0, err
+		}
+		return l.mover.MoveChunks(ctx, t.entry, to, t.StorageClass)
+	}
+
+	to, err := targets.acquire(ctx, t.entry.Metadata, t.StorageClass)
+	if err != nil {
+		return 0, err
+	}
+	return l.mover.TransitionObject(ctx, t.entry, to, t.StorageClass)
+}
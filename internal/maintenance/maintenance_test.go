@@ -0,0 +1,67 @@
+package maintenance
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/qwp0905/go-object-storage/internal/kv"
+	"github.com/qwp0905/go-object-storage/internal/locker"
+	"github.com/qwp0905/go-object-storage/internal/namenode"
+	"github.com/qwp0905/go-object-storage/internal/nodepool/pooltest"
+	"github.com/qwp0905/go-object-storage/internal/trie"
+)
+
+type cluster struct {
+	pool       *pooltest.Pool
+	lockerPool locker.LockerPool
+	namenode   namenode.NameNode
+	walker     trie.Walker
+}
+
+// newCluster runs a namenode with locks on three in-memory datanodes.
+func newCluster(t *testing.T, config *namenode.Config) *cluster {
+	t.Helper()
+	if config == nil {
+		config = new(namenode.Config)
+	}
+	config.Concurrency = namenode.ConcurrencyLock
+	c := &cluster{
+		pool:       pooltest.New("node-0", "node-1", "node-2"),
+		lockerPool: locker.NewStorePool(kv.NewLocal(), time.Second*30),
+	}
+	c.namenode = namenode.New(c.pool, c.lockerPool, config)
+	c.walker = trie.NewWalker(c.pool, c.lockerPool)
+	return c
+}
+
+func (c *cluster) put(t *testing.T, keys ...string) {
+	t.Helper()
+	for _, key := range keys {
+		if err := c.namenode.PutObject(context.Background(), &namenode.PutObjectInput{
+			Key:  key,
+			Size: len(key),
+			Body: strings.NewReader(key),
+		}); err != nil {
+			t.Fatalf("put %s: %+v", key, err)
+		}
+	}
+}
+
+// get fails unless key holds what put wrote.
+func (c *cluster) get(t *testing.T, key string) {
+	t.Helper()
+	_, r, err := c.namenode.GetObject(context.Background(), key)
+	if err != nil {
+		t.Fatalf("get %s: %+v", key, err)
+	}
+	b := new(strings.Builder)
+	if _, err := io.Copy(b, r); err != nil {
+		t.Fatal(err)
+	}
+	if b.String() != key {
+		t.Fatalf("%s holds %q", key, b.String())
+	}
+}
@@ -0,0 +1,345 @@
+package maintenance
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pkg/errors"
+	"github.com/qwp0905/go-object-storage/internal/metadata"
+	"github.com/qwp0905/go-object-storage/internal/nodepool"
+	"github.com/qwp0905/go-object-storage/internal/trie"
+	"github.com/qwp0905/go-object-storage/pkg/logger"
+	"github.com/qwp0905/go-object-storage/pkg/nocopy"
+)
+
+const (
+	moveKindObject   = "object"
+	moveKindMetadata = "metadata"
+)
+
+type Rebalancer interface {
+	Plan(ctx context.Context) (*RebalancePlan, error)
+	Run(ctx context.Context) error
+	RunAsync() error
+	Start(sec int)
+}
+
+type RebalanceConfig struct {
+	Threshold float64
+	Bandwidth int
+}
+
+type NodeUtilization struct {
+	Id       string `json:"id"`
+	Capacity uint64 `json:"capacity"`
+	Used     uint64 `json:"used"`
+	Metadata int    `json:"metadata"`
+	Tier     string `json:"tier"`
+}
+
+func (u *NodeUtilization) ratio() float64 {
+	if u.Capacity == 0 {
+		return 1
+	}
+	return float64(u.Used) / float64(u.Capacity)
+}
+
+type RebalanceMove struct {
+	Entry *trie.Entry `json:"-"`
+	Key   string      `json:"key"`
+	Kind  string      `json:"kind"`
+	From  string      `json:"from"`
+	To    string      `json:"to"`
+	Size  uint        `json:"size"`
+}
+
+type RebalancePlan struct {
+	Nodes []*NodeUtilization `json:"nodes"`
+	Moves []*RebalanceMove   `json:"moves"`
+}
+
+var ErrRebalanceRunning = fiber.NewError(fiber.StatusConflict, "rebalance already running")
+
+type rebalancerImpl struct {
+	noCopy nocopy.NoCopy
+	pool   nodepool.NodePool
+	walker trie.Walker
+	mover  trie.Mover
+	config *RebalanceConfig
+	mu     *sync.Mutex
+}
+
+func NewRebalancer(
+	pool nodepool.NodePool,
+	walker trie.Walker,
+	mover trie.Mover,
+	config *RebalanceConfig,
+) Rebalancer {
+	return &rebalancerImpl{
+		pool:   pool,
+		walker: walker,
+		mover:  mover,
+		config: config,
+		mu:     new(sync.Mutex),
+	}
+}
+
+func (r *rebalancerImpl) Start(sec int) {
+	ctx := context.Background()
+	timer := time.NewTicker(time.Second * time.Duration(sec))
+	for range timer.C {
+		if err := r.Run(ctx); err != nil {
+			logger.Errorf("%+v", err)
+		}
+	}
+}
+
+func (r *rebalancerImpl) Run(ctx context.Context) error {
+	if !r.mu.TryLock() {
+		return ErrRebalanceRunning
+	}
+	defer r.mu.Unlock()
+	return r.run(ctx)
+}
+
+// RunAsync starts a run in the background and fails right away when one is
+// already running.
+func (r *rebalancerImpl) RunAsync() error {
+	if !r.mu.TryLock() {
+		return ErrRebalanceRunning
+	}
+	go func() {
+		defer r.mu.Unlock()
+		if err := r.run(context.Background()); err != nil {
+			logger.Warnf("%+v", err)
+		}
+	}()
+	return nil
+}
+
+func (r *rebalancerImpl) run(ctx context.Context) error {
+	plan, err := r.Plan(ctx)
+	if err != nil {
+		return err
+	}
+	if len(plan.Moves) == 0 {
+		return nil
+	}
+	logger.Infof("rebalance started with %d moves", len(plan.Moves))
+
+	moved := make(map[string]string)
+	for _, move := range plan.Moves {
+		switch move.Kind {
+		case moveKindObject:
+			size, err := r.mover.MoveObject(ctx, move.Entry, move.To)
+			if err != nil {
+				if !errors.Is(err, trie.ErrStale) {
+					logger.Warnf("%+v", err)
+				}
+				continue
+			}
+			r.throttle(size)
+		case moveKindMetadata:
+			if parentId, ok := moved[move.Entry.Parent]; ok {
+				move.Entry.ParentId = parentId
+			}
+			if err := r.mover.MoveMetadata(ctx, move.Entry, move.To); err != nil {
+				if !errors.Is(err, trie.ErrStale) {
+					logger.Warnf("%+v", err)
+				}
+				continue
+			}
+			moved[move.Key] = move.To
+		}
+	}
+
+	logger.Info("rebalance finished")
+	return nil
+}
+
+func (r *rebalancerImpl) throttle(size uint) {
+	if r.config.Bandwidth <= 0 {
+		return
+	}
+	time.Sleep(time.Duration(float64(size) / float64(r.config.Bandwidth) * float64(time.Second)))
+}
+
+func (r *rebalancerImpl) Plan(ctx context.Context) (*RebalancePlan, error) {
+	nodes, err := r.pool.GetNodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	utilization := make(map[string]*NodeUtilization)
+	plan := &RebalancePlan{
+		Nodes: make([]*NodeUtilization, 0, len(nodes)),
+		Moves: make([]*RebalanceMove, 0),
+	}
+	for _, node := range nodes {
+		if node.State != nodepool.NodeStateActive {
+			continue
+		}
+		usage, err := r.pool.GetNodeUsage(ctx, node.Id)
+		if err != nil {
+			return nil, err
+		}
+		u := &NodeUtilization{
+			Id:       node.Id,
+			Capacity: usage.Total,
+			Used:     usage.Total - usage.Free,
+			Tier:     node.Labels.StorageClass(),
+		}
+		utilization[node.Id] = u
+		plan.Nodes = append(plan.Nodes, u)
+	}
+	if len(plan.Nodes) < 2 {
+		return plan, nil
+	}
+
+	objects := make(map[string][]*trie.Entry)
+	metadataList := make(map[string][]*trie.Entry)
+	if err := r.walker.Walk(ctx, func(e *trie.Entry) error {
+		if u, ok := utilization[e.Id]; ok {
+			u.Metadata++
+			if !e.IsRoot() {
+				metadataList[e.Id] = append(metadataList[e.Id], e)
+			}
+		}
+		// chunked objects are spread over the tier already.
+		if _, ok := utilization[e.Metadata.NodeId]; ok && e.Metadata.FileExists() && !e.Metadata.Chunked() {
+			objects[e.Metadata.NodeId] = append(objects[e.Metadata.NodeId], e)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	// objects only move between nodes of the same tier and metadata only
+	// lives on standard nodes.
+	tiers := make(map[string][]*NodeUtilization)
+	for _, u := range plan.Nodes {
+		tiers[u.Tier] = append(tiers[u.Tier], u)
+	}
+	for _, nodes := range tiers {
+		plan.Moves = append(plan.Moves, r.planObjects(nodes, objects)...)
+	}
+	if nodes := tiers[metadata.StorageClassStandard]; len(nodes) > 1 {
+		plan.Moves = append(plan.Moves, r.planMetadata(nodes, metadataList)...)
+	}
+	return plan, nil
+}
+
+func (r *rebalancerImpl) planObjects(
+	nodes []*NodeUtilization,
+	objects map[string][]*trie.Entry,
+) []*RebalanceMove {
+	used := make(map[string]uint64)
+	var totalUsed, totalCapacity uint64
+	for _, u := range nodes {
+		used[u.Id] = u.Used
+		totalUsed += u.Used
+		totalCapacity += u.Capacity
+	}
+	if totalCapacity == 0 {
+		return nil
+	}
+	mean := float64(totalUsed) / float64(totalCapacity)
+	ratio := func(u *NodeUtilization, size uint64) float64 {
+		if u.Capacity == 0 {
+			return 1
+		}
+		return float64(size) / float64(u.Capacity)
+	}
+
+	moves := make([]*RebalanceMove, 0)
+	for {
+		var src, dst *NodeUtilization
+		for _, u := range nodes {
+			if src == nil || ratio(u, used[u.Id]) > ratio(src, used[src.Id]) {
+				src = u
+			}
+			if dst == nil || ratio(u, used[u.Id]) < ratio(dst, used[dst.Id]) {
+				dst = u
+			}
+		}
+		if ratio(src, used[src.Id])-mean <= r.config.Threshold {
+			return moves
+		}
+
+		candidates := objects[src.Id]
+		best := -1
+		for i, e := range candidates {
+			size := uint64(e.Metadata.StoredSize())
+			if size > used[src.Id] {
+				continue
+			}
+			if ratio(dst, used[dst.Id]+size) > ratio(src, used[src.Id]-size) {
+				continue
+			}
+			if best == -1 || e.Metadata.StoredSize() > candidates[best].Metadata.StoredSize() {
+				best = i
+			}
+		}
+		if best == -1 {
+			return moves
+		}
+
+		e := candidates[best]
+		objects[src.Id] = append(candidates[:best], candidates[best+1:]...)
+		used[src.Id] -= uint64(e.Metadata.StoredSize())
+		used[dst.Id] += uint64(e.Metadata.StoredSize())
+		moves = append(moves, &RebalanceMove{
+			Entry: e,
+			Key:   e.Metadata.Key,
+			Kind:  moveKindObject,
+			From:  src.Id,
+			To:    dst.Id,
+			Size:  e.Metadata.StoredSize(),
+		})
+	}
+}
+
+func (r *rebalancerImpl) planMetadata(
+	nodes []*NodeUtilization,
+	metadataList map[string][]*trie.Entry,
+) []*RebalanceMove {
+	count := make(map[string]int)
+	total := 0
+	for _, u := range nodes {
+		count[u.Id] = u.Metadata
+		total += u.Metadata
+	}
+	limit := float64(total) / float64(len(nodes)) * (1 + r.config.Threshold)
+
+	moves := make([]*RebalanceMove, 0)
+	for {
+		var src, dst *NodeUtilization
+		for _, u := range nodes {
+			if src == nil || count[u.Id] > count[src.Id] {
+				src = u
+			}
+			if dst == nil || count[u.Id] < count[dst.Id] {
+				dst = u
+			}
+		}
+		if float64(count[src.Id]) <= limit ||
+			count[src.Id]-count[dst.Id] <= 1 ||
+			len(metadataList[src.Id]) == 0 {
+			return moves
+		}
+
+		e := metadataList[src.Id][0]
+		metadataList[src.Id] = metadataList[src.Id][1:]
+		count[src.Id]--
+		count[dst.Id]++
+		moves = append(moves, &RebalanceMove{
+			Entry: e,
+			Key:   e.Metadata.Key,
+			Kind:  moveKindMetadata,
+			From:  src.Id,
+			To:    dst.Id,
+		})
+	}
+}
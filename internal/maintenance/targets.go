@@ -0,0 +1,60 @@
+package maintenance
+
+import (
+	"context"
+
+	"github.com/qwp0905/go-object-storage/internal/metadata"
+	"github.com/qwp0905/go-object-storage/internal/nodepool"
+	"github.com/qwp0905/go-object-storage/pkg/logger"
+)
+
+// targets picks the nodes objects move to. objects sharing data all follow
+// the first one, preferably to a node that already holds it, so the data is
+// copied once.
+type targets struct {
+	pool   nodepool.NodePool
+	shared map[string]string
+}
+
+func newTargets(pool nodepool.NodePool) *targets {
+	return &targets{pool: pool, shared: make(map[string]string)}
+}
+
+func (t *targets) acquire(ctx context.Context, meta *metadata.Metadata, class string) (string, error) {
+	if !meta.Shared() {
+		return t.pool.AcquireTierNode(ctx, class)
+	}
+	if to, ok := t.shared[meta.Source]; ok {
+		return to, nil
+	}
+
+	to, err := t.pool.FindContent(ctx, meta.Source, class)
+	if err != nil {
+		return "", err
+	}
+	if to == "" || to == meta.NodeId {
+		if to, err = t.pool.AcquireTierNode(ctx, class); err != nil {
+			return "", err
+		}
+		if err := t.pool.IndexContent(ctx, meta.Source, to); err != nil {
+			logger.Warnf("%+v", err)
+		}
+	}
+	t.shared[meta.Source] = to
+	return to, nil
+}
+
+// chunks picks a node of class for every chunk of meta on from, or for all of
+// them when from is empty. the other chunks stay where they are.
+func (t *targets) chunks(ctx context.Context, meta *metadata.Metadata, from, class string) ([]string, error) {
+	to, err := t.pool.SpreadNodes(ctx, class, len(meta.Chunks))
+	if err != nil {
+		return nil, err
+	}
+	for i, chunk := range meta.Chunks {
+		if from != "" && chunk.NodeId != from {
+			to[i] = ""
+		}
+	}
+	return to, nil
+}
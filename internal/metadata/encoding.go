@@ -0,0 +1,299 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/pkg/errors"
+)
+
+const ContentType = "application/x-object-metadata"
+
+// version 2 added expires, version 3 the storage class, version 4 the stored
+// size and encryption, version 5 compression and version 6 chunks. older
+// versions are still read.
+const encodingVersion byte = 6
+
+// binary encoded metadata starts with a magic that can never start a json
+// document, so files written before the binary format stay readable.
+var magic = []byte{0xff, 'M'}
+
+var ErrUnknownEncoding = errors.New("unknown metadata encoding")
+
+func Marshal(m *Metadata) []byte {
+	return marshal(m, encodingVersion)
+}
+
+// marshal writes the fields known to version, older layouts are only written
+// to check that they are still read.
+func marshal(m *Metadata, version byte) []byte {
+	b := make([]byte, 0, 128+len(m.DataKey)+len(m.NextNodes)*16)
+	b = append(b, magic...)
+	b = append(b, version)
+
+	b = appendString(b, m.Key)
+	b = appendString(b, m.Source)
+	b = binary.AppendUvarint(b, uint64(m.Size))
+	b = appendString(b, m.Type)
+	b = appendString(b, m.NodeId)
+	b = appendTime(b, m.LastModified)
+	b = binary.AppendUvarint(b, m.Fence)
+	b = binary.AppendUvarint(b, m.Version)
+	if version >= 2 {
+		b = appendTime(b, m.Expires)
+	}
+	if version >= 3 {
+		b = appendString(b, m.StorageClass)
+	}
+	if version >= 4 {
+		b = binary.AppendUvarint(b, uint64(m.Stored))
+		b = appendString(b, m.Encryption)
+		b = appendString(b, m.KeyId)
+		b = appendString(b, string(m.DataKey))
+	}
+	if version >= 5 {
+		b = appendString(b, m.Compression)
+	}
+
+	// routes and chunks mostly point to a handful of datanodes and routes
+	// share the node key as prefix, so node ids are indexed and only the key
+	// suffix is stored.
+	ids := make([]string, 0)
+	index := make(map[string]uint64)
+	indexId := func(id string) {
+		if _, ok := index[id]; !ok {
+			index[id] = uint64(len(ids))
+			ids = append(ids, id)
+		}
+	}
+	for _, next := range m.NextNodes {
+		indexId(next.NodeId)
+	}
+	chunks := m.Chunks
+	if version < 6 {
+		chunks = nil
+	}
+	for _, chunk := range chunks {
+		indexId(chunk.NodeId)
+	}
+	b = binary.AppendUvarint(b, uint64(len(ids)))
+	for _, id := range ids {
+		b = appendString(b, id)
+	}
+
+	b = binary.AppendUvarint(b, uint64(len(m.NextNodes)))
+	for _, next := range m.NextNodes {
+		shared := sharedLength(m.Key, next.Key)
+		b = binary.AppendUvarint(b, index[next.NodeId])
+		b = binary.AppendUvarint(b, uint64(shared))
+		b = appendString(b, next.Key[shared:])
+	}
+
+	if version < 6 {
+		return b
+	}
+	b = binary.AppendUvarint(b, uint64(len(chunks)))
+	for _, chunk := range chunks {
+		b = binary.AppendUvarint(b, index[chunk.NodeId])
+		b = binary.AppendUvarint(b, uint64(chunk.Size))
+	}
+
+	return b
+}
+
+func Unmarshal(b []byte) (*Metadata, error) {
+	if !bytes.HasPrefix(b, magic) {
+		m := new(Metadata)
+		if err := json.Unmarshal(b, m); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if m.NextNodes == nil {
+			m.NextNodes = make([]*NextRoute, 0)
+		}
+		return m, nil
+	}
+
+	d := &decoder{b: b[len(magic):]}
+	version := d.byte()
+	if version == 0 || version > encodingVersion {
+		return nil, errors.WithStack(ErrUnknownEncoding)
+	}
+
+	m := new(Metadata)
+	m.Key = d.string()
+	m.Source = d.string()
+	m.Size = uint(d.uvarint())
+	m.Type = d.string()
+	m.NodeId = d.string()
+	m.LastModified = d.time()
+	m.Fence = d.uvarint()
+	m.Version = d.uvarint()
+	if version >= 2 {
+		m.Expires = d.time()
+	}
+	if version >= 3 {
+		m.StorageClass = d.string()
+	}
+	if version >= 4 {
+		m.Stored = uint(d.uvarint())
+		m.Encryption = d.string()
+		m.KeyId = d.string()
+		if key := d.bytes(); len(key) > 0 {
+			m.DataKey = append([]byte(nil), key...)
+		}
+	}
+	if version >= 5 {
+		m.Compression = d.string()
+	}
+
+	ids := make([]string, d.length())
+	for i := range ids {
+		ids[i] = d.string()
+	}
+
+	// every key is written into one buffer and sliced out of it afterwards, so
+	// decoding a node costs a few allocations regardless of its fan-out.
+	count := d.length()
+	routes := make([]NextRoute, count)
+	bounds := make([]int, count)
+	keys := make([]byte, 0, count*(len(m.Key)+8))
+	for i := range routes {
+		id := d.uvarint()
+		shared := d.uvarint()
+		suffix := d.bytes()
+		if d.err != nil {
+			break
+		}
+		if id >= uint64(len(ids)) || shared > uint64(len(m.Key)) {
+			return nil, errors.WithStack(ErrUnknownEncoding)
+		}
+		routes[i].NodeId = ids[id]
+		keys = append(keys, m.Key[:shared]...)
+		keys = append(keys, suffix...)
+		bounds[i] = len(keys)
+	}
+	if version >= 6 && d.err == nil {
+		if count := d.length(); count > 0 {
+			m.Chunks = make([]Chunk, count)
+		}
+		for i := range m.Chunks {
+			id := d.uvarint()
+			m.Chunks[i].Size = uint(d.uvarint())
+			if d.err != nil {
+				break
+			}
+			if id >= uint64(len(ids)) {
+				return nil, errors.WithStack(ErrUnknownEncoding)
+			}
+			m.Chunks[i].NodeId = ids[id]
+		}
+	}
+	if d.err != nil {
+		return nil, errors.WithStack(d.err)
+	}
+
+	all := string(keys)
+	m.NextNodes = make([]*NextRoute, count)
+	for i := range routes {
+		start := 0
+		if i > 0 {
+			start = bounds[i-1]
+		}
+		routes[i].Key = all[start:bounds[i]]
+		m.NextNodes[i] = &routes[i]
+	}
+
+	return m, nil
+}
+
+func sharedLength(a, b string) int {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return i
+}
+
+func appendString(b []byte, s string) []byte {
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+func appendTime(b []byte, t time.Time) []byte {
+	if t.IsZero() {
+		return append(b, 0)
+	}
+	b = append(b, 1)
+	return binary.AppendVarint(b, t.UnixNano())
+}
+
+type decoder struct {
+	b   []byte
+	err error
+}
+
+func (d *decoder) fail() {
+	if d.err == nil {
+		d.err = errors.New("truncated metadata")
+	}
+	d.b = nil
+}
+
+func (d *decoder) byte() byte {
+	if len(d.b) == 0 {
+		d.fail()
+		return 0
+	}
+	v := d.b[0]
+	d.b = d.b[1:]
+	return v
+}
+
+func (d *decoder) uvarint() uint64 {
+	v, n := binary.Uvarint(d.b)
+	if n <= 0 {
+		d.fail()
+		return 0
+	}
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *decoder) varint() int64 {
+	v, n := binary.Varint(d.b)
+	if n <= 0 {
+		d.fail()
+		return 0
+	}
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *decoder) time() time.Time {
+	if d.byte() != 1 {
+		return time.Time{}
+	}
+	return time.Unix(0, d.varint())
+}
+
+func (d *decoder) length() int {
+	n := d.uvarint()
+	if n > uint64(len(d.b)) {
+		d.fail()
+		return 0
+	}
+	return int(n)
+}
+
+func (d *decoder) bytes() []byte {
+	n := d.length()
+	b := d.b[:n]
+	d.b = d.b[n:]
+	return b
+}
+
+func (d *decoder) string() string {
+	return string(d.bytes())
+}
@@ -0,0 +1,182 @@
+package metadata
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/pkg/errors"
+)
+
+func sample(fanOut int) *Metadata {
+	m := &Metadata{
+		Key:          "photos/2024/",
+		Source:       "0b6f3c0e-source",
+		Size:         1 << 20,
+		Type:         "image/jpeg",
+		NodeId:       "node-a",
+		LastModified: time.Unix(1700000000, 123),
+		NextNodes:    make([]*NextRoute, fanOut),
+		Fence:        42,
+		Version:      7,
+		Expires:      time.Unix(1800000000, 0),
+		StorageClass: StorageClassCold,
+		Stored:       1<<20 + 28,
+		Encryption:   EncryptionServer,
+		KeyId:        "k1",
+		DataKey:      []byte{1, 2, 3, 4},
+		Compression:  "zstd",
+		Chunks: []Chunk{
+			{NodeId: "node-b", Size: 1 << 19},
+			{NodeId: "node-c", Size: 1 << 19},
+		},
+	}
+	for i := range m.NextNodes {
+		m.NextNodes[i] = &NextRoute{
+			NodeId: fmt.Sprintf("node-%d", i%5),
+			Key:    fmt.Sprintf("%s%06d.jpg", m.Key, i),
+		}
+	}
+	return m
+}
+
+// known drops the fields written after version.
+func known(m *Metadata, version byte) *Metadata {
+	out := *m
+	if version < 2 {
+		out.Expires = time.Time{}
+	}
+	if version < 3 {
+		out.StorageClass = ""
+	}
+	if version < 4 {
+		out.Stored, out.Encryption, out.KeyId, out.DataKey = 0, "", "", nil
+	}
+	if version < 5 {
+		out.Compression = ""
+	}
+	if version < 6 {
+		out.Chunks = nil
+	}
+	return &out
+}
+
+// same compares m with want, times are compared by instant.
+func same(m, want *Metadata) bool {
+	a, b := *m, *want
+	a.LastModified, b.LastModified = a.LastModified.UTC(), b.LastModified.UTC()
+	a.Expires, b.Expires = a.Expires.UTC(), b.Expires.UTC()
+	return reflect.DeepEqual(&a, &b)
+}
+
+func TestRoundTripEveryVersion(t *testing.T) {
+	for version := byte(1); version <= encodingVersion; version++ {
+		t.Run(fmt.Sprintf("v%d", version), func(t *testing.T) {
+			want := known(sample(16), version)
+
+			// json written before the binary format is converted on the next write.
+			b, err := json.Marshal(want)
+			if err != nil {
+				t.Fatal(err)
+			}
+			fromJson, err := Unmarshal(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !same(fromJson, want) {
+				t.Fatalf("json decoded %+v, want %+v", fromJson, want)
+			}
+
+			got, err := Unmarshal(marshal(fromJson, version))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !same(got, want) {
+				t.Fatalf("binary decoded %+v, want %+v", got, want)
+			}
+
+			back, err := json.Marshal(got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(back) != string(b) {
+				t.Fatalf("json after binary is %s, want %s", back, b)
+			}
+		})
+	}
+}
+
+func TestRoundTripEmpty(t *testing.T) {
+	want := New("/")
+	got, err := Unmarshal(Marshal(want))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !same(got, want) {
+		t.Fatalf("decoded %+v, want %+v", got, want)
+	}
+}
+
+func TestUnmarshalRejectsUnknownVersion(t *testing.T) {
+	b := Marshal(sample(1))
+	b[len(magic)] = encodingVersion + 1
+	if _, err := Unmarshal(b); !errors.Is(err, ErrUnknownEncoding) {
+		t.Fatalf("expected unknown encoding, got %v", err)
+	}
+}
+
+func TestUnmarshalRejectsTruncated(t *testing.T) {
+	b := Marshal(sample(4))
+	for n := len(magic) + 1; n < len(b); n++ {
+		if _, err := Unmarshal(b[:n]); err == nil {
+			t.Fatalf("decoding %d of %d bytes should fail", n, len(b))
+		}
+	}
+}
+
+func BenchmarkMarshal(b *testing.B) {
+	for _, fanOut := range []int{16, 4096} {
+		m := sample(fanOut)
+		b.Run(fmt.Sprintf("routes=%d", fanOut), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				Marshal(m)
+			}
+		})
+	}
+}
+
+func BenchmarkUnmarshal(b *testing.B) {
+	for _, fanOut := range []int{16, 4096} {
+		encoded := Marshal(sample(fanOut))
+		b.Run(fmt.Sprintf("routes=%d", fanOut), func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(encoded)))
+			for i := 0; i < b.N; i++ {
+				if _, err := Unmarshal(encoded); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkUnmarshalJson(b *testing.B) {
+	for _, fanOut := range []int{16, 4096} {
+		encoded, err := json.Marshal(sample(fanOut))
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Run(fmt.Sprintf("routes=%d", fanOut), func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(encoded)))
+			for i := 0; i < b.N; i++ {
+				if _, err := Unmarshal(encoded); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
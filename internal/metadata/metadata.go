@@ -1,8 +1,10 @@
 package metadata
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
-	"regexp"
+	"sort"
 	"strings"
 	"time"
 
@@ -17,6 +19,60 @@ type Metadata struct {
 	NodeId       string       `json:"node_id,omitempty"`
 	LastModified time.Time    `json:"last_modified,omitempty"`
 	NextNodes    []*NextRoute `json:"next_nodes"`
+	Fence        uint64       `json:"fence,omitempty"`
+	Version      uint64       `json:"version,omitempty"`
+	// zero when the object never expires.
+	Expires time.Time `json:"expires,omitempty"`
+	// empty for objects written before storage classes, read as standard.
+	StorageClass string `json:"storage_class,omitempty"`
+	// bytes kept on the datanode, zero when it is the same as size.
+	Stored uint `json:"stored,omitempty"`
+	// empty when the data is kept in plaintext. the data key is wrapped by
+	// the master key KeyId or by the key of the customer.
+	Encryption string `json:"encryption,omitempty"`
+	KeyId      string `json:"key_id,omitempty"`
+	DataKey    []byte `json:"data_key,omitempty"`
+	// codec the data was compressed with before encryption, empty when it is
+	// kept as is.
+	Compression string `json:"compression,omitempty"`
+	// large objects are split into chunks kept on different nodes, empty
+	// when the data is a single file.
+	Chunks []Chunk `json:"chunks,omitempty"`
+}
+
+// Chunk is a part of the stored data, chunks follow each other in order.
+type Chunk struct {
+	NodeId string `json:"node_id"`
+	Size   uint   `json:"size"`
+}
+
+const (
+	EncryptionServer   = "AES256"
+	EncryptionCustomer = "SSE-C"
+)
+
+// StoredSize is the length of the data on the datanode.
+func (m *Metadata) StoredSize() uint {
+	if m.Stored == 0 {
+		return m.Size
+	}
+	return m.Stored
+}
+
+const (
+	StorageClassStandard = "STANDARD"
+	StorageClassCold     = "COLD"
+)
+
+func ValidStorageClass(class string) bool {
+	return class == StorageClassStandard || class == StorageClassCold
+}
+
+func (m *Metadata) Class() string {
+	if m.StorageClass == "" {
+		return StorageClassStandard
+	}
+	return m.StorageClass
 }
 
 func New(key string) *Metadata {
@@ -28,29 +84,85 @@ type NextRoute struct {
 	Key    string `json:"key"`
 }
 
+// content addressed sources are named by the digest of their data, every
+// object with the same data shares them.
+const contentPrefix = "sha256-"
+
+func ContentSource(data []byte) string {
+	sum := sha256.Sum256(data)
+	return contentPrefix + hex.EncodeToString(sum[:])
+}
+
+func IsContentSource(source string) bool {
+	return strings.HasPrefix(source, contentPrefix)
+}
+
+func (m *Metadata) Shared() bool {
+	return IsContentSource(m.Source)
+}
+
+func (m *Metadata) Chunked() bool {
+	return len(m.Chunks) > 0
+}
+
+// Parts returns the files the data of m is kept in, chunks are named after
+// the source and their index.
+func (m *Metadata) Parts() []*Metadata {
+	if !m.Chunked() {
+		return []*Metadata{m}
+	}
+	out := make([]*Metadata, len(m.Chunks))
+	for i, chunk := range m.Chunks {
+		out[i] = &Metadata{
+			Key:    m.Key,
+			Source: fmt.Sprintf("%s.%d", m.Source, i),
+			NodeId: chunk.NodeId,
+			Size:   chunk.Size,
+		}
+	}
+	return out
+}
+
+// Expired reports whether the object is past its own expiry at now.
+func (m *Metadata) Expired(now time.Time) bool {
+	return !m.Expires.IsZero() && now.After(m.Expires)
+}
+
 func (m *Metadata) FileExists() bool {
 	return m.Source != "" && m.NodeId != ""
 }
 
+// siblings never share a prefix longer than the node key, so the only routes
+// that can match key are the ones sorted right around it.
 func (m *Metadata) FindPrefix(key string) int {
-	for i := range m.NextNodes {
-		if strings.HasPrefix(key, m.NextNodes[i].Key) {
-			return i
-		}
+	i := m.Search(key) - 1
+	if i >= 0 && strings.HasPrefix(key, m.NextNodes[i].Key) {
+		return i
 	}
 	return -1
 }
 
 func (m *Metadata) FindMatched(key string) (int, string) {
-	for i := range m.NextNodes {
-		if matched := compare(m.NextNodes[i].Key, key); len(matched) > len(m.Key) {
-			return i, matched
+	i := m.Search(key)
+	for _, j := range []int{i - 1, i} {
+		if j < 0 || j >= len(m.NextNodes) {
+			continue
+		}
+		if matched := compare(m.NextNodes[j].Key, key); len(matched) > len(m.Key) {
+			return j, matched
 		}
 	}
 
 	return -1, ""
 }
 
+// Search returns the index of the first route sorted after key.
+func (m *Metadata) Search(key string) int {
+	return sort.Search(len(m.NextNodes), func(i int) bool {
+		return m.NextNodes[i].Key > key
+	})
+}
+
 func (m *Metadata) GetNext(index int) *NextRoute {
 	return m.NextNodes[index]
 }
@@ -61,23 +173,45 @@ func (m *Metadata) UpdateAttr(size int, contentType string) {
 	m.LastModified = time.Now()
 }
 
+// NewSource names data that is stored for a single object.
+func NewSource() string {
+	return uuid.Must(uuid.NewRandom()).String()
+}
+
 func (m *Metadata) SetNew(nodeId string) {
-	m.Source = uuid.Must(uuid.NewRandom()).String()
+	m.Source = NewSource()
 	m.NodeId = nodeId
+	m.Chunks = nil
+}
+
+// SetObject points m at the object o holds, leaving its key and routes.
+func (m *Metadata) SetObject(o *Metadata) {
+	m.Source = o.Source
+	m.NodeId = o.NodeId
+	m.Size = o.Size
+	m.Type = o.Type
+	m.LastModified = o.LastModified
+	m.Expires = o.Expires
+	m.StorageClass = o.StorageClass
+	m.Stored = o.Stored
+	m.Encryption = o.Encryption
+	m.KeyId = o.KeyId
+	m.DataKey = o.DataKey
+	m.Compression = o.Compression
+	m.Chunks = append([]Chunk(nil), o.Chunks...)
+}
+
+// SameObject reports whether m still holds the object o was read with.
+func (m *Metadata) SameObject(o *Metadata) bool {
+	return m.NodeId == o.NodeId && m.Source == o.Source && m.LastModified.Equal(o.LastModified)
 }
 
 func (m *Metadata) Clear() {
-	*m = Metadata{Key: m.Key, NextNodes: m.NextNodes}
+	*m = Metadata{Key: m.Key, NextNodes: m.NextNodes, Fence: m.Fence, Version: m.Version}
 }
 
 func (m *Metadata) InsertNext(id, key string) {
-	index := 0
-	for i := range m.NextNodes {
-		if m.NextNodes[i].Key > key {
-			break
-		}
-		index++
-	}
+	index := m.Search(key)
 	m.NextNodes = append(m.NextNodes, nil)
 	copy(m.NextNodes[index+1:], m.NextNodes[index:])
 	m.NextNodes[index] = &NextRoute{NodeId: id, Key: key}
@@ -107,12 +241,3 @@ func compare(a, b string) string {
 	}
 	return out
 }
-
-func (m *Metadata) IsMatch(prefix, delimiter string) string {
-	t := "^" + prefix
-	if delimiter != "" {
-		t += fmt.Sprintf("[^%s]*(%s)?", delimiter, delimiter)
-	}
-
-	return regexp.MustCompile(t).FindString(m.Key)
-}
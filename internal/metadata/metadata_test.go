@@ -0,0 +1,24 @@
+package metadata
+
+import (
+	"testing"
+	"time"
+)
+
+func TestExpired(t *testing.T) {
+	now := time.Now()
+	for _, c := range []struct {
+		expires time.Time
+		want    bool
+	}{
+		{time.Time{}, false},
+		{now.Add(time.Minute), false},
+		{now, false},
+		{now.Add(-time.Minute), true},
+	} {
+		m := &Metadata{Expires: c.expires}
+		if got := m.Expired(now); got != c.want {
+			t.Fatalf("expires %s at %s: got %t, want %t", c.expires, now, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package namenode
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/qwp0905/go-object-storage/internal/metadata"
+	"github.com/qwp0905/go-object-storage/pkg/logger"
+)
+
+// MaxDeleteKeys is the most keys a single batch delete takes.
+const MaxDeleteKeys = 1000
+
+var ErrTooManyKeys = fiber.NewError(fiber.StatusBadRequest, "too many keys in one request")
+
+type DeleteObjectsResult struct {
+	Deleted []string       `json:"deleted,omitempty"`
+	Errors  []*DeleteError `json:"errors,omitempty"`
+}
+
+type DeleteError struct {
+	Key     string `json:"key"`
+	Message string `json:"message"`
+}
+
+// batch tracks a batch delete. keys missing from failed were deleted or did
+// not exist, which is the same to the caller.
+type batch struct {
+	removed []*metadata.Metadata
+	failed  map[string]error
+	// objects the keys have to hold to be deleted, any when nil.
+	expect map[string]*metadata.Metadata
+}
+
+func (b *batch) holds(meta *metadata.Metadata) bool {
+	if b.expect == nil {
+		return true
+	}
+	o, ok := b.expect[meta.Key]
+	return ok && meta.SameObject(o)
+}
+
+func (b *batch) fail(keys []string, err error) {
+	for _, key := range keys {
+		b.failed[key] = err
+	}
+}
+
+func (n *nameNodeImpl) DeleteObjects(ctx context.Context, keys []string) (*DeleteObjectsResult, error) {
+	if len(keys) > MaxDeleteKeys {
+		return nil, ErrTooManyKeys
+	}
+	return n.deleteObjects(ctx, keys, nil), nil
+}
+
+// ExpireObjects deletes the keys of objects only while they still hold them.
+// a key written again since it was read counts as deleted, the object it
+// was read with is gone either way.
+func (n *nameNodeImpl) ExpireObjects(ctx context.Context, objects []*metadata.Metadata) (*DeleteObjectsResult, error) {
+	if len(objects) > MaxDeleteKeys {
+		return nil, ErrTooManyKeys
+	}
+
+	keys := make([]string, len(objects))
+	expect := make(map[string]*metadata.Metadata)
+	for i, object := range objects {
+		keys[i] = object.Key
+		expect[object.Key] = object
+	}
+	return n.deleteObjects(ctx, keys, expect), nil
+}
+
+func (n *nameNodeImpl) deleteObjects(
+	ctx context.Context,
+	keys []string,
+	expect map[string]*metadata.Metadata,
+) *DeleteObjectsResult {
+	sorted := make([]string, 0, len(keys))
+	seen := make(map[string]bool)
+	for _, key := range keys {
+		if !seen[key] {
+			seen[key] = true
+			sorted = append(sorted, key)
+		}
+	}
+	sort.Strings(sorted)
+
+	b := n.remove(ctx, sorted, expect)
+	out := &DeleteObjectsResult{Deleted: make([]string, 0), Errors: make([]*DeleteError, 0)}
+	for _, key := range sorted {
+		if err, ok := b.failed[key]; ok {
+			out.Errors = append(out.Errors, &DeleteError{Key: key, Message: err.Error()})
+		} else {
+			out.Deleted = append(out.Deleted, key)
+		}
+	}
+	return out
+}
+
+// remove unlinks sorted keys and drops the objects they held.
+func (n *nameNodeImpl) remove(
+	ctx context.Context,
+	sorted []string,
+	expect map[string]*metadata.Metadata,
+) *batch {
+	b := &batch{
+		removed: make([]*metadata.Metadata, 0),
+		failed:  make(map[string]error),
+		expect:  expect,
+	}
+	if n.concurrency == ConcurrencyOptimistic {
+		for _, key := range sorted {
+			var deleted *metadata.Metadata
+			if err := n.retry(ctx, func() (err error) {
+				deleted, err = n.clear(ctx, key, expect[key])
+				return err
+			}); err != nil {
+				b.fail([]string{key}, err)
+			} else if deleted != nil {
+				b.removed = append(b.removed, deleted)
+			}
+		}
+	} else if id, err := n.getRootId(ctx); err != nil {
+		b.fail(sorted, err)
+	} else if len(sorted) > 0 {
+		n.deleteMany(ctx, b, sorted, id, n.rootKey)
+	}
+
+	n.dropObjects(ctx, b.removed)
+	return b
+}
+
+// deleteMany deletes sorted keys under current with one lock and at most one
+// write per node on their paths. it returns like delete does, an error means
+// current was left as it was and its keys are marked failed.
+func (n *nameNodeImpl) deleteMany(
+	ctx context.Context,
+	b *batch,
+	keys []string,
+	id, current string,
+) (*metadata.Metadata, error) {
+	locker := n.lockerPool.Get(current)
+	token, err := locker.Lock(ctx)
+	if err != nil {
+		b.fail(keys, err)
+		return nil, err
+	}
+	defer locker.Unlock(ctx)
+
+	currentMeta, err := n.getMetadata(ctx, id, current)
+	if err != nil {
+		b.fail(keys, err)
+		return nil, err
+	}
+	currentMeta.Fence = token
+
+	// the object of current is only dropped once current is written.
+	var own *metadata.Metadata
+	changed := false
+	rest := keys
+	if rest[0] == current {
+		rest = rest[1:]
+		if currentMeta.FileExists() && b.holds(currentMeta) {
+			prev := *currentMeta
+			own = &prev
+			currentMeta.Clear()
+			changed = true
+		}
+	}
+
+	// keys sharing a route are next to each other once sorted.
+	type group struct {
+		index int
+		keys  []string
+	}
+	groups := make([]group, 0)
+	for i := 0; i < len(rest); {
+		index := currentMeta.FindPrefix(rest[i])
+		if index == -1 {
+			i++
+			continue
+		}
+		j := i + 1
+		for j < len(rest) && strings.HasPrefix(rest[j], currentMeta.GetNext(index).Key) {
+			j++
+		}
+		groups = append(groups, group{index: index, keys: rest[i:j]})
+		i = j
+	}
+
+	// later routes go first so removing one keeps the earlier indexes.
+	merged := make([]*metadata.NextRoute, 0)
+	for i := len(groups) - 1; i >= 0; i-- {
+		g := groups[i]
+		next := currentMeta.GetNext(g.index)
+		deleted, err := n.deleteMany(ctx, b, g.keys, next.NodeId, next.Key)
+		switch {
+		case err != nil:
+		case deleted == nil:
+			currentMeta.RemoveNext(g.index)
+			changed = true
+		case deleted.Len() == 1 && !deleted.FileExists():
+			currentMeta.NextNodes[g.index] = deleted.NextNodes[0]
+			merged = append(merged, next)
+			changed = true
+		}
+	}
+
+	if currentMeta.Len() == 0 && !currentMeta.FileExists() && current != n.rootKey {
+		if err := n.pool.DeleteMetadata(ctx, id, current); err != nil {
+			b.fail(keys, err)
+			return nil, err
+		}
+		if own != nil {
+			b.removed = append(b.removed, own)
+		}
+		return nil, nil
+	}
+
+	if changed {
+		if err := n.pool.PutMetadata(ctx, id, currentMeta); err != nil {
+			b.fail(keys, err)
+			return nil, err
+		}
+	}
+	if own != nil {
+		b.removed = append(b.removed, own)
+	}
+	for _, next := range merged {
+		if err := n.pool.DeleteMetadata(ctx, next.NodeId, next.Key); err != nil {
+			logger.Warnf("%+v", err)
+		}
+	}
+
+	return currentMeta, nil
+}
+
+// dropObjects removes the data of unlinked objects with one request per
+// datanode. failures only leave garbage for the collector.
+func (n *nameNodeImpl) dropObjects(ctx context.Context, objects []*metadata.Metadata) {
+	sources := make(map[string][]string)
+	for _, object := range objects {
+		for _, part := range object.Parts() {
+			sources[part.NodeId] = append(sources[part.NodeId], part.Source)
+		}
+	}
+
+	for id, list := range sources {
+		if err := n.pool.DeleteDirectBatch(ctx, id, list); err != nil {
+			logger.Warnf("%+v", err)
+		}
+	}
+}
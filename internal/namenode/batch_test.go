@@ -0,0 +1,60 @@
+package namenode
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pkg/errors"
+)
+
+func TestDeleteObjectsPartialFailure(t *testing.T) {
+	for _, mode := range modes {
+		t.Run(string(mode), func(t *testing.T) {
+			n, pool := newNameNode(t, mode, nil)
+			for _, key := range []string{"/x/a", "/x/b", "/y/a", "/y/b", "/z"} {
+				putObject(t, n, key, key)
+			}
+
+			// nothing under /y/ can be written.
+			failed := errors.New("write failed")
+			pool.Fail(func(method, id, key string) error {
+				if method == "PutMetadata" || method == "DeleteMetadata" {
+					if strings.HasPrefix(key, "/y/") {
+						return failed
+					}
+				}
+				return nil
+			})
+			result, err := n.DeleteObjects(context.Background(), []string{"/z", "/y/b", "/x/a", "/missing", "/y/a", "/x/b", "/z"})
+			pool.Fail(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			equalKeys(t, result.Deleted, "/missing", "/x/a", "/x/b", "/z")
+			if len(result.Errors) != 2 {
+				t.Fatalf("errors are %+v, want /y/a and /y/b", result.Errors)
+			}
+			for i, key := range []string{"/y/a", "/y/b"} {
+				if e := result.Errors[i]; e.Key != key || e.Message != failed.Error() {
+					t.Fatalf("error %d is %s: %s, want %s: %s", i, e.Key, e.Message, key, failed)
+				}
+			}
+
+			for _, key := range result.Deleted {
+				if _, err := getObject(t, n, key); !errors.Is(err, fiber.ErrNotFound) {
+					t.Fatalf("deleted %s read with %v", key, err)
+				}
+			}
+			for _, e := range result.Errors {
+				mustGet(t, n, e.Key, e.Key)
+			}
+			if data, _ := pool.Files(); data != len(result.Errors) {
+				t.Fatalf("%d data files kept, want %d", data, len(result.Errors))
+			}
+			equalKeys(t, checkTrie(t, n, pool), "/y/a", "/y/b")
+		})
+	}
+}
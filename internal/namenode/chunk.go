@@ -0,0 +1,40 @@
+package namenode
+
+import (
+	"context"
+	"io"
+
+	"github.com/qwp0905/go-object-storage/internal/metadata"
+)
+
+// chunks reports whether the data being put is split into chunks.
+func (n *nameNodeImpl) chunks(e *encoding) bool {
+	return n.chunkThreshold > 0 && e.stored > n.chunkThreshold
+}
+
+// storeChunks writes the data of meta in chunks spread over nodes of class, so
+// reads of large objects are served by many nodes at once.
+func (n *nameNodeImpl) storeChunks(
+	ctx context.Context,
+	meta *metadata.Metadata,
+	class string,
+	body io.Reader,
+	e *encoding,
+) error {
+	count := (e.stored + n.chunkSize - 1) / n.chunkSize
+	nodes, err := n.pool.SpreadNodes(ctx, class, int(count))
+	if err != nil {
+		return err
+	}
+
+	meta.SetNew(nodes[0])
+	meta.Chunks = make([]metadata.Chunk, count)
+	for i, id := range nodes {
+		size := n.chunkSize
+		if left := e.stored - uint(i)*n.chunkSize; left < size {
+			size = left
+		}
+		meta.Chunks[i] = metadata.Chunk{NodeId: id, Size: size}
+	}
+	return n.pool.PutDirect(ctx, meta, body)
+}
@@ -0,0 +1,107 @@
+package namenode
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/qwp0905/go-object-storage/internal/metadata"
+)
+
+const chunkedBody = "0123456789abcdefghijklmnopqrst"
+
+func chunkConfig() *Config {
+	return &Config{ChunkThreshold: 10, ChunkSize: 8, Dedup: true}
+}
+
+func TestChunkSplit(t *testing.T) {
+	n, pool := newNameNode(t, ConcurrencyLock, chunkConfig())
+	putObject(t, n, "/small", "0123456789")
+	putObject(t, n, "/a", chunkedBody)
+
+	if meta := head(t, n, "/small"); meta.Chunked() || !metadata.IsContentSource(meta.Source) {
+		t.Fatalf("object under the threshold is kept as %s in %d chunks", meta.Source, len(meta.Chunks))
+	}
+
+	meta := head(t, n, "/a")
+	if metadata.IsContentSource(meta.Source) {
+		t.Fatal("chunked object is shared")
+	}
+	sizes := []uint{8, 8, 8, 6}
+	if len(meta.Chunks) != len(sizes) {
+		t.Fatalf("%d chunks, want %d", len(meta.Chunks), len(sizes))
+	}
+	offset := uint(0)
+	for i, part := range meta.Parts() {
+		if part.Size != sizes[i] {
+			t.Fatalf("chunk %d holds %d bytes, want %d", i, part.Size, sizes[i])
+		}
+		if i > 0 && part.NodeId == meta.Chunks[i-1].NodeId {
+			t.Fatalf("chunks %d and %d are both on %s", i-1, i, part.NodeId)
+		}
+		data, ok := pool.Data(part.NodeId, part.Source)
+		if !ok || string(data) != chunkedBody[offset:offset+part.Size] {
+			t.Fatalf("chunk %d on %s holds %q", i, part.NodeId, data)
+		}
+		offset += part.Size
+	}
+
+	for _, c := range []struct{ offset, length int64 }{
+		{0, -1},
+		{2, 4},
+		{6, 4},
+		{7, 18},
+		{8, 8},
+		{29, 1},
+	} {
+		got, err := readRange(t, n, "/a", nil, c.offset, c.length)
+		if err != nil {
+			t.Fatalf("range %d+%d: %+v", c.offset, c.length, err)
+		}
+		want := chunkedBody
+		if c.length >= 0 {
+			want = chunkedBody[c.offset : c.offset+c.length]
+		}
+		if string(got) != want {
+			t.Fatalf("range %d+%d read %q, want %q", c.offset, c.length, got, want)
+		}
+	}
+
+	deleteObject(t, n, "/a")
+	if data, _ := pool.Files(); data != 1 {
+		t.Fatalf("%d data files left, want the small object only", data)
+	}
+}
+
+func TestChunkWriteFails(t *testing.T) {
+	n, pool := newNameNode(t, ConcurrencyLock, chunkConfig())
+	putObject(t, n, "/a", strings.ToUpper(chunkedBody))
+
+	failed := errors.New("write failed")
+	writes := 0
+	pool.Fail(func(method, id, key string) error {
+		if method != "PutDirect" {
+			return nil
+		}
+		if writes++; writes == 3 {
+			return failed
+		}
+		return nil
+	})
+	err := n.PutObject(context.Background(), &PutObjectInput{
+		Key:  "/a",
+		Size: len(chunkedBody),
+		Body: strings.NewReader(chunkedBody),
+	})
+	pool.Fail(nil)
+	if !errors.Is(err, failed) {
+		t.Fatalf("put went on after a chunk failed: %v", err)
+	}
+
+	// the chunks written before are removed and the old object is kept.
+	if data, _ := pool.Files(); data != 4 {
+		t.Fatalf("%d data files kept, want the 4 chunks of the old object", data)
+	}
+	mustGet(t, n, "/a", strings.ToUpper(chunkedBody))
+}
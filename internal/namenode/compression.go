@@ -0,0 +1,46 @@
+package namenode
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/pkg/errors"
+	"github.com/qwp0905/go-object-storage/internal/compression"
+)
+
+// compress returns the data to store for input, compressed with the codec the
+// policy picks for it. data that does not get smaller is kept as is.
+func (n *nameNodeImpl) compress(input *PutObjectInput) (io.Reader, *encoding, error) {
+	e := &encoding{stored: uint(input.Size)}
+	codec := n.compression.Codec(input.Key, input.ContentType)
+	if codec == "" || input.Size == 0 {
+		return input.Body, e, nil
+	}
+
+	data := make([]byte, input.Size)
+	if _, err := io.ReadFull(input.Body, data); err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	compressed, err := compression.Compress(codec, data)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(compressed) >= len(data) {
+		return bytes.NewReader(data), e, nil
+	}
+
+	e.compression, e.stored = codec, uint(len(compressed))
+	return bytes.NewReader(compressed), e, nil
+}
+
+// decompress returns length bytes from offset of the data r decompresses to.
+func decompress(codec string, r io.Reader, offset, length int64) (io.Reader, error) {
+	d, err := compression.NewReader(codec, r)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.CopyN(io.Discard, d, offset); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return io.LimitReader(d, length), nil
+}
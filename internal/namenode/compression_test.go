@@ -0,0 +1,103 @@
+package namenode
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/qwp0905/go-object-storage/internal/compression"
+	"github.com/qwp0905/go-object-storage/internal/encryption"
+)
+
+func TestCompressedObject(t *testing.T) {
+	policy, err := compression.NewPolicy("/zstd/=zstd", "text/=gzip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := encryption.NewLocalKeyManager([]string{"master"}, [][]byte{randomBody(t, encryption.KeySize)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := strings.Repeat("compressible text ", 10000)
+
+	for _, c := range []struct {
+		name        string
+		key         string
+		contentType string
+		encrypted   bool
+		codec       string
+	}{
+		{"by prefix", "/zstd/a", "", false, compression.Zstd},
+		{"by content type", "/a", "text/plain", false, compression.Gzip},
+		{"encrypted", "/zstd/a", "", true, compression.Zstd},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			n, pool := newNameNode(t, ConcurrencyLock, &Config{Compression: policy, Keys: keys})
+			if err := n.PutObject(context.Background(), &PutObjectInput{
+				Key:                  c.key,
+				ContentType:          c.contentType,
+				Size:                 len(body),
+				Body:                 strings.NewReader(body),
+				ServerSideEncryption: c.encrypted,
+			}); err != nil {
+				t.Fatalf("%+v", err)
+			}
+
+			// Size is what is read, Stored what the datanode keeps.
+			meta := head(t, n, c.key)
+			if meta.Compression != c.codec || meta.Size != uint(len(body)) {
+				t.Fatalf("kept %d bytes by %q", meta.Size, meta.Compression)
+			}
+			stored, _ := pool.Data(meta.NodeId, meta.Source)
+			if meta.Stored == 0 || meta.Stored >= meta.Size || uint(len(stored)) != meta.Stored {
+				t.Fatalf("stored %d bytes of %d, the datanode keeps %d", meta.Stored, meta.Size, len(stored))
+			}
+
+			for _, r := range []struct{ offset, length int64 }{
+				{0, -1},
+				{0, 10},
+				{int64(len(body)) - 10, 10},
+				{int64(meta.Stored), 100},
+				{1000, 50000},
+			} {
+				got, err := readRange(t, n, c.key, nil, r.offset, r.length)
+				if err != nil {
+					t.Fatalf("range %d+%d: %+v", r.offset, r.length, err)
+				}
+				want := body
+				if r.length >= 0 {
+					want = body[r.offset : r.offset+r.length]
+				}
+				if string(got) != want {
+					t.Fatalf("range %d+%d does not match", r.offset, r.length)
+				}
+			}
+
+			// ranges are checked against the size of the object, not of the
+			// stored data.
+			if _, err := readRange(t, n, c.key, nil, int64(len(body))-10, 11); !errors.Is(err, ErrInvalidRange) {
+				t.Fatalf("read past the end: %v", err)
+			}
+		})
+	}
+}
+
+func TestIncompressibleObject(t *testing.T) {
+	policy, err := compression.NewPolicy("/=zstd", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, _ := newNameNode(t, ConcurrencyLock, &Config{Compression: policy})
+	body := randomBody(t, 1000)
+	putObject(t, n, "/a", string(body))
+	putObject(t, n, "/empty", "")
+
+	for _, key := range []string{"/a", "/empty"} {
+		if meta := head(t, n, key); meta.Compression != "" || meta.Stored != 0 {
+			t.Fatalf("%s is stored in %d bytes by %q", key, meta.Stored, meta.Compression)
+		}
+	}
+	mustGet(t, n, "/a", string(body))
+	mustGet(t, n, "/empty", "")
+}
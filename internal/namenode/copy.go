@@ -0,0 +1,244 @@
+package namenode
+
+import (
+	"context"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/qwp0905/go-object-storage/internal/metadata"
+)
+
+type MetadataDirective string
+
+const (
+	MetadataCopy    MetadataDirective = "COPY"
+	MetadataReplace MetadataDirective = "REPLACE"
+)
+
+var (
+	ErrInvalidDirective = fiber.NewError(fiber.StatusBadRequest, "metadata directive must be COPY or REPLACE")
+	ErrCopyToItself     = fiber.NewError(fiber.StatusBadRequest, "copying an object to itself requires the REPLACE directive or another storage class")
+)
+
+type CopyObjectInput struct {
+	Source    string
+	Key       string
+	Directive MetadataDirective
+	// only used with the REPLACE directive.
+	ContentType string
+	Expires     time.Time
+	// standard when empty, whatever the source is stored as.
+	StorageClass string
+	// encryption of the copy, the source key is needed when the source is
+	// encrypted with a customer key.
+	ServerSideEncryption bool
+	CustomerKey          []byte
+	SourceCustomerKey    []byte
+}
+
+// sealing is the encryption a copy asks for.
+func (n *nameNodeImpl) sealingOf(input *CopyObjectInput) string {
+	switch {
+	case input.CustomerKey != nil:
+		return metadata.EncryptionCustomer
+	case input.ServerSideEncryption || n.encryptByDefault:
+		return metadata.EncryptionServer
+	}
+	return ""
+}
+
+func (n *nameNodeImpl) CopyObject(ctx context.Context, input *CopyObjectInput) error {
+	switch input.Directive {
+	case "", MetadataCopy, MetadataReplace:
+	default:
+		return ErrInvalidDirective
+	}
+
+	class, err := (&PutObjectInput{StorageClass: input.StorageClass}).class()
+	if err != nil {
+		return err
+	}
+
+	src, err := n.headLive(ctx, input.Source, n.getMetadata)
+	if err != nil {
+		return err
+	}
+	if input.Source == input.Key &&
+		input.Directive != MetadataReplace &&
+		src.Class() == class &&
+		src.Encryption == n.sealingOf(input) &&
+		input.CustomerKey == nil {
+		return ErrCopyToItself
+	}
+
+	contentType, expires := src.Type, src.Expires
+	if input.Directive == MetadataReplace {
+		contentType, expires = input.ContentType, input.Expires
+	}
+
+	// replacing the metadata of an object in place keeps its data, unless it
+	// moves to another tier or is encrypted another way.
+	sealing := n.sealingOf(input)
+	if input.Source == input.Key &&
+		src.Class() == class &&
+		src.Encryption == sealing &&
+		sealing != metadata.EncryptionCustomer {
+		object := *src
+		object.Type = contentType
+		object.Expires = expires
+		object.LastModified = time.Now()
+		prev, err := n.relink(ctx, input.Key, &object)
+		if err != nil {
+			return err
+		}
+		return n.dropReplaced(ctx, prev, &object)
+	}
+
+	// data that stays as it is stored is copied on the datanodes holding it.
+	if src.Class() == class && src.Encryption == "" && sealing == "" {
+		object, err := n.clone(ctx, src)
+		if err != nil {
+			return err
+		}
+		object.Type = contentType
+		object.Expires = expires
+		object.LastModified = time.Now()
+		prev, err := n.relink(ctx, input.Key, object)
+		if err != nil {
+			n.pool.DeleteDirect(ctx, object)
+			return err
+		}
+		return n.dropReplaced(ctx, prev, object)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	r, err := n.ReadObject(ctx, &ReadObjectInput{
+		Metadata:    src,
+		Length:      -1,
+		CustomerKey: input.SourceCustomerKey,
+	})
+	if err != nil {
+		return err
+	}
+
+	return n.PutObject(ctx, &PutObjectInput{
+		Key:                  input.Key,
+		ContentType:          contentType,
+		Size:                 int(src.Size),
+		Body:                 r,
+		Expires:              expires,
+		StorageClass:         class,
+		ServerSideEncryption: sealing == metadata.EncryptionServer,
+		CustomerKey:          input.CustomerKey,
+		fresh:                true,
+	})
+}
+
+// RenameObject moves the object at source to key without passing its data
+// through the namenode.
+func (n *nameNodeImpl) RenameObject(ctx context.Context, source, key string) error {
+	if source == key {
+		return nil
+	}
+
+	object, err := n.headLive(ctx, source, n.getMetadata)
+	if err != nil {
+		return err
+	}
+
+	// key gets data of its own, so overwriting source in place meanwhile
+	// never reaches the object of key.
+	moved, err := n.clone(ctx, object)
+	if err != nil {
+		return err
+	}
+	prev, err := n.relink(ctx, key, moved)
+	if err != nil {
+		n.pool.DeleteDirect(ctx, moved)
+		return err
+	}
+
+	// source is only unlinked while it still holds the object that was
+	// copied, anything written to it meanwhile stays.
+	b := n.remove(ctx, []string{source}, map[string]*metadata.Metadata{source: object})
+	if err := b.failed[source]; err != nil {
+		return err
+	}
+
+	return n.dropReplaced(ctx, prev, moved)
+}
+
+// clone stores the data of object again on the nodes holding it, shared data
+// only counts another reference.
+func (n *nameNodeImpl) clone(ctx context.Context, object *metadata.Metadata) (*metadata.Metadata, error) {
+	out := *object
+	if object.Shared() {
+		return &out, n.pool.LinkDirect(ctx, object)
+	}
+	out.Source = metadata.NewSource()
+	return &out, n.pool.CopyDirect(ctx, object, out.Source)
+}
+
+// relink points key at an object that is already stored and returns the
+// object key held before.
+func (n *nameNodeImpl) relink(ctx context.Context, key string, object *metadata.Metadata) (*metadata.Metadata, error) {
+	var prev *metadata.Metadata
+	if n.concurrency == ConcurrencyOptimistic {
+		target := metadata.New(key)
+		target.SetObject(object)
+		err := n.retry(ctx, func() error {
+			rootId, err := n.getRootId(ctx)
+			if err != nil {
+				return err
+			}
+			prev, err = n.link(ctx, target, "", nil, rootId, n.rootKey)
+			return err
+		})
+		return prev, err
+	}
+
+	id, start, err := n.findEntry(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	err = n.put(ctx, key, id, start, func(ctx context.Context, meta *metadata.Metadata) error {
+		if meta.FileExists() {
+			replaced := *meta
+			prev = &replaced
+		}
+		meta.SetObject(object)
+		return nil
+	})
+	return prev, err
+}
+
+func (n *nameNodeImpl) dropReplaced(ctx context.Context, prev, object *metadata.Metadata) error {
+	if prev == nil || !prev.FileExists() {
+		return nil
+	}
+	// shared data counts a reference for every key, so only the same key
+	// keeps its own.
+	if prev.NodeId == object.NodeId && prev.Source == object.Source &&
+		(!prev.Shared() || prev.Key == object.Key) {
+		return nil
+	}
+	return n.pool.DeleteDirect(ctx, prev)
+}
+
+// update changes the metadata of key in place without touching its data.
+func (n *nameNodeImpl) update(ctx context.Context, key string, fn func(meta *metadata.Metadata) bool) error {
+	if n.concurrency == ConcurrencyOptimistic {
+		return n.retry(ctx, func() error {
+			return n.rewriteOptimistic(ctx, key, fn)
+		})
+	}
+
+	id, start, err := n.findEntry(ctx, key)
+	if err != nil {
+		return err
+	}
+	return n.rewrite(ctx, key, id, start, fn)
+}
@@ -0,0 +1,128 @@
+package namenode
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pkg/errors"
+	"github.com/qwp0905/go-object-storage/internal/datanode"
+	"github.com/qwp0905/go-object-storage/internal/metadata"
+	"github.com/qwp0905/go-object-storage/internal/nodepool/pooltest"
+)
+
+const coldNode = "cold-0"
+
+// newTieredNameNode adds a cold datanode to the nodes of newNameNode.
+func newTieredNameNode(t *testing.T, concurrency Concurrency) (*nameNodeImpl, *pooltest.Pool) {
+	t.Helper()
+	n, pool := newNameNode(t, concurrency, nil)
+	pool.AddNode(coldNode, datanode.Labels{Tier: metadata.StorageClassCold})
+	return n, pool
+}
+
+func copyObject(t *testing.T, n NameNode, input *CopyObjectInput) {
+	t.Helper()
+	if err := n.CopyObject(context.Background(), input); err != nil {
+		t.Fatalf("copy %s to %s: %+v", input.Source, input.Key, err)
+	}
+}
+
+func renameObject(t *testing.T, n NameNode, source, key string) {
+	t.Helper()
+	if err := n.RenameObject(context.Background(), source, key); err != nil {
+		t.Fatalf("rename %s to %s: %+v", source, key, err)
+	}
+}
+
+// mustStore fails unless the data of key is kept on a node of class and
+// every data file belongs to one of want.
+func mustStore(t *testing.T, n NameNode, pool *pooltest.Pool, key, class string, want int) *metadata.Metadata {
+	t.Helper()
+	meta := head(t, n, key)
+	if meta.Class() != class || (meta.NodeId == coldNode) != (class == metadata.StorageClassCold) {
+		t.Fatalf("%s is %s on %s, want %s", key, meta.Class(), meta.NodeId, class)
+	}
+	if _, ok := pool.Data(meta.NodeId, meta.Source); !ok {
+		t.Fatalf("data of %s is missing", key)
+	}
+	if data, _ := pool.Files(); data != want {
+		t.Fatalf("%d data files kept, want %d", data, want)
+	}
+	return meta
+}
+
+func TestCopyObject(t *testing.T) {
+	for _, mode := range modes {
+		t.Run(string(mode), func(t *testing.T) {
+			ctx := context.Background()
+			n, pool := newTieredNameNode(t, mode)
+			putObject(t, n, "/a", "body")
+			a := head(t, n, "/a")
+
+			copyObject(t, n, &CopyObjectInput{Source: "/a", Key: "/b"})
+			b := mustStore(t, n, pool, "/b", metadata.StorageClassStandard, 2)
+			if b.NodeId != a.NodeId || b.Source == a.Source {
+				t.Fatalf("copy on the same tier is %s on %s, source %s on %s", b.Source, b.NodeId, a.Source, a.NodeId)
+			}
+			deleteObject(t, n, "/a")
+			mustGet(t, n, "/b", "body")
+
+			copyObject(t, n, &CopyObjectInput{Source: "/b", Key: "/c", StorageClass: metadata.StorageClassCold})
+			cold := mustStore(t, n, pool, "/c", metadata.StorageClassCold, 2)
+			mustGet(t, n, "/c", "body")
+
+			err := n.CopyObject(ctx, &CopyObjectInput{Source: "/c", Key: "/c", StorageClass: metadata.StorageClassCold})
+			if !errors.Is(err, ErrCopyToItself) {
+				t.Fatalf("copy to itself returned %v", err)
+			}
+
+			// copying in place to another tier moves the data.
+			copyObject(t, n, &CopyObjectInput{Source: "/c", Key: "/c"})
+			mustStore(t, n, pool, "/c", metadata.StorageClassStandard, 2)
+			if _, ok := pool.Data(coldNode, cold.Source); ok {
+				t.Fatal("data left behind on the cold node")
+			}
+			mustGet(t, n, "/c", "body")
+
+			// replacing the metadata in place keeps the data.
+			copyObject(t, n, &CopyObjectInput{Source: "/b", Key: "/b", Directive: MetadataReplace, ContentType: "text/plain"})
+			replaced := mustStore(t, n, pool, "/b", metadata.StorageClassStandard, 2)
+			if replaced.Source != b.Source || replaced.Type != "text/plain" {
+				t.Fatalf("replaced metadata is %s of %s, want text/plain of %s", replaced.Type, replaced.Source, b.Source)
+			}
+
+			equalKeys(t, checkTrie(t, n, pool), "/b", "/c")
+		})
+	}
+}
+
+func TestRenameObject(t *testing.T) {
+	for _, mode := range modes {
+		t.Run(string(mode), func(t *testing.T) {
+			ctx := context.Background()
+			n, pool := newTieredNameNode(t, mode)
+			putObject(t, n, "/a", "a")
+			putObject(t, n, "/b", "old")
+
+			renameObject(t, n, "/a", "/b")
+			mustGet(t, n, "/b", "a")
+			if _, err := getObject(t, n, "/a"); !errors.Is(err, fiber.ErrNotFound) {
+				t.Fatalf("renamed source read with %v", err)
+			}
+			mustStore(t, n, pool, "/b", metadata.StorageClassStandard, 1)
+
+			// a cold object keeps its tier.
+			copyObject(t, n, &CopyObjectInput{Source: "/b", Key: "/cold", StorageClass: metadata.StorageClassCold})
+			renameObject(t, n, "/cold", "/moved")
+			mustStore(t, n, pool, "/moved", metadata.StorageClassCold, 2)
+			mustGet(t, n, "/moved", "a")
+
+			if err := n.RenameObject(ctx, "/missing", "/c"); !errors.Is(err, fiber.ErrNotFound) {
+				t.Fatalf("rename of a missing key returned %v", err)
+			}
+
+			equalKeys(t, checkTrie(t, n, pool), "/b", "/moved")
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package namenode
+
+import (
+	"bytes"
+	"context"
+	"io"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pkg/errors"
+	"github.com/qwp0905/go-object-storage/internal/metadata"
+	"github.com/qwp0905/go-object-storage/pkg/logger"
+)
+
+// digest names the data of input by its content when objects are
+// deduplicated. encrypted data never matches, every object has a key of its
+// own, and chunked data is not shared.
+func (n *nameNodeImpl) digest(input *PutObjectInput, body io.Reader, e *encoding) (io.Reader, error) {
+	if !n.dedup || n.encrypts(input) || n.chunks(e) {
+		return body, nil
+	}
+
+	data := make([]byte, e.stored)
+	if _, err := io.ReadFull(body, data); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	e.source = metadata.ContentSource(data)
+	return bytes.NewReader(data), nil
+}
+
+// store writes the data of meta, which has no source yet, to a node of class.
+// content addressed data is only linked when a node already holds it.
+func (n *nameNodeImpl) store(
+	ctx context.Context,
+	meta *metadata.Metadata,
+	class string,
+	body io.Reader,
+	e *encoding,
+) error {
+	if n.chunks(e) {
+		return n.storeChunks(ctx, meta, class, body, e)
+	}
+	if e.source == "" {
+		nodeId, err := n.pool.AcquireTierNode(ctx, class)
+		if err != nil {
+			return err
+		}
+		meta.SetNew(nodeId)
+		return n.pool.PutDirect(ctx, meta, body)
+	}
+
+	meta.Source, meta.Chunks = e.source, nil
+	nodeId, err := n.pool.FindContent(ctx, e.source, class)
+	if err != nil {
+		return err
+	}
+	if nodeId != "" {
+		meta.NodeId = nodeId
+		if err := n.pool.LinkDirect(ctx, meta); !errors.Is(err, fiber.ErrNotFound) {
+			return err
+		}
+	}
+
+	if meta.NodeId, err = n.pool.AcquireTierNode(ctx, class); err != nil {
+		return err
+	}
+	if err := n.pool.PutDirect(ctx, meta, body); err != nil {
+		return err
+	}
+	// the index is only a hint, the next upload writes the data again.
+	if err := n.pool.IndexContent(ctx, e.source, meta.NodeId); err != nil {
+		logger.Warnf("%+v", err)
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package namenode
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/qwp0905/go-object-storage/internal/encryption"
+	"github.com/qwp0905/go-object-storage/internal/metadata"
+	"github.com/qwp0905/go-object-storage/internal/nodepool/pooltest"
+)
+
+func head(t *testing.T, n NameNode, key string) *metadata.Metadata {
+	t.Helper()
+	meta, err := n.HeadObject(context.Background(), key)
+	if err != nil {
+		t.Fatalf("head %s: %+v", key, err)
+	}
+	return meta
+}
+
+func deleteObject(t *testing.T, n NameNode, key string) {
+	t.Helper()
+	if err := n.DeleteObject(context.Background(), key); err != nil {
+		t.Fatalf("delete %s: %+v", key, err)
+	}
+}
+
+func mustKeep(t *testing.T, pool *pooltest.Pool, meta *metadata.Metadata, refs uint64) {
+	t.Helper()
+	_, kept := pool.Data(meta.NodeId, meta.Source)
+	if got := pool.Refs(meta.NodeId, meta.Source); got != refs || kept != (refs > 0) {
+		t.Fatalf("%s counts %d refs and is kept %t, want %d refs", meta.Source, got, kept, refs)
+	}
+}
+
+func TestDedupRefs(t *testing.T) {
+	for _, mode := range modes {
+		t.Run(string(mode), func(t *testing.T) {
+			n, pool := newNameNode(t, mode, &Config{Dedup: true})
+			putObject(t, n, "/a", "same")
+			putObject(t, n, "/b", "same")
+
+			a, b := head(t, n, "/a"), head(t, n, "/b")
+			if !metadata.IsContentSource(a.Source) || a.Source != b.Source || a.NodeId != b.NodeId {
+				t.Fatalf("%s on %s and %s on %s are not shared", a.Source, a.NodeId, b.Source, b.NodeId)
+			}
+			mustKeep(t, pool, a, 2)
+			if data, _ := pool.Files(); data != 1 {
+				t.Fatalf("%d data files kept for one content", data)
+			}
+
+			deleteObject(t, n, "/a")
+			mustKeep(t, pool, a, 1)
+			mustGet(t, n, "/b", "same")
+
+			deleteObject(t, n, "/b")
+			mustKeep(t, pool, a, 0)
+			if data, _ := pool.Files(); data != 0 {
+				t.Fatalf("%d data files left", data)
+			}
+		})
+	}
+}
+
+func TestDedupOverwrite(t *testing.T) {
+	for _, mode := range modes {
+		t.Run(string(mode), func(t *testing.T) {
+			n, pool := newNameNode(t, mode, &Config{Dedup: true})
+			putObject(t, n, "/a", "old")
+			putObject(t, n, "/b", "old")
+			old := head(t, n, "/a")
+
+			putObject(t, n, "/a", "new")
+			mustKeep(t, pool, old, 1)
+			mustKeep(t, pool, head(t, n, "/a"), 1)
+			mustGet(t, n, "/a", "new")
+			mustGet(t, n, "/b", "old")
+
+			// the same content again keeps the one reference.
+			putObject(t, n, "/b", "old")
+			mustKeep(t, pool, old, 1)
+
+			putObject(t, n, "/b", "new")
+			mustKeep(t, pool, old, 0)
+			mustKeep(t, pool, head(t, n, "/b"), 2)
+		})
+	}
+}
+
+func TestDedupSkipsEncrypted(t *testing.T) {
+	keys, err := encryption.NewLocalKeyManager([]string{"master"}, [][]byte{randomBody(t, encryption.KeySize)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, pool := newNameNode(t, ConcurrencyLock, &Config{Dedup: true, Keys: keys})
+	for _, key := range []string{"/a", "/b"} {
+		if err := n.PutObject(context.Background(), &PutObjectInput{
+			Key:                  key,
+			Size:                 4,
+			Body:                 strings.NewReader("same"),
+			ServerSideEncryption: true,
+		}); err != nil {
+			t.Fatalf("%+v", err)
+		}
+	}
+	for _, key := range []string{"/a", "/b"} {
+		if meta := head(t, n, key); metadata.IsContentSource(meta.Source) {
+			t.Fatalf("encrypted %s is shared as %s", key, meta.Source)
+		}
+	}
+	if data, _ := pool.Files(); data != 2 {
+		t.Fatalf("%d data files kept for two encrypted objects", data)
+	}
+}
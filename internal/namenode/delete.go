@@ -6,19 +6,28 @@ import (
 	"github.com/qwp0905/go-object-storage/internal/metadata"
 )
 
-func (n *nameNodeImpl) delete(ctx context.Context, key, id, current string) (*metadata.Metadata, error) {
+// delete unlinks the object at key. with expect, it only does so while the
+// key still holds that object.
+func (n *nameNodeImpl) delete(
+	ctx context.Context,
+	key string,
+	expect *metadata.Metadata,
+	id, current string,
+) (*metadata.Metadata, error) {
 	locker := n.lockerPool.Get(current)
-	if err := locker.Lock(ctx); err != nil {
+	token, err := locker.Lock(ctx)
+	if err != nil {
 		return nil, err
 	}
 	defer locker.Unlock(ctx)
 
-	currentMeta, err := n.pool.GetMetadata(ctx, id, current)
+	currentMeta, err := n.getMetadata(ctx, id, current)
 	if err != nil {
 		return nil, err
 	}
+	currentMeta.Fence = token
 
-	if key == currentMeta.Key && currentMeta.FileExists() {
+	if key == currentMeta.Key && currentMeta.FileExists() && (expect == nil || currentMeta.SameObject(expect)) {
 		if len(currentMeta.NextNodes) == 0 {
 			if err := n.pool.DeleteMetadata(ctx, id, key); err != nil {
 				return nil, err
@@ -40,11 +49,25 @@ func (n *nameNodeImpl) delete(ctx context.Context, key, id, current string) (*me
 	}
 
 	next := currentMeta.GetNext(index)
-	deleted, err := n.delete(ctx, key, next.NodeId, next.Key)
+	deleted, err := n.delete(ctx, key, expect, next.NodeId, next.Key)
 	if err != nil {
 		return nil, err
 	}
 
+	return n.settle(ctx, id, currentMeta, index, deleted)
+}
+
+// settle fixes up the route at index after the node it points to was
+// changed to deleted, or removed when deleted is nil. it keeps every non root
+// node without object branching, and returns nil if current went away too.
+func (n *nameNodeImpl) settle(
+	ctx context.Context,
+	id string,
+	currentMeta *metadata.Metadata,
+	index int,
+	deleted *metadata.Metadata,
+) (*metadata.Metadata, error) {
+	next := currentMeta.GetNext(index)
 	if deleted != nil {
 		if deleted.Len() != 1 || deleted.FileExists() {
 			return currentMeta, nil
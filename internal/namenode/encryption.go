@@ -0,0 +1,246 @@
+package namenode
+
+import (
+	"context"
+	"io"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pkg/errors"
+	"github.com/qwp0905/go-object-storage/internal/encryption"
+	"github.com/qwp0905/go-object-storage/internal/metadata"
+)
+
+var (
+	ErrEncryptionDisabled  = fiber.NewError(fiber.StatusBadRequest, "server side encryption is not configured")
+	ErrCustomerKeyRequired = fiber.NewError(fiber.StatusBadRequest, "object is encrypted with a customer key")
+	ErrInvalidCustomerKey  = fiber.NewError(fiber.StatusBadRequest, "customer key must be 32 bytes")
+	ErrInvalidRange        = fiber.NewError(fiber.StatusRequestedRangeNotSatisfiable, "range is out of the object")
+)
+
+// encoding holds how the data of an object being put is kept on the
+// datanode. stored is the length of the data as it is written and source the
+// name of content addressed data.
+type encoding struct {
+	source      string
+	compression string
+	encryption  string
+	keyId       string
+	dataKey     []byte
+	stored      uint
+}
+
+func (e *encoding) apply(meta *metadata.Metadata) {
+	meta.Stored = e.stored
+	if e.compression == "" && e.encryption == "" {
+		meta.Stored = 0
+	}
+	meta.Compression, meta.Encryption, meta.KeyId, meta.DataKey = e.compression, e.encryption, e.keyId, e.dataKey
+}
+
+// encrypts reports whether input asks for encryption or every object is
+// encrypted.
+func (n *nameNodeImpl) encrypts(input *PutObjectInput) bool {
+	return input.CustomerKey != nil || input.ServerSideEncryption || n.encryptByDefault
+}
+
+// seal encrypts body with a new data key when input is encrypted.
+func (n *nameNodeImpl) seal(ctx context.Context, input *PutObjectInput, body io.Reader, e *encoding) (io.Reader, error) {
+	if !n.encrypts(input) {
+		return body, nil
+	}
+
+	dataKey, err := encryption.GenerateDataKey()
+	if err != nil {
+		return nil, err
+	}
+
+	if input.CustomerKey != nil {
+		if len(input.CustomerKey) != encryption.KeySize {
+			return nil, ErrInvalidCustomerKey
+		}
+		e.encryption = metadata.EncryptionCustomer
+		e.dataKey, err = encryption.WrapKey(input.CustomerKey, dataKey)
+	} else {
+		if n.keys == nil {
+			return nil, ErrEncryptionDisabled
+		}
+		e.encryption = metadata.EncryptionServer
+		e.keyId, e.dataKey, err = n.keys.Wrap(ctx, dataKey)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := encryption.NewEncrypter(dataKey, body, uint64(e.stored))
+	if err != nil {
+		return nil, err
+	}
+	e.stored = uint(encryption.SealedSize(uint64(e.stored)))
+	return r, nil
+}
+
+// dataKey unwraps the key the data of meta is encrypted with.
+func (n *nameNodeImpl) dataKey(ctx context.Context, meta *metadata.Metadata, customerKey []byte) ([]byte, error) {
+	switch meta.Encryption {
+	case metadata.EncryptionCustomer:
+		if customerKey == nil {
+			return nil, ErrCustomerKeyRequired
+		}
+		if len(customerKey) != encryption.KeySize {
+			return nil, ErrInvalidCustomerKey
+		}
+		return encryption.UnwrapKey(customerKey, meta.DataKey)
+	case metadata.EncryptionServer:
+		if n.keys == nil {
+			return nil, ErrEncryptionDisabled
+		}
+		return n.keys.Unwrap(ctx, meta.KeyId, meta.DataKey)
+	}
+	return nil, errors.Errorf("unknown encryption %s", meta.Encryption)
+}
+
+type ReadObjectInput struct {
+	Metadata *metadata.Metadata
+	// the whole object when length is negative.
+	Offset int64
+	Length int64
+	// only needed for objects encrypted with a customer key.
+	CustomerKey []byte
+}
+
+// ReadObject returns the plaintext of a range of an object that was looked up
+// with HeadObject.
+func (n *nameNodeImpl) ReadObject(ctx context.Context, input *ReadObjectInput) (io.Reader, error) {
+	meta := input.Metadata
+	offset, length := input.Offset, input.Length
+	if length < 0 {
+		offset, length = 0, int64(meta.Size)
+	}
+	if offset < 0 || offset+length > int64(meta.Size) {
+		return nil, ErrInvalidRange
+	}
+
+	if meta.Compression == "" {
+		return n.readStored(ctx, meta, input.CustomerKey, int64(meta.Size), offset, length)
+	}
+
+	// compressed data can only be read from the start.
+	size := int64(meta.StoredSize())
+	if meta.Encryption != "" {
+		size = int64(encryption.OpenedSize(uint64(size)))
+	}
+	r, err := n.readStored(ctx, meta, input.CustomerKey, size, 0, size)
+	if err != nil {
+		return nil, err
+	}
+	return decompress(meta.Compression, r, offset, length)
+}
+
+// readStored returns a range of the data kept on the datanode once decrypted,
+// size is the length of that data.
+func (n *nameNodeImpl) readStored(
+	ctx context.Context,
+	meta *metadata.Metadata,
+	customerKey []byte,
+	size, offset, length int64,
+) (io.Reader, error) {
+	if meta.Encryption == "" {
+		if offset == 0 && length == size {
+			return n.pool.GetDirect(ctx, meta)
+		}
+		return n.pool.GetDirectRange(ctx, meta, offset, length)
+	}
+
+	key, err := n.dataKey(ctx, meta, customerKey)
+	if err != nil {
+		return nil, err
+	}
+
+	start, sealed, first := encryption.SealedRange(uint64(size), uint64(offset), uint64(length))
+	r, err := n.pool.GetDirectRange(ctx, meta, int64(start), int64(sealed))
+	if err != nil {
+		return nil, err
+	}
+
+	skip := int(uint64(offset) - first*encryption.ChunkSize)
+	out, err := encryption.NewDecrypter(key, r, uint64(size), first, skip)
+	if err != nil {
+		return nil, err
+	}
+	return io.LimitReader(out, length), nil
+}
+
+// RotateKeys wraps the data keys of objects under prefix that are not
+// wrapped by the current master key again. the data itself is not touched.
+func (n *nameNodeImpl) RotateKeys(ctx context.Context, prefix string, progress *Progress) error {
+	if n.keys == nil {
+		return ErrEncryptionDisabled
+	}
+	if prefix == "" {
+		prefix = n.rootKey
+	}
+	if !strings.HasPrefix(prefix, n.rootKey) {
+		return ErrInvalidPrefix
+	}
+
+	current := n.keys.CurrentKeyId()
+	input := &ListObjectInput{Prefix: prefix, MaxKeys: MaxListKeys}
+	for {
+		page, err := n.ListObject(ctx, input)
+		if err != nil {
+			return err
+		}
+
+		for _, object := range page.List {
+			if err := ctx.Err(); err != nil {
+				return errors.WithStack(err)
+			}
+			rotated, err := n.rotateKey(ctx, object.Key, current)
+			if err != nil {
+				return err
+			}
+			if rotated {
+				progress.Objects.Add(1)
+				progress.Bytes.Add(int64(object.Size))
+			}
+		}
+
+		if !page.IsTruncated {
+			return nil
+		}
+		input.ContinuationToken = page.NextContinuationToken
+	}
+}
+
+func (n *nameNodeImpl) rotateKey(ctx context.Context, key, current string) (bool, error) {
+	object, err := n.head(ctx, key, n.getMetadata)
+	if errors.Is(err, fiber.ErrNotFound) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	if object.Encryption != metadata.EncryptionServer || object.KeyId == current {
+		return false, nil
+	}
+
+	dataKey, err := n.keys.Unwrap(ctx, object.KeyId, object.DataKey)
+	if err != nil {
+		return false, err
+	}
+	keyId, wrapped, err := n.keys.Wrap(ctx, dataKey)
+	if err != nil {
+		return false, err
+	}
+
+	// an object written meanwhile already has a key of its own.
+	rotated := false
+	err = n.update(ctx, key, func(meta *metadata.Metadata) bool {
+		rotated = meta.SameObject(object) && meta.KeyId == object.KeyId
+		if rotated {
+			meta.KeyId, meta.DataKey = keyId, wrapped
+		}
+		return rotated
+	})
+	return rotated, err
+}
@@ -0,0 +1,134 @@
+package namenode
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/qwp0905/go-object-storage/internal/encryption"
+	"github.com/qwp0905/go-object-storage/internal/metadata"
+)
+
+func randomBody(t *testing.T, size int) []byte {
+	t.Helper()
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func readRange(t *testing.T, n NameNode, key string, customerKey []byte, offset, length int64) ([]byte, error) {
+	t.Helper()
+	meta, err := n.HeadObject(context.Background(), key)
+	if err != nil {
+		t.Fatalf("head %s: %+v", key, err)
+	}
+	r, err := n.ReadObject(context.Background(), &ReadObjectInput{
+		Metadata:    meta,
+		Offset:      offset,
+		Length:      length,
+		CustomerKey: customerKey,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func TestEncryptedObject(t *testing.T) {
+	masterKey := randomBody(t, encryption.KeySize)
+	keys, err := encryption.NewLocalKeyManager([]string{"master"}, [][]byte{masterKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	customerKey := randomBody(t, encryption.KeySize)
+	body := randomBody(t, encryption.ChunkSize*2+100)
+
+	for name, input := range map[string]*PutObjectInput{
+		"master key":   {ServerSideEncryption: true},
+		"customer key": {CustomerKey: customerKey},
+	} {
+		t.Run(name, func(t *testing.T) {
+			n, pool := newNameNode(t, ConcurrencyLock, &Config{Keys: keys})
+			input.Key, input.Size, input.Body = "/a", len(body), bytes.NewReader(body)
+			if err := n.PutObject(context.Background(), input); err != nil {
+				t.Fatalf("%+v", err)
+			}
+
+			meta, err := n.HeadObject(context.Background(), "/a")
+			if err != nil {
+				t.Fatal(err)
+			}
+			stored, _ := pool.Data(meta.NodeId, meta.Source)
+			if uint64(len(stored)) != encryption.SealedSize(uint64(len(body))) || bytes.Contains(stored, body[:64]) {
+				t.Fatal("data is not kept sealed")
+			}
+
+			for _, c := range []struct{ offset, length int64 }{
+				{0, -1},
+				{10, 100},
+				{encryption.ChunkSize - 10, 20},
+				{encryption.ChunkSize * 2, 100},
+			} {
+				got, err := readRange(t, n, "/a", input.CustomerKey, c.offset, c.length)
+				if err != nil {
+					t.Fatalf("range %d+%d: %+v", c.offset, c.length, err)
+				}
+				want := body
+				if c.length >= 0 {
+					want = body[c.offset : c.offset+c.length]
+				}
+				if !bytes.Equal(got, want) {
+					t.Fatalf("range %d+%d does not match", c.offset, c.length)
+				}
+			}
+		})
+	}
+}
+
+func TestCustomerKeyRequired(t *testing.T) {
+	n, _ := newNameNode(t, ConcurrencyLock, nil)
+	customerKey := randomBody(t, encryption.KeySize)
+	if err := n.PutObject(context.Background(), &PutObjectInput{
+		Key:         "/a",
+		Size:        4,
+		Body:        bytes.NewReader([]byte("data")),
+		CustomerKey: customerKey,
+	}); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	meta, err := n.HeadObject(context.Background(), "/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Encryption != metadata.EncryptionCustomer || meta.KeyId != "" {
+		t.Fatalf("object is encrypted by %s %s", meta.Encryption, meta.KeyId)
+	}
+
+	if _, err := readRange(t, n, "/a", nil, 0, -1); !errors.Is(err, ErrCustomerKeyRequired) {
+		t.Fatalf("read without the customer key: %v", err)
+	}
+	if _, err := readRange(t, n, "/a", randomBody(t, encryption.KeySize), 0, -1); !errors.Is(err, encryption.ErrWrongKey) {
+		t.Fatalf("read with a wrong customer key: %v", err)
+	}
+	if _, err := readRange(t, n, "/a", customerKey[:16], 0, -1); !errors.Is(err, ErrInvalidCustomerKey) {
+		t.Fatalf("read with a short customer key: %v", err)
+	}
+	if got, err := readRange(t, n, "/a", customerKey, 0, -1); err != nil || string(got) != "data" {
+		t.Fatalf("read %q with the customer key: %v", got, err)
+	}
+
+	// without master keys only customer keys encrypt.
+	err = n.PutObject(context.Background(), &PutObjectInput{
+		Key:                  "/b",
+		Body:                 bytes.NewReader(nil),
+		ServerSideEncryption: true,
+	})
+	if !errors.Is(err, ErrEncryptionDisabled) {
+		t.Fatalf("encrypted without a master key: %v", err)
+	}
+}
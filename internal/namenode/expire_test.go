@@ -0,0 +1,69 @@
+package namenode
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pkg/errors"
+)
+
+func putExpiring(t *testing.T, n NameNode, key string, expires time.Time) {
+	t.Helper()
+	if err := n.PutObject(context.Background(), &PutObjectInput{
+		Key:     key,
+		Size:    len(key),
+		Body:    strings.NewReader(key),
+		Expires: expires,
+	}); err != nil {
+		t.Fatalf("put %s: %+v", key, err)
+	}
+}
+
+// mustExpire fails unless every way of reading key answers 404.
+func mustExpire(t *testing.T, n NameNode, key string) {
+	t.Helper()
+	ctx := context.Background()
+	if _, err := n.HeadObject(ctx, key); !errors.Is(err, fiber.ErrNotFound) {
+		t.Fatalf("head of expired %s returned %v", key, err)
+	}
+	if _, err := getObject(t, n, key); !errors.Is(err, fiber.ErrNotFound) {
+		t.Fatalf("get of expired %s returned %v", key, err)
+	}
+	if err := n.CopyObject(ctx, &CopyObjectInput{Source: key, Key: key + "-copy"}); !errors.Is(err, fiber.ErrNotFound) {
+		t.Fatalf("copy of expired %s returned %v", key, err)
+	}
+	if err := n.RenameObject(ctx, key, key+"-renamed"); !errors.Is(err, fiber.ErrNotFound) {
+		t.Fatalf("rename of expired %s returned %v", key, err)
+	}
+}
+
+func TestExpiredObject(t *testing.T) {
+	for _, mode := range modes {
+		t.Run(string(mode), func(t *testing.T) {
+			n, pool := newNameNode(t, mode, nil)
+			now := time.Now()
+			putExpiring(t, n, "/past", now.Add(-time.Minute))
+			putExpiring(t, n, "/future", now.Add(time.Hour))
+			putExpiring(t, n, "/soon", now.Add(200*time.Millisecond))
+
+			mustExpire(t, n, "/past")
+			mustGet(t, n, "/future", "/future")
+			if expires := head(t, n, "/future").Expires; !expires.Equal(now.Add(time.Hour)) {
+				t.Fatalf("expiry kept as %v", expires)
+			}
+
+			mustGet(t, n, "/soon", "/soon")
+			time.Sleep(200 * time.Millisecond)
+			mustExpire(t, n, "/soon")
+
+			// the expired object stays until the lifecycle deletes it, a put
+			// over it is read again.
+			equalKeys(t, checkTrie(t, n, pool), "/future", "/past", "/soon")
+			putObject(t, n, "/past", "again")
+			mustGet(t, n, "/past", "again")
+		})
+	}
+}
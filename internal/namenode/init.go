@@ -22,18 +22,19 @@ func (n *nameNodeImpl) findEntry(ctx context.Context, key string) (string, strin
 }
 
 func (n *nameNodeImpl) getRootId(ctx context.Context) (string, error) {
-	if n.rootId != "" {
-		return n.rootId, nil
+	if id := n.root(); id != "" {
+		return id, nil
 	}
 	locker := n.lockerPool.Get(n.rootKey)
-	if err := locker.Lock(ctx); err != nil {
+	token, err := locker.Lock(ctx)
+	if err != nil {
 		return "", err
 	}
 	defer locker.Unlock(ctx)
 
 	id, err := n.findRoot(ctx)
 	if err == nil {
-		n.rootId = id
+		n.setRoot(id)
 		return id, nil
 	}
 
@@ -42,12 +43,26 @@ func (n *nameNodeImpl) getRootId(ctx context.Context) (string, error) {
 		return "", err
 	}
 	root := metadata.New(n.rootKey)
+	root.Fence = token
 	if err := n.pool.PutMetadata(ctx, rootId, root); err != nil {
 		return "", err
 	}
 
-	n.rootId = rootId
-	return n.rootId, nil
+	n.setRoot(rootId)
+	return rootId, nil
+}
+
+func (n *nameNodeImpl) root() string {
+	n.rootMu.RLock()
+	defer n.rootMu.RUnlock()
+	return n.rootId
+}
+
+// setRoot records where the root node is kept, an empty id looks it up again.
+func (n *nameNodeImpl) setRoot(id string) {
+	n.rootMu.Lock()
+	defer n.rootMu.Unlock()
+	n.rootId = id
 }
 
 func (n *nameNodeImpl) findRoot(ctx context.Context) (string, error) {
@@ -65,3 +80,21 @@ func (n *nameNodeImpl) findRoot(ctx context.Context) (string, error) {
 
 	return "", fiber.ErrNotFound
 }
+
+func (n *nameNodeImpl) getMetadata(ctx context.Context, id, key string) (*metadata.Metadata, error) {
+	meta, err := n.pool.GetMetadata(ctx, id, key)
+	if err == fiber.ErrNotFound && key == n.rootKey {
+		n.setRoot("")
+	}
+	return meta, err
+}
+
+// readMetadata may answer from the metadata cache, so it is only used by
+// reads that never write what they got back.
+func (n *nameNodeImpl) readMetadata(ctx context.Context, id, key string) (*metadata.Metadata, error) {
+	meta, err := n.pool.GetCachedMetadata(ctx, id, key)
+	if err == fiber.ErrNotFound && key == n.rootKey {
+		n.setRoot("")
+	}
+	return meta, err
+}
@@ -2,41 +2,32 @@ package namenode
 
 import (
 	"context"
-	"io"
 
 	"github.com/qwp0905/go-object-storage/internal/metadata"
 )
 
-func (n *nameNodeImpl) put(
-	ctx context.Context,
-	key, id, current, contentType string,
-	size int,
-	r io.Reader,
-) error {
+// attachFunc sets the object a node holds for the key being put. it runs
+// while the node is locked.
+type attachFunc func(ctx context.Context, meta *metadata.Metadata) error
+
+func (n *nameNodeImpl) put(ctx context.Context, key, id, current string, attach attachFunc) error {
 	locker := n.lockerPool.Get(current)
-	if err := locker.Lock(ctx); err != nil {
+	token, err := locker.Lock(ctx)
+	if err != nil {
 		return err
 	}
 
-	currentMeta, err := n.pool.GetMetadata(ctx, id, current)
+	currentMeta, err := n.getMetadata(ctx, id, current)
 	if err != nil {
 		defer locker.Unlock(ctx)
 		return err
 	}
+	currentMeta.Fence = token
 
 	if key == currentMeta.Key {
 		defer locker.Unlock(ctx)
 
-		currentMeta.UpdateAttr(size, contentType)
-		if !currentMeta.FileExists() {
-			nodeId, err := n.pool.AcquireNode(ctx)
-			if err != nil {
-				return err
-			}
-			currentMeta.SetNew(nodeId)
-		}
-
-		if err := n.pool.PutDirect(ctx, currentMeta, r); err != nil {
+		if err := attach(ctx, currentMeta); err != nil {
 			return err
 		}
 
@@ -46,15 +37,9 @@ func (n *nameNodeImpl) put(
 	index, matched := currentMeta.FindMatched(key)
 	if index == -1 {
 		defer locker.Unlock(ctx)
-		dataId, err := n.pool.AcquireNode(ctx)
-		if err != nil {
-			return err
-		}
-
 		newMeta := metadata.New(key)
-		newMeta.SetNew(dataId)
-		newMeta.UpdateAttr(size, contentType)
-		if err := n.pool.PutDirect(ctx, newMeta, r); err != nil {
+		newMeta.Fence = token
+		if err := attach(ctx, newMeta); err != nil {
 			return err
 		}
 
@@ -75,27 +60,87 @@ func (n *nameNodeImpl) put(
 		if err := locker.Unlock(ctx); err != nil {
 			return err
 		}
-		return n.put(ctx, key, next.NodeId, next.Key, contentType, size, r)
+		return n.put(ctx, key, next.NodeId, next.Key, attach)
 	}
 
-	nodeId, err := n.pool.AcquireNode(ctx)
+	nodeId, err := n.split(ctx, id, currentMeta, index, matched)
 	if err != nil {
+		defer locker.Unlock(ctx)
 		return err
 	}
 
-	newMeta := &metadata.Metadata{Key: matched, NextNodes: []*metadata.NextRoute{next}}
-	if err := n.pool.PutMetadata(ctx, nodeId, newMeta); err != nil {
+	if err := locker.Unlock(ctx); err != nil {
 		return err
 	}
 
+	return n.put(ctx, key, nodeId, matched, attach)
+}
+
+func (n *nameNodeImpl) split(
+	ctx context.Context,
+	id string,
+	currentMeta *metadata.Metadata,
+	index int,
+	matched string,
+) (string, error) {
+	nodeId, err := n.pool.AcquireNode(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	newMeta := &metadata.Metadata{
+		Key:       matched,
+		NextNodes: []*metadata.NextRoute{currentMeta.GetNext(index)},
+		Fence:     currentMeta.Fence,
+	}
+	if err := n.pool.PutMetadata(ctx, nodeId, newMeta); err != nil {
+		return "", err
+	}
+
 	currentMeta.NextNodes[index] = &metadata.NextRoute{NodeId: nodeId, Key: matched}
 	if err := n.pool.PutMetadata(ctx, id, currentMeta); err != nil {
+		return "", err
+	}
+
+	return nodeId, nil
+}
+
+// rewrite changes the node of key while it is locked. unlike put it never
+// creates the node, nothing is written when key has none or fn declines.
+func (n *nameNodeImpl) rewrite(
+	ctx context.Context,
+	key, id, current string,
+	fn func(meta *metadata.Metadata) bool,
+) error {
+	locker := n.lockerPool.Get(current)
+	token, err := locker.Lock(ctx)
+	if err != nil {
 		return err
 	}
 
+	currentMeta, err := n.getMetadata(ctx, id, current)
+	if err != nil {
+		defer locker.Unlock(ctx)
+		return err
+	}
+
+	if key == currentMeta.Key {
+		defer locker.Unlock(ctx)
+		if !fn(currentMeta) {
+			return nil
+		}
+		currentMeta.Fence = token
+		return n.pool.PutMetadata(ctx, id, currentMeta)
+	}
+
+	index := currentMeta.FindPrefix(key)
 	if err := locker.Unlock(ctx); err != nil {
 		return err
 	}
+	if index == -1 {
+		return nil
+	}
 
-	return n.put(ctx, key, nodeId, matched, contentType, size, r)
+	next := currentMeta.GetNext(index)
+	return n.rewrite(ctx, key, next.NodeId, next.Key, fn)
 }
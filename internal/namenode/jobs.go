@@ -0,0 +1,328 @@
+package namenode
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/qwp0905/go-object-storage/internal/kv"
+	"github.com/qwp0905/go-object-storage/pkg/logger"
+	"github.com/qwp0905/go-object-storage/pkg/nocopy"
+)
+
+type JobKind string
+
+const (
+	JobDeletePrefix JobKind = "delete-prefix"
+	JobMovePrefix   JobKind = "move-prefix"
+	JobRotateKeys   JobKind = "rotate-keys"
+)
+
+type JobState string
+
+const (
+	JobRunning   JobState = "running"
+	JobDone      JobState = "done"
+	JobFailed    JobState = "failed"
+	JobCancelled JobState = "cancelled"
+)
+
+// running jobs are written to the store this often, one not written for
+// jobStaleAfter lost the namenode running it.
+const (
+	jobSyncInterval = time.Second
+	jobStaleAfter   = jobSyncInterval * 10
+	// jobs are kept for their status this long after their last write.
+	jobRetention = time.Hour * 24
+)
+
+func JobKey(id string) string {
+	return fmt.Sprintf("JOB:%s", id)
+}
+
+// a cancel asked for on any namenode is seen by the one running the job.
+func jobCancelKey(id string) string {
+	return fmt.Sprintf("JOB_CANCEL:%s", id)
+}
+
+var ErrInvalidJob = fiber.NewError(fiber.StatusBadRequest, "unknown job kind")
+
+// Progress is updated while a prefix operation runs.
+type Progress struct {
+	Objects atomic.Int64
+	Bytes   atomic.Int64
+}
+
+type JobRequest struct {
+	Kind   JobKind `json:"kind"`
+	Prefix string  `json:"prefix"`
+	Target string  `json:"target,omitempty"`
+}
+
+type JobStatus struct {
+	Id         string     `json:"id"`
+	Kind       JobKind    `json:"kind"`
+	Prefix     string     `json:"prefix"`
+	Target     string     `json:"target,omitempty"`
+	State      JobState   `json:"state"`
+	Objects    int64      `json:"objects"`
+	Bytes      int64      `json:"bytes"`
+	Error      string     `json:"error,omitempty"`
+	StartedAt  time.Time  `json:"started_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// stale marks a running job failed when its namenode stopped writing it.
+func (s *JobStatus) stale() *JobStatus {
+	if s.State == JobRunning && time.Since(s.UpdatedAt) > jobStaleAfter {
+		s.State = JobFailed
+		s.Error = "namenode running the job stopped"
+	}
+	return s
+}
+
+// Jobs runs prefix operations in the background. their status is kept in the
+// cluster store, so any namenode answers for jobs of the others.
+type Jobs interface {
+	Start(ctx context.Context, req *JobRequest) (*JobStatus, error)
+	Get(ctx context.Context, id string) (*JobStatus, error)
+	List(ctx context.Context) ([]*JobStatus, error)
+	Cancel(ctx context.Context, id string) (*JobStatus, error)
+}
+
+type job struct {
+	mu       *sync.Mutex
+	status   JobStatus
+	progress *Progress
+	cancel   context.CancelFunc
+}
+
+func (j *job) snapshot() *JobStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	out := j.status
+	out.Objects = j.progress.Objects.Load()
+	out.Bytes = j.progress.Bytes.Load()
+	return &out
+}
+
+func (j *job) finish(err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	now := time.Now()
+	j.status.FinishedAt = &now
+	switch {
+	case j.status.State == JobCancelled:
+	case err != nil:
+		j.status.State = JobFailed
+		j.status.Error = err.Error()
+	default:
+		j.status.State = JobDone
+	}
+}
+
+func (j *job) stop() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.status.State == JobRunning {
+		j.status.State = JobCancelled
+		j.cancel()
+	}
+}
+
+type jobsImpl struct {
+	noCopy  nocopy.NoCopy
+	svc     NameNode
+	store   kv.Store
+	mu      *sync.Mutex
+	running map[string]*job
+}
+
+func NewJobs(svc NameNode, store kv.Store) Jobs {
+	return &jobsImpl{
+		svc:     svc,
+		store:   store,
+		mu:      new(sync.Mutex),
+		running: make(map[string]*job),
+	}
+}
+
+func (s *jobsImpl) Start(ctx context.Context, req *JobRequest) (*JobStatus, error) {
+	var run func(ctx context.Context, progress *Progress) error
+	switch req.Kind {
+	case JobDeletePrefix:
+		run = func(ctx context.Context, progress *Progress) error {
+			return s.svc.DeletePrefix(ctx, req.Prefix, progress)
+		}
+	case JobMovePrefix:
+		run = func(ctx context.Context, progress *Progress) error {
+			return s.svc.MovePrefix(ctx, req.Prefix, req.Target, progress)
+		}
+	case JobRotateKeys:
+		run = func(ctx context.Context, progress *Progress) error {
+			return s.svc.RotateKeys(ctx, req.Prefix, progress)
+		}
+	default:
+		return nil, ErrInvalidJob
+	}
+
+	jobCtx, cancel := context.WithCancel(context.Background())
+	j := &job{
+		mu:       new(sync.Mutex),
+		progress: new(Progress),
+		cancel:   cancel,
+		status: JobStatus{
+			Id:        uuid.Must(uuid.NewRandom()).String(),
+			Kind:      req.Kind,
+			Prefix:    req.Prefix,
+			Target:    req.Target,
+			State:     JobRunning,
+			StartedAt: time.Now(),
+		},
+	}
+	status := j.snapshot()
+	if err := s.save(ctx, status); err != nil {
+		cancel()
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.running[status.Id] = j
+	s.mu.Unlock()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- run(jobCtx, j.progress)
+	}()
+	go s.watch(j, done)
+
+	return status, nil
+}
+
+// watch writes the progress of j until it is done and stops it once a cancel
+// is asked for on another namenode.
+func (s *jobsImpl) watch(j *job, done chan error) {
+	ctx := context.Background()
+	id := j.status.Id
+	ticker := time.NewTicker(jobSyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case err := <-done:
+			if err != nil {
+				logger.Warnf("job %s: %+v", id, err)
+			}
+			j.finish(err)
+			if err := s.save(ctx, j.snapshot()); err != nil {
+				logger.Warnf("%+v", err)
+			}
+			s.mu.Lock()
+			delete(s.running, id)
+			s.mu.Unlock()
+			s.store.Del(ctx, jobCancelKey(id))
+			return
+
+		case <-ticker.C:
+			if _, err := s.store.Get(ctx, jobCancelKey(id)); err == nil {
+				j.stop()
+			}
+			if err := s.save(ctx, j.snapshot()); err != nil {
+				logger.Warnf("%+v", err)
+			}
+		}
+	}
+}
+
+func (s *jobsImpl) save(ctx context.Context, status *JobStatus) error {
+	status.UpdatedAt = time.Now()
+	b, err := json.Marshal(status)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return s.store.Set(ctx, JobKey(status.Id), string(b), jobRetention)
+}
+
+// local is the status of a job running on this namenode, which is ahead of
+// the store.
+func (s *jobsImpl) local(id string) (*job, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, ok := s.running[id]
+	return j, ok
+}
+
+func (s *jobsImpl) Get(ctx context.Context, id string) (*JobStatus, error) {
+	if j, ok := s.local(id); ok {
+		return j.snapshot(), nil
+	}
+
+	value, err := s.store.Get(ctx, JobKey(id))
+	if err != nil {
+		return nil, err
+	}
+	status := new(JobStatus)
+	if err := json.Unmarshal([]byte(value), status); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return status.stale(), nil
+}
+
+func (s *jobsImpl) List(ctx context.Context) ([]*JobStatus, error) {
+	keys, err := s.store.Keys(ctx, JobKey(""))
+	if err != nil {
+		return nil, err
+	}
+	out := make([]*JobStatus, 0, len(keys))
+	if len(keys) == 0 {
+		return out, nil
+	}
+
+	values, err := s.store.MGet(ctx, keys...)
+	if err != nil {
+		return nil, err
+	}
+	for _, value := range values {
+		if value == "" {
+			continue
+		}
+		status := new(JobStatus)
+		if err := json.Unmarshal([]byte(value), status); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if j, ok := s.local(status.Id); ok {
+			status = j.snapshot()
+		}
+		out = append(out, status.stale())
+	}
+
+	sort.Slice(out, func(i, k int) bool {
+		return out[i].StartedAt.Before(out[k].StartedAt)
+	})
+	return out, nil
+}
+
+func (s *jobsImpl) Cancel(ctx context.Context, id string) (*JobStatus, error) {
+	if j, ok := s.local(id); ok {
+		j.stop()
+		return j.snapshot(), nil
+	}
+
+	status, err := s.Get(ctx, id)
+	if err != nil || status.State != JobRunning {
+		return status, err
+	}
+	if err := s.store.Set(ctx, jobCancelKey(id), "1", jobRetention); err != nil {
+		return nil, err
+	}
+	status.State = JobCancelled
+	return status, nil
+}
@@ -0,0 +1,166 @@
+package namenode
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+var listFixture = []string{
+	"/a", "/a-", "/a/1", "/a/2", "/a/b/1", "/a/b/2", "/a/c",
+	"/ab/1", "/b", "/b/c/d", "/c/1/2/3", "/c/2",
+}
+
+// expectList lists keys the way ListObject should, in a single page.
+func expectList(keys []string, prefix, delimiter, after string) []string {
+	seen := map[string]bool{}
+	out := make([]string, 0)
+	for _, key := range keys {
+		if !strings.HasPrefix(key, prefix) || key <= after {
+			continue
+		}
+		entry := key
+		if delimiter != "" {
+			if i := strings.Index(key[len(prefix):], delimiter); i >= 0 {
+				entry = key[:len(prefix)+i+len(delimiter)]
+			}
+		}
+		if !seen[entry] {
+			seen[entry] = true
+			out = append(out, entry)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// listPages pages through a listing maxKeys entries at a time, common
+// prefixes and keys are returned in order.
+func listPages(t *testing.T, n NameNode, input ListObjectInput) []string {
+	t.Helper()
+	out := make([]string, 0)
+	for pages := 0; ; pages++ {
+		if pages > 100 {
+			t.Fatal("listing does not end")
+		}
+		result, err := n.ListObject(context.Background(), &input)
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		page := make([]string, 0)
+		page = append(page, result.Prefixes...)
+		for _, object := range result.List {
+			page = append(page, object.Key)
+		}
+		sort.Strings(page)
+		if result.KeyCount != len(page) || len(page) > input.MaxKeys {
+			t.Fatalf("page of %d entries counts %d, max %d", len(page), result.KeyCount, input.MaxKeys)
+		}
+		out = append(out, page...)
+		if !result.IsTruncated {
+			return out
+		}
+		if len(page) != input.MaxKeys {
+			t.Fatalf("truncated page holds %d entries, want %d", len(page), input.MaxKeys)
+		}
+		input.ContinuationToken = result.NextContinuationToken
+	}
+}
+
+func TestListObject(t *testing.T) {
+	for _, mode := range modes {
+		t.Run(string(mode), func(t *testing.T) {
+			n, _ := newNameNode(t, mode, nil)
+			for _, key := range listFixture {
+				putObject(t, n, key, key)
+			}
+
+			for _, prefix := range []string{"/", "/a", "/a/", "/a/b/", "/b/c", "/c/", "/d"} {
+				for _, delimiter := range []string{"", "/"} {
+					for _, after := range []string{"", "/a", "/a/", "/a/b", "/a/b/1", "/ab", "/c/1/2/3"} {
+						for _, maxKeys := range []int{1, 2, 3, MaxListKeys} {
+							name := fmt.Sprintf("prefix %s delimiter %q after %q max %d", prefix, delimiter, after, maxKeys)
+							got := listPages(t, n, ListObjectInput{
+								Prefix:     prefix,
+								Delimiter:  delimiter,
+								StartAfter: after,
+								MaxKeys:    maxKeys,
+							})
+							want := expectList(listFixture, prefix, delimiter, after)
+							if strings.Join(got, ",") != strings.Join(want, ",") {
+								t.Fatalf("%s listed %v, want %v", name, got, want)
+							}
+						}
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestListObjectLimits(t *testing.T) {
+	n, _ := newNameNode(t, ConcurrencyLock, nil)
+	for i := 0; i < MaxListKeys+5; i++ {
+		putObject(t, n, fmt.Sprintf("/k/%04d", i), "")
+	}
+	ctx := context.Background()
+
+	result, err := n.ListObject(ctx, &ListObjectInput{Prefix: "/k/", MaxKeys: MaxListKeys * 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.List) != MaxListKeys || !result.IsTruncated {
+		t.Fatalf("listed %d keys truncated %t, want %d", len(result.List), result.IsTruncated, MaxListKeys)
+	}
+	if token, _ := decodeToken(result.NextContinuationToken); token != fmt.Sprintf("/k/%04d", MaxListKeys-1) {
+		t.Fatalf("next page starts after %s", token)
+	}
+	result, err = n.ListObject(ctx, &ListObjectInput{
+		Prefix:            "/k/",
+		MaxKeys:           MaxListKeys,
+		ContinuationToken: result.NextContinuationToken,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.List) != 5 || result.IsTruncated {
+		t.Fatalf("last page lists %d keys truncated %t", len(result.List), result.IsTruncated)
+	}
+
+	result, err = n.ListObject(ctx, &ListObjectInput{Prefix: "/k/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.KeyCount != 0 || len(result.List) != 0 || len(result.Prefixes) != 0 || result.IsTruncated {
+		t.Fatalf("max keys 0 listed %d entries truncated %t", result.KeyCount, result.IsTruncated)
+	}
+
+	if _, err := n.ListObject(ctx, &ListObjectInput{Prefix: "/k/", MaxKeys: -1}); !errors.Is(err, ErrInvalidMaxKeys) {
+		t.Fatalf("negative max keys: %v", err)
+	}
+}
+
+func TestListObjectInvalidToken(t *testing.T) {
+	n, _ := newNameNode(t, ConcurrencyLock, nil)
+	putObject(t, n, "/a/1", "")
+	putObject(t, n, "/b/1", "")
+
+	for _, token := range []string{encodeToken("/b/1"), encodeToken("/"), "not base64!"} {
+		_, err := n.ListObject(context.Background(), &ListObjectInput{
+			Prefix:            "/a/",
+			MaxKeys:           10,
+			ContinuationToken: token,
+		})
+		if !errors.Is(err, ErrInvalidToken) {
+			t.Fatalf("token %q: %v", token, err)
+		}
+	}
+
+	if token, err := decodeToken(encodeToken("/a/1")); err != nil || token != "/a/1" {
+		t.Fatalf("token decodes to %q: %v", token, err)
+	}
+}
@@ -2,50 +2,119 @@ package namenode
 
 import (
 	"context"
+	"encoding/base64"
 	"io"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/pkg/errors"
+	"github.com/qwp0905/go-object-storage/internal/compression"
+	"github.com/qwp0905/go-object-storage/internal/encryption"
 	"github.com/qwp0905/go-object-storage/internal/locker"
 	"github.com/qwp0905/go-object-storage/internal/metadata"
 	"github.com/qwp0905/go-object-storage/internal/nodepool"
-	"github.com/redis/go-redis/v9"
 )
 
 type NameNode interface {
 	HeadObject(ctx context.Context, key string) (*metadata.Metadata, error)
 	GetObject(ctx context.Context, key string) (*metadata.Metadata, io.Reader, error)
-	ListObject(ctx context.Context, prefix, delimiter, after string, limit int) (*ListObjectResult, error)
-	PutObject(ctx context.Context, key, contentType string, size int, r io.Reader) error
+	ReadObject(ctx context.Context, input *ReadObjectInput) (io.Reader, error)
+	ListObject(ctx context.Context, input *ListObjectInput) (*ListObjectResult, error)
+	PutObject(ctx context.Context, input *PutObjectInput) error
 	DeleteObject(ctx context.Context, key string) error
+	DeleteObjects(ctx context.Context, keys []string) (*DeleteObjectsResult, error)
+	ExpireObjects(ctx context.Context, objects []*metadata.Metadata) (*DeleteObjectsResult, error)
+	CopyObject(ctx context.Context, input *CopyObjectInput) error
+	RenameObject(ctx context.Context, source, key string) error
+	DeletePrefix(ctx context.Context, prefix string, progress *Progress) error
+	MovePrefix(ctx context.Context, prefix, target string, progress *Progress) error
+	RotateKeys(ctx context.Context, prefix string, progress *Progress) error
+}
+
+type Concurrency string
+
+const (
+	ConcurrencyLock       Concurrency = "lock"
+	ConcurrencyOptimistic Concurrency = "optimistic"
+)
+
+type Config struct {
+	Concurrency Concurrency
+	// master keys for server side encryption, disabled when nil.
+	Keys encryption.KeyManager
+	// encrypt objects even when the request does not ask for it.
+	EncryptByDefault bool
+	// objects are stored uncompressed when nil.
+	Compression *compression.Policy
+	// objects with the same data share it, unless encrypted.
+	Dedup bool
+	// objects with more stored data than the threshold are split into chunks
+	// of ChunkSize kept on different nodes, disabled when zero.
+	ChunkThreshold uint
+	ChunkSize      uint
 }
 
 type nameNodeImpl struct {
-	pool       nodepool.NodePool
-	lockerPool locker.LockerPool
-	rootKey    string
-	rootId     string
+	pool             nodepool.NodePool
+	lockerPool       locker.LockerPool
+	concurrency      Concurrency
+	keys             encryption.KeyManager
+	encryptByDefault bool
+	compression      *compression.Policy
+	dedup            bool
+	chunkThreshold   uint
+	chunkSize        uint
+	rootKey          string
+	rootId           string
+	rootMu           *sync.RWMutex
+}
+
+func New(
+	pool nodepool.NodePool,
+	lockerPool locker.LockerPool,
+	config *Config,
+) NameNode {
+	return &nameNodeImpl{
+		pool:             pool,
+		lockerPool:       lockerPool,
+		concurrency:      config.Concurrency,
+		keys:             config.Keys,
+		encryptByDefault: config.EncryptByDefault,
+		compression:      config.Compression,
+		dedup:            config.Dedup,
+		chunkThreshold:   config.ChunkThreshold,
+		chunkSize:        config.ChunkSize,
+		rootKey:          "/",
+		rootMu:           new(sync.RWMutex),
+	}
 }
 
-func New(pool nodepool.NodePool, rc *redis.Client) (*nameNodeImpl, error) {
-	lp, err := locker.NewPool(rc, time.Second*30)
+func (n *nameNodeImpl) HeadObject(ctx context.Context, key string) (*metadata.Metadata, error) {
+	return n.headLive(ctx, key, n.readMetadata)
+}
+
+// headLive is head for objects that can still be read, expired objects are
+// not found before the lifecycle deletes them.
+func (n *nameNodeImpl) headLive(ctx context.Context, key string, read readFunc) (*metadata.Metadata, error) {
+	metadata, err := n.head(ctx, key, read)
 	if err != nil {
 		return nil, err
 	}
-	return &nameNodeImpl{
-		pool:       pool,
-		lockerPool: lp,
-		rootKey:    "/",
-	}, nil
+	if metadata.Expired(time.Now()) {
+		return nil, fiber.ErrNotFound
+	}
+	return metadata, nil
 }
 
-func (n *nameNodeImpl) HeadObject(ctx context.Context, key string) (*metadata.Metadata, error) {
+func (n *nameNodeImpl) head(ctx context.Context, key string, read readFunc) (*metadata.Metadata, error) {
 	id, start, err := n.findEntry(ctx, key)
 	if err != nil {
 		return nil, err
 	}
 
-	metadata, err := n.get(ctx, key, id, start)
+	metadata, err := n.get(ctx, key, id, start, read)
 	if err != nil {
 		return nil, err
 	}
@@ -59,7 +128,7 @@ func (n *nameNodeImpl) GetObject(ctx context.Context, key string) (*metadata.Met
 		return nil, nil, err
 	}
 
-	r, err := n.pool.GetDirect(ctx, metadata)
+	r, err := n.ReadObject(ctx, &ReadObjectInput{Metadata: metadata, Length: -1})
 	if err != nil {
 		return nil, nil, err
 	}
@@ -67,9 +136,29 @@ func (n *nameNodeImpl) GetObject(ctx context.Context, key string) (*metadata.Met
 	return metadata, r, nil
 }
 
+// MaxListKeys is the most entries a single listing returns.
+const MaxListKeys = 1000
+
+var (
+	ErrInvalidToken   = fiber.NewError(fiber.StatusBadRequest, "invalid continuation token")
+	ErrInvalidMaxKeys = fiber.NewError(fiber.StatusBadRequest, "max-keys must not be negative")
+)
+
+type ListObjectInput struct {
+	Prefix    string
+	Delimiter string
+	// ignored when a continuation token is given.
+	StartAfter        string
+	ContinuationToken string
+	MaxKeys           int
+}
+
 type ListObjectResult struct {
-	Prefixes []string     `json:"prefixes,omitempty"`
-	List     []ObjectList `json:"list,omitempty"`
+	Prefixes              []string     `json:"prefixes,omitempty"`
+	List                  []ObjectList `json:"list,omitempty"`
+	KeyCount              int          `json:"key_count"`
+	IsTruncated           bool         `json:"is_truncated"`
+	NextContinuationToken string       `json:"next_continuation_token,omitempty"`
 }
 
 type ObjectList struct {
@@ -77,47 +166,175 @@ type ObjectList struct {
 	Size         uint      `json:"size"`
 	LastModified time.Time `json:"last_modified"`
 	ContentType  string    `json:"content-type"`
+	StorageClass string    `json:"storage_class"`
 }
 
-func (n *nameNodeImpl) ListObject(
-	ctx context.Context,
-	prefix, delimiter, after string,
-	limit int,
-) (*ListObjectResult, error) {
-	id, start, err := n.findEntry(ctx, prefix)
-	if err != nil {
-		return nil, err
+func (n *nameNodeImpl) ListObject(ctx context.Context, input *ListObjectInput) (*ListObjectResult, error) {
+	if input.MaxKeys < 0 {
+		return nil, ErrInvalidMaxKeys
+	}
+	maxKeys := input.MaxKeys
+	if maxKeys > MaxListKeys {
+		maxKeys = MaxListKeys
 	}
 
-	p, l, err := n.scan(ctx, prefix, delimiter, after, limit, id, start)
-	if err != nil {
-		return nil, err
+	l := &listing{
+		prefix:    input.Prefix,
+		delimiter: input.Delimiter,
+		after:     input.StartAfter,
+		limit:     maxKeys + 1,
+		entries:   make([]*listEntry, 0),
+	}
+	if input.ContinuationToken != "" {
+		marker, err := decodeToken(input.ContinuationToken)
+		if err != nil || !strings.HasPrefix(marker, input.Prefix) {
+			return nil, ErrInvalidToken
+		}
+		l.after, l.marker = marker, marker
 	}
 
-	list := make([]ObjectList, len(l))
-	for i, v := range l {
-		list[i] = ObjectList{
-			Size:         v.Size,
-			LastModified: v.LastModified,
-			Key:          v.Key,
-			ContentType:  v.Type,
+	if l.limit > 1 {
+		id, start, err := n.findEntry(ctx, input.Prefix)
+		if err != nil {
+			return nil, err
+		}
+		if err := n.scan(ctx, l, id, start); err != nil {
+			return nil, err
 		}
 	}
 
-	return &ListObjectResult{Prefixes: p.Values(), List: list}, nil
+	out := &ListObjectResult{Prefixes: make([]string, 0), List: make([]ObjectList, 0)}
+	if len(l.entries) == l.limit {
+		l.entries = l.entries[:l.limit-1]
+		out.IsTruncated = true
+		out.NextContinuationToken = encodeToken(l.entries[len(l.entries)-1].key)
+	}
+	for _, e := range l.entries {
+		if e.metadata == nil {
+			out.Prefixes = append(out.Prefixes, e.key)
+			continue
+		}
+		out.List = append(out.List, ObjectList{
+			Size:         e.metadata.Size,
+			LastModified: e.metadata.LastModified,
+			Key:          e.metadata.Key,
+			ContentType:  e.metadata.Type,
+			StorageClass: e.metadata.Class(),
+		})
+	}
+	out.KeyCount = len(l.entries)
+
+	return out, nil
 }
 
-func (n *nameNodeImpl) PutObject(ctx context.Context, key, contentType string, size int, r io.Reader) error {
-	id, start, err := n.findEntry(ctx, key)
+// continuation tokens are opaque to clients, they only carry the last entry
+// of the previous page.
+func encodeToken(key string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(key))
+}
+
+func decodeToken(token string) (string, error) {
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	return string(b), nil
+}
+
+type PutObjectInput struct {
+	Key         string
+	ContentType string
+	Size        int
+	Body        io.Reader
+	// zero when the object never expires.
+	Expires time.Time
+	// standard when empty.
+	StorageClass string
+	// the data is encrypted with a key wrapped by the master key or by the
+	// customer key when one is given.
+	ServerSideEncryption bool
+	CustomerKey          []byte
+
+	// write the data to a new source even when the key is overwritten in
+	// place, for copies that still read the old one.
+	fresh bool
+}
+
+var ErrInvalidStorageClass = fiber.NewError(fiber.StatusBadRequest, "storage class must be STANDARD or COLD")
+
+func (input *PutObjectInput) class() (string, error) {
+	if input.StorageClass == "" {
+		return metadata.StorageClassStandard, nil
+	}
+	if !metadata.ValidStorageClass(input.StorageClass) {
+		return "", ErrInvalidStorageClass
+	}
+	return input.StorageClass, nil
+}
+
+// apply sets the attributes of the object being put, its data is written
+// separately.
+func (input *PutObjectInput) apply(meta *metadata.Metadata, class string) {
+	meta.UpdateAttr(input.Size, input.ContentType)
+	meta.Expires = input.Expires
+	meta.StorageClass = class
+}
+
+func (n *nameNodeImpl) PutObject(ctx context.Context, input *PutObjectInput) error {
+	class, err := input.class()
 	if err != nil {
 		return err
 	}
+	body, encoding, err := n.compress(input)
+	if err != nil {
+		return err
+	}
+	if body, err = n.digest(input, body, encoding); err != nil {
+		return err
+	}
+	if body, err = n.seal(ctx, input, body, encoding); err != nil {
+		return err
+	}
+	if n.concurrency == ConcurrencyOptimistic {
+		return n.putOptimistic(ctx, input, body, class, encoding)
+	}
 
-	return n.put(ctx, key, id, start, contentType, size, r)
+	id, start, err := n.findEntry(ctx, input.Key)
+	if err != nil {
+		return err
+	}
+
+	// objects are overwritten in place unless they move to another tier, the
+	// data is shared or either is chunked.
+	var moved *metadata.Metadata
+	if err := n.put(ctx, input.Key, id, start, func(ctx context.Context, meta *metadata.Metadata) error {
+		if meta.FileExists() &&
+			(meta.Class() != class || input.fresh || meta.Shared() || encoding.source != "" ||
+				meta.Chunked() || n.chunks(encoding)) {
+			prev := *meta
+			moved = &prev
+			meta.Source = ""
+		}
+		input.apply(meta, class)
+		encoding.apply(meta)
+		if !meta.FileExists() {
+			return n.store(ctx, meta, class, body, encoding)
+		}
+
+		return n.pool.PutDirect(ctx, meta, body)
+	}); err != nil || moved == nil {
+		return err
+	}
+
+	return n.pool.DeleteDirect(ctx, moved)
 }
 
 func (n *nameNodeImpl) DeleteObject(ctx context.Context, key string) error {
-	metadata, err := n.HeadObject(ctx, key)
+	if n.concurrency == ConcurrencyOptimistic {
+		return n.deleteOptimistic(ctx, key)
+	}
+
+	metadata, err := n.head(ctx, key, n.getMetadata)
 	if err != nil {
 		if err == fiber.ErrNotFound {
 			return nil
@@ -130,7 +347,7 @@ func (n *nameNodeImpl) DeleteObject(ctx context.Context, key string) error {
 		return err
 	}
 
-	if _, err := n.delete(ctx, key, id, n.rootKey); err != nil {
+	if _, err := n.delete(ctx, key, nil, id, n.rootKey); err != nil {
 		return err
 	}
 
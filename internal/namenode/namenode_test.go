@@ -0,0 +1,157 @@
+package namenode
+
+import (
+	"context"
+	"io"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/qwp0905/go-object-storage/internal/kv"
+	"github.com/qwp0905/go-object-storage/internal/locker"
+	"github.com/qwp0905/go-object-storage/internal/metadata"
+	"github.com/qwp0905/go-object-storage/internal/nodepool/pooltest"
+)
+
+var modes = []Concurrency{ConcurrencyLock, ConcurrencyOptimistic}
+
+// newNameNode runs a namenode on three in-memory datanodes, config may be nil.
+func newNameNode(t *testing.T, concurrency Concurrency, config *Config) (*nameNodeImpl, *pooltest.Pool) {
+	t.Helper()
+	if config == nil {
+		config = new(Config)
+	}
+	config.Concurrency = concurrency
+	pool := pooltest.New("node-0", "node-1", "node-2")
+	lockerPool := locker.NewStorePool(kv.NewLocal(), time.Second*30)
+	return New(pool, lockerPool, config).(*nameNodeImpl), pool
+}
+
+func putObject(t *testing.T, n NameNode, key, body string) {
+	t.Helper()
+	if err := n.PutObject(context.Background(), &PutObjectInput{
+		Key:  key,
+		Size: len(body),
+		Body: strings.NewReader(body),
+	}); err != nil {
+		t.Fatalf("put %s: %+v", key, err)
+	}
+}
+
+func getObject(t *testing.T, n NameNode, key string) (string, error) {
+	t.Helper()
+	_, r, err := n.GetObject(context.Background(), key)
+	if err != nil {
+		return "", err
+	}
+	b, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b), nil
+}
+
+func mustGet(t *testing.T, n NameNode, key, want string) {
+	t.Helper()
+	got, err := getObject(t, n, key)
+	if err != nil {
+		t.Fatalf("get %s: %+v", key, err)
+	}
+	if got != want {
+		t.Fatalf("%s holds %q, want %q", key, got, want)
+	}
+}
+
+// listKeys pages through every key under prefix.
+func listKeys(t *testing.T, n NameNode, prefix string) []string {
+	t.Helper()
+	out := make([]string, 0)
+	input := &ListObjectInput{Prefix: prefix, MaxKeys: MaxListKeys}
+	for {
+		result, err := n.ListObject(context.Background(), input)
+		if err != nil {
+			t.Fatalf("list %s: %+v", prefix, err)
+		}
+		for _, object := range result.List {
+			out = append(out, object.Key)
+		}
+		if !result.IsTruncated {
+			return out
+		}
+		input.ContinuationToken = result.NextContinuationToken
+	}
+}
+
+func equalKeys(t *testing.T, got []string, want ...string) {
+	t.Helper()
+	sort.Strings(want)
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("keys are %v, want %v", got, want)
+	}
+}
+
+// checkTrie walks the trie and fails on routes to missing nodes, keys out of
+// order and metadata no route reaches. it returns the keys of the objects.
+func checkTrie(t *testing.T, n *nameNodeImpl, pool *pooltest.Pool) []string {
+	t.Helper()
+	ctx := context.Background()
+	rootId, err := n.getRootId(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	objects := make([]string, 0)
+	nodes := 0
+	stack := []*metadata.NextRoute{{NodeId: rootId, Key: n.rootKey}}
+	for len(stack) > 0 {
+		route := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		meta, err := pool.GetMetadata(ctx, route.NodeId, route.Key)
+		if err != nil {
+			t.Fatalf("route to %s on %s: %+v", route.Key, route.NodeId, err)
+		}
+		nodes++
+		if meta.FileExists() {
+			objects = append(objects, meta.Key)
+		}
+		for i, next := range meta.NextNodes {
+			if !strings.HasPrefix(next.Key, meta.Key) || len(next.Key) <= len(meta.Key) {
+				t.Fatalf("route %s is not under %s", next.Key, meta.Key)
+			}
+			if i > 0 && meta.NextNodes[i-1].Key >= next.Key {
+				t.Fatalf("routes of %s are out of order", meta.Key)
+			}
+		}
+		stack = append(stack, meta.NextNodes...)
+	}
+
+	if _, files := pool.Files(); files != nodes {
+		t.Fatalf("%d metadata files kept, %d reachable", files, nodes)
+	}
+	sort.Strings(objects)
+	return objects
+}
+
+// findNode returns the route to the node of key.
+func findNode(t *testing.T, n *nameNodeImpl, pool *pooltest.Pool, key string) *metadata.NextRoute {
+	t.Helper()
+	ctx := context.Background()
+	rootId, err := n.getRootId(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	route := &metadata.NextRoute{NodeId: rootId, Key: n.rootKey}
+	for route.Key != key {
+		meta, err := pool.GetMetadata(ctx, route.NodeId, route.Key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		index := meta.FindPrefix(key)
+		if index == -1 {
+			t.Fatalf("no node of %s", key)
+		}
+		route = meta.GetNext(index)
+	}
+	return route
+}
@@ -0,0 +1,321 @@
+package namenode
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pkg/errors"
+	"github.com/qwp0905/go-object-storage/internal/datanode"
+	"github.com/qwp0905/go-object-storage/internal/locker"
+	"github.com/qwp0905/go-object-storage/internal/metadata"
+)
+
+const maxConflictRetries = 16
+
+var (
+	ErrTooManyConflicts = fiber.NewError(fiber.StatusConflict, "too many concurrent updates")
+	errRetry            = errors.New("metadata changed concurrently")
+)
+
+func conflict(err error) error {
+	if errors.Is(err, datanode.ErrVersionMismatch) || errors.Is(err, fiber.ErrNotFound) {
+		return errRetry
+	}
+	return err
+}
+
+func (n *nameNodeImpl) retry(ctx context.Context, fn func() error) error {
+	for i := 0; i < maxConflictRetries; i++ {
+		err := fn()
+		if !errors.Is(err, errRetry) {
+			return err
+		}
+
+		shift := i
+		if shift > 6 {
+			shift = 6
+		}
+		backoff := time.Duration(rand.Int63n(int64(time.Millisecond) << shift))
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+	}
+
+	return errors.WithStack(ErrTooManyConflicts)
+}
+
+type noopLocker struct{}
+
+func (noopLocker) RLock(ctx context.Context) error          { return nil }
+func (noopLocker) RUnlock(ctx context.Context) error        { return nil }
+func (noopLocker) Lock(ctx context.Context) (uint64, error) { return 0, nil }
+func (noopLocker) Unlock(ctx context.Context) error         { return nil }
+
+func (n *nameNodeImpl) readLocker(key string) locker.RWMutex {
+	if n.concurrency == ConcurrencyOptimistic {
+		return noopLocker{}
+	}
+	return n.lockerPool.Get(key)
+}
+
+// a non root node without object and routes can only be left behind by an
+// unfinished optimistic delete. writers never touch it and unlink it instead.
+func (n *nameNodeImpl) isTombstone(meta *metadata.Metadata) bool {
+	return meta.Key != n.rootKey && !meta.FileExists() && meta.Len() == 0
+}
+
+func (n *nameNodeImpl) putOptimistic(
+	ctx context.Context,
+	input *PutObjectInput,
+	body io.Reader,
+	class string,
+	encoding *encoding,
+) error {
+	object := metadata.New(input.Key)
+	input.apply(object, class)
+	encoding.apply(object)
+	if err := n.store(ctx, object, class, body, encoding); err != nil {
+		return err
+	}
+
+	var prev *metadata.Metadata
+	if err := n.retry(ctx, func() error {
+		rootId, err := n.getRootId(ctx)
+		if err != nil {
+			return err
+		}
+		prev, err = n.link(ctx, object, "", nil, rootId, n.rootKey)
+		return err
+	}); err != nil {
+		n.pool.DeleteDirect(ctx, object)
+		return err
+	}
+
+	if prev == nil || !prev.FileExists() {
+		return nil
+	}
+	return n.pool.DeleteDirect(ctx, prev)
+}
+
+func (n *nameNodeImpl) link(
+	ctx context.Context,
+	object *metadata.Metadata,
+	parentId string,
+	parent *metadata.Metadata,
+	id, current string,
+) (*metadata.Metadata, error) {
+	currentMeta, err := n.getMetadata(ctx, id, current)
+	if err != nil {
+		return nil, conflict(err)
+	}
+	if n.isTombstone(currentMeta) {
+		if err := n.unlink(ctx, parentId, parent, id, currentMeta); err != nil {
+			return nil, err
+		}
+		return nil, errRetry
+	}
+
+	version := currentMeta.Version
+	if object.Key == currentMeta.Key {
+		prev := *currentMeta
+		currentMeta.SetObject(object)
+		if err := n.pool.PutMetadataIf(ctx, id, currentMeta, version); err != nil {
+			return nil, conflict(err)
+		}
+
+		return &prev, nil
+	}
+
+	index, matched := currentMeta.FindMatched(object.Key)
+	if index == -1 {
+		metadataId, err := n.pool.AcquireNode(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		leaf := *object
+		leaf.NextNodes = make([]*metadata.NextRoute, 0)
+		if err := n.pool.PutMetadataIf(ctx, metadataId, &leaf, 0); err != nil {
+			return nil, conflict(err)
+		}
+
+		currentMeta.InsertNext(metadataId, object.Key)
+		if err := n.pool.PutMetadataIf(ctx, id, currentMeta, version); err != nil {
+			n.pool.DeleteMetadataIf(ctx, metadataId, leaf.Key, leaf.Version)
+			return nil, conflict(err)
+		}
+
+		return nil, nil
+	}
+
+	next := currentMeta.GetNext(index)
+	if next.Key == matched {
+		return n.link(ctx, object, id, currentMeta, next.NodeId, next.Key)
+	}
+
+	nodeId, err := n.pool.AcquireNode(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	newMeta := &metadata.Metadata{Key: matched, NextNodes: []*metadata.NextRoute{next}}
+	if err := n.pool.PutMetadataIf(ctx, nodeId, newMeta, 0); err != nil {
+		return nil, conflict(err)
+	}
+
+	currentMeta.NextNodes[index] = &metadata.NextRoute{NodeId: nodeId, Key: matched}
+	if err := n.pool.PutMetadataIf(ctx, id, currentMeta, version); err != nil {
+		n.pool.DeleteMetadataIf(ctx, nodeId, newMeta.Key, newMeta.Version)
+		return nil, conflict(err)
+	}
+
+	return n.link(ctx, object, id, currentMeta, nodeId, matched)
+}
+
+func (n *nameNodeImpl) unlink(
+	ctx context.Context,
+	parentId string,
+	parent *metadata.Metadata,
+	id string,
+	child *metadata.Metadata,
+) error {
+	for i := 0; ; i++ {
+		index := -1
+		for j, next := range parent.NextNodes {
+			if next.Key == child.Key && next.NodeId == id {
+				index = j
+				break
+			}
+		}
+		// a split moved the route under a new node, the child is unlinked
+		// from there.
+		if index == -1 {
+			return errRetry
+		}
+
+		version := parent.Version
+		parent.RemoveNext(index)
+		err := n.pool.PutMetadataIf(ctx, parentId, parent, version)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, datanode.ErrVersionMismatch) || i == maxConflictRetries {
+			return conflict(err)
+		}
+
+		latest, err := n.getMetadata(ctx, parentId, parent.Key)
+		if err != nil {
+			return conflict(err)
+		}
+		*parent = *latest
+	}
+
+	if err := n.pool.DeleteMetadataIf(ctx, id, child.Key, child.Version); err != nil {
+		return conflict(err)
+	}
+
+	return nil
+}
+
+type pathEntry struct {
+	id   string
+	meta *metadata.Metadata
+}
+
+func (n *nameNodeImpl) deleteOptimistic(ctx context.Context, key string) error {
+	var deleted *metadata.Metadata
+	if err := n.retry(ctx, func() (err error) {
+		deleted, err = n.clear(ctx, key, nil)
+		return err
+	}); err != nil {
+		return err
+	}
+
+	if deleted == nil {
+		return nil
+	}
+	return n.pool.DeleteDirect(ctx, deleted)
+}
+
+// locate returns the nodes from the root to the node of key, or nil when key
+// has no node.
+func (n *nameNodeImpl) locate(ctx context.Context, key string) ([]pathEntry, error) {
+	id, err := n.getRootId(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	path := make([]pathEntry, 0)
+	current := n.rootKey
+	for {
+		currentMeta, err := n.getMetadata(ctx, id, current)
+		if err != nil {
+			return nil, conflict(err)
+		}
+		if n.isTombstone(currentMeta) {
+			parent := path[len(path)-1]
+			if err := n.unlink(ctx, parent.id, parent.meta, id, currentMeta); err != nil {
+				return nil, err
+			}
+			return nil, errRetry
+		}
+
+		path = append(path, pathEntry{id: id, meta: currentMeta})
+		if key == currentMeta.Key {
+			return path, nil
+		}
+
+		index := currentMeta.FindPrefix(key)
+		if index == -1 {
+			return nil, nil
+		}
+		next := currentMeta.GetNext(index)
+		id, current = next.NodeId, next.Key
+	}
+}
+
+func (n *nameNodeImpl) clear(ctx context.Context, key string, expect *metadata.Metadata) (*metadata.Metadata, error) {
+	path, err := n.locate(ctx, key)
+	if err != nil || path == nil {
+		return nil, err
+	}
+
+	target := path[len(path)-1]
+	if !target.meta.FileExists() || (expect != nil && !target.meta.SameObject(expect)) {
+		return nil, nil
+	}
+
+	prev := *target.meta
+	target.meta.Clear()
+	if err := n.pool.PutMetadataIf(ctx, target.id, target.meta, prev.Version); err != nil {
+		return nil, conflict(err)
+	}
+
+	for i := len(path) - 1; i > 0 && n.isTombstone(path[i].meta); i-- {
+		parent := path[i-1]
+		if err := n.unlink(ctx, parent.id, parent.meta, path[i].id, path[i].meta); err != nil {
+			break
+		}
+	}
+
+	return &prev, nil
+}
+
+func (n *nameNodeImpl) rewriteOptimistic(ctx context.Context, key string, fn func(meta *metadata.Metadata) bool) error {
+	path, err := n.locate(ctx, key)
+	if err != nil || path == nil {
+		return err
+	}
+
+	target := path[len(path)-1]
+	version := target.meta.Version
+	if !fn(target.meta) {
+		return nil
+	}
+	return conflict(n.pool.PutMetadataIf(ctx, target.id, target.meta, version))
+}
@@ -0,0 +1,127 @@
+package namenode
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/qwp0905/go-object-storage/internal/nodepool/pooltest"
+)
+
+// churn runs fn on workers goroutines at once and fails on any error. calls
+// to the pool are delayed so the writers interleave.
+func churn(t *testing.T, pool *pooltest.Pool, workers int, fn func(worker int) error) {
+	t.Helper()
+	pool.Fail(func(method, id, key string) error {
+		time.Sleep(time.Duration(rand.Int63n(int64(time.Microsecond * 200))))
+		return nil
+	})
+	defer pool.Fail(nil)
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			if err := fn(w); err != nil {
+				errs <- err
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("%+v", err)
+	}
+}
+
+func TestOptimisticSameKey(t *testing.T) {
+	n, pool := newNameNode(t, ConcurrencyOptimistic, nil)
+	ctx := context.Background()
+	// neighbours split and merge with the node of the key being churned.
+	kept := []string{"/k/x", "/k-", "/kk"}
+	for _, key := range kept {
+		putObject(t, n, key, key)
+	}
+
+	churn(t, pool, 8, func(w int) error {
+		for i := 0; i < 30; i++ {
+			body := fmt.Sprintf("%d-%d", w, i)
+			if err := n.PutObject(ctx, &PutObjectInput{
+				Key:  "/k",
+				Size: len(body),
+				Body: strings.NewReader(body),
+			}); err != nil {
+				return err
+			}
+			if (w+i)%3 == 0 {
+				if err := n.DeleteObject(ctx, "/k"); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+
+	objects := checkTrie(t, n, pool)
+	for _, key := range kept {
+		mustGet(t, n, key, key)
+	}
+	if got, err := getObject(t, n, "/k"); err == nil {
+		var w, i int
+		if _, err := fmt.Sscanf(got, "%d-%d", &w, &i); err != nil {
+			t.Fatalf("/k holds %q", got)
+		}
+	}
+	if data, _ := pool.Files(); data != len(objects) {
+		t.Fatalf("%d data files kept for %d objects", data, len(objects))
+	}
+}
+
+func TestOptimisticSiblings(t *testing.T) {
+	n, pool := newNameNode(t, ConcurrencyOptimistic, nil)
+	ctx := context.Background()
+	const workers, keys = 8, 20
+
+	// every worker puts its own keys under shared parents, then deletes
+	// every other one while the others still put.
+	key := func(w, i int) string {
+		return fmt.Sprintf("/s/%d/%d", i%4, w*keys+i)
+	}
+	churn(t, pool, workers, func(w int) error {
+		for i := 0; i < keys; i++ {
+			if err := n.PutObject(ctx, &PutObjectInput{
+				Key:  key(w, i),
+				Size: len(key(w, i)),
+				Body: strings.NewReader(key(w, i)),
+			}); err != nil {
+				return err
+			}
+			if i%2 == 1 {
+				if err := n.DeleteObject(ctx, key(w, i-1)); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+
+	want := make([]string, 0)
+	for w := 0; w < workers; w++ {
+		for i := 1; i < keys; i += 2 {
+			want = append(want, key(w, i))
+		}
+	}
+	equalKeys(t, checkTrie(t, n, pool), want...)
+	equalKeys(t, listKeys(t, n, "/s/"), want...)
+	for _, key := range want {
+		mustGet(t, n, key, key)
+	}
+	if data, _ := pool.Files(); data != len(want) {
+		t.Fatalf("%d data files kept for %d objects", data, len(want))
+	}
+}
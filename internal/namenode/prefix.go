@@ -0,0 +1,577 @@
+package namenode
+
+import (
+	"context"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pkg/errors"
+	"github.com/qwp0905/go-object-storage/internal/locker"
+	"github.com/qwp0905/go-object-storage/internal/metadata"
+	"github.com/qwp0905/go-object-storage/pkg/logger"
+)
+
+var (
+	ErrInvalidPrefix = fiber.NewError(fiber.StatusBadRequest, "prefix must not cover the root")
+	ErrInvalidTarget = fiber.NewError(fiber.StatusBadRequest, "target must start with the root and not overlap the prefix")
+	ErrTargetExists  = fiber.NewError(fiber.StatusConflict, "target already holds objects")
+)
+
+// objects of a deleted prefix are removed from a datanode this many at a time.
+const cleanupBatchSize = 256
+
+func (n *nameNodeImpl) validPrefix(prefix string) bool {
+	return prefix != "" && !strings.HasPrefix(n.rootKey, prefix)
+}
+
+func (n *nameNodeImpl) DeletePrefix(ctx context.Context, prefix string, progress *Progress) error {
+	if !n.validPrefix(prefix) {
+		return ErrInvalidPrefix
+	}
+
+	route, err := n.detachPrefix(ctx, prefix)
+	if err != nil || route == nil {
+		return err
+	}
+	return n.cleanup(ctx, route, progress)
+}
+
+// detachPrefix unlinks the subtree under prefix, nil when there is none.
+func (n *nameNodeImpl) detachPrefix(ctx context.Context, prefix string) (*metadata.NextRoute, error) {
+	var route *metadata.NextRoute
+	if n.concurrency == ConcurrencyOptimistic {
+		err := n.retry(ctx, func() (err error) {
+			route, err = n.cut(ctx, prefix)
+			return err
+		})
+		return route, err
+	}
+
+	id, err := n.getRootId(ctx)
+	if err != nil {
+		return nil, err
+	}
+	_, route, err = n.detach(ctx, prefix, id, n.rootKey)
+	return route, err
+}
+
+// subtree returns the route every key under prefix goes through. siblings
+// share no prefix longer than the node key, so there is at most one.
+func subtree(m *metadata.Metadata, prefix string) int {
+	i := m.Search(prefix)
+	for _, j := range []int{i - 1, i} {
+		if j >= 0 && j < m.Len() && strings.HasPrefix(m.NextNodes[j].Key, prefix) {
+			return j
+		}
+	}
+	return -1
+}
+
+// detach unlinks the subtree under prefix and returns its route, the trie is
+// fixed up on the way back like a single delete.
+func (n *nameNodeImpl) detach(
+	ctx context.Context,
+	prefix, id, current string,
+) (*metadata.Metadata, *metadata.NextRoute, error) {
+	locker := n.lockerPool.Get(current)
+	token, err := locker.Lock(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer locker.Unlock(ctx)
+
+	currentMeta, err := n.getMetadata(ctx, id, current)
+	if err != nil {
+		return nil, nil, err
+	}
+	currentMeta.Fence = token
+
+	if index := subtree(currentMeta, prefix); index != -1 {
+		route := currentMeta.GetNext(index)
+		updated, err := n.settle(ctx, id, currentMeta, index, nil)
+		return updated, route, err
+	}
+
+	index := currentMeta.FindPrefix(prefix)
+	if index == -1 {
+		return currentMeta, nil, nil
+	}
+
+	next := currentMeta.GetNext(index)
+	deleted, route, err := n.detach(ctx, prefix, next.NodeId, next.Key)
+	if err != nil || route == nil {
+		return currentMeta, route, err
+	}
+
+	updated, err := n.settle(ctx, id, currentMeta, index, deleted)
+	return updated, route, err
+}
+
+// cut is detach for optimistic mode, nodes left without object and routes are
+// unlinked the same way clear does.
+func (n *nameNodeImpl) cut(ctx context.Context, prefix string) (*metadata.NextRoute, error) {
+	id, err := n.getRootId(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	path := make([]pathEntry, 0)
+	current := n.rootKey
+	for {
+		currentMeta, err := n.getMetadata(ctx, id, current)
+		if err != nil {
+			return nil, conflict(err)
+		}
+		if n.isTombstone(currentMeta) {
+			parent := path[len(path)-1]
+			if err := n.unlink(ctx, parent.id, parent.meta, id, currentMeta); err != nil {
+				return nil, err
+			}
+			return nil, errRetry
+		}
+		path = append(path, pathEntry{id: id, meta: currentMeta})
+
+		if index := subtree(currentMeta, prefix); index != -1 {
+			route := currentMeta.GetNext(index)
+			version := currentMeta.Version
+			currentMeta.RemoveNext(index)
+			if err := n.pool.PutMetadataIf(ctx, id, currentMeta, version); err != nil {
+				return nil, conflict(err)
+			}
+
+			for i := len(path) - 1; i > 0 && n.isTombstone(path[i].meta); i-- {
+				parent := path[i-1]
+				if err := n.unlink(ctx, parent.id, parent.meta, path[i].id, path[i].meta); err != nil {
+					break
+				}
+			}
+			return route, nil
+		}
+
+		index := currentMeta.FindPrefix(prefix)
+		if index == -1 {
+			return nil, nil
+		}
+		next := currentMeta.GetNext(index)
+		id, current = next.NodeId, next.Key
+	}
+}
+
+// cleanup removes a detached subtree. nothing reaches it anymore, so whatever
+// a cancelled cleanup leaves behind is found by fsck and the collector.
+func (n *nameNodeImpl) cleanup(ctx context.Context, route *metadata.NextRoute, progress *Progress) error {
+	batches := make(map[string][]*metadata.Metadata)
+	flush := func(id string) error {
+		objects := batches[id]
+		delete(batches, id)
+		sources := make([]string, len(objects))
+		size := uint(0)
+		for i, object := range objects {
+			sources[i] = object.Source
+			size += object.Size
+		}
+		if err := n.pool.DeleteDirectBatch(ctx, id, sources); err != nil {
+			return err
+		}
+		progress.Objects.Add(int64(len(objects)))
+		progress.Bytes.Add(int64(size))
+		return nil
+	}
+
+	stack := []*metadata.NextRoute{route}
+	for len(stack) > 0 {
+		if err := ctx.Err(); err != nil {
+			return errors.WithStack(err)
+		}
+		next := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		meta, err := n.getMetadata(ctx, next.NodeId, next.Key)
+		if err == fiber.ErrNotFound {
+			continue
+		} else if err != nil {
+			return err
+		}
+		stack = append(stack, meta.NextNodes...)
+
+		if err := n.pool.DeleteMetadata(ctx, next.NodeId, next.Key); err != nil && err != fiber.ErrNotFound {
+			return err
+		}
+		if !meta.FileExists() {
+			continue
+		}
+		// chunks are spread over nodes, so they do not batch.
+		if meta.Chunked() {
+			if err := n.pool.DeleteDirect(ctx, meta); err != nil {
+				return err
+			}
+			progress.Objects.Add(1)
+			progress.Bytes.Add(int64(meta.Size))
+			continue
+		}
+
+		batches[meta.NodeId] = append(batches[meta.NodeId], meta)
+		if len(batches[meta.NodeId]) >= cleanupBatchSize {
+			if err := flush(meta.NodeId); err != nil {
+				return err
+			}
+		}
+	}
+
+	for id := range batches {
+		if err := flush(id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MovePrefix moves the subtree under prefix to target followed by the rest of
+// its keys. target is reserved first, then the subtree is detached, written
+// again under the new keys on the same datanodes and linked at target as a
+// whole, the data stays where it is. objects under prefix are not found while
+// it moves, so a detached move is not cancelled anymore. when it can not be
+// linked at target it goes back under prefix, a namenode failing on the way
+// leaves it for fsck.
+func (n *nameNodeImpl) MovePrefix(ctx context.Context, prefix, target string, progress *Progress) error {
+	if !n.validPrefix(prefix) {
+		return ErrInvalidPrefix
+	}
+	if !strings.HasPrefix(target, n.rootKey) ||
+		strings.HasPrefix(target, prefix) ||
+		strings.HasPrefix(prefix, target) {
+		return ErrInvalidTarget
+	}
+
+	r, err := n.reserve(ctx, target)
+	if err != nil {
+		return err
+	}
+
+	route, err := n.detachPrefix(ctx, prefix)
+	if err != nil || route == nil {
+		r.cancel(context.Background())
+		return err
+	}
+
+	ctx = context.Background()
+	written := make([]*metadata.NextRoute, 0)
+	moved, err := n.rekey(ctx, route, prefix, r, &written, progress)
+	if err == nil {
+		err = r.link(ctx, moved)
+	}
+	if err != nil {
+		r.cancel(ctx)
+		n.prune(ctx, written...)
+		if err := n.graft(ctx, route); err != nil {
+			logger.Errorf("subtree %s is left unlinked: %+v", route.Key, err)
+		}
+		return err
+	}
+
+	r.release(ctx)
+	return n.prune(ctx, n.nodesOf(ctx, route)...)
+}
+
+// reservation keeps target free while a subtree is moved there. with locks an
+// empty node is linked at target and held locked, so puts under target wait
+// for the move. optimistic writers would unlink an empty node, so target is
+// only checked and the subtree is grafted at the end.
+type reservation struct {
+	n      *nameNodeImpl
+	key    string
+	id     string
+	fence  uint64
+	locker locker.RWMutex
+	meta   *metadata.Metadata
+}
+
+func (n *nameNodeImpl) reserve(ctx context.Context, target string) (*reservation, error) {
+	r := &reservation{n: n, key: target}
+	if n.concurrency == ConcurrencyOptimistic {
+		id, err := n.getRootId(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return r, n.free(ctx, target, id, n.rootKey)
+	}
+
+	r.locker = n.lockerPool.Get(target)
+	token, err := r.locker.Lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	r.fence = token
+
+	if r.id, err = n.pool.AcquireNode(ctx); err != nil {
+		r.locker.Unlock(ctx)
+		return nil, err
+	}
+	r.meta = metadata.New(target)
+	r.meta.Fence = token
+	if err := n.pool.PutMetadata(ctx, r.id, r.meta); err != nil {
+		r.locker.Unlock(ctx)
+		return nil, err
+	}
+	if err := n.graft(ctx, &metadata.NextRoute{NodeId: r.id, Key: target}); err != nil {
+		n.pool.DeleteMetadata(ctx, r.id, target)
+		r.locker.Unlock(ctx)
+		return nil, err
+	}
+	return r, nil
+}
+
+// free fails with ErrTargetExists when graft would not link a subtree at key.
+func (n *nameNodeImpl) free(ctx context.Context, key, id, current string) error {
+	currentMeta, err := n.getMetadata(ctx, id, current)
+	if err != nil {
+		return err
+	}
+	if key == currentMeta.Key && !n.isTombstone(currentMeta) {
+		return ErrTargetExists
+	}
+
+	index, matched := currentMeta.FindMatched(key)
+	if index == -1 {
+		return nil
+	}
+	next := currentMeta.GetNext(index)
+	if next.Key == matched {
+		return n.free(ctx, key, next.NodeId, next.Key)
+	}
+	if matched == key {
+		return ErrTargetExists
+	}
+	return nil
+}
+
+// link puts the moved subtree of route at target. a subtree rooted at target
+// itself was written over the reserved node already.
+func (r *reservation) link(ctx context.Context, route *metadata.NextRoute) error {
+	if r.locker == nil {
+		return r.n.graft(ctx, route)
+	}
+	if route.Key == r.key {
+		return nil
+	}
+	r.meta.InsertNext(route.NodeId, route.Key)
+	return r.n.pool.PutMetadata(ctx, r.id, r.meta)
+}
+
+// release lets writers under target go on once the subtree is linked.
+func (r *reservation) release(ctx context.Context) {
+	if r.locker != nil {
+		r.locker.Unlock(ctx)
+	}
+}
+
+// cancel unlinks and removes the reserved node.
+func (r *reservation) cancel(ctx context.Context) {
+	if r.locker == nil {
+		return
+	}
+	defer r.locker.Unlock(ctx)
+	if _, err := r.n.detachPrefix(ctx, r.key); err != nil {
+		logger.Errorf("reserved node %s is left linked: %+v", r.key, err)
+		return
+	}
+	r.n.prune(ctx, &metadata.NextRoute{NodeId: r.id, Key: r.key})
+}
+
+// rekey writes the subtree of route again with the key of r in place of
+// prefix in every key, each node on the datanode it was on. a node that gets
+// the key of r replaces the reserved one. the routes written are appended to
+// written, children before their parents.
+func (n *nameNodeImpl) rekey(
+	ctx context.Context,
+	route *metadata.NextRoute,
+	prefix string,
+	r *reservation,
+	written *[]*metadata.NextRoute,
+	progress *Progress,
+) (*metadata.NextRoute, error) {
+	meta, err := n.getMetadata(ctx, route.NodeId, route.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	moved := *meta
+	moved.Key = r.key + strings.TrimPrefix(meta.Key, prefix)
+	moved.Version = 0
+	// replacing a shared prefix keeps the routes sorted.
+	moved.NextNodes = make([]*metadata.NextRoute, len(meta.NextNodes))
+	for i, next := range meta.NextNodes {
+		if moved.NextNodes[i], err = n.rekey(ctx, next, prefix, r, written, progress); err != nil {
+			return nil, err
+		}
+	}
+
+	id := route.NodeId
+	if moved.Key == r.key && r.locker != nil {
+		id, moved.Fence = r.id, r.fence
+	}
+	if err := n.pool.PutMetadata(ctx, id, &moved); err != nil {
+		return nil, err
+	}
+	if moved.FileExists() {
+		progress.Objects.Add(1)
+		progress.Bytes.Add(int64(moved.Size))
+	}
+	out := &metadata.NextRoute{NodeId: id, Key: moved.Key}
+	*written = append(*written, out)
+	return out, nil
+}
+
+// nodesOf returns the routes of every node in the subtree of route. nodes
+// that can not be read are skipped, they are left for fsck.
+func (n *nameNodeImpl) nodesOf(ctx context.Context, route *metadata.NextRoute) []*metadata.NextRoute {
+	out := make([]*metadata.NextRoute, 0)
+	stack := []*metadata.NextRoute{route}
+	for len(stack) > 0 {
+		next := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		out = append(out, next)
+
+		meta, err := n.getMetadata(ctx, next.NodeId, next.Key)
+		if err != nil {
+			continue
+		}
+		stack = append(stack, meta.NextNodes...)
+	}
+	return out
+}
+
+// prune removes the metadata of nodes nothing routes to anymore, their data
+// belongs to other nodes.
+func (n *nameNodeImpl) prune(ctx context.Context, routes ...*metadata.NextRoute) error {
+	var first error
+	for _, route := range routes {
+		err := n.pool.DeleteMetadata(ctx, route.NodeId, route.Key)
+		if err != nil && !errors.Is(err, fiber.ErrNotFound) && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// graft links the subtree of route into the trie where its key belongs. it
+// only goes where no key under it is stored yet.
+func (n *nameNodeImpl) graft(ctx context.Context, route *metadata.NextRoute) error {
+	if n.concurrency == ConcurrencyOptimistic {
+		return n.retry(ctx, func() error {
+			rootId, err := n.getRootId(ctx)
+			if err != nil {
+				return err
+			}
+			return n.graftOptimistic(ctx, route, "", nil, rootId, n.rootKey)
+		})
+	}
+
+	id, err := n.getRootId(ctx)
+	if err != nil {
+		return err
+	}
+	return n.graftLocked(ctx, route, id, n.rootKey)
+}
+
+func (n *nameNodeImpl) graftLocked(ctx context.Context, route *metadata.NextRoute, id, current string) error {
+	locker := n.lockerPool.Get(current)
+	token, err := locker.Lock(ctx)
+	if err != nil {
+		return err
+	}
+
+	currentMeta, err := n.getMetadata(ctx, id, current)
+	if err != nil {
+		defer locker.Unlock(ctx)
+		return err
+	}
+	currentMeta.Fence = token
+
+	if route.Key == currentMeta.Key {
+		defer locker.Unlock(ctx)
+		return ErrTargetExists
+	}
+
+	index, matched := currentMeta.FindMatched(route.Key)
+	if index == -1 {
+		defer locker.Unlock(ctx)
+		currentMeta.InsertNext(route.NodeId, route.Key)
+		return n.pool.PutMetadata(ctx, id, currentMeta)
+	}
+
+	next := currentMeta.GetNext(index)
+	if next.Key == matched {
+		if err := locker.Unlock(ctx); err != nil {
+			return err
+		}
+		return n.graftLocked(ctx, route, next.NodeId, next.Key)
+	}
+	if matched == route.Key {
+		defer locker.Unlock(ctx)
+		return ErrTargetExists
+	}
+
+	nodeId, err := n.split(ctx, id, currentMeta, index, matched)
+	if err != nil {
+		defer locker.Unlock(ctx)
+		return err
+	}
+	if err := locker.Unlock(ctx); err != nil {
+		return err
+	}
+	return n.graftLocked(ctx, route, nodeId, matched)
+}
+
+func (n *nameNodeImpl) graftOptimistic(
+	ctx context.Context,
+	route *metadata.NextRoute,
+	parentId string,
+	parent *metadata.Metadata,
+	id, current string,
+) error {
+	currentMeta, err := n.getMetadata(ctx, id, current)
+	if err != nil {
+		return conflict(err)
+	}
+	if n.isTombstone(currentMeta) {
+		if err := n.unlink(ctx, parentId, parent, id, currentMeta); err != nil {
+			return err
+		}
+		return errRetry
+	}
+	if route.Key == currentMeta.Key {
+		return ErrTargetExists
+	}
+
+	version := currentMeta.Version
+	index, matched := currentMeta.FindMatched(route.Key)
+	if index == -1 {
+		currentMeta.InsertNext(route.NodeId, route.Key)
+		return conflict(n.pool.PutMetadataIf(ctx, id, currentMeta, version))
+	}
+
+	next := currentMeta.GetNext(index)
+	if next.Key == matched {
+		return n.graftOptimistic(ctx, route, id, currentMeta, next.NodeId, next.Key)
+	}
+	if matched == route.Key {
+		return ErrTargetExists
+	}
+
+	nodeId, err := n.pool.AcquireNode(ctx)
+	if err != nil {
+		return err
+	}
+	newMeta := &metadata.Metadata{Key: matched, NextNodes: []*metadata.NextRoute{next}}
+	if err := n.pool.PutMetadataIf(ctx, nodeId, newMeta, 0); err != nil {
+		return conflict(err)
+	}
+
+	currentMeta.NextNodes[index] = &metadata.NextRoute{NodeId: nodeId, Key: matched}
+	if err := n.pool.PutMetadataIf(ctx, id, currentMeta, version); err != nil {
+		n.pool.DeleteMetadataIf(ctx, nodeId, newMeta.Key, newMeta.Version)
+		return conflict(err)
+	}
+	return n.graftOptimistic(ctx, route, id, currentMeta, nodeId, matched)
+}
@@ -0,0 +1,205 @@
+package namenode
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func putMoveFixture(t *testing.T, n NameNode) {
+	t.Helper()
+	for _, key := range []string{"/a/1", "/a/2", "/a/b/3", "/ab", "/other/x"} {
+		putObject(t, n, key, "data of "+key)
+	}
+}
+
+func TestMovePrefix(t *testing.T) {
+	for _, mode := range modes {
+		t.Run(string(mode), func(t *testing.T) {
+			n, pool := newNameNode(t, mode, nil)
+			putMoveFixture(t, n)
+
+			progress := new(Progress)
+			if err := n.MovePrefix(context.Background(), "/a/", "/c/", progress); err != nil {
+				t.Fatalf("%+v", err)
+			}
+			if progress.Objects.Load() != 3 {
+				t.Fatalf("%d objects moved, want 3", progress.Objects.Load())
+			}
+
+			equalKeys(t, checkTrie(t, n, pool), "/ab", "/c/1", "/c/2", "/c/b/3", "/other/x")
+			equalKeys(t, listKeys(t, n, "/c/"), "/c/1", "/c/2", "/c/b/3")
+			mustGet(t, n, "/c/b/3", "data of /a/b/3")
+			if _, err := getObject(t, n, "/a/1"); err == nil {
+				t.Fatal("/a/1 is still found")
+			}
+		})
+	}
+}
+
+func TestMovePrefixToTakenTarget(t *testing.T) {
+	for _, mode := range modes {
+		t.Run(string(mode), func(t *testing.T) {
+			n, pool := newNameNode(t, mode, nil)
+			putMoveFixture(t, n)
+			putObject(t, n, "/c/z", "taken")
+			_, before := pool.Files()
+
+			err := n.MovePrefix(context.Background(), "/a/", "/c/", new(Progress))
+			if !errors.Is(err, ErrTargetExists) {
+				t.Fatalf("moved onto a taken target: %v", err)
+			}
+
+			// nothing was detached or reserved.
+			if _, after := pool.Files(); after != before {
+				t.Fatalf("%d metadata files before the move, %d after", before, after)
+			}
+			equalKeys(t, checkTrie(t, n, pool), "/a/1", "/a/2", "/a/b/3", "/ab", "/c/z", "/other/x")
+		})
+	}
+}
+
+// a put under the target while the subtree is rewritten waits for the move
+// with locks, and makes an optimistic move go back under its prefix.
+func TestMovePrefixRacingPut(t *testing.T) {
+	for _, mode := range modes {
+		t.Run(string(mode), func(t *testing.T) {
+			n, pool := newNameNode(t, mode, nil)
+			putMoveFixture(t, n)
+
+			var wg sync.WaitGroup
+			var once sync.Once
+			putErr := make(chan error, 1)
+			racingPut := func() {
+				putErr <- n.PutObject(context.Background(), &PutObjectInput{Key: "/c/z", Body: strings.NewReader("")})
+			}
+			pool.Fail(func(method, id, key string) error {
+				if method != "PutMetadata" || key != "/c/b/3" {
+					return nil
+				}
+				once.Do(func() {
+					if mode == ConcurrencyOptimistic {
+						racingPut()
+						return
+					}
+					// the put blocks on the reserved node until the move is done.
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						racingPut()
+					}()
+					time.Sleep(time.Millisecond * 20)
+				})
+				return nil
+			})
+			err := n.MovePrefix(context.Background(), "/a/", "/c/", new(Progress))
+			wg.Wait()
+			pool.Fail(nil)
+			if err := <-putErr; err != nil {
+				t.Fatalf("put under the target: %+v", err)
+			}
+
+			if mode == ConcurrencyLock {
+				if err != nil {
+					t.Fatalf("%+v", err)
+				}
+				equalKeys(t, checkTrie(t, n, pool), "/ab", "/c/1", "/c/2", "/c/b/3", "/c/z", "/other/x")
+				return
+			}
+			if !errors.Is(err, ErrTargetExists) {
+				t.Fatalf("moved over a racing put: %v", err)
+			}
+			equalKeys(t, checkTrie(t, n, pool), "/a/1", "/a/2", "/a/b/3", "/ab", "/c/z", "/other/x")
+			mustGet(t, n, "/a/b/3", "data of /a/b/3")
+		})
+	}
+}
+
+func TestMovePrefixLinkFails(t *testing.T) {
+	for _, mode := range modes {
+		t.Run(string(mode), func(t *testing.T) {
+			n, pool := newNameNode(t, mode, nil)
+			putMoveFixture(t, n)
+
+			// with locks the moved root is written over the reserved node,
+			// optimistic moves graft it into the root.
+			link := "/c/"
+			if mode == ConcurrencyOptimistic {
+				link = "/"
+			}
+			failed := errors.New("link failed")
+			rewritten, linked := false, false
+			pool.Fail(func(method, id, key string) error {
+				if method != "PutMetadata" {
+					return nil
+				}
+				if key == "/c/b/3" {
+					rewritten = true
+				} else if rewritten && !linked && key == link {
+					linked = true
+					return failed
+				}
+				return nil
+			})
+
+			err := n.MovePrefix(context.Background(), "/a/", "/c/", new(Progress))
+			pool.Fail(nil)
+			if !errors.Is(err, failed) {
+				t.Fatalf("move went on after linking failed: %v", err)
+			}
+			equalKeys(t, checkTrie(t, n, pool), "/a/1", "/a/2", "/a/b/3", "/ab", "/other/x")
+			mustGet(t, n, "/a/b/3", "data of /a/b/3")
+
+			// the target is free again.
+			if err := n.MovePrefix(context.Background(), "/a/", "/c/", new(Progress)); err != nil {
+				t.Fatalf("%+v", err)
+			}
+			equalKeys(t, checkTrie(t, n, pool), "/ab", "/c/1", "/c/2", "/c/b/3", "/other/x")
+		})
+	}
+}
+
+func TestMovePrefixLeftUnlinked(t *testing.T) {
+	for _, mode := range modes {
+		t.Run(string(mode), func(t *testing.T) {
+			n, pool := newNameNode(t, mode, nil)
+			putMoveFixture(t, n)
+			ctx := context.Background()
+			route := findNode(t, n, pool, "/a/")
+
+			// once the subtree is detached, neither target nor prefix take it.
+			failed := errors.New("write failed")
+			detached := false
+			pool.Fail(func(method, id, key string) error {
+				if method != "PutMetadata" {
+					return nil
+				}
+				if key == "/c/1" {
+					detached = true
+				}
+				if detached && (key == "/" || key == "/a" || key == "/c/") {
+					return failed
+				}
+				return nil
+			})
+			err := n.MovePrefix(ctx, "/a/", "/c/", new(Progress))
+			pool.Fail(nil)
+			if !errors.Is(err, failed) {
+				t.Fatalf("%v", err)
+			}
+
+			// the subtree keeps its nodes for fsck, only the copies are gone.
+			if _, err := pool.GetMetadata(ctx, route.NodeId, route.Key); err != nil {
+				t.Fatalf("unlinked subtree was removed: %+v", err)
+			}
+			if _, err := getObject(t, n, "/a/1"); err == nil {
+				t.Fatal("/a/1 is found though its subtree is unlinked")
+			}
+			equalKeys(t, listKeys(t, n, "/"), "/ab", "/other/x")
+		})
+	}
+}
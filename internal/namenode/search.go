@@ -2,22 +2,21 @@ package namenode
 
 import (
 	"context"
-	"fmt"
-	"regexp"
 	"strings"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/qwp0905/go-object-storage/internal/metadata"
-	"github.com/qwp0905/go-object-storage/pkg/list"
 )
 
-func (n *nameNodeImpl) get(ctx context.Context, key, id, current string) (*metadata.Metadata, error) {
-	locker := n.lockerPool.Get(current)
+type readFunc func(ctx context.Context, id, key string) (*metadata.Metadata, error)
+
+func (n *nameNodeImpl) get(ctx context.Context, key, id, current string, read readFunc) (*metadata.Metadata, error) {
+	locker := n.readLocker(current)
 	if err := locker.RLock(ctx); err != nil {
 		return nil, err
 	}
 
-	currentMeta, err := n.pool.GetMetadata(ctx, id, current)
+	currentMeta, err := read(ctx, id, current)
 	if err != nil {
 		defer locker.RUnlock(ctx)
 		return nil, err
@@ -34,80 +33,158 @@ func (n *nameNodeImpl) get(ctx context.Context, key, id, current string) (*metad
 			return nil, err
 		}
 
-		return n.get(ctx, key, next.NodeId, next.Key)
+		return n.get(ctx, key, next.NodeId, next.Key, read)
 	}
 
 	defer locker.RUnlock(ctx)
 	return nil, fiber.ErrNotFound
 }
 
-// TODO 쬐끔 개선된 듯...
-func (n *nameNodeImpl) scan(
-	ctx context.Context,
-	prefix, delimiter, after string,
-	limit int,
-	id, current string,
-) (list.Set[string], []*metadata.Metadata, error) {
-	prefixes := make(list.Set[string])
-	list := make([]*metadata.Metadata, 0)
-	if limit <= 0 {
-		return prefixes, list, nil
-	}
-
-	reg := fmt.Sprintf("^%s", prefix)
-	if delimiter != "" {
-		reg += fmt.Sprintf("[^%s]*", delimiter)
-		if dir := regexp.MustCompile(reg + delimiter).FindString(current); dir != "" {
-			if after < current {
-				prefixes.Add(dir)
-			}
-			return prefixes, list, nil
-		}
-	} else {
-		reg += ".*"
+// listing collects keys and common prefixes in key order. keys up to after
+// are skipped, and so is any entry up to marker, which is how a continuation
+// token also skips the rest of a common prefix it ended on.
+type listing struct {
+	prefix    string
+	delimiter string
+	after     string
+	marker    string
+	limit     int
+	entries   []*listEntry
+}
+
+type listEntry struct {
+	key      string
+	metadata *metadata.Metadata
+}
+
+func (l *listing) full() bool {
+	return len(l.entries) >= l.limit
+}
+
+// commonPrefix returns the part of key up to and including the first
+// delimiter after the prefix, or an empty string if it has none.
+func (l *listing) commonPrefix(key string) string {
+	if l.delimiter == "" || !strings.HasPrefix(key, l.prefix) {
+		return ""
+	}
+	i := strings.Index(key[len(l.prefix):], l.delimiter)
+	if i == -1 {
+		return ""
 	}
+	return key[:len(l.prefix)+i+len(l.delimiter)]
+}
 
-	locker := n.lockerPool.Get(current)
-	if err := locker.RLock(ctx); err != nil {
-		return nil, nil, err
+func (l *listing) add(key string, meta *metadata.Metadata) {
+	if key <= l.marker {
+		return
+	}
+	if last := len(l.entries) - 1; last >= 0 && l.entries[last].key == key {
+		return
+	}
+	l.entries = append(l.entries, &listEntry{key: key, metadata: meta})
+}
+
+// trie nodes come before their children and routes are sorted, so a depth
+// first walk visits keys in order.
+func (n *nameNodeImpl) scan(ctx context.Context, l *listing, id, current string) error {
+	if l.full() {
+		return nil
+	}
+	if !strings.HasPrefix(current, l.prefix) && !strings.HasPrefix(l.prefix, current) {
+		return nil
+	}
+	// every key below current is smaller than after.
+	if l.after > current && !strings.HasPrefix(l.after, current) {
+		return nil
+	}
+
+	if dir := l.commonPrefix(current); dir != "" {
+		if dir <= l.marker {
+			return nil
+		}
+		if !strings.HasPrefix(l.after, dir) {
+			l.add(dir, nil)
+			return nil
+		}
+		found, err := n.exists(ctx, l.after, id, current)
+		if err != nil || !found {
+			return err
+		}
+		l.add(dir, nil)
+		return nil
 	}
-	defer locker.RUnlock(ctx)
 
-	currentMeta, err := n.pool.GetMetadata(ctx, id, current)
+	currentMeta, err := n.readNode(ctx, id, current)
 	if err != nil {
-		return nil, nil, err
+		return err
 	}
 
-	matched := regexp.MustCompile(reg).FindString(current)
-	if matched != "" && matched == current && currentMeta.FileExists() && current > after {
-		list = append(list, currentMeta)
+	if strings.HasPrefix(current, l.prefix) && currentMeta.FileExists() && current > l.after {
+		l.add(current, currentMeta)
 	}
 
-	for _, next := range currentMeta.NextNodes {
-		if strings.HasPrefix(prefix, next.Key) {
-			return n.scan(ctx, prefix, delimiter, after, limit, next.NodeId, next.Key)
+	from := l.prefix
+	if l.after > from {
+		from = l.after
+	}
+	start := currentMeta.Search(from) - 1
+	if start < 0 {
+		start = 0
+	}
+	for _, next := range currentMeta.NextNodes[start:] {
+		if l.full() {
+			return nil
 		}
-
-		if !strings.HasPrefix(next.Key, prefix) {
-			continue
+		if next.Key > l.prefix && !strings.HasPrefix(next.Key, l.prefix) {
+			break
 		}
-
-		if !strings.HasPrefix(after, next.Key) && after > next.Key {
-			continue
+		if err := n.scan(ctx, l, next.NodeId, next.Key); err != nil {
+			return err
 		}
+	}
 
-		p, l, err := n.scan(ctx, prefix, delimiter, after, limit-len(list), next.NodeId, next.Key)
-		if err != nil {
-			return nil, nil, err
-		}
-		prefixes.Union(p)
-		for _, v := range l {
-			if len(list) == limit {
-				return prefixes, list, nil
-			}
-			list = append(list, v)
+	return nil
+}
+
+// exists reports whether any object below current is greater than after.
+func (n *nameNodeImpl) exists(ctx context.Context, after, id, current string) (bool, error) {
+	if after > current && !strings.HasPrefix(after, current) {
+		return false, nil
+	}
+
+	currentMeta, err := n.readNode(ctx, id, current)
+	if err != nil {
+		return false, err
+	}
+	if currentMeta.FileExists() && current > after {
+		return true, nil
+	}
+
+	start := currentMeta.Search(after) - 1
+	if start < 0 {
+		start = 0
+	}
+	for _, next := range currentMeta.NextNodes[start:] {
+		found, err := n.exists(ctx, after, next.NodeId, next.Key)
+		if err != nil || found {
+			return found, err
 		}
 	}
+	return false, nil
+}
 
-	return prefixes, list, nil
+// readNode reads a node for listing. a node removed while the walk was on its
+// way to it reads as empty instead of failing the whole listing.
+func (n *nameNodeImpl) readNode(ctx context.Context, id, key string) (*metadata.Metadata, error) {
+	locker := n.readLocker(key)
+	if err := locker.RLock(ctx); err != nil {
+		return nil, err
+	}
+	defer locker.RUnlock(ctx)
+
+	meta, err := n.readMetadata(ctx, id, key)
+	if err == fiber.ErrNotFound {
+		return &metadata.Metadata{Key: key, NextNodes: make([]*metadata.NextRoute, 0)}, nil
+	}
+	return meta, err
 }
@@ -0,0 +1,59 @@
+package namenode
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/qwp0905/go-object-storage/internal/metadata"
+)
+
+func putClass(t *testing.T, n NameNode, key, body, class string) error {
+	t.Helper()
+	return n.PutObject(context.Background(), &PutObjectInput{
+		Key:          key,
+		Size:         len(body),
+		Body:         strings.NewReader(body),
+		StorageClass: class,
+	})
+}
+
+func TestPutMovesStorageClass(t *testing.T) {
+	for _, mode := range modes {
+		t.Run(string(mode), func(t *testing.T) {
+			n, pool := newTieredNameNode(t, mode)
+			putObject(t, n, "/a", "standard")
+			standard := mustStore(t, n, pool, "/a", metadata.StorageClassStandard, 1)
+
+			if err := putClass(t, n, "/a", "cold", metadata.StorageClassCold); err != nil {
+				t.Fatal(err)
+			}
+			cold := mustStore(t, n, pool, "/a", metadata.StorageClassCold, 1)
+			if _, ok := pool.Data(standard.NodeId, standard.Source); ok {
+				t.Fatal("data left behind on the standard node")
+			}
+			mustGet(t, n, "/a", "cold")
+
+			if err := putClass(t, n, "/a", "colder", metadata.StorageClassCold); err != nil {
+				t.Fatal(err)
+			}
+			mustStore(t, n, pool, "/a", metadata.StorageClassCold, 1)
+			mustGet(t, n, "/a", "colder")
+
+			// an empty class is standard, not whatever the object was.
+			putObject(t, n, "/a", "warm")
+			mustStore(t, n, pool, "/a", metadata.StorageClassStandard, 1)
+			if _, ok := pool.Data(coldNode, cold.Source); ok {
+				t.Fatal("data left behind on the cold node")
+			}
+			mustGet(t, n, "/a", "warm")
+
+			if err := putClass(t, n, "/a", "lost", "GLACIER"); !errors.Is(err, ErrInvalidStorageClass) {
+				t.Fatalf("put of an unknown class returned %v", err)
+			}
+			mustGet(t, n, "/a", "warm")
+			equalKeys(t, checkTrie(t, n, pool), "/a")
+		})
+	}
+}
@@ -0,0 +1,146 @@
+package nodepool
+
+import (
+	"context"
+	"io"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pkg/errors"
+	"github.com/qwp0905/go-object-storage/internal/metadata"
+	"github.com/valyala/fasthttp"
+)
+
+// chunks fetched ahead of the one being read, so a download keeps that many
+// datanodes busy while holding at most that many chunks in memory.
+const readAhead = 4
+
+// piece is the range of a chunk a read covers.
+type piece struct {
+	part   *metadata.Metadata
+	offset int64
+	length int64
+}
+
+type fetched struct {
+	data []byte
+	err  error
+}
+
+// chunkReader returns the pieces in order while the next ones are fetched in
+// parallel. fetches are only started by reads, so an abandoned reader leaves
+// nothing running once the pending ones are done.
+type chunkReader struct {
+	ctx     context.Context
+	pool    *nodePoolImpl
+	pieces  []piece
+	results []chan fetched
+	started int
+	current int
+	buf     []byte
+	err     error
+}
+
+func (p *nodePoolImpl) readChunks(
+	ctx context.Context,
+	meta *metadata.Metadata,
+	offset, length int64,
+) (io.Reader, error) {
+	end := int64(meta.StoredSize())
+	if length >= 0 && offset+length < end {
+		end = offset + length
+	}
+
+	r := &chunkReader{ctx: ctx, pool: p, pieces: make([]piece, 0)}
+	start := int64(0)
+	for _, part := range meta.Parts() {
+		size := int64(part.Size)
+		if start < end && start+size > offset {
+			from, to := offset-start, end-start
+			if from < 0 {
+				from = 0
+			}
+			if to > size {
+				to = size
+			}
+			r.pieces = append(r.pieces, piece{part: part, offset: from, length: to - from})
+		}
+		start += size
+	}
+	r.results = make([]chan fetched, len(r.pieces))
+
+	// the first piece is waited for, so a missing chunk fails before anything
+	// is returned.
+	if err := r.next(); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if err := r.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// next starts the fetches up to read ahead and takes the current piece.
+func (r *chunkReader) next() error {
+	if r.err != nil {
+		return r.err
+	}
+	if r.current == len(r.pieces) {
+		return io.EOF
+	}
+
+	for r.started < len(r.pieces) && r.started <= r.current+readAhead {
+		ch := make(chan fetched, 1)
+		r.results[r.started] = ch
+		go func(pc piece) {
+			data, err := r.pool.readFile(r.ctx, pc.part, pc.offset, pc.length)
+			ch <- fetched{data: data, err: err}
+		}(r.pieces[r.started])
+		r.started++
+	}
+
+	select {
+	case f := <-r.results[r.current]:
+		r.buf, r.err = f.data, f.err
+	case <-r.ctx.Done():
+		r.err = errors.WithStack(r.ctx.Err())
+	}
+	r.results[r.current] = nil
+	r.current++
+	return r.err
+}
+
+// readFile reads a range of a single file into memory.
+func (p *nodePoolImpl) readFile(ctx context.Context, meta *metadata.Metadata, offset, length int64) ([]byte, error) {
+	host, err := p.GetNodeHost(ctx, meta.NodeId)
+	if err != nil {
+		return nil, err
+	}
+
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	res := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(res)
+
+	req.Header.SetMethod(fasthttp.MethodGet)
+	req.SetRequestURI(getDataHost(host, meta.Source))
+	setRange(req, offset, length)
+
+	if err := p.client.Do(req, res); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if res.StatusCode() == fiber.StatusNotFound {
+		return nil, fiber.ErrNotFound
+	} else if res.StatusCode() >= 400 {
+		return nil, errors.WithStack(errors.Errorf("%s", string(res.Body())))
+	}
+
+	return append([]byte(nil), res.Body()...), nil
+}
@@ -0,0 +1,146 @@
+package nodepool
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pkg/errors"
+	"github.com/qwp0905/go-object-storage/internal/datanode"
+	"github.com/qwp0905/go-object-storage/internal/metadata"
+)
+
+// dataServer serves ranges of files the way a datanode does.
+type dataServer struct {
+	mu    sync.Mutex
+	files map[string][]byte
+	reads int
+}
+
+func (s *dataServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	data, ok := s.files[strings.TrimPrefix(r.URL.Path, "/data/")]
+	s.reads++
+	s.mu.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+}
+
+func (s *dataServer) set(source string, data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if data == nil {
+		delete(s.files, source)
+		return
+	}
+	s.files[source] = data
+}
+
+// read reports whether the server was read since the last call.
+func (s *dataServer) read() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	read := s.reads > 0
+	s.reads = 0
+	return read
+}
+
+// newChunkedObject keeps data in chunks of the given sizes on a server each.
+func newChunkedObject(t *testing.T, data []byte, sizes ...uint) (*nodePoolImpl, *metadata.Metadata, []*dataServer) {
+	t.Helper()
+	meta := &metadata.Metadata{Key: "/a", Source: "source", Size: uint(len(data))}
+	nodes := make([]testNode, len(sizes))
+	servers := make([]*dataServer, len(sizes))
+	hosts := make([]string, len(sizes))
+	for i, size := range sizes {
+		id := string(rune('a' + i))
+		meta.Chunks = append(meta.Chunks, metadata.Chunk{NodeId: id, Size: size})
+		servers[i] = &dataServer{files: map[string][]byte{meta.Parts()[i].Source: data[:size]}}
+		data = data[size:]
+		server := httptest.NewServer(servers[i])
+		t.Cleanup(server.Close)
+		nodes[i] = testNode{id: id, labels: datanode.Labels{Zone: id}}
+		hosts[i] = strings.TrimPrefix(server.URL, "http://")
+	}
+
+	p := newTestPool(t, nodes...)
+	for i, node := range nodes {
+		if err := p.store.Set(context.Background(), datanode.HostKey(node.id), hosts[i], 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return p, meta, servers
+}
+
+func TestChunkedRange(t *testing.T) {
+	data := []byte("0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOP")
+	sizes := []uint{10, 10, 10, 10, 10, 2}
+	p, meta, servers := newChunkedObject(t, data, sizes...)
+
+	for _, c := range []struct{ offset, length int64 }{
+		{0, -1},
+		{0, 10},
+		{3, 4},
+		{8, 4},
+		{5, 30},
+		{10, 10},
+		{25, -1},
+		{50, 2},
+		{49, 3},
+	} {
+		r, err := p.GetDirectRange(context.Background(), meta, c.offset, c.length)
+		if err != nil {
+			t.Fatalf("range %d+%d: %+v", c.offset, c.length, err)
+		}
+		got, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("range %d+%d: %+v", c.offset, c.length, err)
+		}
+		end := int64(len(data))
+		if c.length >= 0 {
+			end = c.offset + c.length
+		}
+		if string(got) != string(data[c.offset:end]) {
+			t.Fatalf("range %d+%d read %q", c.offset, c.length, got)
+		}
+
+		// only the chunks covering the range are read.
+		for i, s := range servers {
+			start := int64(10 * i)
+			covered := start < end && start+int64(sizes[i]) > c.offset
+			if read := s.read(); read != covered {
+				t.Fatalf("range %d+%d read chunk %d %t", c.offset, c.length, i, read)
+			}
+		}
+	}
+}
+
+func TestChunkedMissing(t *testing.T) {
+	data := []byte("0123456789abcdefghij")
+	p, meta, servers := newChunkedObject(t, data, 10, 10)
+
+	servers[0].set(meta.Parts()[0].Source, nil)
+	if _, err := p.GetDirect(context.Background(), meta); !errors.Is(err, fiber.ErrNotFound) {
+		t.Fatalf("read a missing first chunk: %v", err)
+	}
+
+	servers[0].set(meta.Parts()[0].Source, data[:10])
+	servers[1].set(meta.Parts()[1].Source, nil)
+	r, err := p.GetDirect(context.Background(), meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(r); !errors.Is(err, fiber.ErrNotFound) {
+		t.Fatalf("read a missing chunk: %v", err)
+	}
+}
@@ -0,0 +1,35 @@
+package nodepool
+
+import (
+	"context"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pkg/errors"
+	"github.com/qwp0905/go-object-storage/internal/datanode"
+)
+
+// FindContent returns the active node of class that last stored source, empty
+// when there is none.
+func (p *nodePoolImpl) FindContent(ctx context.Context, source, class string) (string, error) {
+	id, err := p.store.Get(ctx, datanode.ContentKey(source))
+	if errors.Is(err, fiber.ErrNotFound) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+
+	nodes, err := p.getActiveNodes(ctx, class)
+	if err != nil {
+		return "", err
+	}
+	for _, node := range nodes {
+		if node.Id == id {
+			return id, nil
+		}
+	}
+	return "", nil
+}
+
+func (p *nodePoolImpl) IndexContent(ctx context.Context, source, id string) error {
+	return p.store.Set(ctx, datanode.ContentKey(source), id, 0)
+}
@@ -0,0 +1,48 @@
+package nodepool
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/goccy/go-json"
+	"github.com/pkg/errors"
+	"github.com/qwp0905/go-object-storage/internal/filesystem"
+	"github.com/valyala/fasthttp"
+)
+
+func (p *nodePoolImpl) ListMetadata(ctx context.Context, id string) ([]*filesystem.FileInfo, error) {
+	return p.listInventory(ctx, id, "meta")
+}
+
+func (p *nodePoolImpl) ListObjects(ctx context.Context, id string) ([]*filesystem.FileInfo, error) {
+	return p.listInventory(ctx, id, "object")
+}
+
+func (p *nodePoolImpl) listInventory(ctx context.Context, id, kind string) ([]*filesystem.FileInfo, error) {
+	host, err := p.GetNodeHost(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	res := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(res)
+
+	req.Header.SetMethod(fasthttp.MethodGet)
+	req.SetRequestURI(fmt.Sprintf("http://%s/inventory/%s", host, kind))
+
+	if err := p.client.Do(req, res); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if res.StatusCode() >= 400 {
+		return nil, errors.WithStack(errors.Errorf("%s", string(res.Body())))
+	}
+
+	files := make([]*filesystem.FileInfo, 0)
+	if err := json.Unmarshal(res.Body(), &files); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return files, nil
+}
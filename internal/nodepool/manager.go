@@ -5,11 +5,12 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/gofiber/fiber/v2"
 	"github.com/pkg/errors"
 	"github.com/qwp0905/go-object-storage/internal/datanode"
+	"github.com/qwp0905/go-object-storage/internal/kv"
 	"github.com/qwp0905/go-object-storage/pkg/logger"
 	"github.com/qwp0905/go-object-storage/pkg/nocopy"
-	"github.com/redis/go-redis/v9"
 	"github.com/valyala/fasthttp"
 )
 
@@ -19,12 +20,12 @@ type PoolManager interface {
 
 type PoolManagerImpl struct {
 	noCopy nocopy.NoCopy
-	rc     *redis.Client
+	store  kv.Store
 	http   *fasthttp.Client
 }
 
-func NewPoolManager(rc *redis.Client) PoolManager {
-	return &PoolManagerImpl{rc: rc, http: &fasthttp.Client{}}
+func NewPoolManager(store kv.Store) PoolManager {
+	return &PoolManagerImpl{store: store, http: &fasthttp.Client{}}
 }
 
 func (m *PoolManagerImpl) Start(sec int) {
@@ -50,9 +51,9 @@ func (m *PoolManagerImpl) Start(sec int) {
 }
 
 func (m *PoolManagerImpl) getAllNodes(ctx context.Context) ([]string, error) {
-	keys, err := m.rc.Keys(ctx, datanode.HostKey("*")).Result()
+	keys, err := m.store.Keys(ctx, datanode.HostKey(""))
 	if err != nil {
-		return nil, errors.WithStack(err)
+		return nil, err
 	}
 
 	out := make([]string, 0)
@@ -63,15 +64,26 @@ func (m *PoolManagerImpl) getAllNodes(ctx context.Context) ([]string, error) {
 	return out, nil
 }
 
+// a decommissioned node that goes away is removed for good, a node that is
+// down while draining keeps its state for when it comes back.
 func (n *PoolManagerImpl) setNodeDown(ctx context.Context, id string) error {
-	return errors.WithStack(n.rc.Del(ctx, datanode.HostKey(id)).Err())
+	if err := n.store.Del(ctx, datanode.HostKey(id)); err != nil {
+		return err
+	}
+	state, err := n.store.Get(ctx, StateKey(id))
+	if errors.Is(err, fiber.ErrNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if state != NodeStateDecommissioned {
+		return nil
+	}
+	return n.store.Del(ctx, StateKey(id))
 }
 
 func (n *PoolManagerImpl) healthCheck(ctx context.Context, id string) error {
-	host, err := n.rc.Get(ctx, datanode.HostKey(id)).Result()
-	if err != nil {
-		return errors.WithStack(err)
-	}
+	host, err := n.store.Get(ctx, datanode.HostKey(id))
 	if err != nil {
 		return err
 	}
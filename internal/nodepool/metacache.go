@@ -0,0 +1,112 @@
+package nodepool
+
+import (
+	"sync"
+	"time"
+
+	"github.com/qwp0905/go-object-storage/internal/metadata"
+	"github.com/qwp0905/go-object-storage/pkg/list"
+	"github.com/qwp0905/go-object-storage/pkg/nocopy"
+)
+
+// metadataCache keeps decoded metadata by key. an entry is trusted until its
+// lease runs out, after that it is only used to revalidate by version.
+type metadataCache struct {
+	noCopy   nocopy.NoCopy
+	mu       *sync.Mutex
+	accessed *list.DoubleLinked[string]
+	maxSize  int
+	lease    time.Duration
+	table    map[string]*cachedMetadata
+}
+
+type cachedMetadata struct {
+	lastAccess *list.DoubleLinkedElement[string]
+	id         string
+	meta       *metadata.Metadata
+	expire     time.Time
+}
+
+func newMetadataCache(size int, lease time.Duration) *metadataCache {
+	return &metadataCache{
+		mu:       new(sync.Mutex),
+		accessed: list.NewDoubleLinked[string](),
+		maxSize:  size,
+		lease:    lease,
+		table:    make(map[string]*cachedMetadata),
+	}
+}
+
+// get returns a copy of the cached metadata and whether its lease is valid.
+func (c *metadataCache) get(id, key string) (*metadata.Metadata, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	item, ok := c.table[key]
+	if !ok || item.id != id {
+		return nil, false
+	}
+
+	c.accessed.MoveBack(item.lastAccess)
+	return clone(item.meta), time.Now().Before(item.expire)
+}
+
+func (c *metadataCache) set(id string, meta *metadata.Metadata) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if item, ok := c.table[meta.Key]; ok {
+		c.accessed.Remove(item.lastAccess)
+		delete(c.table, meta.Key)
+	}
+
+	for len(c.table) >= c.maxSize {
+		l := c.accessed.First()
+		c.accessed.Remove(l)
+		delete(c.table, l.Value)
+	}
+
+	item := &cachedMetadata{
+		lastAccess: list.NewDoubleLinkedElement[string](meta.Key),
+		id:         id,
+		meta:       clone(meta),
+		expire:     time.Now().Add(c.lease),
+	}
+	c.accessed.PushBack(item.lastAccess)
+	c.table[meta.Key] = item
+}
+
+func (c *metadataCache) renew(id, key string, version uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if item, ok := c.table[key]; ok && item.id == id && item.meta.Version == version {
+		item.expire = time.Now().Add(c.lease)
+	}
+}
+
+func (c *metadataCache) del(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	item, ok := c.table[key]
+	if !ok {
+		return
+	}
+	c.accessed.Remove(item.lastAccess)
+	delete(c.table, key)
+}
+
+// callers modify the metadata they get, so the cache never shares it.
+func clone(m *metadata.Metadata) *metadata.Metadata {
+	out := *m
+	routes := make([]metadata.NextRoute, len(m.NextNodes))
+	out.NextNodes = make([]*metadata.NextRoute, len(m.NextNodes))
+	for i, next := range m.NextNodes {
+		routes[i] = *next
+		out.NextNodes[i] = &routes[i]
+	}
+	if m.DataKey != nil {
+		out.DataKey = append([]byte(nil), m.DataKey...)
+	}
+	if m.Chunks != nil {
+		out.Chunks = append([]metadata.Chunk(nil), m.Chunks...)
+	}
+	return &out
+}
@@ -0,0 +1,185 @@
+package nodepool
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pkg/errors"
+	"github.com/qwp0905/go-object-storage/internal/datanode"
+	"github.com/qwp0905/go-object-storage/internal/metadata"
+)
+
+// metaServer keeps metadata and answers revalidation the way a datanode does.
+type metaServer struct {
+	mu    sync.Mutex
+	table map[string]*metadata.Metadata
+	gets  int
+}
+
+func (s *metaServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := strings.TrimPrefix(r.URL.Path, "/meta")
+	switch r.Method {
+	case http.MethodGet:
+		s.gets++
+		meta, ok := s.table[key]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		version := strconv.FormatUint(meta.Version, 10)
+		w.Header().Set(fiber.HeaderETag, version)
+		if r.Header.Get(fiber.HeaderIfNoneMatch) == version {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write(metadata.Marshal(meta))
+	case http.MethodPut:
+		b, _ := io.ReadAll(r.Body)
+		meta, err := metadata.Unmarshal(b)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if current, ok := s.table[meta.Key]; ok {
+			meta.Version = current.Version
+		}
+		meta.Version++
+		s.table[meta.Key] = meta
+		w.Header().Set(fiber.HeaderETag, strconv.FormatUint(meta.Version, 10))
+	case http.MethodDelete:
+		if _, ok := s.table[key]; !ok {
+			http.NotFound(w, r)
+			return
+		}
+		delete(s.table, key)
+	}
+}
+
+// fetched reports how many reads reached the server since the last call.
+func (s *metaServer) fetched() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	gets := s.gets
+	s.gets = 0
+	return gets
+}
+
+// newMetaPools returns pools sharing one store with a node served by the
+// returned server.
+func newMetaPools(t *testing.T, lease time.Duration, n int) ([]*nodePoolImpl, *metaServer) {
+	t.Helper()
+	s := &metaServer{table: map[string]*metadata.Metadata{}}
+	server := httptest.NewServer(s)
+	t.Cleanup(server.Close)
+
+	p := newTestPool(t, testNode{id: "n"})
+	host := strings.TrimPrefix(server.URL, "http://")
+	if err := p.store.Set(context.Background(), datanode.HostKey("n"), host, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	pools := make([]*nodePoolImpl, n)
+	for i := range pools {
+		pools[i] = NewNodePool(p.store, &Config{
+			CacheSize:         DefaultCacheSize,
+			MetadataCacheSize: DefaultCacheSize,
+			MetadataLease:     lease,
+		}).(*nodePoolImpl)
+	}
+	return pools, s
+}
+
+func TestInvalidateOnDelete(t *testing.T) {
+	ctx := context.Background()
+	pools, s := newMetaPools(t, time.Hour, 2)
+	writer, reader := pools[0], pools[1]
+
+	if err := writer.PutMetadata(ctx, "n", &metadata.Metadata{Key: "/a", Source: "s"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reader.GetCachedMetadata(ctx, "n", "/a"); err != nil {
+		t.Fatal(err)
+	}
+	if id := reader.cache.Get("/a"); id != "n" {
+		t.Fatalf("route cached as %q", id)
+	}
+	s.fetched()
+	if _, err := reader.GetCachedMetadata(ctx, "n", "/a"); err != nil {
+		t.Fatal(err)
+	} else if s.fetched() != 0 {
+		t.Fatal("leased metadata was fetched again")
+	}
+
+	if err := writer.DeleteMetadata(ctx, "n", "/a"); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range pools {
+		if id := p.cache.Get("/a"); id != "" {
+			t.Fatalf("route still cached as %q", id)
+		}
+		if meta, _ := p.metaCache.get("n", "/a"); meta != nil {
+			t.Fatalf("metadata still cached: %+v", meta)
+		}
+	}
+	if _, err := reader.GetCachedMetadata(ctx, "n", "/a"); !errors.Is(err, fiber.ErrNotFound) {
+		t.Fatalf("deleted metadata read with %v", err)
+	}
+}
+
+func TestMetadataLease(t *testing.T) {
+	ctx := context.Background()
+	lease := 100 * time.Millisecond
+	pools, s := newMetaPools(t, lease, 2)
+	writer, reader := pools[0], pools[1]
+
+	if err := writer.PutMetadata(ctx, "n", &metadata.Metadata{Key: "/a", Source: "s1"}); err != nil {
+		t.Fatal(err)
+	}
+	read := func(source string, fetched int) {
+		t.Helper()
+		meta, err := reader.GetCachedMetadata(ctx, "n", "/a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if meta.Source != source {
+			t.Fatalf("read source %q, want %q", meta.Source, source)
+		}
+		if n := s.fetched(); n != fetched {
+			t.Fatalf("fetched %d times, want %d", n, fetched)
+		}
+	}
+
+	read("s1", 1)
+	read("s1", 0)
+
+	// an unchanged entry is revalidated once the lease runs out and leased again.
+	time.Sleep(lease)
+	read("s1", 1)
+	read("s1", 0)
+
+	// an overwrite is not published, readers see it when the lease runs out.
+	if err := writer.PutMetadata(ctx, "n", &metadata.Metadata{Key: "/a", Source: "s2"}); err != nil {
+		t.Fatal(err)
+	}
+	read("s1", 0)
+	time.Sleep(lease)
+	read("s2", 1)
+	read("s2", 0)
+
+	// writers never trust the lease.
+	if _, err := reader.GetMetadata(ctx, "n", "/a"); err != nil {
+		t.Fatal(err)
+	} else if s.fetched() != 1 {
+		t.Fatal("GetMetadata answered from the cache")
+	}
+}
@@ -3,15 +3,32 @@ package nodepool
 import (
 	"bytes"
 	"context"
+	"strconv"
 
-	"github.com/goccy/go-json"
 	"github.com/gofiber/fiber/v2"
 	"github.com/pkg/errors"
+	"github.com/qwp0905/go-object-storage/internal/datanode"
 	"github.com/qwp0905/go-object-storage/internal/metadata"
 	"github.com/valyala/fasthttp"
 )
 
+// GetCachedMetadata answers from the cache while the lease of the entry is
+// valid, writers must use GetMetadata.
+func (p *nodePoolImpl) GetCachedMetadata(ctx context.Context, id, key string) (*metadata.Metadata, error) {
+	if p.metaCache != nil {
+		if meta, ok := p.metaCache.get(id, key); ok {
+			return meta, nil
+		}
+	}
+	return p.GetMetadata(ctx, id, key)
+}
+
 func (p *nodePoolImpl) GetMetadata(ctx context.Context, id, key string) (*metadata.Metadata, error) {
+	var cached *metadata.Metadata
+	if p.metaCache != nil {
+		cached, _ = p.metaCache.get(id, key)
+	}
+
 	host, err := p.GetNodeHost(ctx, id)
 	if err != nil {
 		return nil, err
@@ -24,28 +41,56 @@ func (p *nodePoolImpl) GetMetadata(ctx context.Context, id, key string) (*metada
 
 	req.Header.SetMethod(fasthttp.MethodGet)
 	req.SetRequestURI(getMetaHost(host, key))
-	res.StreamBody = true
+	req.Header.Set(fasthttp.HeaderAccept, metadata.ContentType)
+	if cached != nil && cached.Version != 0 {
+		req.Header.Set(fasthttp.HeaderIfNoneMatch, strconv.FormatUint(cached.Version, 10))
+	}
 
 	if err := p.client.Do(req, res); err != nil {
 		return nil, err
 	}
 
-	if res.StatusCode() == fiber.StatusNotFound {
+	if res.StatusCode() == fiber.StatusNotModified && cached != nil {
+		p.metaCache.renew(id, key, cached.Version)
+		return cached, nil
+	} else if res.StatusCode() == fiber.StatusNotFound {
+		p.forget(key)
 		return nil, fiber.ErrNotFound
 	} else if res.StatusCode() >= 400 {
 		return nil, errors.WithStack(errors.Errorf("%s", string(res.Body())))
 	}
 
-	data := new(metadata.Metadata)
-	if err := json.NewDecoder(res.BodyStream()).Decode(data); err != nil {
+	data, err := metadata.Unmarshal(res.Body())
+	if err != nil {
 		return nil, err
 	}
 	p.cache.Set(key, id)
+	if p.metaCache != nil {
+		p.metaCache.set(id, data)
+	}
 
 	return data, nil
 }
 
-func (p *nodePoolImpl) PutMetadata(ctx context.Context, id string, metadata *metadata.Metadata) error {
+func (p *nodePoolImpl) PutMetadata(ctx context.Context, id string, meta *metadata.Metadata) error {
+	return p.putMetadata(ctx, id, meta, "")
+}
+
+func (p *nodePoolImpl) PutMetadataIf(
+	ctx context.Context,
+	id string,
+	meta *metadata.Metadata,
+	version uint64,
+) error {
+	return p.putMetadata(ctx, id, meta, strconv.FormatUint(version, 10))
+}
+
+func (p *nodePoolImpl) putMetadata(
+	ctx context.Context,
+	id string,
+	meta *metadata.Metadata,
+	match string,
+) error {
 	host, err := p.GetNodeHost(ctx, id)
 	if err != nil {
 		return err
@@ -58,12 +103,12 @@ func (p *nodePoolImpl) PutMetadata(ctx context.Context, id string, metadata *met
 
 	req.Header.SetMethod(fasthttp.MethodPut)
 	req.SetRequestURI(getMetaHost(host, ""))
-	req.Header.SetContentType("application/json")
-
-	b, err := json.Marshal(metadata)
-	if err != nil {
-		return errors.WithStack(err)
+	req.Header.SetContentType(metadata.ContentType)
+	if match != "" {
+		req.Header.Set("If-Match", match)
 	}
+
+	b := metadata.Marshal(meta)
 	req.SetBodyStream(bytes.NewReader(b), len(b))
 
 	if err := p.client.Do(req, res); err != nil {
@@ -72,15 +117,38 @@ func (p *nodePoolImpl) PutMetadata(ctx context.Context, id string, metadata *met
 
 	if res.StatusCode() == fiber.StatusNotFound {
 		return fiber.ErrNotFound
+	} else if res.StatusCode() == fiber.StatusConflict {
+		return errors.WithStack(datanode.ErrStaleToken)
+	} else if res.StatusCode() == fiber.StatusPreconditionFailed {
+		p.forget(meta.Key)
+		return errors.WithStack(datanode.ErrVersionMismatch)
 	} else if res.StatusCode() >= 400 {
 		return errors.WithStack(errors.Errorf("%s", string(res.Body())))
 	}
-	p.cache.Set(metadata.Key, id)
+	version, err := strconv.ParseUint(string(res.Header.Peek(fasthttp.HeaderETag)), 10, 64)
+	if err != nil {
+		// the stored version is unknown, so nothing is cached for it.
+		p.forget(meta.Key)
+		return nil
+	}
+	meta.Version = version
+	p.cache.Set(meta.Key, id)
+	if p.metaCache != nil {
+		p.metaCache.set(id, meta)
+	}
 
 	return nil
 }
 
 func (p *nodePoolImpl) DeleteMetadata(ctx context.Context, id, key string) error {
+	return p.deleteMetadata(ctx, id, key, "")
+}
+
+func (p *nodePoolImpl) DeleteMetadataIf(ctx context.Context, id, key string, version uint64) error {
+	return p.deleteMetadata(ctx, id, key, strconv.FormatUint(version, 10))
+}
+
+func (p *nodePoolImpl) deleteMetadata(ctx context.Context, id, key, match string) error {
 	host, err := p.GetNodeHost(ctx, id)
 	if err != nil {
 		return err
@@ -93,6 +161,9 @@ func (p *nodePoolImpl) DeleteMetadata(ctx context.Context, id, key string) error
 
 	req.Header.SetMethod(fasthttp.MethodDelete)
 	req.SetRequestURI(getMetaHost(host, key))
+	if match != "" {
+		req.Header.Set("If-Match", match)
+	}
 	res.StreamBody = true
 
 	if err := p.client.Do(req, res); err != nil {
@@ -101,10 +172,14 @@ func (p *nodePoolImpl) DeleteMetadata(ctx context.Context, id, key string) error
 
 	if res.StatusCode() == fiber.StatusNotFound {
 		return fiber.ErrNotFound
+	} else if res.StatusCode() == fiber.StatusPreconditionFailed {
+		p.forget(key)
+		return errors.WithStack(datanode.ErrVersionMismatch)
 	} else if res.StatusCode() >= 400 {
 		return errors.WithStack(errors.Errorf("%s", string(res.Body())))
 	}
-	p.cache.Del(key)
+	p.forget(key)
+	p.invalidate(ctx, key)
 
 	return nil
 }
@@ -0,0 +1,158 @@
+package nodepool
+
+import (
+	"context"
+	"sort"
+
+	"github.com/goccy/go-json"
+	"github.com/pkg/errors"
+	"github.com/qwp0905/go-object-storage/internal/datanode"
+)
+
+type Topology map[string]map[string][]*NodeInfo
+
+func (p *nodePoolImpl) GetNodes(ctx context.Context) ([]*NodeInfo, error) {
+	ids, err := p.GetNodeIds(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return []*NodeInfo{}, nil
+	}
+	sort.Strings(ids)
+
+	keys := make([]string, 0, len(ids)*3)
+	for _, id := range ids {
+		keys = append(keys, datanode.HostKey(id), datanode.LabelKey(id), StateKey(id))
+	}
+	values, err := p.store.MGet(ctx, keys...)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]*NodeInfo, 0, len(ids))
+	for i, id := range ids {
+		addr := values[i*3]
+		if addr == "" {
+			continue
+		}
+		info := &NodeInfo{Id: id, Addr: addr, State: NodeStateActive}
+		if state := values[i*3+2]; state != "" {
+			info.State = state
+		}
+		if labels := values[i*3+1]; labels != "" {
+			if err := json.Unmarshal([]byte(labels), &info.Labels); err != nil {
+				return nil, errors.WithStack(err)
+			}
+		}
+		out = append(out, info)
+	}
+
+	return out, nil
+}
+
+func (p *nodePoolImpl) GetTopology(ctx context.Context) (Topology, error) {
+	nodes, err := p.GetNodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(Topology)
+	for _, node := range nodes {
+		racks, ok := out[node.Labels.Zone]
+		if !ok {
+			racks = make(map[string][]*NodeInfo)
+			out[node.Labels.Zone] = racks
+		}
+		racks[node.Labels.Rack] = append(racks[node.Labels.Rack], node)
+	}
+
+	return out, nil
+}
+
+// SpreadNodes picks n nodes of class across failure domains. nodes are picked
+// again once every node of class has been.
+func (p *nodePoolImpl) SpreadNodes(ctx context.Context, class string, n int) ([]string, error) {
+	nodes, err := p.getActiveNodes(ctx, class)
+	if err != nil {
+		return nil, err
+	}
+	if len(nodes) == 0 {
+		return nil, errors.Errorf("no %s datanode registered...", class)
+	}
+
+	picked := n
+	if picked > len(nodes) {
+		picked = len(nodes)
+	}
+	ids := spread(nodes, picked, p.counter(len(nodes)))
+	out := make([]string, n)
+	for i := range out {
+		out[i] = ids[i%len(ids)]
+	}
+	return out, nil
+}
+
+type domainCounter struct {
+	zones map[string]int
+	racks map[string]int
+	hosts map[string]int
+}
+
+func newDomainCounter() *domainCounter {
+	return &domainCounter{
+		zones: make(map[string]int),
+		racks: make(map[string]int),
+		hosts: make(map[string]int),
+	}
+}
+
+func (c *domainCounter) add(labels datanode.Labels) {
+	c.zones[labels.Zone]++
+	c.racks[labels.Zone+"/"+labels.Rack]++
+	c.hosts[labels.Zone+"/"+labels.Rack+"/"+labels.Host]++
+}
+
+func (c *domainCounter) score(labels datanode.Labels) [3]int {
+	return [3]int{
+		c.zones[labels.Zone],
+		c.racks[labels.Zone+"/"+labels.Rack],
+		c.hosts[labels.Zone+"/"+labels.Rack+"/"+labels.Host],
+	}
+}
+
+func less(a, b [3]int) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return false
+}
+
+// spread picks n nodes from offset on, preferring the zone, rack and host
+// that were picked the least so far.
+func spread(nodes []*NodeInfo, n, offset int) []string {
+	used := make([]bool, len(nodes))
+	counter := newDomainCounter()
+	out := make([]string, 0, n)
+
+	for len(out) < n {
+		best := -1
+		for j := range nodes {
+			i := (j + offset) % len(nodes)
+			if used[i] {
+				continue
+			}
+			if best == -1 || less(counter.score(nodes[i].Labels), counter.score(nodes[best].Labels)) {
+				best = i
+			}
+		}
+
+		used[best] = true
+		counter.add(nodes[best].Labels)
+		out = append(out, nodes[best].Id)
+	}
+
+	return out
+}
@@ -0,0 +1,148 @@
+package nodepool
+
+import (
+	"context"
+	"testing"
+
+	"github.com/goccy/go-json"
+	"github.com/qwp0905/go-object-storage/internal/datanode"
+	"github.com/qwp0905/go-object-storage/internal/kv"
+	"github.com/qwp0905/go-object-storage/internal/metadata"
+)
+
+type testNode struct {
+	id     string
+	labels datanode.Labels
+	state  string
+}
+
+// newTestPool registers nodes in an in-memory store, data is never sent to
+// them.
+func newTestPool(t *testing.T, nodes ...testNode) *nodePoolImpl {
+	t.Helper()
+	ctx := context.Background()
+	store := kv.NewLocal()
+	for _, node := range nodes {
+		labels, err := json.Marshal(node.labels)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for key, value := range map[string]string{
+			datanode.HostKey(node.id):  node.id + ":8080",
+			datanode.LabelKey(node.id): string(labels),
+			StateKey(node.id):          node.state,
+		} {
+			if value == "" {
+				continue
+			}
+			if err := store.Set(ctx, key, value, 0); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	return NewNodePool(store, &Config{CacheSize: DefaultCacheSize}).(*nodePoolImpl)
+}
+
+func labelsOf(id string) datanode.Labels {
+	for _, node := range zonedNodes {
+		if node.id == id {
+			return node.labels
+		}
+	}
+	return datanode.Labels{}
+}
+
+var zonedNodes = []testNode{
+	{id: "a1", labels: datanode.Labels{Zone: "a", Rack: "1", Host: "h1"}},
+	{id: "a2", labels: datanode.Labels{Zone: "a", Rack: "1", Host: "h2"}},
+	{id: "a3", labels: datanode.Labels{Zone: "a", Rack: "2", Host: "h3"}},
+	{id: "b1", labels: datanode.Labels{Zone: "b", Rack: "1", Host: "h4"}},
+	{id: "b2", labels: datanode.Labels{Zone: "b", Rack: "2", Host: "h5"}},
+	{id: "c1", labels: datanode.Labels{Zone: "c", Rack: "1", Host: "h6"}},
+	{id: "drained", labels: datanode.Labels{Zone: "d", Rack: "1", Host: "h7"}, state: NodeStateDecommissioning},
+	{id: "cold", labels: datanode.Labels{Zone: "d", Rack: "1", Host: "h8", Tier: metadata.StorageClassCold}},
+}
+
+func TestSpread(t *testing.T) {
+	infos := make([]*NodeInfo, 0)
+	for _, node := range zonedNodes[:6] {
+		infos = append(infos, &NodeInfo{Id: node.id, Labels: node.labels})
+	}
+
+	for offset := range infos {
+		ids := spread(infos, 3, offset)
+		zones := map[string]bool{}
+		for _, id := range ids {
+			zones[labelsOf(id).Zone] = true
+		}
+		if len(zones) != 3 {
+			t.Fatalf("offset %d picked %v in %d zones", offset, ids, len(zones))
+		}
+	}
+
+	// zone a is picked again on its other rack first.
+	ids := spread(infos, 5, 0)
+	racks := map[string]bool{}
+	for _, id := range ids {
+		if labelsOf(id).Zone == "a" {
+			racks[labelsOf(id).Rack] = true
+		}
+	}
+	if len(racks) != 2 {
+		t.Fatalf("picked %v, want both racks of zone a", ids)
+	}
+
+	all := map[string]bool{}
+	for _, id := range spread(infos, len(infos), 2) {
+		all[id] = true
+	}
+	if len(all) != len(infos) {
+		t.Fatalf("spread over every node picked %d of them", len(all))
+	}
+}
+
+func TestSpreadNodes(t *testing.T) {
+	ctx := context.Background()
+	p := newTestPool(t, zonedNodes...)
+
+	ids, err := p.SpreadNodes(ctx, metadata.StorageClassStandard, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zones := map[string]bool{}
+	for _, id := range ids {
+		if id == "drained" || id == "cold" {
+			t.Fatalf("picked %s", id)
+		}
+		zones[labelsOf(id).Zone] = true
+	}
+	if len(zones) != 3 {
+		t.Fatalf("picked %v in %d zones", ids, len(zones))
+	}
+
+	// more chunks than nodes go round every node before any is picked twice.
+	ids, err = p.SpreadNodes(ctx, metadata.StorageClassStandard, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := map[string]bool{}
+	for _, id := range ids[:6] {
+		seen[id] = true
+	}
+	if len(seen) != 6 || ids[6] != ids[0] || ids[7] != ids[1] {
+		t.Fatalf("picked %v", ids)
+	}
+
+	ids, err = p.SpreadNodes(ctx, metadata.StorageClassCold, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids[0] != "cold" || ids[1] != "cold" {
+		t.Fatalf("cold chunks are placed on %v", ids)
+	}
+
+	empty := newTestPool(t, zonedNodes[:1]...)
+	if _, err := empty.SpreadNodes(ctx, metadata.StorageClassCold, 1); err == nil {
+		t.Fatal("cold chunks are placed without a cold node")
+	}
+}
@@ -3,12 +3,15 @@ package nodepool
 import (
 	"context"
 	"io"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/qwp0905/go-object-storage/internal/datanode"
+	"github.com/qwp0905/go-object-storage/internal/filesystem"
+	"github.com/qwp0905/go-object-storage/internal/kv"
 	"github.com/qwp0905/go-object-storage/internal/metadata"
+	"github.com/qwp0905/go-object-storage/pkg/logger"
 	"github.com/qwp0905/go-object-storage/pkg/nocopy"
-	"github.com/redis/go-redis/v9"
 	"github.com/valyala/fasthttp"
 )
 
@@ -17,39 +20,102 @@ type NodePool interface {
 	GetNodeHost(ctx context.Context, id string) (string, error)
 	GetNodeIds(ctx context.Context) ([]string, error)
 	AcquireNode(ctx context.Context) (string, error)
+	AcquireTierNode(ctx context.Context, class string) (string, error)
+	SpreadNodes(ctx context.Context, class string, n int) ([]string, error)
+	GetNodes(ctx context.Context) ([]*NodeInfo, error)
+	GetTopology(ctx context.Context) (Topology, error)
+	GetNodeState(ctx context.Context, id string) (string, error)
+	SetNodeState(ctx context.Context, id, state string) error
+	ListMetadata(ctx context.Context, id string) ([]*filesystem.FileInfo, error)
+	ListObjects(ctx context.Context, id string) ([]*filesystem.FileInfo, error)
+	GetNodeUsage(ctx context.Context, id string) (*filesystem.Usage, error)
 	GetMetadata(ctx context.Context, id, key string) (*metadata.Metadata, error)
+	GetCachedMetadata(ctx context.Context, id, key string) (*metadata.Metadata, error)
 	PutMetadata(ctx context.Context, id string, metadata *metadata.Metadata) error
+	PutMetadataIf(ctx context.Context, id string, metadata *metadata.Metadata, version uint64) error
 	DeleteMetadata(ctx context.Context, id, key string) error
+	DeleteMetadataIf(ctx context.Context, id, key string, version uint64) error
 	PutDirect(ctx context.Context, metadata *metadata.Metadata, r io.Reader) error
 	GetDirect(ctx context.Context, metadata *metadata.Metadata) (io.Reader, error)
+	GetDirectRange(ctx context.Context, metadata *metadata.Metadata, offset, length int64) (io.Reader, error)
 	DeleteDirect(ctx context.Context, metadata *metadata.Metadata) error
+	DeleteDirectBatch(ctx context.Context, id string, sources []string) error
+	LinkDirect(ctx context.Context, metadata *metadata.Metadata) error
+	CopyDirect(ctx context.Context, metadata *metadata.Metadata, source string) error
+	PurgeDirect(ctx context.Context, metadata *metadata.Metadata) error
+	FindContent(ctx context.Context, source, class string) (string, error)
+	IndexContent(ctx context.Context, source, id string) error
 }
 
 type nodePoolImpl struct {
-	noCopy  nocopy.NoCopy
-	client  *fasthttp.Client
-	counter func(int) int
-	rc      *redis.Client
-	cache   Cache
+	noCopy    nocopy.NoCopy
+	client    *fasthttp.Client
+	counter   func(int) int
+	store     kv.Store
+	cache     Cache
+	metaCache *metadataCache
 }
 
 type NodeInfo struct {
-	Id string `json:"id"`
+	Id     string          `json:"id"`
+	Addr   string          `json:"addr"`
+	Labels datanode.Labels `json:"labels"`
+	State  string          `json:"state"`
 }
 
-func NewNodePool(rc *redis.Client) NodePool {
-	return &nodePoolImpl{
+const DefaultCacheSize = 100
+
+type Config struct {
+	CacheSize int
+	// decoded metadata kept for reads, disabled when zero.
+	MetadataCacheSize int
+	MetadataLease     time.Duration
+}
+
+// keys of deleted or relocated metadata are published on this channel so
+// every process sharing the store drops them from its cache.
+const invalidateChannel = "nodepool:invalidate"
+
+func NewNodePool(store kv.Store, config *Config) NodePool {
+	p := &nodePoolImpl{
 		client:  &fasthttp.Client{MaxConnsPerHost: 1024},
 		counter: counter(),
-		rc:      rc,
-		cache:   NewCache(100),
+		store:   store,
+		cache:   NewCache(config.CacheSize),
+	}
+	if config.MetadataCacheSize > 0 {
+		p.metaCache = newMetadataCache(config.MetadataCacheSize, config.MetadataLease)
+	}
+
+	if sub, ok := store.(kv.Subscriber); ok {
+		if err := sub.Subscribe(context.Background(), invalidateChannel, p.forget); err != nil {
+			logger.Warnf("cache invalidation disabled: %+v", err)
+		}
+	}
+	return p
+}
+
+func (p *nodePoolImpl) forget(key string) {
+	p.cache.Del(key)
+	if p.metaCache != nil {
+		p.metaCache.del(key)
+	}
+}
+
+func (p *nodePoolImpl) invalidate(ctx context.Context, key string) {
+	publisher, ok := p.store.(kv.Publisher)
+	if !ok {
+		return
+	}
+	if err := publisher.Publish(ctx, invalidateChannel, key); err != nil {
+		logger.Warnf("%+v", err)
 	}
 }
 
 func (p *nodePoolImpl) GetNodeIds(ctx context.Context) ([]string, error) {
-	ids, err := p.rc.Keys(ctx, datanode.HostKey("*")).Result()
+	ids, err := p.store.Keys(ctx, datanode.HostKey(""))
 	if err != nil {
-		return nil, errors.WithStack(err)
+		return nil, err
 	}
 
 	for i := range ids {
@@ -59,25 +125,35 @@ func (p *nodePoolImpl) GetNodeIds(ctx context.Context) ([]string, error) {
 }
 
 func (p *nodePoolImpl) GetNodeHost(ctx context.Context, id string) (string, error) {
-	host, err := p.rc.Get(ctx, datanode.HostKey(id)).Result()
+	host, err := p.store.Get(ctx, datanode.HostKey(id))
 	if err != nil {
-		return "", errors.WithStack(err)
+		return "", err
 	}
 
 	return host, nil
 }
 
+// AcquireNode picks a standard node, which holds metadata and hot objects.
 func (p *nodePoolImpl) AcquireNode(ctx context.Context) (string, error) {
-	ids, err := p.rc.Keys(ctx, datanode.HostKey("*")).Result()
+	return p.AcquireTierNode(ctx, metadata.StorageClassStandard)
+}
+
+// AcquireTierNode picks nodes of class round robin in spread order, so
+// consecutive placements land in different failure domains.
+func (p *nodePoolImpl) AcquireTierNode(ctx context.Context, class string) (string, error) {
+	nodes, err := p.getActiveNodes(ctx, class)
 	if err != nil {
-		return "", errors.WithStack(err)
+		return "", err
 	}
 
-	if len(ids) == 0 {
+	if len(nodes) == 0 {
+		if class != metadata.StorageClassStandard {
+			return "", errors.Errorf("no %s datanode registered...", class)
+		}
 		return "", errors.New("no datanode registered...")
 	}
 
-	return datanode.IdFromKey(ids[p.counter(len(ids))]), nil
+	return spread(nodes, len(nodes), 0)[p.counter(len(nodes))], nil
 }
 
 func (p *nodePoolImpl) FindInCache(key string) (string, string) {
@@ -0,0 +1,562 @@
+// Package pooltest keeps a cluster of datanodes in memory behind the NodePool
+// interface, for tests of the packages that work on the trie.
+package pooltest
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pkg/errors"
+	"github.com/qwp0905/go-object-storage/internal/datanode"
+	"github.com/qwp0905/go-object-storage/internal/filesystem"
+	"github.com/qwp0905/go-object-storage/internal/metadata"
+	"github.com/qwp0905/go-object-storage/internal/nodepool"
+)
+
+// FailFunc runs before every call that reaches a node, an error it returns
+// fails the call. it may call the pool itself.
+type FailFunc func(method, id, key string) error
+
+type file struct {
+	data     []byte
+	modified time.Time
+}
+
+type node struct {
+	info *nodepool.NodeInfo
+	meta map[string]*file
+	data map[string]*file
+	// references of content addressed data, like the datanode counts them.
+	refs map[string]uint64
+}
+
+type Pool struct {
+	mu      sync.Mutex
+	nodes   map[string]*node
+	ids     []string
+	next    int
+	version uint64
+	content map[string]string
+	fail    FailFunc
+}
+
+var _ nodepool.NodePool = (*Pool)(nil)
+
+// New returns a pool of standard nodes with the given ids.
+func New(ids ...string) *Pool {
+	p := &Pool{nodes: make(map[string]*node), content: make(map[string]string)}
+	for _, id := range ids {
+		p.AddNode(id, datanode.Labels{Tier: metadata.StorageClassStandard})
+	}
+	return p
+}
+
+func (p *Pool) AddNode(id string, labels datanode.Labels) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.nodes[id] = &node{
+		info: &nodepool.NodeInfo{Id: id, Addr: id, Labels: labels, State: nodepool.NodeStateActive},
+		meta: make(map[string]*file),
+		data: make(map[string]*file),
+		refs: make(map[string]uint64),
+	}
+	p.ids = append(p.ids, id)
+	sort.Strings(p.ids)
+}
+
+// Fail sets the function every call goes through, nil clears it.
+func (p *Pool) Fail(fn FailFunc) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.fail = fn
+}
+
+// Data returns what a node keeps under source.
+func (p *Pool) Data(id, source string) ([]byte, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n, ok := p.nodes[id]
+	if !ok {
+		return nil, false
+	}
+	f, ok := n.data[source]
+	if !ok {
+		return nil, false
+	}
+	return f.data, true
+}
+
+// Refs returns the references a node counts for source.
+func (p *Pool) Refs(id, source string) uint64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if n, ok := p.nodes[id]; ok {
+		return n.refs[source]
+	}
+	return 0
+}
+
+// Files returns the number of data files and metadata files kept on all nodes.
+func (p *Pool) Files() (int, int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	data, meta := 0, 0
+	for _, n := range p.nodes {
+		data += len(n.data)
+		meta += len(n.meta)
+	}
+	return data, meta
+}
+
+// Age moves the modification time of every file back by d, so maintenance
+// does not skip them as recent.
+func (p *Pool) Age(d time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, n := range p.nodes {
+		for _, files := range []map[string]*file{n.meta, n.data} {
+			for _, f := range files {
+				f.modified = f.modified.Add(-d)
+			}
+		}
+	}
+}
+
+// call finds the node a call goes to, the pool is locked when it returns
+// without error.
+func (p *Pool) call(method, id, key string) (*node, error) {
+	p.mu.Lock()
+	fail := p.fail
+	p.mu.Unlock()
+	if fail != nil {
+		if err := fail(method, id, key); err != nil {
+			return nil, err
+		}
+	}
+
+	p.mu.Lock()
+	n, ok := p.nodes[id]
+	if !ok {
+		p.mu.Unlock()
+		return nil, errors.Errorf("no datanode %s", id)
+	}
+	return n, nil
+}
+
+func (p *Pool) FindInCache(key string) (string, string) {
+	return "", ""
+}
+
+func (p *Pool) GetNodeHost(ctx context.Context, id string) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.nodes[id]; !ok {
+		return "", fiber.ErrNotFound
+	}
+	return id, nil
+}
+
+func (p *Pool) GetNodeIds(ctx context.Context) ([]string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.ids...), nil
+}
+
+func (p *Pool) AcquireNode(ctx context.Context) (string, error) {
+	return p.AcquireTierNode(ctx, metadata.StorageClassStandard)
+}
+
+func (p *Pool) AcquireTierNode(ctx context.Context, class string) (string, error) {
+	ids, err := p.SpreadNodes(ctx, class, 1)
+	if err != nil {
+		return "", err
+	}
+	return ids[0], nil
+}
+
+// SpreadNodes picks nodes of class round robin, there are no failure domains.
+func (p *Pool) SpreadNodes(ctx context.Context, class string, n int) ([]string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	active := make([]string, 0)
+	for _, id := range p.ids {
+		info := p.nodes[id].info
+		if info.State == nodepool.NodeStateActive && info.Labels.StorageClass() == class {
+			active = append(active, id)
+		}
+	}
+	if len(active) == 0 {
+		return nil, errors.Errorf("no %s datanode registered...", class)
+	}
+
+	out := make([]string, n)
+	for i := range out {
+		out[i] = active[p.next%len(active)]
+		p.next++
+	}
+	return out, nil
+}
+
+func (p *Pool) GetNodes(ctx context.Context) ([]*nodepool.NodeInfo, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	out := make([]*nodepool.NodeInfo, len(p.ids))
+	for i, id := range p.ids {
+		info := *p.nodes[id].info
+		out[i] = &info
+	}
+	return out, nil
+}
+
+func (p *Pool) GetTopology(ctx context.Context) (nodepool.Topology, error) {
+	nodes, _ := p.GetNodes(ctx)
+	out := make(nodepool.Topology)
+	for _, info := range nodes {
+		if out[info.Labels.Zone] == nil {
+			out[info.Labels.Zone] = make(map[string][]*nodepool.NodeInfo)
+		}
+		out[info.Labels.Zone][info.Labels.Rack] = append(out[info.Labels.Zone][info.Labels.Rack], info)
+	}
+	return out, nil
+}
+
+func (p *Pool) GetNodeState(ctx context.Context, id string) (string, error) {
+	n, err := p.call("GetNodeState", id, "")
+	if err != nil {
+		return "", err
+	}
+	defer p.mu.Unlock()
+	return n.info.State, nil
+}
+
+func (p *Pool) SetNodeState(ctx context.Context, id, state string) error {
+	n, err := p.call("SetNodeState", id, "")
+	if err != nil {
+		return err
+	}
+	defer p.mu.Unlock()
+	n.info.State = state
+	return nil
+}
+
+func list(files map[string]*file) []*filesystem.FileInfo {
+	out := make([]*filesystem.FileInfo, 0, len(files))
+	for name, f := range files {
+		out = append(out, &filesystem.FileInfo{Name: name, Size: int64(len(f.data)), LastModified: f.modified})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+func (p *Pool) ListMetadata(ctx context.Context, id string) ([]*filesystem.FileInfo, error) {
+	n, err := p.call("ListMetadata", id, "")
+	if err != nil {
+		return nil, err
+	}
+	defer p.mu.Unlock()
+	return list(n.meta), nil
+}
+
+func (p *Pool) ListObjects(ctx context.Context, id string) ([]*filesystem.FileInfo, error) {
+	n, err := p.call("ListObjects", id, "")
+	if err != nil {
+		return nil, err
+	}
+	defer p.mu.Unlock()
+	return list(n.data), nil
+}
+
+func (p *Pool) GetNodeUsage(ctx context.Context, id string) (*filesystem.Usage, error) {
+	n, err := p.call("GetNodeUsage", id, "")
+	if err != nil {
+		return nil, err
+	}
+	defer p.mu.Unlock()
+	usage := &filesystem.Usage{Total: 1 << 30, Free: 1 << 30}
+	for _, f := range n.data {
+		usage.Free -= uint64(len(f.data))
+	}
+	return usage, nil
+}
+
+func (p *Pool) GetMetadata(ctx context.Context, id, key string) (*metadata.Metadata, error) {
+	n, err := p.call("GetMetadata", id, key)
+	if err != nil {
+		return nil, err
+	}
+	defer p.mu.Unlock()
+	f, ok := n.meta[key]
+	if !ok {
+		return nil, fiber.ErrNotFound
+	}
+	return metadata.Unmarshal(f.data)
+}
+
+func (p *Pool) GetCachedMetadata(ctx context.Context, id, key string) (*metadata.Metadata, error) {
+	return p.GetMetadata(ctx, id, key)
+}
+
+func (p *Pool) PutMetadata(ctx context.Context, id string, meta *metadata.Metadata) error {
+	return p.putMetadata(id, meta, nil)
+}
+
+func (p *Pool) PutMetadataIf(ctx context.Context, id string, meta *metadata.Metadata, version uint64) error {
+	return p.putMetadata(id, meta, &version)
+}
+
+// putMetadata checks fences and versions the way the datanode does.
+func (p *Pool) putMetadata(id string, meta *metadata.Metadata, expected *uint64) error {
+	n, err := p.call("PutMetadata", id, meta.Key)
+	if err != nil {
+		return err
+	}
+	defer p.mu.Unlock()
+
+	version := uint64(0)
+	if f, ok := n.meta[meta.Key]; ok {
+		current, err := metadata.Unmarshal(f.data)
+		if err != nil {
+			return err
+		}
+		if current.Fence > meta.Fence {
+			return errors.WithStack(datanode.ErrStaleToken)
+		}
+		version = current.Version
+	}
+	if expected != nil && *expected != version {
+		return errors.WithStack(datanode.ErrVersionMismatch)
+	}
+
+	p.version++
+	meta.Version = p.version
+	n.meta[meta.Key] = &file{data: metadata.Marshal(meta), modified: time.Now()}
+	return nil
+}
+
+func (p *Pool) DeleteMetadata(ctx context.Context, id, key string) error {
+	n, err := p.call("DeleteMetadata", id, key)
+	if err != nil {
+		return err
+	}
+	defer p.mu.Unlock()
+	if _, ok := n.meta[key]; !ok {
+		return fiber.ErrNotFound
+	}
+	delete(n.meta, key)
+	return nil
+}
+
+func (p *Pool) DeleteMetadataIf(ctx context.Context, id, key string, version uint64) error {
+	n, err := p.call("DeleteMetadata", id, key)
+	if err != nil {
+		return err
+	}
+	defer p.mu.Unlock()
+	f, ok := n.meta[key]
+	if !ok {
+		return errors.WithStack(datanode.ErrVersionMismatch)
+	}
+	current, err := metadata.Unmarshal(f.data)
+	if err != nil {
+		return err
+	}
+	if current.Version != version {
+		return errors.WithStack(datanode.ErrVersionMismatch)
+	}
+	delete(n.meta, key)
+	return nil
+}
+
+func (p *Pool) PutDirect(ctx context.Context, meta *metadata.Metadata, r io.Reader) error {
+	parts := meta.Parts()
+	for i, part := range parts {
+		var body io.Reader = r
+		if meta.Chunked() {
+			body = io.LimitReader(r, int64(part.Size))
+		}
+		if err := p.putFile(part, body); err != nil {
+			for _, written := range parts[:i] {
+				p.DeleteDirect(ctx, written)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *Pool) putFile(part *metadata.Metadata, r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	n, err := p.call("PutDirect", part.NodeId, part.Source)
+	if err != nil {
+		return err
+	}
+	defer p.mu.Unlock()
+	if !part.Shared() {
+		n.data[part.Source] = &file{data: data, modified: time.Now()}
+		return nil
+	}
+	if n.refs[part.Source] == 0 {
+		n.data[part.Source] = &file{data: data, modified: time.Now()}
+	}
+	n.refs[part.Source]++
+	return nil
+}
+
+func (p *Pool) GetDirect(ctx context.Context, meta *metadata.Metadata) (io.Reader, error) {
+	return p.GetDirectRange(ctx, meta, 0, -1)
+}
+
+func (p *Pool) GetDirectRange(ctx context.Context, meta *metadata.Metadata, offset, length int64) (io.Reader, error) {
+	data := make([]byte, 0, meta.StoredSize())
+	for _, part := range meta.Parts() {
+		n, err := p.call("GetDirect", part.NodeId, part.Source)
+		if err != nil {
+			return nil, err
+		}
+		f, ok := n.data[part.Source]
+		p.mu.Unlock()
+		if !ok {
+			return nil, fiber.ErrNotFound
+		}
+		data = append(data, f.data...)
+	}
+
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	data = data[offset:]
+	if length >= 0 && length < int64(len(data)) {
+		data = data[:length]
+	}
+	return bytes.NewReader(data), nil
+}
+
+// DeleteDirect drops a reference of shared data and removes other data.
+func (p *Pool) DeleteDirect(ctx context.Context, meta *metadata.Metadata) error {
+	var out error
+	for _, part := range meta.Parts() {
+		err := p.deleteFile(part.NodeId, part.Source, false)
+		if meta.Chunked() && errors.Is(err, fiber.ErrNotFound) {
+			continue
+		}
+		if err != nil && out == nil {
+			out = err
+		}
+	}
+	return out
+}
+
+func (p *Pool) deleteFile(id, source string, purge bool) error {
+	n, err := p.call("DeleteDirect", id, source)
+	if err != nil {
+		return err
+	}
+	defer p.mu.Unlock()
+	if _, ok := n.data[source]; !ok {
+		return fiber.ErrNotFound
+	}
+	if metadata.IsContentSource(source) && !purge && n.refs[source] > 1 {
+		n.refs[source]--
+		return nil
+	}
+	delete(n.data, source)
+	delete(n.refs, source)
+	if p.content[source] == id {
+		delete(p.content, source)
+	}
+	return nil
+}
+
+func (p *Pool) DeleteDirectBatch(ctx context.Context, id string, sources []string) error {
+	var out error
+	for _, source := range sources {
+		if err := p.deleteFile(id, source, false); err != nil && out == nil {
+			out = err
+		}
+	}
+	return out
+}
+
+func (p *Pool) LinkDirect(ctx context.Context, meta *metadata.Metadata) error {
+	if !meta.Shared() {
+		return datanode.ErrNotShared
+	}
+	n, err := p.call("LinkDirect", meta.NodeId, meta.Source)
+	if err != nil {
+		return err
+	}
+	defer p.mu.Unlock()
+	if n.refs[meta.Source] == 0 {
+		return fiber.ErrNotFound
+	}
+	n.refs[meta.Source]++
+	return nil
+}
+
+func (p *Pool) CopyDirect(ctx context.Context, meta *metadata.Metadata, source string) error {
+	to := *meta
+	to.Source = source
+	targets := to.Parts()
+	for i, part := range meta.Parts() {
+		if err := p.copyFile(part, targets[i].Source); err != nil {
+			for _, copied := range targets[:i] {
+				p.DeleteDirect(ctx, copied)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *Pool) copyFile(part *metadata.Metadata, to string) error {
+	if part.Shared() || metadata.IsContentSource(to) {
+		return datanode.ErrCopyShared
+	}
+	n, err := p.call("CopyDirect", part.NodeId, part.Source)
+	if err != nil {
+		return err
+	}
+	defer p.mu.Unlock()
+	f, ok := n.data[part.Source]
+	if !ok {
+		return fiber.ErrNotFound
+	}
+	n.data[to] = &file{data: f.data, modified: time.Now()}
+	return nil
+}
+
+func (p *Pool) PurgeDirect(ctx context.Context, meta *metadata.Metadata) error {
+	if meta.Chunked() {
+		return p.DeleteDirect(ctx, meta)
+	}
+	return p.deleteFile(meta.NodeId, meta.Source, true)
+}
+
+func (p *Pool) FindContent(ctx context.Context, source, class string) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	id, ok := p.content[source]
+	if !ok {
+		return "", nil
+	}
+	if info := p.nodes[id].info; info.State != nodepool.NodeStateActive || info.Labels.StorageClass() != class {
+		return "", nil
+	}
+	return id, nil
+}
+
+func (p *Pool) IndexContent(ctx context.Context, source, id string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.content[source] = id
+	return nil
+}
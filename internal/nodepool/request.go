@@ -4,8 +4,10 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"net/url"
 	"sync"
 
+	"github.com/goccy/go-json"
 	"github.com/gofiber/fiber/v2"
 	"github.com/pkg/errors"
 	"github.com/qwp0905/go-object-storage/internal/metadata"
@@ -18,14 +20,34 @@ func counter() func(int) int {
 	return func(max int) int {
 		mu.Lock()
 		defer mu.Unlock()
-		if i == max {
+		if i >= max {
 			i = 0
 		}
-		return i
+		i++
+		return i - 1
 	}
 }
 
+// PutDirect writes the data of meta, chunk by chunk when it is chunked. chunks
+// already written are removed again when one fails.
 func (p *nodePoolImpl) PutDirect(ctx context.Context, meta *metadata.Metadata, r io.Reader) error {
+	if !meta.Chunked() {
+		return p.putFile(ctx, meta, r)
+	}
+
+	parts := meta.Parts()
+	for i, part := range parts {
+		if err := p.putFile(ctx, part, io.LimitReader(r, int64(part.Size))); err != nil {
+			for _, written := range parts[:i] {
+				p.DeleteDirect(ctx, written)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *nodePoolImpl) putFile(ctx context.Context, meta *metadata.Metadata, r io.Reader) error {
 	host, err := p.GetNodeHost(ctx, meta.NodeId)
 	if err != nil {
 		return err
@@ -37,7 +59,7 @@ func (p *nodePoolImpl) PutDirect(ctx context.Context, meta *metadata.Metadata, r
 
 	req.Header.SetMethod(fasthttp.MethodPut)
 	req.SetRequestURI(getDataHost(host, meta.Source))
-	req.SetBodyStream(r, int(meta.Size))
+	req.SetBodyStream(r, int(meta.StoredSize()))
 
 	if err := p.client.Do(req, res); err != nil {
 		return errors.WithStack(err)
@@ -53,6 +75,20 @@ func (p *nodePoolImpl) PutDirect(ctx context.Context, meta *metadata.Metadata, r
 }
 
 func (p *nodePoolImpl) GetDirect(ctx context.Context, metadata *metadata.Metadata) (io.Reader, error) {
+	return p.GetDirectRange(ctx, metadata, 0, -1)
+}
+
+// GetDirectRange reads length bytes of stored data from offset, or up to the
+// end when length is negative.
+func (p *nodePoolImpl) GetDirectRange(
+	ctx context.Context,
+	metadata *metadata.Metadata,
+	offset, length int64,
+) (io.Reader, error) {
+	if metadata.Chunked() {
+		return p.readChunks(ctx, metadata, offset, length)
+	}
+
 	host, err := p.GetNodeHost(ctx, metadata.NodeId)
 	if err != nil {
 		return nil, err
@@ -64,6 +100,7 @@ func (p *nodePoolImpl) GetDirect(ctx context.Context, metadata *metadata.Metadat
 
 	req.Header.SetMethod(fasthttp.MethodGet)
 	req.SetRequestURI(getDataHost(host, metadata.Source))
+	setRange(req, offset, length)
 	res.StreamBody = true
 
 	if err := p.client.Do(req, res); err != nil {
@@ -76,10 +113,72 @@ func (p *nodePoolImpl) GetDirect(ctx context.Context, metadata *metadata.Metadat
 		return nil, errors.WithStack(errors.Errorf("%s", string(res.Body())))
 	}
 
-	return res.BodyStream(), nil
+	// the stream is closed when the response is released, a request it is
+	// passed to as body must not close it before.
+	return struct{ io.Reader }{res.BodyStream()}, nil
+}
+
+func setRange(req *fasthttp.Request, offset, length int64) {
+	if length >= 0 {
+		req.Header.Set(fasthttp.HeaderRange, fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	} else if offset > 0 {
+		req.Header.Set(fasthttp.HeaderRange, fmt.Sprintf("bytes=%d-", offset))
+	}
 }
 
 func (p *nodePoolImpl) DeleteDirect(ctx context.Context, metadata *metadata.Metadata) error {
+	if metadata.Chunked() {
+		return p.deleteChunks(ctx, metadata)
+	}
+	return p.dataRequest(ctx, fasthttp.MethodDelete, metadata, "")
+}
+
+// PurgeDirect removes shared data along with every reference it counts.
+func (p *nodePoolImpl) PurgeDirect(ctx context.Context, metadata *metadata.Metadata) error {
+	if metadata.Chunked() {
+		return p.deleteChunks(ctx, metadata)
+	}
+	return p.dataRequest(ctx, fasthttp.MethodDelete, metadata, "?purge=true")
+}
+
+// deleteChunks removes every chunk it can, chunks already gone are skipped.
+func (p *nodePoolImpl) deleteChunks(ctx context.Context, meta *metadata.Metadata) error {
+	var out error
+	for _, part := range meta.Parts() {
+		err := p.dataRequest(ctx, fasthttp.MethodDelete, part, "")
+		if err != nil && !errors.Is(err, fiber.ErrNotFound) && out == nil {
+			out = err
+		}
+	}
+	return out
+}
+
+// LinkDirect adds a reference to shared data the node already holds, not found
+// when it does not.
+func (p *nodePoolImpl) LinkDirect(ctx context.Context, metadata *metadata.Metadata) error {
+	return p.dataRequest(ctx, fasthttp.MethodPost, metadata, "/link")
+}
+
+// CopyDirect copies the data of meta to source on the nodes already holding
+// it, chunk by chunk when it is chunked. copies already made are removed again
+// when one fails.
+func (p *nodePoolImpl) CopyDirect(ctx context.Context, meta *metadata.Metadata, source string) error {
+	to := *meta
+	to.Source = source
+	targets := to.Parts()
+	for i, part := range meta.Parts() {
+		suffix := "/copy?to=" + url.QueryEscape(targets[i].Source)
+		if err := p.dataRequest(ctx, fasthttp.MethodPost, part, suffix); err != nil {
+			for _, copied := range targets[:i] {
+				p.DeleteDirect(ctx, copied)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *nodePoolImpl) dataRequest(ctx context.Context, method string, metadata *metadata.Metadata, suffix string) error {
 	host, err := p.GetNodeHost(ctx, metadata.NodeId)
 	if err != nil {
 		return err
@@ -90,8 +189,8 @@ func (p *nodePoolImpl) DeleteDirect(ctx context.Context, metadata *metadata.Meta
 	res := fasthttp.AcquireResponse()
 	defer fasthttp.ReleaseResponse(res)
 
-	req.Header.SetMethod(fasthttp.MethodDelete)
-	req.SetRequestURI(getDataHost(host, metadata.Source))
+	req.Header.SetMethod(method)
+	req.SetRequestURI(getDataHost(host, metadata.Source) + suffix)
 
 	if err := p.client.Do(req, res); err != nil {
 		return errors.WithStack(err)
@@ -106,6 +205,38 @@ func (p *nodePoolImpl) DeleteDirect(ctx context.Context, metadata *metadata.Meta
 	return nil
 }
 
+// DeleteDirectBatch removes many objects of one datanode in a single request.
+func (p *nodePoolImpl) DeleteDirectBatch(ctx context.Context, id string, sources []string) error {
+	host, err := p.GetNodeHost(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(sources)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	res := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(res)
+
+	req.Header.SetMethod(fasthttp.MethodPost)
+	req.Header.SetContentType(fiber.MIMEApplicationJSON)
+	req.SetRequestURI(fmt.Sprintf("http://%s/data/delete", host))
+	req.SetBody(body)
+
+	if err := p.client.Do(req, res); err != nil {
+		return errors.WithStack(err)
+	}
+	if res.StatusCode() >= 400 {
+		return errors.WithStack(errors.Errorf("%s", string(res.Body())))
+	}
+
+	return nil
+}
+
 func release(ctx context.Context, res *fasthttp.Response) {
 	defer fasthttp.ReleaseResponse(res)
 	<-ctx.Done()
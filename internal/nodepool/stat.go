@@ -0,0 +1,40 @@
+package nodepool
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/goccy/go-json"
+	"github.com/pkg/errors"
+	"github.com/qwp0905/go-object-storage/internal/filesystem"
+	"github.com/valyala/fasthttp"
+)
+
+func (p *nodePoolImpl) GetNodeUsage(ctx context.Context, id string) (*filesystem.Usage, error) {
+	host, err := p.GetNodeHost(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	res := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(res)
+
+	req.Header.SetMethod(fasthttp.MethodGet)
+	req.SetRequestURI(fmt.Sprintf("http://%s/stat", host))
+
+	if err := p.client.Do(req, res); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if res.StatusCode() >= 400 {
+		return nil, errors.WithStack(errors.Errorf("%s", string(res.Body())))
+	}
+
+	usage := new(filesystem.Usage)
+	if err := json.Unmarshal(res.Body(), usage); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return usage, nil
+}
@@ -0,0 +1,55 @@
+package nodepool
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pkg/errors"
+)
+
+const (
+	NodeStateActive          = "active"
+	NodeStateDecommissioning = "decommissioning"
+	NodeStateDecommissioned  = "decommissioned"
+)
+
+func StateKey(id string) string {
+	return fmt.Sprintf("STATE:%s", id)
+}
+
+func (p *nodePoolImpl) SetNodeState(ctx context.Context, id, state string) error {
+	if state == NodeStateActive {
+		return p.store.Del(ctx, StateKey(id))
+	}
+	return p.store.Set(ctx, StateKey(id), state, 0)
+}
+
+// GetNodeState returns the state of a node, active when none is recorded.
+func (p *nodePoolImpl) GetNodeState(ctx context.Context, id string) (string, error) {
+	state, err := p.store.Get(ctx, StateKey(id))
+	if errors.Is(err, fiber.ErrNotFound) {
+		return NodeStateActive, nil
+	} else if err != nil {
+		return "", err
+	}
+	return state, nil
+}
+
+// getActiveNodes returns the active nodes of the given storage class.
+func (p *nodePoolImpl) getActiveNodes(ctx context.Context, class string) ([]*NodeInfo, error) {
+	nodes, err := p.GetNodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]*NodeInfo, 0, len(nodes))
+	for _, node := range nodes {
+		if node.State != NodeStateActive || node.Labels.StorageClass() != class {
+			continue
+		}
+		out = append(out, node)
+	}
+
+	return out, nil
+}
@@ -1,210 +1,645 @@
 package replication
 
 import (
-	"fmt"
+	"context"
 	"math/rand"
 	"sync"
 	"time"
 
-	"github.com/goccy/go-json"
 	"github.com/gofiber/fiber/v2"
 	"github.com/pkg/errors"
 	"github.com/qwp0905/go-object-storage/pkg/logger"
-	"github.com/valyala/fasthttp"
+	"github.com/qwp0905/go-object-storage/pkg/nocopy"
 )
 
 const (
 	stateLeader    = "leader"
 	stateFollower  = "follower"
 	stateCandidate = "candidate"
+
+	maxEntriesPerAppend = 256
 )
 
+var ErrNotLeader = fiber.NewError(fiber.StatusMisdirectedRequest, "not a leader")
+
+// StateMachine applies committed logs. Snapshot captures everything applied so
+// far so that the log behind it can be dropped, Restore replaces the state.
+type StateMachine interface {
+	Apply(log *Log) any
+	Snapshot() ([]byte, error)
+	Restore(data []byte) error
+}
+
 type Cluster interface {
-	StartReplication()
-	Voting(state *VoteState) error
-	Heartbeat()
+	Start()
+	Stop()
+	Propose(ctx context.Context, log *Log) (any, error)
+	RequestVote(req *VoteRequest) (*VoteResponse, error)
+	AppendEntries(req *AppendRequest) (*AppendResponse, error)
+	InstallSnapshot(req *SnapshotRequest) (*SnapshotResponse, error)
+	IsLeader() bool
+	LeaderAddr() string
 	Quorum() int
 }
 
-type Heartbeat struct {
-	Term uint64 `json:"term"`
-	Log  *Log   `json:"log,omitempty"`
+type Config struct {
+	Addr              string
+	Peers             []string
+	ElectionTimeout   time.Duration
+	HeartbeatInterval time.Duration
+	SnapshotThreshold uint64
 }
 
-type VoteState struct {
-	Term      uint64 `json:"term"`
-	LastIndex uint64 `json:"last_index"`
+type result struct {
+	value any
+	err   error
 }
 
-type clusterImpl struct {
-	log       *logStoreImpl
-	term      uint64
-	heartbeat chan struct{}
-	state     string
-	hosts     []string
+type waiter struct {
+	term uint64
+	ch   chan *result
 }
 
-func NewCluster() Cluster {
-	return &clusterImpl{}
+type clusterImpl struct {
+	noCopy      nocopy.NoCopy
+	config      *Config
+	log         LogStore
+	transport   Transport
+	sm          StateMachine
+	mu          *sync.Mutex
+	applyMu     *sync.Mutex
+	state       string
+	term        uint64
+	votedFor    string
+	leader      string
+	commitIndex uint64
+	lastApplied uint64
+	lastContact time.Time
+	timeout     time.Duration
+	nextIndex   map[string]uint64
+	matchIndex  map[string]uint64
+	inflight    map[string]bool
+	waiters     map[uint64]*waiter
+	applyCh     chan struct{}
+	replicateCh chan struct{}
+	done        chan struct{}
 }
 
-func (c *clusterImpl) Heartbeat() {
-	c.heartbeat <- struct{}{}
+func NewCluster(config *Config, log LogStore, transport Transport, sm StateMachine) (Cluster, error) {
+	if config.ElectionTimeout == 0 {
+		config.ElectionTimeout = time.Millisecond * 300
+	}
+	if config.HeartbeatInterval == 0 {
+		config.HeartbeatInterval = time.Millisecond * 100
+	}
+	if config.SnapshotThreshold == 0 {
+		config.SnapshotThreshold = 1024
+	}
+
+	state, err := log.LoadState()
+	if err != nil {
+		return nil, err
+	}
+
+	c := &clusterImpl{
+		config:      config,
+		log:         log,
+		transport:   transport,
+		sm:          sm,
+		mu:          new(sync.Mutex),
+		applyMu:     new(sync.Mutex),
+		state:       stateFollower,
+		term:        state.Term,
+		votedFor:    state.VotedFor,
+		lastContact: time.Now(),
+		nextIndex:   make(map[string]uint64),
+		matchIndex:  make(map[string]uint64),
+		inflight:    make(map[string]bool),
+		waiters:     make(map[uint64]*waiter),
+		applyCh:     make(chan struct{}, 1),
+		replicateCh: make(chan struct{}, 1),
+		done:        make(chan struct{}),
+	}
+	if snapshot := log.Snapshot(); snapshot.Index > 0 {
+		if err := sm.Restore(snapshot.Data); err != nil {
+			return nil, err
+		}
+		c.commitIndex = snapshot.Index
+		c.lastApplied = snapshot.Index
+	}
+	c.resetTimeout()
+	return c, nil
 }
 
 func (c *clusterImpl) Quorum() int {
-	return len(c.hosts)/2 + 1
+	return (len(c.config.Peers)+1)/2 + 1
+}
+
+func (c *clusterImpl) IsLeader() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state == stateLeader
+}
+
+func (c *clusterImpl) LeaderAddr() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.leader
+}
+
+func (c *clusterImpl) Start() {
+	go c.applyLoop()
+	go c.run()
+}
+
+func (c *clusterImpl) Stop() {
+	close(c.done)
+}
+
+func (c *clusterImpl) Propose(ctx context.Context, log *Log) (any, error) {
+	c.mu.Lock()
+	if c.state != stateLeader {
+		c.mu.Unlock()
+		return nil, errors.WithStack(ErrNotLeader)
+	}
+
+	log.Term = c.term
+	log.Index = c.log.LastIndex() + 1
+	if err := c.log.Append(log); err != nil {
+		c.mu.Unlock()
+		return nil, err
+	}
+	w := &waiter{term: log.Term, ch: make(chan *result, 1)}
+	c.waiters[log.Index] = w
+	c.advanceCommit()
+	c.mu.Unlock()
+	notify(c.replicateCh)
+
+	select {
+	case r := <-w.ch:
+		return r.value, r.err
+	case <-ctx.Done():
+		c.mu.Lock()
+		delete(c.waiters, log.Index)
+		c.mu.Unlock()
+		return nil, errors.WithStack(ctx.Err())
+	}
+}
+
+func (c *clusterImpl) RequestVote(req *VoteRequest) (*VoteResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if req.Term < c.term {
+		return &VoteResponse{Term: c.term, Granted: false}, nil
+	}
+	if req.Term > c.term {
+		c.stepDown(req.Term)
+	}
+
+	lastTerm := c.log.LastTerm()
+	upToDate := req.LastTerm > lastTerm ||
+		(req.LastTerm == lastTerm && req.LastIndex >= c.log.LastIndex())
+	if !upToDate || (c.votedFor != "" && c.votedFor != req.CandidateId) {
+		return &VoteResponse{Term: c.term, Granted: false}, nil
+	}
+
+	c.votedFor = req.CandidateId
+	if err := c.persist(); err != nil {
+		return nil, err
+	}
+	c.lastContact = time.Now()
+	return &VoteResponse{Term: c.term, Granted: true}, nil
+}
+
+func (c *clusterImpl) AppendEntries(req *AppendRequest) (*AppendResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if req.Term < c.term {
+		return &AppendResponse{Term: c.term, LastIndex: c.log.LastIndex()}, nil
+	}
+	if req.Term > c.term || c.state != stateFollower {
+		c.stepDown(req.Term)
+	}
+	c.leader = req.LeaderId
+	c.lastContact = time.Now()
+
+	if req.PrevIndex > c.log.LastIndex() {
+		return &AppendResponse{Term: c.term, LastIndex: c.log.LastIndex()}, nil
+	}
+	entries := req.Entries
+	if snapshot := c.log.Snapshot(); req.PrevIndex < snapshot.Index {
+		// everything up to the snapshot is committed, so it matches the leader.
+		for len(entries) > 0 && entries[0].Index <= snapshot.Index {
+			entries = entries[1:]
+		}
+	} else if req.PrevIndex > 0 && c.log.Term(req.PrevIndex) != req.PrevTerm {
+		return &AppendResponse{Term: c.term, LastIndex: req.PrevIndex - 1}, nil
+	}
+
+	for len(entries) > 0 && entries[0].Index <= c.log.LastIndex() {
+		if c.log.Term(entries[0].Index) != entries[0].Term {
+			if err := c.log.TruncateFrom(entries[0].Index); err != nil {
+				return nil, err
+			}
+			break
+		}
+		entries = entries[1:]
+	}
+	if len(entries) > 0 {
+		if err := c.log.Append(entries...); err != nil {
+			return nil, err
+		}
+	}
+
+	if lastNew := req.PrevIndex + uint64(len(req.Entries)); req.LeaderCommit > c.commitIndex {
+		c.commitIndex = req.LeaderCommit
+		if lastNew < c.commitIndex {
+			c.commitIndex = lastNew
+		}
+		notify(c.applyCh)
+	}
+
+	return &AppendResponse{Term: c.term, Success: true, LastIndex: c.log.LastIndex()}, nil
 }
 
-func (c *clusterImpl) Voting(state *VoteState) error {
-	if state.Term <= c.term || state.LastIndex <= c.log.LastIndex() {
-		return errors.WithStack(fiber.ErrBadRequest)
+func (c *clusterImpl) InstallSnapshot(req *SnapshotRequest) (*SnapshotResponse, error) {
+	c.applyMu.Lock()
+	defer c.applyMu.Unlock()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if req.Term < c.term {
+		return &SnapshotResponse{Term: c.term}, nil
+	}
+	if req.Term > c.term || c.state != stateFollower {
+		c.stepDown(req.Term)
 	}
+	c.leader = req.LeaderId
+	c.lastContact = time.Now()
 
-	return nil
+	if req.Snapshot.Index <= c.lastApplied {
+		return &SnapshotResponse{Term: c.term}, nil
+	}
+	if err := c.log.Compact(req.Snapshot); err != nil {
+		return nil, err
+	}
+	if err := c.sm.Restore(req.Snapshot.Data); err != nil {
+		return nil, err
+	}
+	c.lastApplied = req.Snapshot.Index
+	if c.commitIndex < c.lastApplied {
+		c.commitIndex = c.lastApplied
+	}
+	logger.Infof("%s installed snapshot at index %d", c.config.Addr, req.Snapshot.Index)
+	return &SnapshotResponse{Term: c.term}, nil
 }
 
-func (c *clusterImpl) StartReplication() {
+func (c *clusterImpl) run() {
+	ticker := time.NewTicker(c.config.HeartbeatInterval / 2)
+	defer ticker.Stop()
+	lastHeartbeat := time.Time{}
 	for {
 		select {
-		case <-time.After(time.Duration(rand.Intn(150)+150) * time.Millisecond):
-			if err := c.upgrade(); err != nil {
-				logger.Warnf("%+v", err)
+		case <-c.done:
+			return
+		case <-c.replicateCh:
+			c.broadcast()
+			lastHeartbeat = time.Now()
+		case <-ticker.C:
+			c.mu.Lock()
+			state := c.state
+			expired := time.Since(c.lastContact) > c.timeout
+			c.mu.Unlock()
+
+			if state == stateLeader {
+				if time.Since(lastHeartbeat) >= c.config.HeartbeatInterval {
+					c.broadcast()
+					lastHeartbeat = time.Now()
+				}
 				continue
 			}
-			return
-		case <-c.heartbeat:
-			continue
+			if expired {
+				c.election()
+			}
 		}
 	}
 }
 
-func (c *clusterImpl) upgrade() error {
+func (c *clusterImpl) election() {
+	c.mu.Lock()
 	c.state = stateCandidate
 	c.term += 1
+	c.votedFor = c.config.Addr
+	c.leader = ""
+	c.lastContact = time.Now()
+	c.resetTimeout()
+	if err := c.persist(); err != nil {
+		c.mu.Unlock()
+		logger.Errorf("%+v", err)
+		return
+	}
+	term := c.term
+	req := &VoteRequest{
+		Term:        c.term,
+		CandidateId: c.config.Addr,
+		LastIndex:   c.log.LastIndex(),
+		LastTerm:    c.log.LastTerm(),
+	}
+	c.mu.Unlock()
+	logger.Debugf("%s starts election for term %d", c.config.Addr, term)
 
-	wg := new(sync.WaitGroup)
-	done := make(chan struct{})
-	wg.Add(len(c.hosts))
-	go func() {
-		defer close(done)
-		defer wg.Wait()
-		for _, v := range c.hosts {
-			go func(host string) {
-				defer wg.Done()
-				if err := c.requestVote(host); err != nil {
-					logger.Warnf("%+v", err)
-					return
-				}
-				done <- struct{}{}
-			}(v)
-		}
-	}()
+	votes := 1
+	if votes >= c.Quorum() {
+		c.becomeLeader(term)
+		return
+	}
+
+	responses := make(chan *VoteResponse, len(c.config.Peers))
+	for _, peer := range c.config.Peers {
+		go func(peer string) {
+			ctx, cancel := context.WithTimeout(context.Background(), c.config.ElectionTimeout)
+			defer cancel()
+			res, err := c.transport.RequestVote(ctx, peer, req)
+			if err != nil {
+				logger.Debugf("%+v", err)
+				responses <- nil
+				return
+			}
+			responses <- res
+		}(peer)
+	}
 
-	allowed := 1
-	for range done {
-		if allowed = allowed + 1; allowed < c.Quorum() {
+	for range c.config.Peers {
+		res := <-responses
+		if res == nil {
+			continue
+		}
+		if res.Term > term {
+			c.mu.Lock()
+			if res.Term > c.term {
+				c.stepDown(res.Term)
+			}
+			c.mu.Unlock()
+			return
+		}
+		if !res.Granted {
 			continue
 		}
-		c.state = stateLeader
-		go c.sendHeartbeat()
-		return nil
+		if votes = votes + 1; votes >= c.Quorum() {
+			c.becomeLeader(term)
+			return
+		}
 	}
-
-	return errors.New("election failed")
 }
 
-func (c *clusterImpl) requestVote(host string) error {
-	req := fasthttp.AcquireRequest()
-	defer fasthttp.ReleaseRequest(req)
-	res := fasthttp.AcquireResponse()
-	defer fasthttp.ReleaseResponse(res)
-
-	req.Header.SetMethod(fasthttp.MethodPost)
-	req.SetRequestURI(fmt.Sprintf("http://%s/vote", host))
+func (c *clusterImpl) becomeLeader(term uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.state != stateCandidate || c.term != term {
+		return
+	}
 
-	if err := json.NewEncoder(req.BodyWriter()).Encode(&VoteState{
+	c.state = stateLeader
+	c.leader = c.config.Addr
+	for _, peer := range c.config.Peers {
+		c.nextIndex[peer] = c.log.LastIndex() + 1
+		c.matchIndex[peer] = 0
+	}
+	if err := c.log.Append(&Log{
 		Term:      c.term,
-		LastIndex: c.log.LastIndex(),
+		Index:     c.log.LastIndex() + 1,
+		Operation: OperationNoop,
 	}); err != nil {
-		return errors.WithStack(err)
+		logger.Errorf("%+v", err)
+	}
+	c.advanceCommit()
+	logger.Infof("%s became leader of term %d", c.config.Addr, c.term)
+	notify(c.replicateCh)
+}
+
+func (c *clusterImpl) broadcast() {
+	for _, peer := range c.config.Peers {
+		go c.replicate(peer)
 	}
+}
 
-	if err := fasthttp.Do(req, res); err != nil {
-		return errors.WithStack(err)
+func (c *clusterImpl) replicate(peer string) {
+	c.mu.Lock()
+	if c.state != stateLeader || c.inflight[peer] {
+		c.mu.Unlock()
+		return
 	}
+	c.inflight[peer] = true
+	defer func() {
+		c.mu.Lock()
+		c.inflight[peer] = false
+		c.mu.Unlock()
+	}()
 
-	if res.StatusCode() >= 300 {
-		return errors.New(string(res.Body()))
+	next := c.nextIndex[peer]
+	if snapshot := c.log.Snapshot(); next <= snapshot.Index {
+		req := &SnapshotRequest{Term: c.term, LeaderId: c.config.Addr, Snapshot: snapshot}
+		c.mu.Unlock()
+		c.sendSnapshot(peer, req)
+		return
+	}
+	entries := c.log.From(next)
+	if len(entries) > maxEntriesPerAppend {
+		entries = entries[:maxEntriesPerAppend]
+	}
+	req := &AppendRequest{
+		Term:         c.term,
+		LeaderId:     c.config.Addr,
+		PrevIndex:    next - 1,
+		PrevTerm:     c.log.Term(next - 1),
+		Entries:      entries,
+		LeaderCommit: c.commitIndex,
+	}
+	c.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.config.ElectionTimeout)
+	defer cancel()
+	res, err := c.transport.AppendEntries(ctx, peer, req)
+	if err != nil {
+		logger.Debugf("%+v", err)
+		return
 	}
 
-	return nil
-}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if res.Term > c.term {
+		c.stepDown(res.Term)
+		return
+	}
+	if c.state != stateLeader || c.term != req.Term {
+		return
+	}
 
-func (c *clusterImpl) sendHeartbeat() {
-	t := time.NewTicker(time.Millisecond * 100)
-	for range t.C {
-		if err := c.replicateLogs(); err != nil {
-			logger.Warnf("%+v", err)
-			continue
+	if !res.Success {
+		next := req.PrevIndex
+		if res.LastIndex+1 < next {
+			next = res.LastIndex + 1
+		}
+		if next < 1 {
+			next = 1
 		}
+		c.nextIndex[peer] = next
+		return
 	}
+
+	if match := req.PrevIndex + uint64(len(req.Entries)); match > c.matchIndex[peer] {
+		c.matchIndex[peer] = match
+	}
+	c.nextIndex[peer] = c.matchIndex[peer] + 1
+	c.advanceCommit()
 }
 
-func (c *clusterImpl) replicateLogs() error {
-	logs := c.log.Uncommitted()
-	done := make(chan struct{})
-	wg := new(sync.WaitGroup)
-	wg.Add(len(c.hosts))
+func (c *clusterImpl) sendSnapshot(peer string, req *SnapshotRequest) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.config.ElectionTimeout)
+	defer cancel()
+	res, err := c.transport.InstallSnapshot(ctx, peer, req)
+	if err != nil {
+		logger.Debugf("%+v", err)
+		return
+	}
 
-	go func() {
-		defer close(done)
-		defer wg.Wait()
-		for _, v := range c.hosts {
-			go func(host string) {
-				if err := c.sendLogs(host, logs); err != nil {
-					logger.Warnf("%+v", err)
-					return
-				}
-				done <- struct{}{}
-			}(v)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if res.Term > c.term {
+		c.stepDown(res.Term)
+		return
+	}
+	if c.state != stateLeader || c.term != req.Term {
+		return
+	}
+
+	if req.Snapshot.Index > c.matchIndex[peer] {
+		c.matchIndex[peer] = req.Snapshot.Index
+	}
+	c.nextIndex[peer] = c.matchIndex[peer] + 1
+	c.advanceCommit()
+}
+
+func (c *clusterImpl) advanceCommit() {
+	if c.state != stateLeader {
+		return
+	}
+	for n := c.log.LastIndex(); n > c.commitIndex; n-- {
+		if c.log.Term(n) != c.term {
+			return
 		}
-	}()
 
-	allowed := 1
-	for range done {
-		if allowed = allowed + 1; allowed < c.Quorum() {
-			continue
+		count := 1
+		for _, peer := range c.config.Peers {
+			if c.matchIndex[peer] >= n {
+				count++
+			}
+		}
+		if count >= c.Quorum() {
+			c.commitIndex = n
+			notify(c.applyCh)
+			return
+		}
+	}
+}
+
+func (c *clusterImpl) applyLoop() {
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-c.applyCh:
+		}
+
+		for c.applyNext() {
 		}
-		return c.log.Commit(c.log.LastIndex())
 	}
-	return nil
 }
 
-func (c *clusterImpl) sendLogs(host string, logs []*Log) error {
-	req := fasthttp.AcquireRequest()
-	defer fasthttp.ReleaseRequest(req)
-	res := fasthttp.AcquireResponse()
-	defer fasthttp.ReleaseResponse(res)
+func (c *clusterImpl) applyNext() bool {
+	c.applyMu.Lock()
+	defer c.applyMu.Unlock()
 
-	req.SetRequestURI(fmt.Sprintf("http://%s/repl/log", host))
-	req.Header.SetMethod(fasthttp.MethodPut)
+	c.mu.Lock()
+	if c.lastApplied >= c.commitIndex {
+		c.mu.Unlock()
+		return false
+	}
+	index := c.lastApplied + 1
+	log := c.log.Get(index)
+	c.mu.Unlock()
 
-	if err := json.NewEncoder(req.BodyWriter()).Encode(logs); err != nil {
-		return errors.WithStack(err)
+	var value any
+	if log.Operation != OperationNoop {
+		value = c.sm.Apply(log)
 	}
 
-	if err := fasthttp.Do(req, res); err != nil {
-		return errors.WithStack(err)
+	c.mu.Lock()
+	c.lastApplied = index
+	w, ok := c.waiters[index]
+	delete(c.waiters, index)
+	c.mu.Unlock()
+
+	if ok {
+		if w.term != log.Term {
+			w.ch <- &result{err: errors.WithStack(ErrNotLeader)}
+		} else {
+			w.ch <- &result{value: value}
+		}
 	}
 
-	if err := fasthttp.Do(req, res); err != nil {
-		return errors.WithStack(err)
+	if index-c.log.Snapshot().Index >= c.config.SnapshotThreshold {
+		c.compact(index, log.Term)
 	}
+	return true
+}
 
-	if res.StatusCode() >= 300 {
-		return errors.New(string(res.Body()))
+// compact runs on the apply loop, so the snapshot holds exactly the logs up to index.
+func (c *clusterImpl) compact(index, term uint64) {
+	data, err := c.sm.Snapshot()
+	if err != nil {
+		logger.Errorf("%+v", err)
+		return
+	}
+	if err := c.log.Compact(&Snapshot{Index: index, Term: term, Data: data}); err != nil {
+		logger.Errorf("%+v", err)
+	}
+}
+
+func (c *clusterImpl) stepDown(term uint64) {
+	if term > c.term {
+		c.term = term
+		c.votedFor = ""
+		if err := c.persist(); err != nil {
+			logger.Errorf("%+v", err)
+		}
 	}
+	if c.state == stateLeader {
+		for index, w := range c.waiters {
+			w.ch <- &result{err: errors.WithStack(ErrNotLeader)}
+			delete(c.waiters, index)
+		}
+	}
+	if c.state != stateFollower {
+		c.lastContact = time.Now()
+	}
+	c.state = stateFollower
+}
 
-	return nil
+func (c *clusterImpl) persist() error {
+	return c.log.SaveState(&State{Term: c.term, VotedFor: c.votedFor})
+}
+
+func (c *clusterImpl) resetTimeout() {
+	base := c.config.ElectionTimeout
+	c.timeout = base + time.Duration(rand.Int63n(int64(base)))
+}
+
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
 }
@@ -0,0 +1,324 @@
+package replication
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/goccy/go-json"
+)
+
+type memoryMachine struct {
+	mu   *sync.Mutex
+	data map[string]string
+}
+
+func newMemoryMachine() *memoryMachine {
+	return &memoryMachine{mu: new(sync.Mutex), data: make(map[string]string)}
+}
+
+func (m *memoryMachine) Apply(log *Log) any {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	switch log.Operation {
+	case OperationPut:
+		m.data[log.Key] = string(log.Value)
+	case OperationDel:
+		delete(m.data, log.Key)
+	}
+	return nil
+}
+
+func (m *memoryMachine) Snapshot() ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return json.Marshal(m.data)
+}
+
+func (m *memoryMachine) Restore(data []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.data = make(map[string]string)
+	return json.Unmarshal(data, &m.data)
+}
+
+func (m *memoryMachine) get(key string) (string, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	v, ok := m.data[key]
+	return v, ok
+}
+
+func (m *memoryMachine) len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.data)
+}
+
+type testNode struct {
+	addr    string
+	dir     string
+	log     LogStore
+	sm      *memoryMachine
+	cluster Cluster
+}
+
+type testCluster struct {
+	t         *testing.T
+	transport *LocalTransport
+	nodes     []*testNode
+}
+
+func newTestCluster(t *testing.T, size int, threshold uint64) *testCluster {
+	tc := &testCluster{t: t, transport: NewLocalTransport()}
+	addrs := make([]string, size)
+	for i := range addrs {
+		addrs[i] = fmt.Sprintf("node-%d", i)
+	}
+
+	for i, addr := range addrs {
+		peers := make([]string, 0, size-1)
+		peers = append(peers, addrs[:i]...)
+		peers = append(peers, addrs[i+1:]...)
+		node := &testNode{addr: addr, dir: t.TempDir()}
+		tc.start(node, peers, threshold)
+		tc.nodes = append(tc.nodes, node)
+	}
+	t.Cleanup(func() {
+		for _, node := range tc.nodes {
+			node.cluster.Stop()
+		}
+	})
+	return tc
+}
+
+func (tc *testCluster) start(node *testNode, peers []string, threshold uint64) {
+	log, err := NewLogStore(node.dir)
+	if err != nil {
+		tc.t.Fatal(err)
+	}
+	sm := newMemoryMachine()
+	c, err := NewCluster(&Config{
+		Addr:              node.addr,
+		Peers:             peers,
+		ElectionTimeout:   time.Millisecond * 50,
+		HeartbeatInterval: time.Millisecond * 15,
+		SnapshotThreshold: threshold,
+	}, log, tc.transport.Node(node.addr), sm)
+	if err != nil {
+		tc.t.Fatal(err)
+	}
+	node.log, node.sm, node.cluster = log, sm, c
+	tc.transport.Register(node.addr, c)
+	c.Start()
+}
+
+func (tc *testCluster) leader(except ...string) *testNode {
+	tc.t.Helper()
+	var leader *testNode
+	waitFor(tc.t, func() bool {
+		for _, node := range tc.nodes {
+			if contains(except, node.addr) || !node.cluster.IsLeader() {
+				continue
+			}
+			leader = node
+			return true
+		}
+		return false
+	})
+	return leader
+}
+
+func (tc *testCluster) put(node *testNode, key, value string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*500)
+	defer cancel()
+	_, err := node.cluster.Propose(ctx, &Log{Operation: OperationPut, Key: key, Value: []byte(value)})
+	return err
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second * 5)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition was not met in time")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+func contains(list []string, v string) bool {
+	for _, e := range list {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
+
+func TestClusterReplicates(t *testing.T) {
+	tc := newTestCluster(t, 3, 0)
+	leader := tc.leader()
+
+	for i := 0; i < 10; i++ {
+		if err := tc.put(leader, fmt.Sprintf("key-%d", i), "value"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, node := range tc.nodes {
+		waitFor(t, func() bool { return node.sm.len() == 10 })
+	}
+}
+
+func TestClusterLeaderFailover(t *testing.T) {
+	tc := newTestCluster(t, 3, 0)
+	old := tc.leader()
+	if err := tc.put(old, "a", "1"); err != nil {
+		t.Fatal(err)
+	}
+
+	tc.transport.Partition(old.addr)
+	leader := tc.leader(old.addr)
+	if err := tc.put(leader, "a", "2"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the isolated leader can not reach a quorum, so its write never commits.
+	if err := tc.put(old, "b", "lost"); err == nil {
+		t.Fatal("write on a partitioned leader must not commit")
+	}
+
+	tc.transport.Heal(old.addr)
+	waitFor(t, func() bool { return !old.cluster.IsLeader() })
+	if err := tc.put(leader, "c", "3"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, node := range tc.nodes {
+		waitFor(t, func() bool {
+			v, _ := node.sm.get("a")
+			_, ok := node.sm.get("c")
+			return v == "2" && ok
+		})
+		if _, ok := node.sm.get("b"); ok {
+			t.Fatalf("%s applied the write of a deposed leader", node.addr)
+		}
+	}
+}
+
+func TestClusterMinorityCanNotElect(t *testing.T) {
+	tc := newTestCluster(t, 3, 0)
+	leader := tc.leader()
+
+	var isolated *testNode
+	for _, node := range tc.nodes {
+		if node != leader {
+			isolated = node
+			break
+		}
+	}
+	tc.transport.Partition(isolated.addr)
+
+	time.Sleep(time.Millisecond * 300)
+	if isolated.cluster.IsLeader() || !leader.cluster.IsLeader() {
+		t.Fatal("an isolated follower must not take over")
+	}
+	if err := tc.put(leader, "x", "1"); err != nil {
+		t.Fatal(err)
+	}
+
+	tc.transport.Heal(isolated.addr)
+	waitFor(t, func() bool {
+		_, ok := isolated.sm.get("x")
+		return ok
+	})
+}
+
+func TestClusterInstallsSnapshot(t *testing.T) {
+	tc := newTestCluster(t, 3, 4)
+	leader := tc.leader()
+
+	var lagging *testNode
+	for _, node := range tc.nodes {
+		if node != leader {
+			lagging = node
+			break
+		}
+	}
+	tc.transport.Partition(lagging.addr)
+
+	for i := 0; i < 20; i++ {
+		if err := tc.put(leader, fmt.Sprintf("key-%d", i), "value"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor(t, func() bool { return leader.log.Snapshot().Index > 0 })
+
+	tc.transport.Heal(lagging.addr)
+	waitFor(t, func() bool { return lagging.sm.len() == 20 })
+	if lagging.log.Snapshot().Index == 0 {
+		t.Fatal("lagging follower should have caught up from a snapshot")
+	}
+}
+
+func TestClusterRestoresSnapshotOnRestart(t *testing.T) {
+	tc := newTestCluster(t, 1, 4)
+	node := tc.nodes[0]
+	leader := tc.leader()
+	for i := 0; i < 10; i++ {
+		if err := tc.put(leader, fmt.Sprintf("key-%d", i), "value"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	node.cluster.Stop()
+
+	tc.start(node, nil, 4)
+	if node.log.Snapshot().Index == 0 {
+		t.Fatal("snapshot was not persisted")
+	}
+	waitFor(t, func() bool { return node.sm.len() == 10 })
+}
+
+func TestLogStoreCompact(t *testing.T) {
+	dir := t.TempDir()
+	l, err := NewLogStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := uint64(1); i <= 10; i++ {
+		if err := l.Append(&Log{Term: 1, Index: i, Operation: OperationNoop}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := l.Compact(&Snapshot{Index: 6, Term: 1, Data: []byte("{}")}); err != nil {
+		t.Fatal(err)
+	}
+	if l.Get(6) != nil || l.Get(7) == nil || l.Term(6) != 1 || l.LastIndex() != 10 {
+		t.Fatal("compaction must keep the entries after the snapshot")
+	}
+	if err := l.TruncateFrom(5); err == nil {
+		t.Fatal("compacted entries must not be truncated")
+	}
+
+	reopened, err := NewLogStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := reopened.Snapshot(); s.Index != 6 || s.Term != 1 {
+		t.Fatalf("unexpected snapshot %+v", s)
+	}
+	if reopened.LastIndex() != 10 || len(reopened.From(0)) != 4 {
+		t.Fatal("entries after the snapshot were not reloaded")
+	}
+
+	// a snapshot that disagrees with the log replaces it entirely.
+	if err := reopened.Compact(&Snapshot{Index: 8, Term: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if reopened.LastIndex() != 8 || reopened.LastTerm() != 2 {
+		t.Fatal("conflicting entries must be dropped")
+	}
+}
@@ -2,117 +2,285 @@ package replication
 
 import (
 	"bytes"
-	"encoding/binary"
 	"fmt"
-	"os"
 	"sync"
 
 	"github.com/goccy/go-json"
+	"github.com/gofiber/fiber/v2"
 	"github.com/pkg/errors"
-	"github.com/qwp0905/go-object-storage/internal/bufferpool"
 	"github.com/qwp0905/go-object-storage/internal/filesystem"
-	"github.com/qwp0905/go-object-storage/pkg/list"
+	"github.com/qwp0905/go-object-storage/pkg/nocopy"
 )
 
 type Operation string
 
 const (
-	OperationPut = Operation("PUT")
-	OperationDel = Operation("DEL")
+	OperationNoop = Operation("NOOP")
+	OperationPut  = Operation("PUT")
+	OperationDel  = Operation("DEL")
 )
 
 type Log struct {
-	Operation Operation `json:"operation"`
-	Key       string    `json:"key"`
+	Term      uint64    `json:"term"`
 	Index     uint64    `json:"index"`
+	Operation Operation `json:"operation"`
+	Key       string    `json:"key,omitempty"`
+	Value     []byte    `json:"value,omitempty"`
+}
+
+type Snapshot struct {
+	Index uint64 `json:"index"`
+	Term  uint64 `json:"term"`
+	Data  []byte `json:"data,omitempty"`
+}
+
+type State struct {
+	Term     uint64 `json:"term"`
+	VotedFor string `json:"voted_for,omitempty"`
 }
 
 type LogStore interface {
-	Insert(log *Log)
-	Uncommitted() []*Log
-	Commit(lastIndex uint64) error
+	Append(logs ...*Log) error
+	Get(index uint64) *Log
+	From(index uint64) []*Log
+	TruncateFrom(index uint64) error
+	Term(index uint64) uint64
 	LastIndex() uint64
+	LastTerm() uint64
+	LoadState() (*State, error)
+	SaveState(state *State) error
+	Snapshot() *Snapshot
+	Compact(snapshot *Snapshot) error
 }
 
+// logs holds the entries after the snapshot, logs[0] is snapshot.Index+1.
 type logStoreImpl struct {
-	uncommitted *list.Queue[*Log]
-	committed   uint64
-	mu          *sync.RWMutex
-	bp          bufferpool.BufferPool
+	noCopy   nocopy.NoCopy
+	fs       filesystem.FileSystem
+	logs     []*Log
+	snapshot *Snapshot
+	mu       *sync.RWMutex
 }
 
-func NewLogStore(basedir string, bp bufferpool.BufferPool) (LogStore, error) {
+func NewLogStore(basedir string) (LogStore, error) {
 	if err := filesystem.EnsureDir(fmt.Sprintf("%s/log", basedir)); err != nil {
 		return nil, err
 	}
 	l := &logStoreImpl{
-		uncommitted: list.NewQueue[*Log](),
-		mu:          new(sync.RWMutex),
-		bp:          bp,
-		committed:   0,
+		fs:       filesystem.NewFileSystem(basedir),
+		logs:     make([]*Log, 0),
+		snapshot: new(Snapshot),
+		mu:       new(sync.RWMutex),
 	}
-	b, err := os.ReadFile(l.lastIndexPath())
-	if err != nil {
-		if os.IsNotExist(err) {
+
+	if err := l.read(l.snapshotPath(), l.snapshot); err != nil && !errors.Is(err, fiber.ErrNotFound) {
+		return nil, err
+	}
+
+	var last uint64
+	if err := l.read(l.lastIndexPath(), &last); err != nil {
+		if errors.Is(err, fiber.ErrNotFound) {
 			return l, nil
 		}
-		return nil, errors.WithStack(err)
+		return nil, err
+	}
+
+	for i := l.snapshot.Index + 1; i <= last; i++ {
+		log := new(Log)
+		if err := l.read(l.path(i), log); err != nil {
+			return nil, err
+		}
+		l.logs = append(l.logs, log)
 	}
-	l.committed = binary.LittleEndian.Uint64(b)
 
 	return l, nil
 }
 
 func (l *logStoreImpl) lastIndexPath() string {
-	return fmt.Sprintf("log/%d", -1)
+	return "log/last"
+}
+
+func (l *logStoreImpl) snapshotPath() string {
+	return "log/snapshot"
+}
+
+func (l *logStoreImpl) statePath() string {
+	return "log/state"
 }
 
 func (l *logStoreImpl) path(index uint64) string {
 	return fmt.Sprintf("log/%d", index)
 }
 
-func (l *logStoreImpl) Insert(log *Log) {
+func (l *logStoreImpl) read(key string, v any) error {
+	f, _, err := l.fs.ReadFile(key)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return errors.WithStack(json.NewDecoder(f).Decode(v))
+}
+
+func (l *logStoreImpl) write(key string, v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	_, err = l.fs.WriteFile(key, bytes.NewReader(b))
+	return err
+}
+
+func (l *logStoreImpl) lastIndex() uint64 {
+	return l.snapshot.Index + uint64(len(l.logs))
+}
+
+func (l *logStoreImpl) get(index uint64) *Log {
+	if index <= l.snapshot.Index || index > l.lastIndex() {
+		return nil
+	}
+	return l.logs[index-l.snapshot.Index-1]
+}
+
+func (l *logStoreImpl) Append(logs ...*Log) error {
 	l.mu.Lock()
 	defer l.mu.Unlock()
-	l.uncommitted.Push(log)
+	for _, log := range logs {
+		if log.Index != l.lastIndex()+1 {
+			return errors.Errorf("log index %d is not continuous", log.Index)
+		}
+		if err := l.write(l.path(log.Index), log); err != nil {
+			return err
+		}
+		l.logs = append(l.logs, log)
+	}
+
+	return l.write(l.lastIndexPath(), l.lastIndex())
 }
 
-func (l *logStoreImpl) Uncommitted() []*Log {
+func (l *logStoreImpl) Get(index uint64) *Log {
 	l.mu.RLock()
 	defer l.mu.RUnlock()
-	return *l.uncommitted
+	return l.get(index)
 }
 
-func (l *logStoreImpl) Commit(lastIndex uint64) error {
+func (l *logStoreImpl) From(index uint64) []*Log {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if index <= l.snapshot.Index {
+		index = l.snapshot.Index + 1
+	}
+	if index > l.lastIndex() {
+		return []*Log{}
+	}
+	out := make([]*Log, l.lastIndex()-index+1)
+	copy(out, l.logs[index-l.snapshot.Index-1:])
+	return out
+}
+
+func (l *logStoreImpl) TruncateFrom(index uint64) error {
 	l.mu.Lock()
 	defer l.mu.Unlock()
-	list := make([]*Log, 0)
-	for l.uncommitted.Len() > 0 && l.uncommitted.First().Index <= lastIndex {
-		list = append(list, l.uncommitted.Shift())
+	if index > l.lastIndex() {
+		return nil
 	}
-	b, err := json.Marshal(list)
-	if err != nil {
-		return errors.WithStack(err)
+	if index <= l.snapshot.Index {
+		return errors.Errorf("log index %d is already compacted", index)
 	}
 
-	if err := l.bp.Put(l.path(lastIndex), len(b), bytes.NewReader(b)); err != nil {
+	if err := l.write(l.lastIndexPath(), index-1); err != nil {
 		return err
 	}
-	lb := make([]byte, 8)
-	binary.LittleEndian.PutUint64(lb, uint64(lastIndex))
-	if err := os.WriteFile(l.lastIndexPath(), lb, 0666); err != nil {
-		return errors.WithStack(err)
+	for i := index; i <= l.lastIndex(); i++ {
+		if err := l.fs.RemoveFile(l.path(i)); err != nil {
+			return err
+		}
 	}
-
-	l.committed = lastIndex
+	l.logs = l.logs[:index-l.snapshot.Index-1]
 	return nil
 }
 
+func (l *logStoreImpl) Term(index uint64) uint64 {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if index == l.snapshot.Index {
+		return l.snapshot.Term
+	}
+	if log := l.get(index); log != nil {
+		return log.Term
+	}
+	return 0
+}
+
 func (l *logStoreImpl) LastIndex() uint64 {
 	l.mu.RLock()
 	defer l.mu.RUnlock()
-	if l.uncommitted.Len() == 0 {
-		return l.committed
+	return l.lastIndex()
+}
+
+func (l *logStoreImpl) LastTerm() uint64 {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if len(l.logs) == 0 {
+		return l.snapshot.Term
+	}
+	return l.logs[len(l.logs)-1].Term
+}
+
+func (l *logStoreImpl) Snapshot() *Snapshot {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.snapshot
+}
+
+// Compact stores the snapshot and drops the entries it covers. entries after
+// it are kept only if the log agrees with the snapshot at its index.
+func (l *logStoreImpl) Compact(snapshot *Snapshot) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if snapshot.Index <= l.snapshot.Index {
+		return nil
+	}
+
+	if err := l.write(l.snapshotPath(), snapshot); err != nil {
+		return err
+	}
+
+	last := l.lastIndex()
+	keep := []*Log{}
+	if log := l.get(snapshot.Index); log != nil && log.Term == snapshot.Term {
+		keep = l.logs[snapshot.Index-l.snapshot.Index:]
+	} else if err := l.write(l.lastIndexPath(), snapshot.Index); err != nil {
+		return err
+	}
+
+	first := l.snapshot.Index + 1
+	if len(keep) > 0 {
+		last = snapshot.Index
 	}
-	return l.uncommitted.Last().Index
+	l.logs = append(make([]*Log, 0, len(keep)), keep...)
+	l.snapshot = snapshot
+
+	for i := first; i <= last; i++ {
+		if err := l.fs.RemoveFile(l.path(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (l *logStoreImpl) LoadState() (*State, error) {
+	state := new(State)
+	if err := l.read(l.statePath(), state); err != nil {
+		if errors.Is(err, fiber.ErrNotFound) {
+			return state, nil
+		}
+		return nil, err
+	}
+	return state, nil
+}
+
+func (l *logStoreImpl) SaveState(state *State) error {
+	return l.write(l.statePath(), state)
 }
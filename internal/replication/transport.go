@@ -0,0 +1,220 @@
+package replication
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/pkg/errors"
+	"github.com/valyala/fasthttp"
+)
+
+type VoteRequest struct {
+	Term        uint64 `json:"term"`
+	CandidateId string `json:"candidate_id"`
+	LastIndex   uint64 `json:"last_index"`
+	LastTerm    uint64 `json:"last_term"`
+}
+
+type VoteResponse struct {
+	Term    uint64 `json:"term"`
+	Granted bool   `json:"granted"`
+}
+
+type AppendRequest struct {
+	Term         uint64 `json:"term"`
+	LeaderId     string `json:"leader_id"`
+	PrevIndex    uint64 `json:"prev_index"`
+	PrevTerm     uint64 `json:"prev_term"`
+	Entries      []*Log `json:"entries,omitempty"`
+	LeaderCommit uint64 `json:"leader_commit"`
+}
+
+type AppendResponse struct {
+	Term      uint64 `json:"term"`
+	Success   bool   `json:"success"`
+	LastIndex uint64 `json:"last_index"`
+}
+
+type SnapshotRequest struct {
+	Term     uint64    `json:"term"`
+	LeaderId string    `json:"leader_id"`
+	Snapshot *Snapshot `json:"snapshot"`
+}
+
+type SnapshotResponse struct {
+	Term uint64 `json:"term"`
+}
+
+type Transport interface {
+	RequestVote(ctx context.Context, addr string, req *VoteRequest) (*VoteResponse, error)
+	AppendEntries(ctx context.Context, addr string, req *AppendRequest) (*AppendResponse, error)
+	InstallSnapshot(ctx context.Context, addr string, req *SnapshotRequest) (*SnapshotResponse, error)
+}
+
+type httpTransportImpl struct {
+	client *fasthttp.Client
+}
+
+func NewHttpTransport() Transport {
+	return &httpTransportImpl{client: &fasthttp.Client{}}
+}
+
+func (t *httpTransportImpl) RequestVote(
+	ctx context.Context,
+	addr string,
+	req *VoteRequest,
+) (*VoteResponse, error) {
+	res := new(VoteResponse)
+	if err := t.post(ctx, fmt.Sprintf("http://%s/repl/vote", addr), req, res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (t *httpTransportImpl) AppendEntries(
+	ctx context.Context,
+	addr string,
+	req *AppendRequest,
+) (*AppendResponse, error) {
+	res := new(AppendResponse)
+	if err := t.post(ctx, fmt.Sprintf("http://%s/repl/append", addr), req, res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (t *httpTransportImpl) InstallSnapshot(
+	ctx context.Context,
+	addr string,
+	req *SnapshotRequest,
+) (*SnapshotResponse, error) {
+	res := new(SnapshotResponse)
+	if err := t.post(ctx, fmt.Sprintf("http://%s/repl/snapshot", addr), req, res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (t *httpTransportImpl) post(ctx context.Context, uri string, body, out any) error {
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	res := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(res)
+
+	req.Header.SetMethod(fasthttp.MethodPost)
+	req.Header.SetContentType("application/json")
+	req.SetRequestURI(uri)
+
+	b, err := json.Marshal(body)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	req.SetBody(b)
+
+	timeout := time.Second
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = time.Until(deadline)
+	}
+	if err := t.client.DoTimeout(req, res, timeout); err != nil {
+		return errors.WithStack(err)
+	}
+	if res.StatusCode() >= 300 {
+		return errors.New(string(res.Body()))
+	}
+
+	return errors.WithStack(json.Unmarshal(res.Body(), out))
+}
+
+type LocalTransport struct {
+	mu          *sync.RWMutex
+	clusters    map[string]Cluster
+	partitioned map[string]bool
+}
+
+func NewLocalTransport() *LocalTransport {
+	return &LocalTransport{
+		mu:          new(sync.RWMutex),
+		clusters:    make(map[string]Cluster),
+		partitioned: make(map[string]bool),
+	}
+}
+
+func (t *LocalTransport) Register(addr string, c Cluster) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.clusters[addr] = c
+}
+
+func (t *LocalTransport) Partition(addrs ...string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, addr := range addrs {
+		t.partitioned[addr] = true
+	}
+}
+
+func (t *LocalTransport) Heal(addrs ...string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, addr := range addrs {
+		delete(t.partitioned, addr)
+	}
+}
+
+func (t *LocalTransport) Node(addr string) Transport {
+	return &localNodeTransport{parent: t, addr: addr}
+}
+
+func (t *LocalTransport) get(from, to string) (Cluster, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	c, ok := t.clusters[to]
+	if !ok || t.partitioned[from] != t.partitioned[to] {
+		return nil, errors.Errorf("%s is unreachable from %s", to, from)
+	}
+	return c, nil
+}
+
+type localNodeTransport struct {
+	parent *LocalTransport
+	addr   string
+}
+
+func (t *localNodeTransport) RequestVote(
+	ctx context.Context,
+	addr string,
+	req *VoteRequest,
+) (*VoteResponse, error) {
+	c, err := t.parent.get(t.addr, addr)
+	if err != nil {
+		return nil, err
+	}
+	return c.RequestVote(req)
+}
+
+func (t *localNodeTransport) AppendEntries(
+	ctx context.Context,
+	addr string,
+	req *AppendRequest,
+) (*AppendResponse, error) {
+	c, err := t.parent.get(t.addr, addr)
+	if err != nil {
+		return nil, err
+	}
+	return c.AppendEntries(req)
+}
+
+func (t *localNodeTransport) InstallSnapshot(
+	ctx context.Context,
+	addr string,
+	req *SnapshotRequest,
+) (*SnapshotResponse, error) {
+	c, err := t.parent.get(t.addr, addr)
+	if err != nil {
+		return nil, err
+	}
+	return c.InstallSnapshot(req)
+}
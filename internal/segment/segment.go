@@ -0,0 +1,492 @@
+package segment
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/qwp0905/go-object-storage/internal/filesystem"
+	"github.com/qwp0905/go-object-storage/pkg/logger"
+	"github.com/qwp0905/go-object-storage/pkg/nocopy"
+)
+
+// small objects are appended to segment files as records and found through an
+// index kept in memory, which is rebuilt by reading the segments on start. a
+// delete appends a tombstone, so the latest record of a key always wins.
+//
+// record: magic(2) flag(1) key length(2) data length(4) modified(8) crc(4)
+// key data
+const (
+	headerSize        = 21
+	flagPut      byte = 0
+	flagDelete   byte = 1
+	MaxKeyLength      = 1<<16 - 1
+)
+
+var magic = [2]byte{'S', 'G'}
+
+var ErrCorrupted = errors.New("corrupted segment record")
+
+type Config struct {
+	// a segment is sealed and a new one started once it grows past this.
+	SegmentSize int64
+}
+
+type Stat struct {
+	Segments int   `json:"segments"`
+	Objects  int   `json:"objects"`
+	Bytes    int64 `json:"bytes"`
+	Dead     int64 `json:"dead"`
+}
+
+type CompactResult struct {
+	Segments  int   `json:"segments"`
+	Objects   int   `json:"objects"`
+	Reclaimed int64 `json:"reclaimed"`
+}
+
+type Store interface {
+	Get(key string) (*Reader, bool)
+	Put(key string, data []byte) error
+	Delete(key string) (bool, error)
+	List() []*filesystem.FileInfo
+	Stat() *Stat
+	// Compact rewrites the live records of sealed segments whose share of dead
+	// bytes reached ratio and removes them.
+	Compact(ratio float64) (*CompactResult, error)
+}
+
+type entry struct {
+	segment  uint32
+	offset   int64
+	length   int64
+	modified time.Time
+}
+
+func (e *entry) record(key string) int64 {
+	return headerSize + int64(len(key)) + e.length
+}
+
+type segment struct {
+	id   uint32
+	file *os.File
+	size int64
+	dead int64
+	// the store holds one reference until the segment is compacted away and
+	// every open reader another, the file is closed with the last one.
+	refs atomic.Int64
+}
+
+func (seg *segment) release() {
+	if seg.refs.Add(-1) == 0 {
+		seg.file.Close()
+	}
+}
+
+// Reader reads the data of one record. the segment file stays open until the
+// reader is closed, even when the segment is compacted meanwhile.
+type Reader struct {
+	*io.SectionReader
+	seg  *segment
+	once sync.Once
+}
+
+func (r *Reader) Close() error {
+	r.once.Do(r.seg.release)
+	return nil
+}
+
+type storeImpl struct {
+	noCopy     nocopy.NoCopy
+	dir        string
+	config     *Config
+	mu         *sync.RWMutex
+	compacting *sync.Mutex
+	index      map[string]*entry
+	segments   map[uint32]*segment
+	active     *segment
+}
+
+func Open(dir string, config *Config) (Store, error) {
+	if err := filesystem.EnsureDir(dir); err != nil {
+		return nil, err
+	}
+
+	s := &storeImpl{
+		dir:        dir,
+		config:     config,
+		mu:         new(sync.RWMutex),
+		compacting: new(sync.Mutex),
+		index:      make(map[string]*entry),
+		segments:   make(map[uint32]*segment),
+	}
+
+	files, err := filesystem.List(dir)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]uint32, 0, len(files))
+	for _, file := range files {
+		id, err := strconv.ParseUint(file.Name, 10, 32)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, uint32(id))
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	for _, id := range ids {
+		seg, err := s.open(id)
+		if err != nil {
+			return nil, err
+		}
+		s.segments[id] = seg
+		s.active = seg
+		if err := s.load(seg); err != nil {
+			return nil, err
+		}
+	}
+	if s.active == nil {
+		if err := s.roll(); err != nil {
+			return nil, err
+		}
+	}
+
+	logger.Infof("%d objects in %d segments", len(s.index), len(s.segments))
+	return s, nil
+}
+
+func (s *storeImpl) path(id uint32) string {
+	return fmt.Sprintf("%s/%010d", s.dir, id)
+}
+
+func (s *storeImpl) open(id uint32) (*segment, error) {
+	file, err := os.OpenFile(s.path(id), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	seg := &segment{id: id, file: file}
+	seg.refs.Store(1)
+	return seg, nil
+}
+
+// load indexes the records of seg. a torn record left by a crash ends the
+// segment, it is cut off so appending starts after the last good one.
+func (s *storeImpl) load(seg *segment) error {
+	r := bufio.NewReaderSize(io.NewSectionReader(seg.file, 0, 1<<62), 1<<16)
+	offset := int64(0)
+	header := make([]byte, headerSize)
+	for {
+		flag, key, length, modified, err := readRecord(r, header)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			logger.Warnf("segment %d is cut at %d: %+v", seg.id, offset, err)
+			if err := seg.file.Truncate(offset); err != nil {
+				return errors.WithStack(err)
+			}
+			break
+		}
+
+		size := headerSize + int64(len(key)) + length
+		s.drop(key)
+		if flag == flagPut {
+			s.index[key] = &entry{
+				segment:  seg.id,
+				offset:   offset + headerSize + int64(len(key)),
+				length:   length,
+				modified: modified,
+			}
+		} else {
+			seg.dead += size
+		}
+		offset += size
+	}
+	seg.size = offset
+	return nil
+}
+
+func readRecord(r io.Reader, header []byte) (byte, string, int64, time.Time, error) {
+	if _, err := io.ReadFull(r, header); err == io.EOF {
+		return 0, "", 0, time.Time{}, io.EOF
+	} else if err != nil {
+		return 0, "", 0, time.Time{}, errors.WithStack(err)
+	}
+	if header[0] != magic[0] || header[1] != magic[1] {
+		return 0, "", 0, time.Time{}, errors.WithStack(ErrCorrupted)
+	}
+
+	flag := header[2]
+	keyLength := binary.BigEndian.Uint16(header[3:5])
+	length := binary.BigEndian.Uint32(header[5:9])
+	modified := time.Unix(0, int64(binary.BigEndian.Uint64(header[9:17])))
+	sum := binary.BigEndian.Uint32(header[17:21])
+
+	body := make([]byte, int(keyLength)+int(length))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, "", 0, time.Time{}, errors.WithStack(err)
+	}
+	if crc32.ChecksumIEEE(body) != sum {
+		return 0, "", 0, time.Time{}, errors.WithStack(ErrCorrupted)
+	}
+	return flag, string(body[:keyLength]), int64(length), modified, nil
+}
+
+func encodeRecord(flag byte, key string, data []byte, modified time.Time) []byte {
+	b := make([]byte, headerSize+len(key)+len(data))
+	b[0], b[1], b[2] = magic[0], magic[1], flag
+	binary.BigEndian.PutUint16(b[3:5], uint16(len(key)))
+	binary.BigEndian.PutUint32(b[5:9], uint32(len(data)))
+	binary.BigEndian.PutUint64(b[9:17], uint64(modified.UnixNano()))
+	copy(b[headerSize:], key)
+	copy(b[headerSize+len(key):], data)
+	binary.BigEndian.PutUint32(b[17:21], crc32.ChecksumIEEE(b[headerSize:]))
+	return b
+}
+
+// roll seals the active segment and starts a new one.
+func (s *storeImpl) roll() error {
+	id := uint32(1)
+	if s.active != nil {
+		id = s.active.id + 1
+	}
+	seg, err := s.open(id)
+	if err != nil {
+		return err
+	}
+	s.segments[id] = seg
+	s.active = seg
+	return nil
+}
+
+// appendRecord writes a record to the active segment and returns the offset of
+// its data. the lock must be held.
+func (s *storeImpl) appendRecord(flag byte, key string, data []byte, modified time.Time) (int64, error) {
+	b := encodeRecord(flag, key, data, modified)
+	if s.active.size > 0 && s.active.size+int64(len(b)) > s.config.SegmentSize {
+		if err := s.roll(); err != nil {
+			return 0, err
+		}
+	}
+
+	seg := s.active
+	if _, err := seg.file.WriteAt(b, seg.size); err != nil {
+		return 0, errors.WithStack(err)
+	}
+	offset := seg.size + headerSize + int64(len(key))
+	seg.size += int64(len(b))
+	return offset, nil
+}
+
+// Get returns the data of key, the reader has to be closed.
+func (s *storeImpl) Get(key string) (*Reader, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	e, ok := s.index[key]
+	if !ok {
+		return nil, false
+	}
+	seg := s.segments[e.segment]
+	seg.refs.Add(1)
+	return &Reader{SectionReader: io.NewSectionReader(seg.file, e.offset, e.length), seg: seg}, true
+}
+
+func (s *storeImpl) Put(key string, data []byte) error {
+	if len(key) > MaxKeyLength {
+		return errors.Errorf("key of %d bytes is too long for a segment", len(key))
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.put(key, data, time.Now())
+}
+
+func (s *storeImpl) put(key string, data []byte, modified time.Time) error {
+	offset, err := s.appendRecord(flagPut, key, data, modified)
+	if err != nil {
+		return err
+	}
+	s.drop(key)
+	s.index[key] = &entry{
+		segment:  s.active.id,
+		offset:   offset,
+		length:   int64(len(data)),
+		modified: modified,
+	}
+	return nil
+}
+
+// drop forgets the record of key, counting it as dead.
+func (s *storeImpl) drop(key string) {
+	if prev, ok := s.index[key]; ok {
+		s.segments[prev.segment].dead += prev.record(key)
+		delete(s.index, key)
+	}
+}
+
+func (s *storeImpl) Delete(key string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.index[key]; !ok {
+		return false, nil
+	}
+	if _, err := s.appendRecord(flagDelete, key, nil, time.Now()); err != nil {
+		return false, err
+	}
+	s.drop(key)
+	s.active.dead += headerSize + int64(len(key))
+	return true, nil
+}
+
+func (s *storeImpl) List() []*filesystem.FileInfo {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	out := make([]*filesystem.FileInfo, 0, len(s.index))
+	for key, e := range s.index {
+		out = append(out, &filesystem.FileInfo{Name: key, Size: e.length, LastModified: e.modified})
+	}
+	return out
+}
+
+func (s *storeImpl) Stat() *Stat {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	stat := &Stat{Segments: len(s.segments), Objects: len(s.index)}
+	for _, seg := range s.segments {
+		stat.Bytes += seg.size
+		stat.Dead += seg.dead
+	}
+	return stat
+}
+
+func (s *storeImpl) Compact(ratio float64) (*CompactResult, error) {
+	s.compacting.Lock()
+	defer s.compacting.Unlock()
+
+	s.mu.RLock()
+	candidates := make([]*segment, 0)
+	for _, seg := range s.segments {
+		if seg != s.active && seg.size > 0 && float64(seg.dead) >= float64(seg.size)*ratio {
+			candidates = append(candidates, seg)
+		}
+	}
+	s.mu.RUnlock()
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].id < candidates[j].id })
+
+	result := new(CompactResult)
+	for _, seg := range candidates {
+		moved, written, err := s.compact(seg)
+		if err != nil {
+			return result, err
+		}
+		result.Segments++
+		result.Objects += moved
+		result.Reclaimed += seg.size - written
+	}
+	return result, nil
+}
+
+// compact moves the live records of seg to the active segment and removes it.
+// tombstones are carried along unless no older segment is left that could
+// still hold a record they delete, or the key was put again since.
+func (s *storeImpl) compact(seg *segment) (int, int64, error) {
+	s.mu.RLock()
+	oldest := true
+	for id := range s.segments {
+		if id < seg.id {
+			oldest = false
+			break
+		}
+	}
+	size := seg.size
+	s.mu.RUnlock()
+
+	r := bufio.NewReaderSize(io.NewSectionReader(seg.file, 0, size), 1<<16)
+	header := make([]byte, headerSize)
+	offset := int64(0)
+	moved, written := 0, int64(0)
+	for offset < size {
+		flag, key, length, modified, err := readRecord(r, header)
+		if err != nil {
+			return moved, written, err
+		}
+		dataOffset := offset + headerSize + int64(len(key))
+		offset = dataOffset + length
+
+		if flag == flagDelete {
+			if oldest {
+				continue
+			}
+			carried, err := s.carry(key, modified)
+			if err != nil {
+				return moved, written, err
+			}
+			if carried {
+				written += headerSize + int64(len(key))
+			}
+			continue
+		}
+
+		ok, err := s.move(seg, key, dataOffset, length, modified)
+		if err != nil {
+			return moved, written, err
+		}
+		if ok {
+			moved++
+			written += headerSize + int64(len(key)) + length
+		}
+	}
+
+	s.mu.Lock()
+	delete(s.segments, seg.id)
+	s.mu.Unlock()
+	if err := os.Remove(s.path(seg.id)); err != nil {
+		return moved, written, errors.WithStack(err)
+	}
+	seg.release()
+	return moved, written, nil
+}
+
+// move appends the record of key at offset in seg again, unless it was
+// replaced meanwhile.
+func (s *storeImpl) move(seg *segment, key string, offset, length int64, modified time.Time) (bool, error) {
+	data := make([]byte, length)
+	if _, err := seg.file.ReadAt(data, offset); err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.index[key]; !ok || e.segment != seg.id || e.offset != offset {
+		return false, nil
+	}
+	return true, s.put(key, data, modified)
+}
+
+// carry appends a tombstone of key again. a key in the index was put after the
+// tombstone, which would delete it on the next start once appended after it.
+func (s *storeImpl) carry(key string, modified time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.index[key]; ok {
+		return false, nil
+	}
+	if _, err := s.appendRecord(flagDelete, key, nil, modified); err != nil {
+		return false, err
+	}
+	s.active.dead += headerSize + int64(len(key))
+	return true, nil
+}
@@ -0,0 +1,292 @@
+package segment
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func open(t *testing.T, dir string, size int64) *storeImpl {
+	t.Helper()
+	s, err := Open(dir, &Config{SegmentSize: size})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s.(*storeImpl)
+}
+
+func read(t *testing.T, s Store, key string) (string, bool) {
+	t.Helper()
+	r, ok := s.Get(key)
+	if !ok {
+		return "", false
+	}
+	defer r.Close()
+	b, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b), true
+}
+
+func mustRead(t *testing.T, s Store, key, want string) {
+	t.Helper()
+	got, ok := read(t, s, key)
+	if !ok {
+		t.Fatalf("%s is missing", key)
+	}
+	if got != want {
+		t.Fatalf("%s holds %q, want %q", key, got, want)
+	}
+}
+
+func mustMiss(t *testing.T, s Store, key string) {
+	t.Helper()
+	if got, ok := read(t, s, key); ok {
+		t.Fatalf("%s should be deleted, holds %q", key, got)
+	}
+}
+
+func TestRecordsSurviveReopen(t *testing.T) {
+	dir := t.TempDir()
+	s := open(t, dir, 1<<20)
+	for _, kv := range [][2]string{{"a", "1"}, {"b", "2"}, {"a", "3"}, {"c", ""}} {
+		if err := s.Put(kv[0], []byte(kv[1])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if ok, err := s.Delete("b"); err != nil || !ok {
+		t.Fatalf("delete of b: %t %v", ok, err)
+	}
+	if ok, _ := s.Delete("missing"); ok {
+		t.Fatal("a missing key can not be deleted")
+	}
+
+	for _, s := range []Store{s, open(t, dir, 1<<20)} {
+		mustRead(t, s, "a", "3")
+		mustRead(t, s, "c", "")
+		mustMiss(t, s, "b")
+		if n := len(s.List()); n != 2 {
+			t.Fatalf("%d objects listed, want 2", n)
+		}
+	}
+}
+
+func TestTornTailIsCut(t *testing.T) {
+	dir := t.TempDir()
+	s := open(t, dir, 1<<20)
+	if err := s.Put("kept", []byte("data")); err != nil {
+		t.Fatal(err)
+	}
+	size := s.active.size
+
+	// a crash in the middle of an append leaves part of a record behind.
+	torn := encodeRecord(flagPut, "torn", []byte("lost"), s.index["kept"].modified)
+	if _, err := s.active.file.WriteAt(torn[:len(torn)-2], size); err != nil {
+		t.Fatal(err)
+	}
+
+	s = open(t, dir, 1<<20)
+	mustRead(t, s, "kept", "data")
+	mustMiss(t, s, "torn")
+	if s.active.size != size {
+		t.Fatalf("segment is %d bytes, want %d", s.active.size, size)
+	}
+
+	if err := s.Put("after", []byte("next")); err != nil {
+		t.Fatal(err)
+	}
+	s = open(t, dir, 1<<20)
+	mustRead(t, s, "kept", "data")
+	mustRead(t, s, "after", "next")
+}
+
+func TestCompactKeepsLiveRecords(t *testing.T) {
+	dir := t.TempDir()
+	s := open(t, dir, 64)
+	for i := 0; i < 20; i++ {
+		if err := s.Put(fmt.Sprintf("key-%d", i%5), []byte(fmt.Sprintf("v%d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.Delete("key-0"); err != nil {
+		t.Fatal(err)
+	}
+	before := s.Stat()
+
+	result, err := s.Compact(0.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Segments == 0 || result.Reclaimed <= 0 {
+		t.Fatalf("nothing was compacted: %+v", result)
+	}
+	if after := s.Stat(); after.Bytes >= before.Bytes || after.Objects != before.Objects {
+		t.Fatalf("stat went from %+v to %+v", before, after)
+	}
+
+	for _, s := range []Store{s, open(t, dir, 64)} {
+		mustMiss(t, s, "key-0")
+		for i := 1; i < 5; i++ {
+			mustRead(t, s, fmt.Sprintf("key-%d", i), fmt.Sprintf("v%d", 15+i))
+		}
+	}
+}
+
+func TestCompactDoesNotCarryTombstoneOverNewerPut(t *testing.T) {
+	dir := t.TempDir()
+	// every record gets a segment of its own.
+	s := open(t, dir, 1)
+	steps := []func() error{
+		func() error { return s.Put("other", []byte("live")) },
+		func() error { return s.Put("key", []byte("old")) },
+		func() error { _, err := s.Delete("key"); return err },
+		func() error { return s.Put("key", []byte("new")) },
+	}
+	for _, step := range steps {
+		if err := step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the tombstone is not in the oldest segment, so it would be carried.
+	if _, err := s.Compact(0.5); err != nil {
+		t.Fatal(err)
+	}
+	mustRead(t, s, "key", "new")
+	mustRead(t, open(t, dir, 1), "key", "new")
+}
+
+func TestReaderOutlivesCompaction(t *testing.T) {
+	dir := t.TempDir()
+	s := open(t, dir, 1)
+	if err := s.Put("key", []byte("first")); err != nil {
+		t.Fatal(err)
+	}
+	r, ok := s.Get("key")
+	if !ok {
+		t.Fatal("key is missing")
+	}
+	if err := s.Put("key", []byte("second")); err != nil {
+		t.Fatal(err)
+	}
+
+	if result, err := s.Compact(0.5); err != nil || result.Segments != 1 {
+		t.Fatalf("compacted %+v, %v", result, err)
+	}
+	b, err := io.ReadAll(r)
+	if err != nil || string(b) != "first" {
+		t.Fatalf("reader of a compacted segment read %q, %v", b, err)
+	}
+
+	r.Close()
+	r.Close()
+	if _, err := r.ReadAt(make([]byte, 1), 0); !errors.Is(err, os.ErrClosed) {
+		t.Fatalf("segment file should be closed with its last reader, got %v", err)
+	}
+	mustRead(t, s, "key", "second")
+}
+
+func TestCompactRatioThreshold(t *testing.T) {
+	// two records of 26 bytes fill a segment, so the third one starts the next.
+	record := int64(headerSize + 1 + 4)
+	for _, c := range []struct {
+		ratio float64
+		want  int
+	}{
+		{0.5, 1},
+		{0.51, 0},
+		{0, 1},
+		{1, 0},
+	} {
+		dir := t.TempDir()
+		s := open(t, dir, 2*record)
+		for _, kv := range [][2]string{{"a", "old1"}, {"b", "live"}, {"a", "new1"}} {
+			if err := s.Put(kv[0], []byte(kv[1])); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if stat := s.Stat(); stat.Segments != 2 || stat.Dead != record {
+			t.Fatalf("unexpected layout %+v", stat)
+		}
+
+		result, err := s.Compact(c.ratio)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Segments != c.want {
+			t.Fatalf("ratio %v compacted %d segments, want %d", c.ratio, result.Segments, c.want)
+		}
+		mustRead(t, s, "a", "new1")
+		mustRead(t, s, "b", "live")
+	}
+}
+
+func TestReadDuringCompaction(t *testing.T) {
+	dir := t.TempDir()
+	s := open(t, dir, 256)
+	const keys = 16
+	for i := 0; i < keys; i++ {
+		if err := s.Put(fmt.Sprintf("key-%d", i), []byte(fmt.Sprintf("key-%d-0", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	done := make(chan struct{})
+	failed := make(chan error, keys)
+	var wg sync.WaitGroup
+	for i := 0; i < keys; i++ {
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				r, ok := s.Get(key)
+				if !ok {
+					failed <- errors.Errorf("%s is missing", key)
+					return
+				}
+				b, err := io.ReadAll(r)
+				r.Close()
+				if err != nil || !strings.HasPrefix(string(b), key+"-") {
+					failed <- errors.Errorf("%s read %q, %v", key, b, err)
+					return
+				}
+			}
+		}(fmt.Sprintf("key-%d", i))
+	}
+
+	for round := 1; round <= 50; round++ {
+		for i := 0; i < keys; i += 2 {
+			if err := s.Put(fmt.Sprintf("key-%d", i), []byte(fmt.Sprintf("key-%d-%d", i, round))); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := s.Compact(0.3); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(done)
+	wg.Wait()
+	close(failed)
+	for err := range failed {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < keys; i++ {
+		want := fmt.Sprintf("key-%d-0", i)
+		if i%2 == 0 {
+			want = fmt.Sprintf("key-%d-50", i)
+		}
+		mustRead(t, s, fmt.Sprintf("key-%d", i), want)
+	}
+}
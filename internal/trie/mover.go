@@ -0,0 +1,287 @@
+package trie
+
+import (
+	"context"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pkg/errors"
+	"github.com/qwp0905/go-object-storage/internal/datanode"
+	"github.com/qwp0905/go-object-storage/internal/locker"
+	"github.com/qwp0905/go-object-storage/internal/metadata"
+	"github.com/qwp0905/go-object-storage/internal/nodepool"
+)
+
+var ErrStale = errors.New("entry has been changed")
+
+type Mover interface {
+	MoveMetadata(ctx context.Context, e *Entry, to string) error
+	MoveObject(ctx context.Context, e *Entry, to string) (uint, error)
+	TransitionObject(ctx context.Context, e *Entry, to, class string) (uint, error)
+	MoveChunks(ctx context.Context, e *Entry, to []string, class string) (uint, error)
+}
+
+type moverImpl struct {
+	pool       nodepool.NodePool
+	lockerPool locker.LockerPool
+}
+
+func NewMover(pool nodepool.NodePool, lockerPool locker.LockerPool) Mover {
+	return &moverImpl{pool: pool, lockerPool: lockerPool}
+}
+
+func (m *moverImpl) MoveMetadata(ctx context.Context, e *Entry, to string) error {
+	if e.Id == to {
+		return nil
+	}
+	if e.IsRoot() {
+		return m.moveRoot(ctx, e, to)
+	}
+
+	parentLocker := m.lockerPool.Get(e.Parent)
+	parentToken, err := parentLocker.Lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer parentLocker.Unlock(ctx)
+
+	parentMeta, err := m.pool.GetMetadata(ctx, e.ParentId, e.Parent)
+	if err != nil {
+		return err
+	}
+
+	index := -1
+	for i, next := range parentMeta.NextNodes {
+		if next.Key == e.Metadata.Key && next.NodeId == e.Id {
+			index = i
+			break
+		}
+	}
+	if index == -1 {
+		return errors.WithStack(ErrStale)
+	}
+
+	locker := m.lockerPool.Get(e.Metadata.Key)
+	token, err := locker.Lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer locker.Unlock(ctx)
+
+	currentMeta, err := m.pool.GetMetadata(ctx, e.Id, e.Metadata.Key)
+	if err != nil {
+		return err
+	}
+	currentMeta.Fence = token
+	version := currentMeta.Version
+	if err := m.pool.PutMetadata(ctx, to, currentMeta); err != nil {
+		return err
+	}
+
+	parentMeta.Fence = parentToken
+	parentMeta.NextNodes[index] = &metadata.NextRoute{NodeId: to, Key: currentMeta.Key}
+	if err := m.pool.PutMetadataIf(ctx, e.ParentId, parentMeta, parentMeta.Version); err != nil {
+		m.pool.DeleteMetadata(ctx, to, currentMeta.Key)
+		if errors.Is(err, datanode.ErrVersionMismatch) {
+			return errors.WithStack(ErrStale)
+		}
+		return err
+	}
+
+	return m.deleteMoved(ctx, e.Id, to, currentMeta, version)
+}
+
+// writers that read the parent before it was repointed may still update the
+// old copy, so it is only removed once it matches what has been copied.
+func (m *moverImpl) deleteMoved(
+	ctx context.Context,
+	from, to string,
+	moved *metadata.Metadata,
+	version uint64,
+) error {
+	for {
+		err := m.pool.DeleteMetadataIf(ctx, from, moved.Key, version)
+		if !errors.Is(err, datanode.ErrVersionMismatch) {
+			return err
+		}
+
+		currentMeta, err := m.pool.GetMetadata(ctx, from, moved.Key)
+		if errors.Is(err, fiber.ErrNotFound) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		version = currentMeta.Version
+		if err := m.pool.PutMetadataIf(ctx, to, currentMeta, moved.Version); err != nil {
+			if errors.Is(err, datanode.ErrVersionMismatch) {
+				return errors.WithStack(ErrStale)
+			}
+			return err
+		}
+		moved = currentMeta
+	}
+}
+
+func (m *moverImpl) moveRoot(ctx context.Context, e *Entry, to string) error {
+	locker := m.lockerPool.Get(e.Metadata.Key)
+	token, err := locker.Lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer locker.Unlock(ctx)
+
+	rootMeta, err := m.pool.GetMetadata(ctx, e.Id, e.Metadata.Key)
+	if err != nil {
+		return err
+	}
+	rootMeta.Fence = token
+	if err := m.pool.PutMetadata(ctx, to, rootMeta); err != nil {
+		return err
+	}
+
+	return m.pool.DeleteMetadata(ctx, e.Id, rootMeta.Key)
+}
+
+func (m *moverImpl) MoveObject(ctx context.Context, e *Entry, to string) (uint, error) {
+	return m.moveObject(ctx, e, to, "")
+}
+
+// TransitionObject moves an object to a node of another storage class and
+// records the class it is stored as.
+func (m *moverImpl) TransitionObject(ctx context.Context, e *Entry, to, class string) (uint, error) {
+	return m.moveObject(ctx, e, to, class)
+}
+
+func (m *moverImpl) moveObject(ctx context.Context, e *Entry, to, class string) (uint, error) {
+	if e.Metadata.Chunked() {
+		all := make([]string, len(e.Metadata.Chunks))
+		for i := range all {
+			all[i] = to
+		}
+		return m.MoveChunks(ctx, e, all, class)
+	}
+
+	from := e.Metadata.NodeId
+	if from == to {
+		return 0, nil
+	}
+
+	locker := m.lockerPool.Get(e.Metadata.Key)
+	token, err := locker.Lock(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer locker.Unlock(ctx)
+
+	currentMeta, err := m.pool.GetMetadata(ctx, e.Id, e.Metadata.Key)
+	if err != nil {
+		return 0, err
+	}
+	currentMeta.Fence = token
+	if !currentMeta.FileExists() ||
+		currentMeta.NodeId != from ||
+		currentMeta.Source != e.Metadata.Source {
+		return 0, errors.WithStack(ErrStale)
+	}
+
+	if err := m.copyObject(ctx, currentMeta, to); err != nil {
+		return 0, err
+	}
+
+	prev := *currentMeta
+	currentMeta.NodeId = to
+	if class != "" {
+		currentMeta.StorageClass = class
+	}
+	if err := m.pool.PutMetadataIf(ctx, e.Id, currentMeta, prev.Version); err != nil {
+		m.pool.DeleteDirect(ctx, currentMeta)
+		if errors.Is(err, datanode.ErrVersionMismatch) {
+			return 0, errors.WithStack(ErrStale)
+		}
+		return 0, err
+	}
+
+	return currentMeta.StoredSize(), m.pool.DeleteDirect(ctx, &prev)
+}
+
+// MoveChunks moves every chunk i of a chunked object to node to[i], chunks
+// with no node or already there stay. the class is recorded unless empty.
+func (m *moverImpl) MoveChunks(ctx context.Context, e *Entry, to []string, class string) (uint, error) {
+	locker := m.lockerPool.Get(e.Metadata.Key)
+	token, err := locker.Lock(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer locker.Unlock(ctx)
+
+	currentMeta, err := m.pool.GetMetadata(ctx, e.Id, e.Metadata.Key)
+	if err != nil {
+		return 0, err
+	}
+	currentMeta.Fence = token
+	if !currentMeta.FileExists() ||
+		currentMeta.Source != e.Metadata.Source ||
+		len(currentMeta.Chunks) != len(to) {
+		return 0, errors.WithStack(ErrStale)
+	}
+
+	prev := *currentMeta
+	prev.Chunks = append([]metadata.Chunk(nil), currentMeta.Chunks...)
+	olds := prev.Parts()
+	moved := make([]int, 0, len(to))
+	drop := func() {
+		news := currentMeta.Parts()
+		for _, i := range moved {
+			m.pool.DeleteDirect(ctx, news[i])
+		}
+	}
+
+	size := uint(0)
+	for i, id := range to {
+		if id == "" || id == olds[i].NodeId {
+			continue
+		}
+		if err := m.copyObject(ctx, olds[i], id); err != nil {
+			drop()
+			return 0, err
+		}
+		currentMeta.Chunks[i].NodeId = id
+		moved = append(moved, i)
+		size += olds[i].Size
+	}
+	if len(moved) == 0 && (class == "" || class == currentMeta.Class()) {
+		return 0, nil
+	}
+
+	currentMeta.NodeId = currentMeta.Chunks[0].NodeId
+	if class != "" {
+		currentMeta.StorageClass = class
+	}
+	if err := m.pool.PutMetadataIf(ctx, e.Id, currentMeta, prev.Version); err != nil {
+		drop()
+		if errors.Is(err, datanode.ErrVersionMismatch) {
+			return 0, errors.WithStack(ErrStale)
+		}
+		return 0, err
+	}
+
+	for _, i := range moved {
+		if err := m.pool.DeleteDirect(ctx, olds[i]); err != nil {
+			return size, err
+		}
+	}
+	return size, nil
+}
+
+func (m *moverImpl) copyObject(ctx context.Context, meta *metadata.Metadata, to string) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	r, err := m.pool.GetDirect(ctx, meta)
+	if err != nil {
+		return err
+	}
+
+	dest := *meta
+	dest.NodeId = to
+	return m.pool.PutDirect(ctx, &dest, r)
+}
@@ -0,0 +1,96 @@
+package trie
+
+import (
+	"context"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/qwp0905/go-object-storage/internal/locker"
+	"github.com/qwp0905/go-object-storage/internal/metadata"
+	"github.com/qwp0905/go-object-storage/internal/nodepool"
+)
+
+type Entry struct {
+	Id       string             `json:"id"`
+	ParentId string             `json:"parent_id,omitempty"`
+	Parent   string             `json:"parent,omitempty"`
+	Metadata *metadata.Metadata `json:"metadata"`
+}
+
+func (e *Entry) IsRoot() bool {
+	return e.Parent == ""
+}
+
+type Walker interface {
+	RootId(ctx context.Context) (string, error)
+	Walk(ctx context.Context, fn func(*Entry) error) error
+}
+
+type walkerImpl struct {
+	pool       nodepool.NodePool
+	lockerPool locker.LockerPool
+	rootKey    string
+}
+
+func NewWalker(pool nodepool.NodePool, lockerPool locker.LockerPool) Walker {
+	return &walkerImpl{pool: pool, lockerPool: lockerPool, rootKey: "/"}
+}
+
+func (w *walkerImpl) RootId(ctx context.Context) (string, error) {
+	ids, err := w.pool.GetNodeIds(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	for _, id := range ids {
+		if _, err := w.pool.GetMetadata(ctx, id, w.rootKey); err != nil {
+			continue
+		}
+		return id, nil
+	}
+
+	return "", fiber.ErrNotFound
+}
+
+func (w *walkerImpl) Walk(ctx context.Context, fn func(*Entry) error) error {
+	rootId, err := w.RootId(ctx)
+	if err != nil {
+		return err
+	}
+
+	return w.walk(ctx, "", "", rootId, w.rootKey, fn)
+}
+
+func (w *walkerImpl) walk(
+	ctx context.Context,
+	parentId, parent, id, current string,
+	fn func(*Entry) error,
+) error {
+	locker := w.lockerPool.Get(current)
+	if err := locker.RLock(ctx); err != nil {
+		return err
+	}
+	currentMeta, err := w.pool.GetMetadata(ctx, id, current)
+	if err := locker.RUnlock(ctx); err != nil {
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := fn(&Entry{
+		Id:       id,
+		ParentId: parentId,
+		Parent:   parent,
+		Metadata: currentMeta,
+	}); err != nil {
+		return err
+	}
+
+	for _, next := range currentMeta.NextNodes {
+		if err := w.walk(ctx, id, current, next.NodeId, next.Key, fn); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}